@@ -0,0 +1,369 @@
+// Package blob stores objects larger than a row in a table, split into
+// chunk rows under a manifest row.
+//
+// The table has two STRING primary key columns, see KeyColumn and
+// PartColumn. The manifest of an object is the row with part "manifest", it
+// records the size, the chunk size, the SHA-256 of the content and the
+// generation of the object. Chunks are the rows with part
+// "chunk/<generation>/<index>", each one holding a slice of the content and
+// its SHA-256.
+//
+// Put writes the chunks of a new generation first and then swaps the
+// manifest with a conditional write, so readers see either the old or the
+// new object and two writers of the same key cannot both win. The chunks of
+// the previous generation are deleted once the new manifest is in place.
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/internal/batchwrite"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/internal/cond"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var (
+	ErrInvalidConfig = errors.New("[blob] invalid config")
+	ErrNotFound      = errors.New("[blob] object not found")
+	// ErrConflict is returned when another writer changed the object
+	// between the read of its manifest and the write of the new one.
+	ErrConflict = errors.New("[blob] object changed concurrently")
+	// ErrChanged is returned by a Reader whose object was overwritten or
+	// deleted after it was opened.
+	ErrChanged      = errors.New("[blob] object changed while reading")
+	ErrHashMismatch = errors.New("[blob] content hash mismatch")
+	ErrCorrupted    = errors.New("[blob] corrupted chunk")
+)
+
+const (
+	KeyColumn  = "key"
+	PartColumn = "part"
+
+	manifestPart = "manifest"
+
+	generationColumn = "generation"
+	sizeColumn       = "size"
+	chunkSizeColumn  = "chunk_size"
+	chunksColumn     = "chunks"
+	sha256Column     = "sha256"
+	modifiedColumn   = "modified"
+	dataColumn       = "data"
+)
+
+const (
+	DefaultChunkSize = 1 << 20
+	// MaxChunkSize keeps a chunk row under the 2 MB limit of a column value.
+	MaxChunkSize         = 2<<20 - 1024
+	DefaultMaxBatchBytes = 3 << 20
+	DefaultBatchSize     = 200
+	DefaultPrefetch      = 2
+	DefaultMaxRetries    = 5
+)
+
+// Client is the part of *tablestore.TableStoreClient used by a Store.
+type Client interface {
+	GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error)
+	PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error)
+	DeleteRow(request *tablestore.DeleteRowRequest) (*tablestore.DeleteRowResponse, error)
+	GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error)
+	BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error)
+}
+
+type Config struct {
+	Table string
+	// ChunkSize is the size of the chunks written by Put, objects written
+	// before keep the chunk size recorded in their manifest.
+	ChunkSize int
+	// MaxBatchBytes bounds the chunk bytes of a single BatchWriteRow.
+	MaxBatchBytes int
+	// Prefetch is the number of chunks a Reader fetches in one GetRange.
+	Prefetch int
+	// MaxRetries is how many times rows rejected by BatchWriteRow are
+	// written again before Put or Delete fails.
+	MaxRetries int
+}
+
+// Info describes a stored object.
+type Info struct {
+	Key        string
+	Size       int64
+	ChunkSize  int64
+	Chunks     int64
+	SHA256     string
+	Generation string
+	Modified   time.Time
+}
+
+type PutOptions struct {
+	// SHA256 is the expected hex encoded hash of the content. Put fails with
+	// ErrHashMismatch and leaves the previous object in place when it does
+	// not match.
+	SHA256 string
+}
+
+type Store struct {
+	client Client
+	config Config
+}
+
+func New(client Client, config Config) (*Store, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: client is required", ErrInvalidConfig)
+	}
+	if config.Table == "" {
+		return nil, fmt.Errorf("%w: table is required", ErrInvalidConfig)
+	}
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = DefaultChunkSize
+	}
+	if config.ChunkSize > MaxChunkSize {
+		return nil, fmt.Errorf("%w: chunk size %d is larger than %d", ErrInvalidConfig, config.ChunkSize, MaxChunkSize)
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = DefaultMaxBatchBytes
+	}
+	if config.Prefetch <= 0 {
+		config.Prefetch = DefaultPrefetch
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+	return &Store{client: client, config: config}, nil
+}
+
+// Stat reads the manifest of an object.
+func (s *Store) Stat(key string) (*Info, error) {
+	criteria := &tablestore.SingleRowQueryCriteria{
+		TableName:  s.config.Table,
+		PrimaryKey: s.primaryKey(key, manifestPart),
+		MaxVersion: 1,
+	}
+	resp, err := s.client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: criteria})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Columns) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	info := &Info{Key: key}
+	for _, col := range resp.Columns {
+		switch col.ColumnName {
+		case generationColumn:
+			info.Generation, _ = col.Value.(string)
+		case sha256Column:
+			info.SHA256, _ = col.Value.(string)
+		case sizeColumn:
+			info.Size, _ = col.Value.(int64)
+		case chunkSizeColumn:
+			info.ChunkSize, _ = col.Value.(int64)
+		case chunksColumn:
+			info.Chunks, _ = col.Value.(int64)
+		case modifiedColumn:
+			if ms, ok := col.Value.(int64); ok {
+				info.Modified = time.Unix(0, ms*int64(time.Millisecond))
+			}
+		}
+	}
+	if info.Generation == "" || info.ChunkSize <= 0 || info.Chunks*info.ChunkSize < info.Size {
+		return nil, fmt.Errorf("%w: invalid manifest of %s", ErrCorrupted, key)
+	}
+	return info, nil
+}
+
+// Put stores the content of r under key, replacing any previous object.
+// When the new object is in place but the chunks of the previous one could
+// not all be deleted, Put returns both the Info and the error.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader, options *PutOptions) (*Info, error) {
+	old, err := s.Stat(key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	generation, err := newGeneration()
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.writeChunks(ctx, key, generation, r)
+	if err == nil && options != nil && options.SHA256 != "" && options.SHA256 != info.SHA256 {
+		err = fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, options.SHA256, info.SHA256)
+	}
+	if err == nil {
+		err = s.putManifest(info, old)
+		// any other error may come after the manifest was written, so the
+		// chunks are kept
+		if err != nil && !errors.Is(err, ErrConflict) {
+			return nil, err
+		}
+	}
+	if err != nil {
+		// the new chunks are not referenced by any manifest
+		s.deleteChunks(ctx, key, generation, info.Chunks)
+		return nil, err
+	}
+
+	if old != nil {
+		if err := s.deleteChunks(ctx, key, old.Generation, old.Chunks); err != nil {
+			return info, fmt.Errorf("[blob] chunks of the previous generation of %s were not deleted: %w", key, err)
+		}
+	}
+	return info, nil
+}
+
+// Delete removes an object and its chunks.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	old, err := s.Stat(key)
+	if err != nil {
+		return err
+	}
+	change := &tablestore.DeleteRowChange{TableName: s.config.Table, PrimaryKey: s.primaryKey(key, manifestPart)}
+	change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+	change.SetColumnCondition(cond.Equal(generationColumn, old.Generation))
+	if _, err := s.client.DeleteRow(&tablestore.DeleteRowRequest{DeleteRowChange: change}); err != nil {
+		if cond.IsConditionFailed(err) {
+			return fmt.Errorf("%w: %s", ErrConflict, key)
+		}
+		return err
+	}
+	return s.deleteChunks(ctx, key, old.Generation, old.Chunks)
+}
+
+// writeChunks writes the content of r as chunk rows of generation. The Info
+// it returns counts the chunks that may have been written even when it
+// fails, so they can be deleted.
+func (s *Store) writeChunks(ctx context.Context, key, generation string, r io.Reader) (*Info, error) {
+	info := &Info{Key: key, ChunkSize: int64(s.config.ChunkSize), Generation: generation}
+	hash := sha256.New()
+	perBatch := s.config.MaxBatchBytes / s.config.ChunkSize
+	if perBatch < 1 {
+		perBatch = 1
+	}
+	if perBatch > DefaultBatchSize {
+		perBatch = DefaultBatchSize
+	}
+
+	var batch []tablestore.RowChange
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.write(ctx, batch); err != nil {
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return info, err
+		}
+		buf := make([]byte, s.config.ChunkSize)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			data := buf[:n]
+			hash.Write(data)
+			sum := sha256.Sum256(data)
+			change := &tablestore.PutRowChange{TableName: s.config.Table, PrimaryKey: s.primaryKey(key, chunkPart(generation, info.Chunks))}
+			change.AddColumn(dataColumn, data)
+			change.AddColumn(sha256Column, hex.EncodeToString(sum[:]))
+			change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+			batch = append(batch, change)
+			info.Chunks++
+			info.Size += int64(n)
+			if len(batch) >= perBatch {
+				if err := flush(); err != nil {
+					return info, err
+				}
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return info, err
+		}
+	}
+	if err := flush(); err != nil {
+		return info, err
+	}
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return info, nil
+}
+
+// putManifest writes the manifest of info if the object is still at the
+// generation of old, or still missing when old is nil.
+func (s *Store) putManifest(info *Info, old *Info) error {
+	info.Modified = time.Now()
+	change := &tablestore.PutRowChange{TableName: s.config.Table, PrimaryKey: s.primaryKey(info.Key, manifestPart)}
+	change.AddColumn(generationColumn, info.Generation)
+	change.AddColumn(sizeColumn, info.Size)
+	change.AddColumn(chunkSizeColumn, info.ChunkSize)
+	change.AddColumn(chunksColumn, info.Chunks)
+	change.AddColumn(sha256Column, info.SHA256)
+	change.AddColumn(modifiedColumn, info.Modified.UnixNano()/int64(time.Millisecond))
+	if old == nil {
+		change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST)
+	} else {
+		change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+		change.SetColumnCondition(cond.Equal(generationColumn, old.Generation))
+	}
+	if _, err := s.client.PutRow(&tablestore.PutRowRequest{PutRowChange: change}); err != nil {
+		if cond.IsConditionFailed(err) {
+			return fmt.Errorf("%w: %s", ErrConflict, info.Key)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Store) deleteChunks(ctx context.Context, key, generation string, chunks int64) error {
+	var batch []tablestore.RowChange
+	for index := int64(0); index < chunks; index++ {
+		change := &tablestore.DeleteRowChange{TableName: s.config.Table, PrimaryKey: s.primaryKey(key, chunkPart(generation, index))}
+		change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+		batch = append(batch, change)
+		if len(batch) == DefaultBatchSize || index == chunks-1 {
+			if err := s.write(ctx, batch); err != nil {
+				return err
+			}
+			batch = nil
+		}
+	}
+	return nil
+}
+
+// write sends a batch, writing the rows the service rejected again up to
+// MaxRetries times.
+func (s *Store) write(ctx context.Context, changes []tablestore.RowChange) error {
+	if err := batchwrite.Write(ctx, s.client, s.config.Table, changes, s.config.MaxRetries); err != nil {
+		return fmt.Errorf("[blob] write %s: %w", s.config.Table, err)
+	}
+	return nil
+}
+
+func (s *Store) primaryKey(key, part string) *tablestore.PrimaryKey {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn(KeyColumn, key)
+	pk.AddPrimaryKeyColumn(PartColumn, part)
+	return pk
+}
+
+// chunkPart pads the index so chunks sort in order.
+func chunkPart(generation string, index int64) string {
+	return fmt.Sprintf("chunk/%s/%012d", generation, index)
+}
+
+func newGeneration() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,322 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+// fakeTable keeps the rows of one table by key and part and checks the row
+// conditions the Store uses.
+type fakeTable struct {
+	mu   sync.Mutex
+	rows map[[2]string]map[string]interface{}
+	// failRows makes BatchWriteRow reject the first rows it is sent.
+	failRows int
+	ranges   int
+}
+
+func newFakeTable() *fakeTable {
+	return &fakeTable{rows: make(map[[2]string]map[string]interface{})}
+}
+
+func rowID(pk *tablestore.PrimaryKey) [2]string {
+	return [2]string{pk.PrimaryKeys[0].Value.(string), pk.PrimaryKeys[1].Value.(string)}
+}
+
+func (t *fakeTable) check(id [2]string, condition *tablestore.RowCondition) error {
+	row, exists := t.rows[id]
+	failed := &tablestore.OtsError{Code: "OTSConditionCheckFail", Message: "Condition check failed."}
+	switch condition.RowExistenceExpectation {
+	case tablestore.RowExistenceExpectation_EXPECT_EXIST:
+		if !exists {
+			return failed
+		}
+	case tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST:
+		if exists {
+			return failed
+		}
+	}
+	if c, ok := condition.ColumnCondition.(*tablestore.SingleColumnCondition); ok {
+		value, ok := row[*c.ColumnName]
+		if ok && value != c.ColumnValue || !ok && c.FilterIfMissing {
+			return failed
+		}
+	}
+	return nil
+}
+
+func (t *fakeTable) put(change *tablestore.PutRowChange) {
+	columns := make(map[string]interface{})
+	for _, col := range change.Columns {
+		columns[col.ColumnName] = col.Value
+	}
+	t.rows[rowID(change.PrimaryKey)] = columns
+}
+
+func (t *fakeTable) GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resp := &tablestore.GetRowResponse{}
+	for name, value := range t.rows[rowID(request.SingleRowQueryCriteria.PrimaryKey)] {
+		resp.Columns = append(resp.Columns, &tablestore.AttributeColumn{ColumnName: name, Value: value})
+	}
+	return resp, nil
+}
+
+func (t *fakeTable) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change := request.PutRowChange
+	if err := t.check(rowID(change.PrimaryKey), change.Condition); err != nil {
+		return nil, err
+	}
+	t.put(change)
+	return &tablestore.PutRowResponse{}, nil
+}
+
+func (t *fakeTable) DeleteRow(request *tablestore.DeleteRowRequest) (*tablestore.DeleteRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change := request.DeleteRowChange
+	if err := t.check(rowID(change.PrimaryKey), change.Condition); err != nil {
+		return nil, err
+	}
+	delete(t.rows, rowID(change.PrimaryKey))
+	return &tablestore.DeleteRowResponse{}, nil
+}
+
+func (t *fakeTable) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ranges++
+	criteria := request.RangeRowQueryCriteria
+	start, end := rowID(criteria.StartPrimaryKey), rowID(criteria.EndPrimaryKey)
+	var ids [][2]string
+	for id := range t.rows {
+		if id[0] == start[0] && id[1] >= start[1] && id[1] < end[1] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i][1] < ids[j][1] })
+	if criteria.Limit > 0 && len(ids) > int(criteria.Limit) {
+		ids = ids[:criteria.Limit]
+	}
+	resp := &tablestore.GetRangeResponse{}
+	for _, id := range ids {
+		row := &tablestore.Row{PrimaryKey: new(tablestore.PrimaryKey)}
+		row.PrimaryKey.AddPrimaryKeyColumn(KeyColumn, id[0])
+		row.PrimaryKey.AddPrimaryKeyColumn(PartColumn, id[1])
+		for name, value := range t.rows[id] {
+			row.Columns = append(row.Columns, &tablestore.AttributeColumn{ColumnName: name, Value: value})
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp, nil
+}
+
+func (t *fakeTable) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resp := &tablestore.BatchWriteRowResponse{TableToRowsResult: make(map[string][]tablestore.RowResult)}
+	for table, changes := range request.RowChangesGroupByTable {
+		for i, change := range changes {
+			result := tablestore.RowResult{TableName: table, IsSucceed: true, Index: int32(i)}
+			if t.failRows > 0 {
+				t.failRows--
+				result.IsSucceed = false
+				result.Error = tablestore.Error{Code: "OTSServerBusy", Message: "busy"}
+			} else {
+				switch change := change.(type) {
+				case *tablestore.PutRowChange:
+					t.put(change)
+				case *tablestore.DeleteRowChange:
+					delete(t.rows, rowID(change.PrimaryKey))
+				}
+			}
+			resp.TableToRowsResult[table] = append(resp.TableToRowsResult[table], result)
+		}
+	}
+	return resp, nil
+}
+
+func (t *fakeTable) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.rows)
+}
+
+func content(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	return data
+}
+
+func TestPutOpen(t *testing.T) {
+	table := newFakeTable()
+	store, err := New(table, Config{Table: "blobs", ChunkSize: 1000, MaxBatchBytes: 2500, Prefetch: 3})
+	assert.Nil(t, err)
+
+	data := content(10500)
+	sum := sha256.Sum256(data)
+	info, err := store.Put(context.Background(), "a", bytes.NewReader(data), &PutOptions{SHA256: hex.EncodeToString(sum[:])})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10500), info.Size)
+	assert.Equal(t, int64(11), info.Chunks)
+	assert.Equal(t, 12, table.count())
+
+	stat, err := store.Stat("a")
+	assert.Nil(t, err)
+	assert.Equal(t, info.SHA256, stat.SHA256)
+	assert.Equal(t, info.Generation, stat.Generation)
+
+	r, err := store.Open(context.Background(), "a")
+	assert.Nil(t, err)
+	read, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, read)
+	// 11 chunks, 3 per GetRange
+	assert.Equal(t, 4, table.ranges)
+
+	pos, err := r.Seek(-600, io.SeekEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9900), pos)
+	buf := make([]byte, 200)
+	_, err = io.ReadFull(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, data[9900:10100], buf)
+
+	_, err = r.Seek(123, io.SeekStart)
+	assert.Nil(t, err)
+	_, err = io.ReadFull(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, data[123:323], buf)
+
+	_, err = r.Seek(-1, io.SeekStart)
+	assert.NotNil(t, err)
+
+	_, err = store.Stat("missing")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestEmptyObject(t *testing.T) {
+	table := newFakeTable()
+	store, _ := New(table, Config{Table: "blobs"})
+	info, err := store.Put(context.Background(), "empty", bytes.NewReader(nil), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), info.Chunks)
+
+	r, err := store.Open(context.Background(), "empty")
+	assert.Nil(t, err)
+	read, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Empty(t, read)
+}
+
+func TestOverwriteAndDelete(t *testing.T) {
+	table := newFakeTable()
+	store, _ := New(table, Config{Table: "blobs", ChunkSize: 100})
+	ctx := context.Background()
+
+	_, err := store.Put(ctx, "a", bytes.NewReader(content(1000)), nil)
+	assert.Nil(t, err)
+	old, err := store.Open(ctx, "a")
+	assert.Nil(t, err)
+
+	data := content(350)
+	_, err = store.Put(ctx, "a", bytes.NewReader(data), nil)
+	assert.Nil(t, err)
+	// the chunks of the first generation are gone
+	assert.Equal(t, 5, table.count())
+
+	_, err = ioutil.ReadAll(old)
+	assert.True(t, errors.Is(err, ErrChanged))
+
+	r, _ := store.Open(ctx, "a")
+	read, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, read)
+
+	// a wrong hash leaves the object as it is
+	_, err = store.Put(ctx, "a", bytes.NewReader(content(500)), &PutOptions{SHA256: "00"})
+	assert.True(t, errors.Is(err, ErrHashMismatch))
+	assert.Equal(t, 5, table.count())
+
+	assert.Nil(t, store.Delete(ctx, "a"))
+	assert.Equal(t, 0, table.count())
+	assert.True(t, errors.Is(store.Delete(ctx, "a"), ErrNotFound))
+}
+
+// conflictTable replaces the manifest between the Stat and the manifest
+// write of a Put.
+type conflictTable struct {
+	*fakeTable
+	manifest map[string]interface{}
+	once     sync.Once
+}
+
+func (t *conflictTable) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	t.once.Do(func() {
+		t.mu.Lock()
+		t.rows[[2]string{"a", manifestPart}] = t.manifest
+		t.mu.Unlock()
+	})
+	return t.fakeTable.PutRow(request)
+}
+
+func TestConflict(t *testing.T) {
+	table := &conflictTable{fakeTable: newFakeTable(), manifest: map[string]interface{}{generationColumn: "other"}}
+	store, _ := New(table, Config{Table: "blobs", ChunkSize: 100})
+	_, err := store.Put(context.Background(), "a", bytes.NewReader(content(450)), nil)
+	assert.True(t, errors.Is(err, ErrConflict))
+	// only the manifest of the other writer is left
+	assert.Equal(t, 1, table.count())
+
+	// a manifest without a generation does not match the one read either
+	table = &conflictTable{fakeTable: newFakeTable(), manifest: map[string]interface{}{sizeColumn: int64(0)}}
+	store, _ = New(table.fakeTable, Config{Table: "blobs", ChunkSize: 100})
+	_, err = store.Put(context.Background(), "a", bytes.NewReader(content(450)), nil)
+	assert.Nil(t, err)
+	store, _ = New(table, Config{Table: "blobs", ChunkSize: 100})
+	_, err = store.Put(context.Background(), "a", bytes.NewReader(content(250)), nil)
+	assert.True(t, errors.Is(err, ErrConflict))
+}
+
+func TestRetriesAndCorruption(t *testing.T) {
+	table := newFakeTable()
+	table.failRows = 3
+	store, _ := New(table, Config{Table: "blobs", ChunkSize: 100})
+	ctx := context.Background()
+
+	data := content(800)
+	_, err := store.Put(ctx, "a", bytes.NewReader(data), nil)
+	assert.Nil(t, err)
+
+	info, _ := store.Stat("a")
+	table.rows[[2]string{"a", chunkPart(info.Generation, 2)}][dataColumn] = content(100)
+	r, _ := store.Open(ctx, "a")
+	_, err = ioutil.ReadAll(r)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, Config{Table: "blobs"})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = New(newFakeTable(), Config{})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = New(newFakeTable(), Config{Table: "blobs", ChunkSize: 4 << 20})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
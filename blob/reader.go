@@ -0,0 +1,146 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// Reader reads an object opened by Store.Open. It fetches chunks lazily,
+// Prefetch chunks per GetRange, and checks the hash of each chunk. A Reader
+// is not safe for concurrent use.
+type Reader struct {
+	store *Store
+	ctx   context.Context
+	info  *Info
+
+	offset int64
+	// chunks holds the fetched chunks from index first on.
+	first  int64
+	chunks [][]byte
+}
+
+// Open returns a Reader of the current generation of an object. If the
+// object is overwritten or deleted while it is read, Read fails with
+// ErrChanged once it needs a chunk that is gone.
+func (s *Store) Open(ctx context.Context, key string) (*Reader, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{store: s, ctx: ctx, info: info}, nil
+}
+
+func (r *Reader) Info() *Info {
+	return r.info
+}
+
+func (r *Reader) Size() int64 {
+	return r.info.Size
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.info.Size {
+		return 0, io.EOF
+	}
+	index := r.offset / r.info.ChunkSize
+	chunk, err := r.chunk(index)
+	if err != nil {
+		return 0, err
+	}
+	start := r.offset - index*r.info.ChunkSize
+	if start >= int64(len(chunk)) {
+		return 0, fmt.Errorf("%w: chunk %d of %s is shorter than expected", ErrCorrupted, index, r.info.Key)
+	}
+	n := copy(p, chunk[start:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.info.Size
+	default:
+		return 0, errors.New("[blob] invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("[blob] negative position")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *Reader) chunk(index int64) ([]byte, error) {
+	if index >= r.first && index < r.first+int64(len(r.chunks)) {
+		return r.chunks[index-r.first], nil
+	}
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	chunks, err := r.fetch(index)
+	if err != nil {
+		return nil, err
+	}
+	r.first, r.chunks = index, chunks
+	return chunks[0], nil
+}
+
+// fetch reads up to Prefetch chunks from index on. GetRange may return
+// fewer rows than asked for, the ones it returns must follow each other.
+func (r *Reader) fetch(index int64) ([][]byte, error) {
+	s := r.store
+	end := index + int64(s.config.Prefetch)
+	if end > r.info.Chunks {
+		end = r.info.Chunks
+	}
+	criteria := &tablestore.RangeRowQueryCriteria{
+		TableName:       s.config.Table,
+		StartPrimaryKey: s.primaryKey(r.info.Key, chunkPart(r.info.Generation, index)),
+		EndPrimaryKey:   s.primaryKey(r.info.Key, chunkPart(r.info.Generation, end)),
+		ColumnsToGet:    []string{dataColumn, sha256Column},
+		MaxVersion:      1,
+		Direction:       tablestore.FORWARD,
+		Limit:           int32(end - index),
+	}
+	resp, err := s.client.GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: criteria})
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks [][]byte
+	for i, row := range resp.Rows {
+		want := chunkPart(r.info.Generation, index+int64(i))
+		if len(row.PrimaryKey.PrimaryKeys) != 2 || row.PrimaryKey.PrimaryKeys[1].Value != want {
+			break
+		}
+		var data []byte
+		var sum string
+		for _, col := range row.Columns {
+			switch col.ColumnName {
+			case dataColumn:
+				data, _ = col.Value.([]byte)
+			case sha256Column:
+				sum, _ = col.Value.(string)
+			}
+		}
+		actual := sha256.Sum256(data)
+		if !strings.EqualFold(sum, hex.EncodeToString(actual[:])) {
+			return nil, fmt.Errorf("%w: chunk %d of %s", ErrCorrupted, index+int64(i), r.info.Key)
+		}
+		chunks = append(chunks, data)
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("%w: chunk %d of %s is missing", ErrChanged, index, r.info.Key)
+	}
+	return chunks, nil
+}
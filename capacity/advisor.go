@@ -0,0 +1,281 @@
+// Package capacity sizes the reserved throughput of tables from the
+// capacity units they actually consume.
+//
+// An Advisor reads the usage that a tablestore.UsageAccountant recorded for
+// a table, compares it with the ReservedThroughput of DescribeTable and
+// recommends reserved read and write capacity that would run the peak rate
+// of the window at the target utilization. It recommends nothing until the
+// accountant has been recording for a whole window. When Apply is set it
+// calls UpdateTable for the tables it has Bounds for, at most once per
+// MinUpdateInterval per table and with at most MaxDecreasesPerDay decreases
+// per table over 24 hours.
+//
+// The usage is the one of this process only: an accountant sees the
+// requests of the clients it is installed on. When several processes share
+// a table, run the Advisor with Apply only where the accountant sees all
+// of its traffic, or its recommendations undersize the table.
+package capacity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var ErrInvalidConfig = errors.New("[capacity] invalid config")
+
+const (
+	DefaultWindow             = 5 * time.Minute
+	DefaultTargetUtilization  = 0.7
+	DefaultMinChange          = 0.2
+	DefaultMinUpdateInterval  = 15 * time.Minute
+	DefaultMaxDecreasesPerDay = 4
+)
+
+// Client is the part of *tablestore.TableStoreClient used by an Advisor.
+type Client interface {
+	DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error)
+	UpdateTable(request *tablestore.UpdateTableRequest) (*tablestore.UpdateTableResponse, error)
+}
+
+// Bounds limit the reserved capacity the Advisor recommends for a table.
+// A zero maximum means no upper bound.
+type Bounds struct {
+	MinRead, MaxRead   int
+	MinWrite, MaxWrite int
+}
+
+type Config struct {
+	// Usage records the capacity consumed by the clients of this process.
+	Usage *tablestore.UsageAccountant
+	// Window is the period of usage a recommendation is based on, it is
+	// capped at the retention of Usage.
+	Window time.Duration
+	// TargetUtilization is the share of the reserved capacity the observed
+	// rate should use, between 0 and 1.
+	TargetUtilization float64
+	// MinChange is the smallest change, relative to the current reserved
+	// capacity, that is recommended. Smaller ones keep the current value.
+	MinChange float64
+	// Bounds by table name.
+	Bounds map[string]Bounds
+
+	// Apply makes Check call UpdateTable for tables that have Bounds.
+	Apply              bool
+	MinUpdateInterval  time.Duration
+	MaxDecreasesPerDay int
+}
+
+type Recommendation struct {
+	Table string
+	Usage *tablestore.TableUsage
+	// CurrentRead and CurrentWrite are the reserved capacity of the table.
+	CurrentRead, CurrentWrite int
+	// Read and Write are the recommended reserved capacity.
+	Read, Write int
+	// Applied is set when UpdateTable was called with Read and Write.
+	Applied bool
+	// Reason tells why a changed recommendation was not applied, or why
+	// the current capacity was kept without looking at the usage.
+	Reason string
+}
+
+func (r *Recommendation) Changed() bool {
+	return r.Read != r.CurrentRead || r.Write != r.CurrentWrite
+}
+
+func (r *Recommendation) String() string {
+	s := fmt.Sprintf("%s: read peak %.1f/s reserved %d -> %d, write peak %.1f/s reserved %d -> %d",
+		r.Table, r.Usage.PeakReadPerSecond, r.CurrentRead, r.Read, r.Usage.PeakWritePerSecond, r.CurrentWrite, r.Write)
+	switch {
+	case r.Applied:
+		s += ", applied"
+	case r.Reason != "":
+		s += ", not applied: " + r.Reason
+	}
+	return s
+}
+
+type Advisor struct {
+	client Client
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	updates map[string]*tableUpdates
+}
+
+// tableUpdates remembers the last update of a table and its recent
+// decreases for the rate limits.
+type tableUpdates struct {
+	last      time.Time
+	decreases []time.Time
+}
+
+func NewAdvisor(client Client, config Config) (*Advisor, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: client is required", ErrInvalidConfig)
+	}
+	if config.Usage == nil {
+		return nil, fmt.Errorf("%w: usage accountant is required", ErrInvalidConfig)
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultWindow
+	}
+	if config.TargetUtilization == 0 {
+		config.TargetUtilization = DefaultTargetUtilization
+	}
+	if config.TargetUtilization < 0 || config.TargetUtilization > 1 {
+		return nil, fmt.Errorf("%w: target utilization %v is not between 0 and 1", ErrInvalidConfig, config.TargetUtilization)
+	}
+	if config.MinChange <= 0 {
+		config.MinChange = DefaultMinChange
+	}
+	if config.MinUpdateInterval <= 0 {
+		config.MinUpdateInterval = DefaultMinUpdateInterval
+	}
+	if config.MaxDecreasesPerDay <= 0 {
+		config.MaxDecreasesPerDay = DefaultMaxDecreasesPerDay
+	}
+	for table, bounds := range config.Bounds {
+		if bounds.MinRead < 0 || bounds.MinWrite < 0 ||
+			(bounds.MaxRead > 0 && bounds.MaxRead < bounds.MinRead) ||
+			(bounds.MaxWrite > 0 && bounds.MaxWrite < bounds.MinWrite) {
+			return nil, fmt.Errorf("%w: invalid bounds of table %s", ErrInvalidConfig, table)
+		}
+	}
+	return &Advisor{client: client, config: config, now: time.Now, updates: make(map[string]*tableUpdates)}, nil
+}
+
+// Advise recommends reserved capacity for a table without changing it. It
+// keeps the current capacity while the usage does not cover the window.
+func (a *Advisor) Advise(table string) (*Recommendation, error) {
+	resp, err := a.client.DescribeTable(&tablestore.DescribeTableRequest{TableName: table})
+	if err != nil {
+		return nil, err
+	}
+	r := &Recommendation{Table: table, Usage: a.config.Usage.Usage(table, a.config.Window)}
+	if resp.ReservedThroughput != nil {
+		r.CurrentRead, r.CurrentWrite = resp.ReservedThroughput.Readcap, resp.ReservedThroughput.Writecap
+	}
+	if covered := a.now().Sub(r.Usage.Since); covered < r.Usage.Window {
+		r.Read, r.Write = r.CurrentRead, r.CurrentWrite
+		r.Reason = fmt.Sprintf("usage covers %s of the %s window", covered.Round(time.Second), r.Usage.Window)
+		return r, nil
+	}
+	bounds := a.config.Bounds[table]
+	r.Read = a.recommend(r.CurrentRead, r.Usage.PeakReadPerSecond, bounds.MinRead, bounds.MaxRead)
+	r.Write = a.recommend(r.CurrentWrite, r.Usage.PeakWritePerSecond, bounds.MinWrite, bounds.MaxWrite)
+	return r, nil
+}
+
+// recommend sizes capacity for the peak rate. The result is below the peak
+// only when max is.
+func (a *Advisor) recommend(current int, peak float64, min, max int) int {
+	target := int(math.Ceil(peak / a.config.TargetUtilization))
+	if target < min {
+		target = min
+	}
+	if max > 0 && target > max {
+		target = max
+	}
+	// a current value out of bounds is always corrected
+	// a small change is skipped, unless the current value is below the peak
+	inBounds := current >= min && (max == 0 || current <= max)
+	if inBounds && float64(current) >= peak && math.Abs(float64(target-current)) < a.config.MinChange*float64(current) {
+		return current
+	}
+	return target
+}
+
+// Check advises on each table and, when Apply is set, updates the reserved
+// capacity of the tables that have Bounds within the rate limits. It stops
+// at the first error and returns the recommendations made so far.
+func (a *Advisor) Check(ctx context.Context, tables ...string) ([]*Recommendation, error) {
+	var recommendations []*Recommendation
+	for _, table := range tables {
+		if err := ctx.Err(); err != nil {
+			return recommendations, err
+		}
+		r, err := a.Advise(table)
+		if err != nil {
+			return recommendations, err
+		}
+		recommendations = append(recommendations, r)
+		if !a.config.Apply || !r.Changed() {
+			continue
+		}
+		if _, ok := a.config.Bounds[table]; !ok {
+			r.Reason = "no bounds configured"
+			continue
+		}
+		if err := a.apply(r); err != nil {
+			return recommendations, err
+		}
+	}
+	return recommendations, nil
+}
+
+func (a *Advisor) apply(r *Recommendation) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	updates, ok := a.updates[r.Table]
+	if !ok {
+		updates = new(tableUpdates)
+		a.updates[r.Table] = updates
+	}
+	if !updates.last.IsZero() && now.Sub(updates.last) < a.config.MinUpdateInterval {
+		r.Reason = fmt.Sprintf("updated %s ago", now.Sub(updates.last).Round(time.Second))
+		return nil
+	}
+	var recent []time.Time
+	for _, t := range updates.decreases {
+		if now.Sub(t) < 24*time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	updates.decreases = recent
+	decrease := r.Read < r.CurrentRead || r.Write < r.CurrentWrite
+	if decrease && len(recent) >= a.config.MaxDecreasesPerDay {
+		r.Reason = fmt.Sprintf("%d decreases in the last 24 hours", len(recent))
+		return nil
+	}
+
+	request := &tablestore.UpdateTableRequest{
+		TableName:          r.Table,
+		ReservedThroughput: &tablestore.ReservedThroughput{Readcap: r.Read, Writecap: r.Write},
+	}
+	if _, err := a.client.UpdateTable(request); err != nil {
+		return err
+	}
+	r.Applied = true
+	updates.last = now
+	if decrease {
+		updates.decreases = append(updates.decreases, now)
+	}
+	return nil
+}
+
+// Run calls Check every interval until ctx is done, passing the
+// recommendations and error of each round to report.
+func (a *Advisor) Run(ctx context.Context, interval time.Duration, tables []string, report func([]*Recommendation, error)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		recommendations, err := a.Check(ctx, tables...)
+		if report != nil && ctx.Err() == nil {
+			report(recommendations, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package capacity
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+type fakeClient struct {
+	reserved map[string]*tablestore.ReservedThroughput
+	updates  []*tablestore.UpdateTableRequest
+}
+
+func (c *fakeClient) DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error) {
+	reserved, ok := c.reserved[request.TableName]
+	if !ok {
+		return nil, &tablestore.OtsError{Code: "OTSObjectNotExist"}
+	}
+	copied := *reserved
+	return &tablestore.DescribeTableResponse{ReservedThroughput: &copied}, nil
+}
+
+func (c *fakeClient) UpdateTable(request *tablestore.UpdateTableRequest) (*tablestore.UpdateTableResponse, error) {
+	c.updates = append(c.updates, request)
+	*c.reserved[request.TableName] = *request.ReservedThroughput
+	return &tablestore.UpdateTableResponse{}, nil
+}
+
+func TestAdvise(t *testing.T) {
+	usage := tablestore.NewUsageAccountant(time.Minute, time.Second)
+	client := &fakeClient{reserved: map[string]*tablestore.ReservedThroughput{
+		"users":  {Readcap: 10, Writecap: 100},
+		"orders": {Readcap: 0, Writecap: 0},
+	}}
+	advisor, err := NewAdvisor(client, Config{
+		Usage:  usage,
+		Window: 10 * time.Second,
+		Bounds: map[string]Bounds{"orders": {MinRead: 5, MaxRead: 50}},
+	})
+	assert.Nil(t, err)
+
+	// a peak of 70 read CU and 90 write CU in one second
+	usage.Record("users", "GetRow", &tablestore.ConsumedCapacityUnit{Read: 70})
+	usage.Record("users", "PutRow", &tablestore.ConsumedCapacityUnit{Write: 90})
+	usage.Record("orders", "GetRange", &tablestore.ConsumedCapacityUnit{Read: 700})
+
+	// the accountant has not been recording for a whole window yet
+	r, err := advisor.Advise("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 10, r.Read)
+	assert.Equal(t, 100, r.Write)
+	assert.False(t, r.Changed())
+	assert.Contains(t, r.Reason, "of the 10s window")
+
+	advisor.now = func() time.Time { return time.Now().Add(time.Minute) }
+	r, err = advisor.Advise("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 10, r.CurrentRead)
+	assert.Equal(t, 100, r.Read)
+	// 90/0.7 rounds up to 129, more than 20% above the current 100
+	assert.Equal(t, 129, r.Write)
+	assert.True(t, r.Changed())
+	assert.Contains(t, r.String(), "users: read peak 70.0/s reserved 10 -> 100")
+
+	r, err = advisor.Advise("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, 50, r.Read)
+	assert.Equal(t, 0, r.Write)
+
+	_, err = advisor.Advise("missing")
+	assert.NotNil(t, err)
+}
+
+func TestMinChange(t *testing.T) {
+	usage := tablestore.NewUsageAccountant(time.Minute, time.Second)
+	client := &fakeClient{reserved: map[string]*tablestore.ReservedThroughput{"users": {Readcap: 100, Writecap: 10}}}
+	advisor, _ := NewAdvisor(client, Config{Usage: usage, Window: 10 * time.Second, Bounds: map[string]Bounds{"users": {MinWrite: 20}}})
+	advisor.now = func() time.Time { return time.Now().Add(time.Minute) }
+
+	// 63/s needs 90 read CU, within 20% of 100. The write capacity is below
+	// its bound and raised although nothing was written.
+	usage.Record("users", "GetRow", &tablestore.ConsumedCapacityUnit{Read: 63})
+	r, err := advisor.Advise("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 100, r.Read)
+	assert.Equal(t, 20, r.Write)
+
+	// 105/s is within 20% of 100 too, but above the current value
+	usage = tablestore.NewUsageAccountant(time.Minute, time.Second)
+	advisor, _ = NewAdvisor(client, Config{Usage: usage, Window: 10 * time.Second, TargetUtilization: 1})
+	advisor.now = func() time.Time { return time.Now().Add(time.Minute) }
+	usage.Record("users", "GetRow", &tablestore.ConsumedCapacityUnit{Read: 105})
+	r, err = advisor.Advise("users")
+	assert.Nil(t, err)
+	assert.Equal(t, 105, r.Read)
+}
+
+func TestCheckApply(t *testing.T) {
+	usage := tablestore.NewUsageAccountant(time.Minute, time.Second)
+	client := &fakeClient{reserved: map[string]*tablestore.ReservedThroughput{
+		"users":  {Readcap: 1000, Writecap: 0},
+		"orders": {Readcap: 0, Writecap: 0},
+	}}
+	now := time.Now().Add(time.Minute)
+	advisor, err := NewAdvisor(client, Config{
+		Usage:              usage,
+		Window:             10 * time.Second,
+		Bounds:             map[string]Bounds{"users": {MaxRead: 2000}},
+		Apply:              true,
+		MinUpdateInterval:  time.Minute,
+		MaxDecreasesPerDay: 1,
+	})
+	assert.Nil(t, err)
+	advisor.now = func() time.Time { return now }
+
+	usage.Record("users", "GetRow", &tablestore.ConsumedCapacityUnit{Read: 7})
+	usage.Record("orders", "GetRow", &tablestore.ConsumedCapacityUnit{Read: 7})
+
+	recommendations, err := advisor.Check(context.Background(), "users", "orders")
+	assert.Nil(t, err)
+	assert.Len(t, recommendations, 2)
+	assert.True(t, recommendations[0].Applied)
+	assert.Equal(t, 10, client.reserved["users"].Readcap)
+	// orders has no bounds, so it is only advised
+	assert.False(t, recommendations[1].Applied)
+	assert.Equal(t, "no bounds configured", recommendations[1].Reason)
+	assert.Len(t, client.updates, 1)
+
+	// too soon after the last update
+	usage.Record("users", "GetRow", &tablestore.ConsumedCapacityUnit{Read: 7000})
+	now = now.Add(30 * time.Second)
+	recommendations, _ = advisor.Check(context.Background(), "users")
+	assert.False(t, recommendations[0].Applied)
+	assert.Contains(t, recommendations[0].Reason, "updated 30s ago")
+
+	// increases are not limited per day
+	now = now.Add(time.Minute)
+	recommendations, _ = advisor.Check(context.Background(), "users")
+	assert.True(t, recommendations[0].Applied)
+	assert.Equal(t, 2000, client.reserved["users"].Readcap)
+
+	// the second decrease of the day is refused
+	usage.Reset()
+	now = now.Add(time.Minute)
+	recommendations, _ = advisor.Check(context.Background(), "users")
+	assert.False(t, recommendations[0].Applied)
+	assert.Equal(t, "1 decreases in the last 24 hours", recommendations[0].Reason)
+
+	now = now.Add(24 * time.Hour)
+	recommendations, _ = advisor.Check(context.Background(), "users")
+	assert.True(t, recommendations[0].Applied)
+	assert.Equal(t, 0, client.reserved["users"].Readcap)
+}
+
+func TestNewAdvisor(t *testing.T) {
+	usage := tablestore.NewUsageAccountant(0, 0)
+	_, err := NewAdvisor(nil, Config{Usage: usage})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = NewAdvisor(&fakeClient{}, Config{})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = NewAdvisor(&fakeClient{}, Config{Usage: usage, TargetUtilization: 1.5})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = NewAdvisor(&fakeClient{}, Config{Usage: usage, Bounds: map[string]Bounds{"t": {MinRead: 10, MaxRead: 5}}})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
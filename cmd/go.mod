@@ -0,0 +1,11 @@
+module github.com/aliyun/aliyun-tablestore-go-sdk/cmd
+
+go 1.13
+
+require (
+	github.com/aliyun/aliyun-tablestore-go-sdk v0.0.0
+	github.com/stretchr/testify v1.7.0
+	go.uber.org/zap v1.19.0
+)
+
+replace github.com/aliyun/aliyun-tablestore-go-sdk => ../
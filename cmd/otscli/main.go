@@ -0,0 +1,249 @@
+// Command otscli runs everyday table operations from the shell.
+//
+//	otscli [-profile name] [-output table|json|csv] <command> [flags]
+//
+// Commands:
+//
+//	table list|describe|create|update|delete
+//	row get|put|update|delete
+//	range
+//	search
+//	sql
+//	index list|describe
+//	tunnel list|describe|tail
+//	timeseries query
+//
+// Run a command with -h for its flags. Credentials come from a profile in
+// ~/.otscli/config, see profile.go for the format. Without a config file the
+// OTS_ENDPOINT, OTS_INSTANCE, OTS_AK_ID, OTS_AK_SECRET and OTS_STS_TOKEN
+// environment variables are used.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tunnel"
+)
+
+// errUsage makes main print the usage of the command instead of an error.
+var errUsage = errors.New("usage")
+
+type command struct {
+	usage string
+	run   func(env *env, args []string) error
+}
+
+// commands are keyed by their name, a group and an action for most of them.
+var commands = map[string]*command{
+	"table list":       {"", tableList},
+	"table describe":   {"-table NAME", tableDescribe},
+	"table create":     {"-table NAME -pk NAME:TYPE... [-col NAME:TYPE...] [options]", tableCreate},
+	"table update":     {"-table NAME [options]", tableUpdate},
+	"table delete":     {"-table NAME -yes", tableDelete},
+	"row get":          {"-table NAME -pk NAME=VALUE...", rowGet},
+	"row put":          {"-table NAME -pk NAME=VALUE... -col NAME[:TYPE]=VALUE...", rowPut},
+	"row update":       {"-table NAME -pk NAME=VALUE... [-col NAME[:TYPE]=VALUE...] [-delete NAME...]", rowUpdate},
+	"row delete":       {"-table NAME -pk NAME=VALUE...", rowDelete},
+	"range":            {"-table NAME [-start NAME=VALUE...] [-end NAME=VALUE...]", rangeRows},
+	"search":           {"-table NAME -index NAME [-query JSON]", searchRows},
+	"sql":              {"QUERY", sqlQuery},
+	"index list":       {"-table NAME", indexList},
+	"index describe":   {"-table NAME -index NAME", indexDescribe},
+	"tunnel list":      {"-table NAME", tunnelList},
+	"tunnel describe":  {"-table NAME -tunnel NAME", tunnelDescribe},
+	"tunnel tail":      {"-table NAME -tunnel NAME -consume", tunnelTail},
+	"timeseries query": {"-table NAME -measurement NAME [-source NAME] [-tag NAME=VALUE...]", timeseriesQuery},
+}
+
+// env is shared by the commands, clients are created on first use.
+type env struct {
+	ctx     context.Context
+	profile *profile
+	out     *printer
+
+	client           *tablestore.TableStoreClient
+	tunnelClient     tunnel.TunnelClient
+	timeseriesClient *tablestore.TimeseriesClient
+	metas            map[string]*tablestore.TableMeta
+}
+
+func (e *env) tableClient() *tablestore.TableStoreClient {
+	if e.client == nil {
+		p := e.profile
+		e.client = tablestore.NewClientWithConfig(p.Endpoint, p.Instance, p.AccessKeyId, p.AccessKeySecret, p.SecurityToken, nil)
+	}
+	return e.client
+}
+
+func (e *env) tunnel() tunnel.TunnelClient {
+	if e.tunnelClient == nil {
+		p := e.profile
+		e.tunnelClient = tunnel.NewTunnelClientWithToken(p.Endpoint, p.Instance, p.AccessKeyId, p.AccessKeySecret, p.SecurityToken, nil)
+	}
+	return e.tunnelClient
+}
+
+func (e *env) timeseries() *tablestore.TimeseriesClient {
+	if e.timeseriesClient == nil {
+		p := e.profile
+		e.timeseriesClient = tablestore.NewTimeseriesClientWithConfig(p.Endpoint, p.Instance, p.AccessKeyId, p.AccessKeySecret, p.SecurityToken, nil, nil)
+	}
+	return e.timeseriesClient
+}
+
+// tableMeta describes a table once, commands need it to type primary key and
+// column values given on the command line.
+func (e *env) tableMeta(table string) (*tablestore.TableMeta, error) {
+	if meta, ok := e.metas[table]; ok {
+		return meta, nil
+	}
+	resp, err := e.tableClient().DescribeTable(&tablestore.DescribeTableRequest{TableName: table})
+	if err != nil {
+		return nil, err
+	}
+	if e.metas == nil {
+		e.metas = make(map[string]*tablestore.TableMeta)
+	}
+	e.metas[table] = resp.TableMeta
+	return resp.TableMeta, nil
+}
+
+func main() {
+	var (
+		profileName = flag.String("profile", os.Getenv("OTSCLI_PROFILE"), "profile in the config file, default when empty")
+		configPath  = flag.String("config", os.Getenv("OTSCLI_CONFIG"), "config file, ~/.otscli/config when empty")
+		output      = flag.String("output", "table", "output format: table, json or csv")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	name, cmd, args := lookup(flag.Args())
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+	out, err := newPrinter(os.Stdout, *output)
+	if err != nil {
+		fatal(err)
+	}
+	p, err := loadProfile(*configPath, *profileName)
+	if err != nil {
+		fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	err = cmd.run(&env{ctx: ctx, profile: p, out: out}, args)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "usage: otscli %s %s\n", name, cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+// lookup finds the command named by the first one or two arguments.
+func lookup(args []string) (string, *command, []string) {
+	if len(args) >= 2 {
+		name := args[0] + " " + args[1]
+		if cmd, ok := commands[name]; ok {
+			return name, cmd, args[2:]
+		}
+	}
+	if len(args) >= 1 {
+		if cmd, ok := commands[args[0]]; ok {
+			return args[0], cmd, args[1:]
+		}
+	}
+	return "", nil, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: otscli [-profile name] [-output table|json|csv] <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nglobal flags:")
+	flag.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "otscli:", err)
+	os.Exit(1)
+}
+
+// newFlags returns a flag set whose errors are returned rather than exiting,
+// so that -h prints the flags of the command.
+func newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet("otscli "+name, flag.ContinueOnError)
+	flags.SetOutput(os.Stderr)
+	return flags
+}
+
+// listFlag collects a flag given several times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// required reports errUsage unless every value is set.
+func required(values ...string) error {
+	for _, value := range values {
+		if value == "" {
+			return errUsage
+		}
+	}
+	return nil
+}
+
+// readArg returns the value of a flag, or the content of a file when the
+// value starts with @, or stdin for @-.
+func readArg(value string) (string, error) {
+	if !strings.HasPrefix(value, "@") {
+		return value, nil
+	}
+	var r io.Reader = os.Stdin
+	if value != "@-" {
+		f, err := os.Open(value[1:])
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+	data, err := ioutil.ReadAll(r)
+	return string(data), err
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	name, cmd, args := lookup([]string{"row", "get", "-table", "t"})
+	assert.Equal(t, "row get", name)
+	assert.NotNil(t, cmd)
+	assert.Equal(t, []string{"-table", "t"}, args)
+
+	name, cmd, args = lookup([]string{"sql", "select 1"})
+	assert.Equal(t, "sql", name)
+	assert.NotNil(t, cmd)
+	assert.Equal(t, []string{"select 1"}, args)
+
+	_, cmd, _ = lookup([]string{"row"})
+	assert.Nil(t, cmd)
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "otscli")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config")
+	config := `
+# comment
+[default]
+endpoint = https://a.cn-hangzhou.ots.aliyuncs.com
+instance = a
+access_key_id = id
+access_key_secret = secret
+
+[sts]
+endpoint = https://b.cn-hangzhou.ots.aliyuncs.com
+instance = b
+access_key_id = sts-id
+access_key_secret = sts-secret
+security_token = token
+
+[broken]
+endpoint = https://c.cn-hangzhou.ots.aliyuncs.com
+`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(config), 0600))
+
+	p, err := loadProfile(path, "")
+	assert.Nil(t, err)
+	assert.Equal(t, &profile{Endpoint: "https://a.cn-hangzhou.ots.aliyuncs.com", Instance: "a", AccessKeyId: "id", AccessKeySecret: "secret"}, p)
+
+	p, err = loadProfile(path, "sts")
+	assert.Nil(t, err)
+	assert.Equal(t, "token", p.SecurityToken)
+
+	_, err = loadProfile(path, "broken")
+	assert.NotNil(t, err)
+	_, err = loadProfile(path, "missing")
+	assert.NotNil(t, err)
+
+	_, err = parseProfiles(strings.NewReader("endpoint = x\n"))
+	assert.NotNil(t, err)
+	_, err = parseProfiles(strings.NewReader("[a]\nregion = x\n"))
+	assert.NotNil(t, err)
+}
+
+func testMeta() *tablestore.TableMeta {
+	meta := &tablestore.TableMeta{TableName: "t"}
+	meta.AddPrimaryKeyColumn("user", tablestore.PrimaryKeyType_STRING)
+	meta.AddPrimaryKeyColumn("seq", tablestore.PrimaryKeyType_INTEGER)
+	meta.AddPrimaryKeyColumnOption("id", tablestore.PrimaryKeyType_INTEGER, tablestore.AUTO_INCREMENT)
+	meta.AddDefinedColumn("amount", tablestore.DefinedColumn_DOUBLE)
+	return meta
+}
+
+func TestParsePrimaryKey(t *testing.T) {
+	meta := testMeta()
+	pk, err := parsePrimaryKey(meta, []string{"seq=7", "user=a=b"}, exactKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "user", pk.PrimaryKeys[0].ColumnName)
+	assert.Equal(t, "a=b", pk.PrimaryKeys[0].Value)
+	assert.Equal(t, int64(7), pk.PrimaryKeys[1].Value)
+	assert.Equal(t, tablestore.AUTO_INCREMENT, pk.PrimaryKeys[2].PrimaryKeyOption)
+
+	_, err = parsePrimaryKey(meta, []string{"user=a"}, exactKey)
+	assert.NotNil(t, err)
+	_, err = parsePrimaryKey(meta, []string{"user=a", "seq=x"}, exactKey)
+	assert.NotNil(t, err)
+	_, err = parsePrimaryKey(meta, []string{"user=a", "seq=1", "other=1"}, exactKey)
+	assert.NotNil(t, err)
+
+	pk, err = parsePrimaryKey(meta, []string{"user=a", "seq=MAX"}, startKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", pk.PrimaryKeys[0].Value)
+	assert.Equal(t, tablestore.MAX, pk.PrimaryKeys[1].PrimaryKeyOption)
+	assert.Equal(t, tablestore.MIN, pk.PrimaryKeys[2].PrimaryKeyOption)
+
+	pk, err = parsePrimaryKey(meta, nil, endKey)
+	assert.Nil(t, err)
+	for _, col := range pk.PrimaryKeys {
+		assert.Equal(t, tablestore.MAX, col.PrimaryKeyOption)
+	}
+}
+
+func TestParseColumn(t *testing.T) {
+	meta := testMeta()
+	name, value, err := parseColumn(meta, "amount=1.5")
+	assert.Nil(t, err)
+	assert.Equal(t, "amount", name)
+	assert.Equal(t, 1.5, value)
+
+	name, value, err = parseColumn(meta, "count:INTEGER=3")
+	assert.Nil(t, err)
+	assert.Equal(t, "count", name)
+	assert.Equal(t, int64(3), value)
+
+	_, value, err = parseColumn(meta, "note=12")
+	assert.Nil(t, err)
+	assert.Equal(t, "12", value)
+
+	_, value, err = parseColumn(meta, "raw:binary=AQI=")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2}, value)
+
+	_, _, err = parseColumn(meta, "amount=x")
+	assert.NotNil(t, err)
+	_, _, err = parseColumn(meta, "a:DATE=1")
+	assert.NotNil(t, err)
+}
+
+func TestParseQuery(t *testing.T) {
+	query, err := parseQuery(`{"Name":"TermQuery","Query":{"FieldName":"status","Term":"paid"}}`)
+	assert.Nil(t, err)
+	assert.Equal(t, &search.TermQuery{FieldName: "status", Term: "paid"}, query)
+
+	query, err = parseQuery("")
+	assert.Nil(t, err)
+	assert.Equal(t, search.QueryType_MatchAllQuery, query.Type())
+
+	_, err = parseQuery(`{"Name":"NoSuchQuery","Query":{}}`)
+	assert.NotNil(t, err)
+	_, err = parseQuery(`{"FieldName":"status"}`)
+	assert.NotNil(t, err)
+}
+
+func TestPrinter(t *testing.T) {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn("id", int64(1))
+	rows := []*tablestore.Row{
+		{PrimaryKey: pk, Columns: []*tablestore.AttributeColumn{{ColumnName: "name", Value: "a,b"}, {ColumnName: "name", Value: "old"}}},
+		{PrimaryKey: pk, Columns: []*tablestore.AttributeColumn{{ColumnName: "raw", Value: []byte{1, 2}}, {ColumnName: "score", Value: math.NaN()}}},
+	}
+	header, values := rowTable(rows)
+	assert.Equal(t, []string{"id", "name", "raw", "score"}, header)
+
+	var b bytes.Buffer
+	p, _ := newPrinter(&b, "csv")
+	assert.Nil(t, p.rows(header, values))
+	assert.Equal(t, "id,name,raw,score\n1,\"a,b\",,\n1,,AQI=,NaN\n", b.String())
+
+	b.Reset()
+	p, _ = newPrinter(&b, "json")
+	assert.Nil(t, p.rows(header, values))
+	assert.Equal(t, "{\"id\":1,\"name\":\"a,b\"}\n{\"id\":1,\"raw\":\"AQI=\",\"score\":\"NaN\"}\n", b.String())
+
+	b.Reset()
+	p, _ = newPrinter(&b, "table")
+	assert.Nil(t, p.object([]field{{"table", "t"}, {"primaryKey", []string{"id:INTEGER", "seq:INTEGER"}}}))
+	assert.Equal(t, "FIELD       VALUE\ntable       t\nprimaryKey  id:INTEGER,seq:INTEGER\n", b.String())
+
+	_, err := newPrinter(&b, "yaml")
+	assert.NotNil(t, err)
+}
+
+func TestTunnelTailRequiresConsume(t *testing.T) {
+	// the check comes before any call to the server
+	err := tunnelTail(&env{}, []string{"-table", "t", "-tunnel", "debug"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "pass -consume")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// printer writes results as an aligned table, JSON Lines or CSV. Commands
+// hand it a header and rows, or the fields of a single object.
+type printer struct {
+	w      io.Writer
+	format string
+	// header is set once a CSV header was written, later batches with the
+	// same columns leave it out
+	header []string
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "table", "json", "csv":
+		return &printer{w: w, format: format}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q, want table, json or csv", format)
+	}
+}
+
+// field is a named value of an object.
+type field struct {
+	name  string
+	value interface{}
+}
+
+// rows prints a result set. A nil value is an absent cell.
+func (p *printer) rows(columns []string, rows [][]interface{}) error {
+	if len(columns) == 0 {
+		return nil
+	}
+	switch p.format {
+	case "json":
+		for _, row := range rows {
+			fields := make([]field, 0, len(columns))
+			for i, col := range columns {
+				if row[i] != nil {
+					fields = append(fields, field{col, row[i]})
+				}
+			}
+			if err := p.writeJSON(fields); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		w := csv.NewWriter(p.w)
+		if !equalStrings(p.header, columns) {
+			p.header = columns
+			w.Write(columns)
+		}
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for i, value := range row {
+				record[i] = formatValue(value)
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		w := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(columns, "\t"))
+		for _, row := range rows {
+			cells := make([]string, len(row))
+			for i, value := range row {
+				cells[i] = strings.NewReplacer("\t", `\t`, "\n", `\n`).Replace(formatValue(value))
+			}
+			fmt.Fprintln(w, strings.Join(cells, "\t"))
+		}
+		return w.Flush()
+	}
+}
+
+// object prints the fields of one object, as a two column table in the
+// table and CSV formats.
+func (p *printer) object(fields []field) error {
+	if p.format == "json" {
+		return p.writeJSON(fields)
+	}
+	rows := make([][]interface{}, len(fields))
+	for i, f := range fields {
+		rows[i] = []interface{}{f.name, f.value}
+	}
+	return p.rows([]string{"FIELD", "VALUE"}, rows)
+}
+
+// writeJSON writes an object keeping the order of its fields.
+func (p *printer) writeJSON(fields []field) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(f.name)
+		value, err := json.Marshal(jsonValue(f.value))
+		if err != nil {
+			return err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteString("}\n")
+	_, err := p.w.Write(b.Bytes())
+	return err
+}
+
+// jsonValue replaces values encoding/json can not write.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	case time.Duration:
+		return v.String()
+	}
+	return value
+}
+
+// formatValue prints a cell, BINARY values as base64 and nil as nothing.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []string:
+		return strings.Join(v, ",")
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// profile holds the credentials of an instance. The config file has one
+// section per profile:
+//
+//	[default]
+//	endpoint = https://myinstance.cn-hangzhou.ots.aliyuncs.com
+//	instance = myinstance
+//	access_key_id = ...
+//	access_key_secret = ...
+//
+//	[prod]
+//	endpoint = ...
+//	security_token = ...
+//
+// Lines starting with # or ; are comments.
+type profile struct {
+	Endpoint        string
+	Instance        string
+	AccessKeyId     string
+	AccessKeySecret string
+	SecurityToken   string
+}
+
+const defaultProfile = "default"
+
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".otscli", "config")
+}
+
+// loadProfile reads a profile from the config file. When the default config
+// file does not exist the profile comes from the environment.
+func loadProfile(path, name string) (*profile, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if name == "" {
+		name = defaultProfile
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) && !explicit && name == defaultProfile {
+		return envProfile()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	profiles, err := parseProfiles(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: profile %q not found", path, name)
+	}
+	if p.Endpoint == "" || p.Instance == "" || p.AccessKeyId == "" || p.AccessKeySecret == "" {
+		return nil, fmt.Errorf("%s: profile %q needs endpoint, instance, access_key_id and access_key_secret", path, name)
+	}
+	return p, nil
+}
+
+func envProfile() (*profile, error) {
+	p := &profile{
+		Endpoint:        os.Getenv("OTS_ENDPOINT"),
+		Instance:        os.Getenv("OTS_INSTANCE"),
+		AccessKeyId:     os.Getenv("OTS_AK_ID"),
+		AccessKeySecret: os.Getenv("OTS_AK_SECRET"),
+		SecurityToken:   os.Getenv("OTS_STS_TOKEN"),
+	}
+	if p.Endpoint == "" || p.Instance == "" || p.AccessKeyId == "" || p.AccessKeySecret == "" {
+		return nil, fmt.Errorf("no config file at %s and OTS_ENDPOINT, OTS_INSTANCE, OTS_AK_ID or OTS_AK_SECRET is not set", defaultConfigPath())
+	}
+	return p, nil
+}
+
+func parseProfiles(r io.Reader) (map[string]*profile, error) {
+	profiles := make(map[string]*profile)
+	var current *profile
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+		if text[0] == '[' {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: invalid section %q", line, text)
+			}
+			current = new(profile)
+			profiles[strings.TrimSpace(text[1:len(text)-1])] = current
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: want key = value", line)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: key outside of a profile section", line)
+		}
+		value := strings.TrimSpace(parts[1])
+		switch key := strings.TrimSpace(parts[0]); key {
+		case "endpoint":
+			current.Endpoint = value
+		case "instance":
+			current.Instance = value
+		case "access_key_id":
+			current.AccessKeyId = value
+		case "access_key_secret":
+			current.AccessKeySecret = value
+		case "security_token":
+			current.SecurityToken = value
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q", line, key)
+		}
+	}
+	return profiles, scanner.Err()
+}
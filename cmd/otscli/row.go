@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// rowFlags are the flags shared by the row commands.
+type rowFlags struct {
+	table      *string
+	primaryKey listFlag
+}
+
+func newRowFlags(name string) (*rowFlags, *flag.FlagSet) {
+	flags := newFlags(name)
+	r := &rowFlags{table: flags.String("table", "", "table name")}
+	flags.Var(&r.primaryKey, "pk", "primary key column name=value, repeated")
+	return r, flags
+}
+
+// key parses the primary key once the flags are parsed.
+func (r *rowFlags) key(e *env) (*tablestore.TableMeta, *tablestore.PrimaryKey, error) {
+	if err := required(*r.table); err != nil {
+		return nil, nil, err
+	}
+	meta, err := e.tableMeta(*r.table)
+	if err != nil {
+		return nil, nil, err
+	}
+	pk, err := parsePrimaryKey(meta, r.primaryKey, exactKey)
+	return meta, pk, err
+}
+
+func splitColumns(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func rowGet(e *env, args []string) error {
+	r, flags := newRowFlags("row get")
+	columns := flags.String("columns", "", "comma separated columns to get, all when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	_, pk, err := r.key(e)
+	if err != nil {
+		return err
+	}
+	resp, err := e.tableClient().GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: &tablestore.SingleRowQueryCriteria{
+		TableName:    *r.table,
+		PrimaryKey:   pk,
+		ColumnsToGet: splitColumns(*columns),
+		MaxVersion:   1,
+	}})
+	if err != nil {
+		return err
+	}
+	if len(resp.PrimaryKey.PrimaryKeys) == 0 {
+		// the row does not exist
+		return e.out.rows(nil, nil)
+	}
+	header, rows := rowTable([]*tablestore.Row{{PrimaryKey: &resp.PrimaryKey, Columns: resp.Columns}})
+	return e.out.rows(header, rows)
+}
+
+func rowPut(e *env, args []string) error {
+	r, flags := newRowFlags("row put")
+	var columns listFlag
+	flags.Var(&columns, "col", "column name[:TYPE]=value, repeated")
+	expect := flags.String("expect", "ignore", "row existence expected: ignore, exist or not-exist")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	meta, pk, err := r.key(e)
+	if err != nil {
+		return err
+	}
+	condition, err := parseExpectation(*expect)
+	if err != nil {
+		return err
+	}
+	change := &tablestore.PutRowChange{TableName: *r.table, PrimaryKey: pk}
+	change.SetCondition(condition)
+	for _, spec := range columns {
+		name, value, err := parseColumn(meta, spec)
+		if err != nil {
+			return err
+		}
+		change.AddColumn(name, value)
+	}
+	change.SetReturnPk()
+	resp, err := e.tableClient().PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+	if err != nil {
+		return err
+	}
+	fields := consumed(resp.ConsumedCapacityUnit, resp.RequestId)
+	for _, col := range resp.PrimaryKey.PrimaryKeys {
+		fields = append(fields, field{col.ColumnName, col.Value})
+	}
+	return e.out.object(fields)
+}
+
+func rowUpdate(e *env, args []string) error {
+	r, flags := newRowFlags("row update")
+	var columns, deletes listFlag
+	flags.Var(&columns, "col", "column name[:TYPE]=value to put, repeated")
+	flags.Var(&deletes, "delete", "column to delete with all of its versions, repeated")
+	expect := flags.String("expect", "ignore", "row existence expected: ignore or exist")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	meta, pk, err := r.key(e)
+	if err != nil {
+		return err
+	}
+	if len(columns) == 0 && len(deletes) == 0 {
+		return errUsage
+	}
+	condition, err := parseExpectation(*expect)
+	if err != nil {
+		return err
+	}
+	change := &tablestore.UpdateRowChange{TableName: *r.table, PrimaryKey: pk}
+	change.SetCondition(condition)
+	for _, spec := range columns {
+		name, value, err := parseColumn(meta, spec)
+		if err != nil {
+			return err
+		}
+		change.PutColumn(name, value)
+	}
+	for _, name := range deletes {
+		change.DeleteColumn(name)
+	}
+	resp, err := e.tableClient().UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change})
+	if err != nil {
+		return err
+	}
+	return e.out.object(consumed(resp.ConsumedCapacityUnit, resp.RequestId))
+}
+
+func rowDelete(e *env, args []string) error {
+	r, flags := newRowFlags("row delete")
+	expect := flags.String("expect", "ignore", "row existence expected: ignore or exist")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	_, pk, err := r.key(e)
+	if err != nil {
+		return err
+	}
+	condition, err := parseExpectation(*expect)
+	if err != nil {
+		return err
+	}
+	change := &tablestore.DeleteRowChange{TableName: *r.table, PrimaryKey: pk}
+	change.SetCondition(condition)
+	resp, err := e.tableClient().DeleteRow(&tablestore.DeleteRowRequest{DeleteRowChange: change})
+	if err != nil {
+		return err
+	}
+	return e.out.object(consumed(resp.ConsumedCapacityUnit, resp.RequestId))
+}
+
+func parseExpectation(value string) (tablestore.RowExistenceExpectation, error) {
+	switch value {
+	case "ignore":
+		return tablestore.RowExistenceExpectation_IGNORE, nil
+	case "exist":
+		return tablestore.RowExistenceExpectation_EXPECT_EXIST, nil
+	case "not-exist":
+		return tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST, nil
+	default:
+		return 0, fmt.Errorf("invalid -expect %q, want ignore, exist or not-exist", value)
+	}
+}
+
+func rangeRows(e *env, args []string) error {
+	flags := newFlags("range")
+	table := flags.String("table", "", "table name")
+	var start, end listFlag
+	flags.Var(&start, "start", "inclusive start primary key column name=value or name=MIN, repeated, MIN when left out")
+	flags.Var(&end, "end", "exclusive end primary key column name=value or name=MAX, repeated, MAX when left out")
+	columns := flags.String("columns", "", "comma separated columns to get, all when empty")
+	limit := flags.Int("limit", 100, "rows to read, 0 reads the whole range")
+	backward := flags.Bool("backward", false, "read from the end, -start must then be greater than -end")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table); err != nil {
+		return err
+	}
+	meta, err := e.tableMeta(*table)
+	if err != nil {
+		return err
+	}
+	startBound, endBound := startKey, endKey
+	if *backward {
+		startBound, endBound = endKey, startKey
+	}
+	startPK, err := parsePrimaryKey(meta, start, startBound)
+	if err != nil {
+		return err
+	}
+	endPK, err := parsePrimaryKey(meta, end, endBound)
+	if err != nil {
+		return err
+	}
+
+	criteria := &tablestore.RangeRowQueryCriteria{
+		TableName:       *table,
+		StartPrimaryKey: startPK,
+		EndPrimaryKey:   endPK,
+		ColumnsToGet:    splitColumns(*columns),
+		MaxVersion:      1,
+	}
+	if *backward {
+		criteria.Direction = tablestore.BACKWARD
+	}
+	var rows []*tablestore.Row
+	for {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		if *limit > 0 {
+			criteria.Limit = int32(*limit - len(rows))
+		}
+		resp, err := e.tableClient().GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: criteria})
+		if err != nil {
+			return err
+		}
+		rows = append(rows, resp.Rows...)
+		if resp.NextStartPrimaryKey == nil || (*limit > 0 && len(rows) >= *limit) {
+			break
+		}
+		criteria.StartPrimaryKey = resp.NextStartPrimaryKey
+	}
+	header, values := rowTable(rows)
+	return e.out.rows(header, values)
+}
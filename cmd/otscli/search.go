@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+// searchPageSize is the most rows a Search request returns.
+const searchPageSize = 100
+
+// parseQuery parses a query in the JSON form of the search package, the
+// query type in Name and its fields in Query:
+//
+//	{"Name":"TermQuery","Query":{"FieldName":"status","Term":"paid"}}
+func parseQuery(data string) (search.Query, error) {
+	if strings.TrimSpace(data) == "" {
+		return &search.MatchAllQuery{}, nil
+	}
+	var named struct {
+		Name  string
+		Query json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(data), &named); err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	if named.Name == "" || len(named.Query) == 0 {
+		return nil, fmt.Errorf(`invalid query, want {"Name":"TermQuery","Query":{...}}`)
+	}
+	query, err := search.UnmarshalQuery(named.Name, named.Query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	return query, nil
+}
+
+func searchRows(e *env, args []string) error {
+	flags := newFlags("search")
+	table := flags.String("table", "", "table name")
+	index := flags.String("index", "", "search index name")
+	queryArg := flags.String("query", "", "query as JSON, @file or @- for stdin, match all when empty")
+	columns := flags.String("columns", "", "comma separated columns to return, all when empty")
+	limit := flags.Int("limit", 10, "rows to return")
+	offset := flags.Int("offset", 0, "rows to skip")
+	total := flags.Bool("total", false, "print the total count of matching rows to stderr")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table, *index); err != nil {
+		return err
+	}
+	data, err := readArg(*queryArg)
+	if err != nil {
+		return err
+	}
+	query, err := parseQuery(data)
+	if err != nil {
+		return err
+	}
+
+	columnsToGet := &tablestore.ColumnsToGet{ReturnAll: true}
+	if *columns != "" {
+		columnsToGet = &tablestore.ColumnsToGet{Columns: splitColumns(*columns)}
+	}
+	searchQuery := search.NewSearchQuery().SetQuery(query).SetGetTotalCount(*total)
+	if *offset > 0 {
+		searchQuery.SetOffset(int32(*offset))
+	}
+
+	var rows []*tablestore.Row
+	for len(rows) < *limit {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		size := *limit - len(rows)
+		if size > searchPageSize {
+			size = searchPageSize
+		}
+		searchQuery.SetLimit(int32(size))
+		request := new(tablestore.SearchRequest).
+			SetTableName(*table).
+			SetIndexName(*index).
+			SetSearchQuery(searchQuery).
+			SetColumnsToGet(columnsToGet)
+		resp, err := e.tableClient().Search(request)
+		if err != nil {
+			return err
+		}
+		if *total && rows == nil {
+			fmt.Fprintln(os.Stderr, "total:", resp.TotalCount)
+		}
+		rows = append(rows, resp.Rows...)
+		if len(resp.NextToken) == 0 || len(resp.Rows) == 0 {
+			break
+		}
+		// the next page continues from the token instead of an offset
+		searchQuery.Offset = nil
+		searchQuery.SetToken(resp.NextToken)
+	}
+	header, values := rowTable(rows)
+	return e.out.rows(header, values)
+}
+
+func indexList(e *env, args []string) error {
+	flags := newFlags("index list")
+	table := flags.String("table", "", "table name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table); err != nil {
+		return err
+	}
+	describe, err := e.tableClient().DescribeTable(&tablestore.DescribeTableRequest{TableName: *table})
+	if err != nil {
+		return err
+	}
+	var rows [][]interface{}
+	for _, index := range describe.IndexMetas {
+		rows = append(rows, []interface{}{index.IndexName, indexTypeName(index.IndexType), index.IndexSyncPhase.String()})
+	}
+	searchIndexes, err := e.tableClient().ListSearchIndex(&tablestore.ListSearchIndexRequest{TableName: *table})
+	if err != nil {
+		return err
+	}
+	for _, index := range searchIndexes.IndexInfo {
+		rows = append(rows, []interface{}{index.IndexName, "SEARCH", nil})
+	}
+	return e.out.rows([]string{"INDEX", "TYPE", "SYNC_PHASE"}, rows)
+}
+
+func indexTypeName(t tablestore.IndexType) string {
+	if t == tablestore.IT_LOCAL_INDEX {
+		return "LOCAL"
+	}
+	return "GLOBAL"
+}
+
+func indexDescribe(e *env, args []string) error {
+	flags := newFlags("index describe")
+	table := flags.String("table", "", "table name")
+	name := flags.String("index", "", "secondary or search index name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table, *name); err != nil {
+		return err
+	}
+	describe, err := e.tableClient().DescribeTable(&tablestore.DescribeTableRequest{TableName: *table})
+	if err != nil {
+		return err
+	}
+	for _, index := range describe.IndexMetas {
+		if index.IndexName == *name {
+			return e.out.object([]field{
+				{"index", index.IndexName},
+				{"type", indexTypeName(index.IndexType)},
+				{"primaryKey", index.Primarykey},
+				{"definedColumns", index.DefinedColumns},
+				{"syncPhase", index.IndexSyncPhase.String()},
+			})
+		}
+	}
+
+	resp, err := e.tableClient().DescribeSearchIndex(&tablestore.DescribeSearchIndexRequest{TableName: *table, IndexName: *name})
+	if err != nil {
+		return err
+	}
+	fields := []field{
+		{"index", *name},
+		{"type", "SEARCH"},
+	}
+	if resp.Schema != nil {
+		fields = append(fields, field{"fields", searchFields(resp.Schema.FieldSchemas, "")})
+	}
+	if resp.SyncStat != nil {
+		fields = append(fields, field{"syncPhase", resp.SyncStat.SyncPhase.String()})
+		if resp.SyncStat.CurrentSyncTimestamp != nil {
+			fields = append(fields, field{"currentSyncTimestamp", *resp.SyncStat.CurrentSyncTimestamp})
+		}
+	}
+	if resp.MeteringInfo != nil {
+		fields = append(fields,
+			field{"storageSize", resp.MeteringInfo.StorageSize},
+			field{"rowCount", resp.MeteringInfo.RowCount},
+			field{"reservedReadCU", resp.MeteringInfo.ReservedReadCU})
+	}
+	fields = append(fields, field{"createTime", resp.CreateTime}, field{"timeToLive", resp.TimeToLive})
+	return e.out.object(fields)
+}
+
+// searchFields lists fields as name:TYPE, nested fields as parent.name:TYPE.
+func searchFields(schemas []*tablestore.FieldSchema, prefix string) []string {
+	var fields []string
+	for _, schema := range schemas {
+		name := prefix + *schema.FieldName
+		fieldType := schema.FieldType.String()
+		if schema.IsArray != nil && *schema.IsArray {
+			fieldType += "[]"
+		}
+		fields = append(fields, name+":"+fieldType)
+		fields = append(fields, searchFields(schema.FieldSchemas, name+".")...)
+	}
+	return fields
+}
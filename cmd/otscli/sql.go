@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+func sqlQuery(e *env, args []string) error {
+	flags := newFlags("sql")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	query := strings.Join(flags.Args(), " ")
+	if err := required(query); err != nil {
+		return err
+	}
+	query, err := readArg(query)
+	if err != nil {
+		return err
+	}
+
+	request := &tablestore.SQLQueryRequest{Query: query}
+	var header []string
+	var rows [][]interface{}
+	for {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		resp, err := e.tableClient().SQLQuery(request)
+		if err != nil {
+			return err
+		}
+		columns := resp.ResultSet.Columns()
+		if header == nil {
+			for _, column := range columns {
+				header = append(header, column.Name)
+			}
+		}
+		for resp.ResultSet.HasNext() {
+			row, err := sqlRow(resp.ResultSet.Next(), columns)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		if resp.NextSearchToken == nil || *resp.NextSearchToken == "" {
+			break
+		}
+		request.SetSearchToken(resp.NextSearchToken)
+	}
+	return e.out.rows(header, rows)
+}
+
+func sqlRow(row tablestore.SQLRow, columns []*tablestore.SQLColumnInfo) ([]interface{}, error) {
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		null, err := row.IsNull(i)
+		if err != nil {
+			return nil, err
+		}
+		if null {
+			continue
+		}
+		switch column.Type {
+		case tablestore.ColumnType_INTEGER:
+			values[i], err = row.GetInt64(i)
+		case tablestore.ColumnType_DOUBLE:
+			values[i], err = row.GetFloat64(i)
+		case tablestore.ColumnType_BOOLEAN:
+			values[i], err = row.GetBool(i)
+		case tablestore.ColumnType_BINARY:
+			values[i], err = row.GetBytes(i)
+		case tablestore.ColumnType_DATETIME:
+			values[i], err = row.GetDateTime(i)
+		case tablestore.ColumnType_DATE:
+			values[i], err = row.GetDate(i)
+		case tablestore.ColumnType_TIME:
+			values[i], err = row.GetTime(i)
+		default:
+			values[i], err = row.GetString(i)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+func tableList(e *env, args []string) error {
+	flags := newFlags("table list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	resp, err := e.tableClient().ListTable()
+	if err != nil {
+		return err
+	}
+	rows := make([][]interface{}, len(resp.TableNames))
+	for i, name := range resp.TableNames {
+		rows[i] = []interface{}{name}
+	}
+	return e.out.rows([]string{"TABLE"}, rows)
+}
+
+func tableDescribe(e *env, args []string) error {
+	flags := newFlags("table describe")
+	table := flags.String("table", "", "table name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table); err != nil {
+		return err
+	}
+	resp, err := e.tableClient().DescribeTable(&tablestore.DescribeTableRequest{TableName: *table})
+	if err != nil {
+		return err
+	}
+
+	var primaryKeys, definedColumns, indexes []string
+	for _, schema := range resp.TableMeta.SchemaEntry {
+		column := *schema.Name + ":" + primaryKeyTypeName(*schema.Type)
+		if schema.Option != nil && *schema.Option == tablestore.AUTO_INCREMENT {
+			column += ":AUTO_INCREMENT"
+		}
+		primaryKeys = append(primaryKeys, column)
+	}
+	for _, column := range resp.TableMeta.DefinedColumns {
+		definedColumns = append(definedColumns, column.Name+":"+columnTypeName(column.ColumnType))
+	}
+	for _, index := range resp.IndexMetas {
+		indexes = append(indexes, index.IndexName)
+	}
+	fields := []field{
+		{"table", resp.TableMeta.TableName},
+		{"primaryKey", primaryKeys},
+		{"definedColumns", definedColumns},
+	}
+	if option := resp.TableOption; option != nil {
+		fields = append(fields,
+			field{"timeToLive", option.TimeToAlive},
+			field{"maxVersions", option.MaxVersion},
+			field{"deviationCellVersionInSec", option.DeviationCellVersionInSec})
+		if option.AllowUpdate != nil {
+			fields = append(fields, field{"allowUpdate", *option.AllowUpdate})
+		}
+	}
+	if cu := resp.ReservedThroughput; cu != nil {
+		fields = append(fields, field{"reservedReadCU", cu.Readcap}, field{"reservedWriteCU", cu.Writecap})
+	}
+	if stream := resp.StreamDetails; stream != nil {
+		fields = append(fields, field{"streamEnabled", stream.EnableStream})
+		if stream.EnableStream {
+			fields = append(fields, field{"streamExpirationHours", stream.ExpirationTime})
+		}
+	}
+	if sse := resp.SSEDetails; sse != nil {
+		fields = append(fields, field{"sseEnabled", sse.Enable})
+	}
+	fields = append(fields, field{"indexes", indexes})
+	return e.out.object(fields)
+}
+
+func tableCreate(e *env, args []string) error {
+	flags := newFlags("table create")
+	table := flags.String("table", "", "table name")
+	var primaryKeys, definedColumns listFlag
+	flags.Var(&primaryKeys, "pk", "primary key column name:TYPE[:AUTO_INCREMENT], in order, repeated")
+	flags.Var(&definedColumns, "col", "defined column name:TYPE, repeated")
+	ttl := flags.Int("ttl", -1, "time to live in seconds, -1 keeps data forever")
+	maxVersions := flags.Int("max-versions", 1, "versions kept per column")
+	readCU := flags.Int("read-cu", 0, "reserved read capacity units")
+	writeCU := flags.Int("write-cu", 0, "reserved write capacity units")
+	streamHours := flags.Int("stream-hours", 0, "enable the stream with this expiration in hours")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table); err != nil {
+		return err
+	}
+	if len(primaryKeys) == 0 {
+		return errUsage
+	}
+
+	meta := &tablestore.TableMeta{TableName: *table}
+	for _, spec := range primaryKeys {
+		name, typeName, autoIncrement, err := parseSchemaColumn(spec)
+		if err != nil {
+			return err
+		}
+		t, ok := primaryKeyTypes[typeName]
+		if !ok {
+			return fmt.Errorf("primary key %s: unknown type %s", name, typeName)
+		}
+		if autoIncrement {
+			meta.AddPrimaryKeyColumnOption(name, t, tablestore.AUTO_INCREMENT)
+		} else {
+			meta.AddPrimaryKeyColumn(name, t)
+		}
+	}
+	for _, spec := range definedColumns {
+		name, typeName, autoIncrement, err := parseSchemaColumn(spec)
+		if err != nil {
+			return err
+		}
+		t, ok := columnTypes[typeName]
+		if !ok || autoIncrement {
+			return fmt.Errorf("invalid defined column %q", spec)
+		}
+		meta.AddDefinedColumn(name, t)
+	}
+
+	request := &tablestore.CreateTableRequest{
+		TableMeta:          meta,
+		TableOption:        &tablestore.TableOption{TimeToAlive: *ttl, MaxVersion: *maxVersions},
+		ReservedThroughput: &tablestore.ReservedThroughput{Readcap: *readCU, Writecap: *writeCU},
+	}
+	if *streamHours > 0 {
+		request.StreamSpec = &tablestore.StreamSpecification{EnableStream: true, ExpirationTime: int32(*streamHours)}
+	}
+	resp, err := e.tableClient().CreateTable(request)
+	if err != nil {
+		return err
+	}
+	return e.out.object([]field{{"table", *table}, {"requestId", resp.RequestId}})
+}
+
+func tableUpdate(e *env, args []string) error {
+	flags := newFlags("table update")
+	table := flags.String("table", "", "table name")
+	ttl := flags.Int("ttl", 0, "time to live in seconds, -1 keeps data forever")
+	maxVersions := flags.Int("max-versions", 0, "versions kept per column")
+	deviation := flags.Int64("max-time-deviation", 0, "max deviation of cell timestamps from now in seconds")
+	allowUpdate := flags.Bool("allow-update", true, "allow UpdateRow on the table")
+	readCU := flags.Int("read-cu", 0, "reserved read capacity units")
+	writeCU := flags.Int("write-cu", 0, "reserved write capacity units")
+	streamHours := flags.Int("stream-hours", 0, "enable the stream with this expiration in hours, -1 disables it")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table); err != nil {
+		return err
+	}
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// options left out keep their current values
+	current, err := e.tableClient().DescribeTable(&tablestore.DescribeTableRequest{TableName: *table})
+	if err != nil {
+		return err
+	}
+	request := &tablestore.UpdateTableRequest{TableName: *table}
+	if set["ttl"] || set["max-versions"] || set["max-time-deviation"] || set["allow-update"] {
+		option := *current.TableOption
+		option.UpdateFullRow = nil
+		if set["ttl"] {
+			option.TimeToAlive = *ttl
+		}
+		if set["max-versions"] {
+			option.MaxVersion = *maxVersions
+		}
+		if set["max-time-deviation"] {
+			option.DeviationCellVersionInSec = *deviation
+		}
+		if set["allow-update"] {
+			option.AllowUpdate = allowUpdate
+		}
+		request.TableOption = &option
+	}
+	if set["read-cu"] || set["write-cu"] {
+		cu := *current.ReservedThroughput
+		if set["read-cu"] {
+			cu.Readcap = *readCU
+		}
+		if set["write-cu"] {
+			cu.Writecap = *writeCU
+		}
+		request.ReservedThroughput = &cu
+	}
+	if set["stream-hours"] {
+		request.StreamSpec = &tablestore.StreamSpecification{EnableStream: *streamHours > 0}
+		if *streamHours > 0 {
+			request.StreamSpec.ExpirationTime = int32(*streamHours)
+		}
+	}
+	if request.TableOption == nil && request.ReservedThroughput == nil && request.StreamSpec == nil {
+		return fmt.Errorf("nothing to update, set -ttl, -max-versions, -max-time-deviation, -allow-update, -read-cu, -write-cu or -stream-hours")
+	}
+	resp, err := e.tableClient().UpdateTable(request)
+	if err != nil {
+		return err
+	}
+	return e.out.object([]field{{"table", *table}, {"requestId", resp.RequestId}})
+}
+
+func tableDelete(e *env, args []string) error {
+	flags := newFlags("table delete")
+	table := flags.String("table", "", "table name")
+	yes := flags.Bool("yes", false, "confirm that the table and its data are deleted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table); err != nil {
+		return err
+	}
+	if !*yes {
+		return fmt.Errorf("deleting table %s drops all of its data, add -yes to confirm", *table)
+	}
+	resp, err := e.tableClient().DeleteTable(&tablestore.DeleteTableRequest{TableName: *table})
+	if err != nil {
+		return err
+	}
+	return e.out.object([]field{{"table", *table}, {"requestId", resp.RequestId}})
+}
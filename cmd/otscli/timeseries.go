@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+func timeseriesQuery(e *env, args []string) error {
+	flags := newFlags("timeseries query")
+	table := flags.String("table", "", "timeseries table name")
+	measurement := flags.String("measurement", "", "measurement name")
+	source := flags.String("source", "", "data source")
+	var tags listFlag
+	flags.Var(&tags, "tag", "tag name=value, repeated")
+	start := flags.String("start", "-1h", "start time, RFC 3339, microseconds since epoch or a duration before now such as -1h")
+	end := flags.String("end", "", "end time in the same forms, now when empty")
+	limit := flags.Int("limit", 100, "rows to return")
+	backward := flags.Bool("backward", false, "return the newest rows first")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table, *measurement); err != nil {
+		return err
+	}
+	now := time.Now()
+	begin, err := parseTime(*start, now)
+	if err != nil {
+		return fmt.Errorf("invalid -start: %v", err)
+	}
+	finish := now.UnixNano() / 1000
+	if *end != "" {
+		if finish, err = parseTime(*end, now); err != nil {
+			return fmt.Errorf("invalid -end: %v", err)
+		}
+	}
+
+	key := tablestore.NewTimeseriesKey()
+	key.SetMeasurementName(*measurement)
+	key.SetDataSource(*source)
+	for _, tag := range tags {
+		name, value, err := splitPair(tag, "=")
+		if err != nil {
+			return err
+		}
+		key.AddTag(name, value)
+	}
+	request := tablestore.NewGetTimeseriesDataRequest(*table)
+	request.SetTimeseriesKey(key)
+	request.SetTimeRange(begin, finish)
+	request.SetBackward(*backward)
+
+	var rows []*tablestore.TimeseriesRow
+	for len(rows) < *limit {
+		if err := e.ctx.Err(); err != nil {
+			return err
+		}
+		request.SetLimit(int32(*limit - len(rows)))
+		resp, err := e.timeseries().GetTimeseriesData(request)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, resp.GetRows()...)
+		if len(resp.GetNextToken()) == 0 {
+			break
+		}
+		request.SetNextToken(resp.GetNextToken())
+	}
+
+	header, values := timeseriesTable(rows)
+	return e.out.rows(header, values)
+}
+
+// parseTime returns microseconds since epoch.
+func parseTime(value string, now time.Time) (int64, error) {
+	if strings.HasPrefix(value, "-") {
+		d, err := time.ParseDuration(value[1:])
+		if err != nil {
+			return 0, err
+		}
+		return now.Add(-d).UnixNano() / 1000, nil
+	}
+	if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return us, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixNano() / 1000, nil
+}
+
+// timeseriesTable lays out rows as time, data source and tags followed by
+// the fields in name order.
+func timeseriesTable(rows []*tablestore.TimeseriesRow) ([]string, [][]interface{}) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for name := range row.GetFieldsMap() {
+			if !seen[name] {
+				seen[name] = true
+				fields = append(fields, name)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	header := append([]string{"TIME", "SOURCE", "TAGS"}, fields...)
+	values := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		key := row.GetTimeseriesKey()
+		value := []interface{}{time.Unix(0, row.GetTimeInus()*1000).UTC(), key.GetDataSource(), formatTags(key.GetTags())}
+		for _, name := range fields {
+			var v interface{}
+			if column, ok := row.GetFieldsMap()[name]; ok {
+				v = column.Value
+			}
+			value = append(value, v)
+		}
+		values = append(values, value)
+	}
+	return header, values
+}
+
+func formatTags(tags map[string]string) string {
+	var parts []string
+	for name, value := range tags {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
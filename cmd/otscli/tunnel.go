@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tunnel"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func tunnelList(e *env, args []string) error {
+	flags := newFlags("tunnel list")
+	table := flags.String("table", "", "table name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table); err != nil {
+		return err
+	}
+	resp, err := e.tunnel().ListTunnel(&tunnel.ListTunnelRequest{TableName: *table})
+	if err != nil {
+		return err
+	}
+	var rows [][]interface{}
+	for _, info := range resp.Tunnels {
+		rows = append(rows, []interface{}{info.TunnelName, info.TunnelId, info.TunnelType, info.Stage, info.Expired, info.CreateTime})
+	}
+	return e.out.rows([]string{"TUNNEL", "ID", "TYPE", "STAGE", "EXPIRED", "CREATED"}, rows)
+}
+
+func tunnelDescribe(e *env, args []string) error {
+	flags := newFlags("tunnel describe")
+	table := flags.String("table", "", "table name")
+	name := flags.String("tunnel", "", "tunnel name")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table, *name); err != nil {
+		return err
+	}
+	resp, err := e.tunnel().DescribeTunnel(&tunnel.DescribeTunnelRequest{TableName: *table, TunnelName: *name})
+	if err != nil {
+		return err
+	}
+	info := resp.Tunnel
+	if err := e.out.object([]field{
+		{"tunnel", info.TunnelName},
+		{"id", info.TunnelId},
+		{"type", info.TunnelType},
+		{"stage", info.Stage},
+		{"expired", info.Expired},
+		{"created", info.CreateTime},
+		{"rpo", resp.TunnelRPO},
+	}); err != nil {
+		return err
+	}
+	var rows [][]interface{}
+	for _, channel := range resp.Channels {
+		rows = append(rows, []interface{}{channel.ChannelId, channel.ChannelType, channel.ChannelStatus, channel.ClientId, channel.ChannelRPO})
+	}
+	return e.out.rows([]string{"CHANNEL", "TYPE", "STATUS", "CLIENT", "RPO"}, rows)
+}
+
+// tunnelTail joins the tunnel as a consumer and prints its records until
+// interrupted. The tunnel client commits checkpoints, so the records it
+// prints are not delivered to other consumers of the tunnel again: tail a
+// tunnel created for the investigation. It refuses to start without
+// -consume.
+func tunnelTail(e *env, args []string) error {
+	flags := newFlags("tunnel tail")
+	table := flags.String("table", "", "table name")
+	name := flags.String("tunnel", "", "tunnel name")
+	consume := flags.Bool("consume", false, "consume the records: they are checkpointed and not delivered to the other consumers of the tunnel again")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := required(*table, *name); err != nil {
+		return err
+	}
+	if !*consume {
+		return fmt.Errorf("tail consumes the records of tunnel %s, other consumers of the tunnel do not get them again: use a tunnel created for tailing and pass -consume", *name)
+	}
+	describe, err := e.tunnel().DescribeTunnel(&tunnel.DescribeTunnelRequest{TableName: *table, TunnelName: *name})
+	if err != nil {
+		return err
+	}
+	consumers := make(map[string]bool)
+	for _, channel := range describe.Channels {
+		if channel.ClientId != "" {
+			consumers[channel.ClientId] = true
+		}
+	}
+	fmt.Fprintf(os.Stderr, "warning: consuming tunnel %s, the records printed are not delivered to its %d other consumers again\n", *name, len(consumers))
+
+	// the tunnel logs warnings to stderr rather than to tunnelClient.log
+	logConfig := tunnel.DefaultLogConfig
+	logConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	logSyncer := zapcore.AddSync(os.Stderr)
+	logger, err := logConfig.Build(tunnel.ReplaceLogCore(logSyncer, logConfig))
+	if err != nil {
+		return err
+	}
+
+	var mu sync.Mutex
+	header := []string{"CHANNEL", "ACTION", "TIMESTAMP", "PRIMARY_KEY", "COLUMNS"}
+	factory := &tunnel.SimpleProcessFactory{
+		Logger: logger,
+		ProcessFunc: func(ctx *tunnel.ChannelContext, records []*tunnel.Record) error {
+			rows := make([][]interface{}, len(records))
+			for i, record := range records {
+				rows[i] = []interface{}{ctx.ChannelId, record.Type.String(), record.Timestamp, recordKey(record), recordColumns(record)}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			return e.out.rows(header, rows)
+		},
+	}
+	daemon := tunnel.NewTunnelDaemon(e.tunnel(), describe.Tunnel.TunnelId, &tunnel.TunnelWorkerConfig{
+		ProcessorFactory: factory,
+		LogConfig:        &logConfig,
+		LogWriteSyncer:   logSyncer,
+	})
+	go func() {
+		<-e.ctx.Done()
+		daemon.Close()
+	}()
+	err = daemon.Run()
+	if e.ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func recordKey(record *tunnel.Record) string {
+	if record.PrimaryKey == nil {
+		return ""
+	}
+	var parts []string
+	for _, col := range record.PrimaryKey.PrimaryKeys {
+		parts = append(parts, col.ColumnName+"="+formatValue(col.Value))
+	}
+	return strings.Join(parts, ",")
+}
+
+// recordColumns prints puts as name=value, deletes of a column as -name and
+// deletes of one version as -name@timestamp.
+func recordColumns(record *tunnel.Record) string {
+	var parts []string
+	for _, col := range record.Columns {
+		switch col.Type {
+		case tunnel.RCT_Put:
+			parts = append(parts, *col.Name+"="+formatValue(col.Value))
+		case tunnel.RCT_DeleteOneVersion:
+			parts = append(parts, fmt.Sprintf("-%s@%d", *col.Name, *col.Timestamp))
+		default:
+			parts = append(parts, "-"+*col.Name)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// Values on the command line are typed by the table: primary key values by
+// the primary key schema, column values by the defined columns unless the
+// column is written as name:TYPE=value. Other columns are strings. BINARY
+// values are base64.
+
+var primaryKeyTypes = map[string]tablestore.PrimaryKeyType{
+	"INTEGER": tablestore.PrimaryKeyType_INTEGER,
+	"STRING":  tablestore.PrimaryKeyType_STRING,
+	"BINARY":  tablestore.PrimaryKeyType_BINARY,
+}
+
+var columnTypes = map[string]tablestore.DefinedColumnType{
+	"INTEGER": tablestore.DefinedColumn_INTEGER,
+	"DOUBLE":  tablestore.DefinedColumn_DOUBLE,
+	"BOOLEAN": tablestore.DefinedColumn_BOOLEAN,
+	"STRING":  tablestore.DefinedColumn_STRING,
+	"BINARY":  tablestore.DefinedColumn_BINARY,
+}
+
+func primaryKeyTypeName(t tablestore.PrimaryKeyType) string {
+	for name, value := range primaryKeyTypes {
+		if value == t {
+			return name
+		}
+	}
+	return strconv.Itoa(int(t))
+}
+
+func columnTypeName(t tablestore.DefinedColumnType) string {
+	for name, value := range columnTypes {
+		if value == t {
+			return name
+		}
+	}
+	return strconv.Itoa(int(t))
+}
+
+func splitPair(spec, sep string) (string, string, error) {
+	parts := strings.SplitN(spec, sep, 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid %q, want name%svalue", spec, sep)
+	}
+	return parts[0], parts[1], nil
+}
+
+// keyBound is how a range bound marks a primary key column as unbounded.
+type keyBound int
+
+const (
+	exactKey keyBound = iota
+	// startKey and endKey accept MIN and MAX
+	startKey
+	endKey
+)
+
+// parsePrimaryKey builds a primary key from name=value pairs in schema
+// order. Range bounds fill missing trailing columns with MIN for a start and
+// MAX for an end, an exact key may only leave out auto increment columns.
+func parsePrimaryKey(meta *tablestore.TableMeta, specs []string, bound keyBound) (*tablestore.PrimaryKey, error) {
+	values := make(map[string]string)
+	for _, spec := range specs {
+		name, value, err := splitPair(spec, "=")
+		if err != nil {
+			return nil, err
+		}
+		values[name] = value
+	}
+
+	pk := new(tablestore.PrimaryKey)
+	for _, schema := range meta.SchemaEntry {
+		name := *schema.Name
+		value, ok := values[name]
+		delete(values, name)
+		switch {
+		case !ok && bound == startKey, ok && bound != exactKey && value == "MIN":
+			pk.AddPrimaryKeyColumnWithMinValue(name)
+		case !ok && bound == endKey, ok && bound != exactKey && value == "MAX":
+			pk.AddPrimaryKeyColumnWithMaxValue(name)
+		case !ok && schema.Option != nil && *schema.Option == tablestore.AUTO_INCREMENT:
+			pk.AddPrimaryKeyColumnWithAutoIncrement(name)
+		case !ok:
+			return nil, fmt.Errorf("missing primary key column %s", name)
+		default:
+			v, err := parseValue(value, primaryKeyColumnType(*schema.Type))
+			if err != nil {
+				return nil, fmt.Errorf("primary key %s: %v", name, err)
+			}
+			pk.AddPrimaryKeyColumn(name, v)
+		}
+	}
+	for name := range values {
+		return nil, fmt.Errorf("table %s has no primary key column %s", meta.TableName, name)
+	}
+	return pk, nil
+}
+
+func primaryKeyColumnType(t tablestore.PrimaryKeyType) tablestore.DefinedColumnType {
+	switch t {
+	case tablestore.PrimaryKeyType_INTEGER:
+		return tablestore.DefinedColumn_INTEGER
+	case tablestore.PrimaryKeyType_BINARY:
+		return tablestore.DefinedColumn_BINARY
+	default:
+		return tablestore.DefinedColumn_STRING
+	}
+}
+
+// parseColumn parses name[:TYPE]=value.
+func parseColumn(meta *tablestore.TableMeta, spec string) (string, interface{}, error) {
+	name, value, err := splitPair(spec, "=")
+	if err != nil {
+		return "", nil, err
+	}
+	t := tablestore.DefinedColumn_STRING
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		var ok bool
+		if t, ok = columnTypes[strings.ToUpper(name[i+1:])]; !ok {
+			return "", nil, fmt.Errorf("column %s: unknown type %q", name[:i], name[i+1:])
+		}
+		name = name[:i]
+	} else {
+		for _, column := range meta.DefinedColumns {
+			if column.Name == name {
+				t = column.ColumnType
+			}
+		}
+	}
+	v, err := parseValue(value, t)
+	if err != nil {
+		return "", nil, fmt.Errorf("column %s: %v", name, err)
+	}
+	return name, v, nil
+}
+
+func parseValue(value string, t tablestore.DefinedColumnType) (interface{}, error) {
+	switch t {
+	case tablestore.DefinedColumn_INTEGER:
+		return strconv.ParseInt(value, 10, 64)
+	case tablestore.DefinedColumn_DOUBLE:
+		return strconv.ParseFloat(value, 64)
+	case tablestore.DefinedColumn_BOOLEAN:
+		return strconv.ParseBool(value)
+	case tablestore.DefinedColumn_BINARY:
+		return base64.StdEncoding.DecodeString(value)
+	default:
+		return value, nil
+	}
+}
+
+// parseSchemaColumn parses name:TYPE of table create, primary key columns
+// may add :AUTO_INCREMENT.
+func parseSchemaColumn(spec string) (name, typeName string, autoIncrement bool, err error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return "", "", false, fmt.Errorf("invalid column %q, want name:TYPE", spec)
+	}
+	if len(parts) == 3 {
+		if !strings.EqualFold(parts[2], "AUTO_INCREMENT") {
+			return "", "", false, fmt.Errorf("invalid column %q, only AUTO_INCREMENT may follow the type", spec)
+		}
+		autoIncrement = true
+	}
+	return parts[0], strings.ToUpper(parts[1]), autoIncrement, nil
+}
+
+// rowTable lays out rows as primary key columns followed by the attribute
+// columns in the order they are first seen. Only the newest version of a
+// cell is shown.
+func rowTable(rows []*tablestore.Row) ([]string, [][]interface{}) {
+	var columns []string
+	index := make(map[string]int)
+	add := func(name string) int {
+		i, ok := index[name]
+		if !ok {
+			i = len(columns)
+			index[name] = i
+			columns = append(columns, name)
+		}
+		return i
+	}
+	for _, row := range rows {
+		if row.PrimaryKey != nil {
+			for _, col := range row.PrimaryKey.PrimaryKeys {
+				add(col.ColumnName)
+			}
+		}
+	}
+	for _, row := range rows {
+		for _, col := range row.Columns {
+			add(col.ColumnName)
+		}
+	}
+
+	result := make([][]interface{}, 0, len(rows))
+	for _, row := range rows {
+		values := make([]interface{}, len(columns))
+		if row.PrimaryKey != nil {
+			for _, col := range row.PrimaryKey.PrimaryKeys {
+				values[index[col.ColumnName]] = col.Value
+			}
+		}
+		for _, col := range row.Columns {
+			if i := index[col.ColumnName]; values[i] == nil {
+				values[i] = col.Value
+			}
+		}
+		result = append(result, values)
+	}
+	return columns, result
+}
+
+// consumed lists the capacity units and request id of a write.
+func consumed(cu *tablestore.ConsumedCapacityUnit, requestId string) []field {
+	fields := []field{{"requestId", requestId}}
+	if cu != nil {
+		fields = append(fields, field{"readCU", cu.Read}, field{"writeCU", cu.Write})
+	}
+	return fields
+}
@@ -0,0 +1,173 @@
+// Command otscopy copies a table to another table, on the same or another
+// instance.
+//
+//	otscopy -src-endpoint https://a.cn-hangzhou.ots.aliyuncs.com -src-instance a \
+//	    -src-ak-id ID -src-ak-secret SECRET -src-table orders \
+//	    -dst-endpoint https://b.cn-shanghai.ots.aliyuncs.com -dst-instance b \
+//	    -dst-ak-id ID -dst-ak-secret SECRET -dst-table orders \
+//	    -checkpoint orders.checkpoint -verify
+//
+// Target flags default to the source ones. Credentials can also be given in
+// the OTS_SRC_AK_ID, OTS_SRC_AK_SECRET, OTS_DST_AK_ID and OTS_DST_AK_SECRET
+// environment variables. Running the same command again with -checkpoint
+// resumes an interrupted copy.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/copier"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+func main() {
+	var (
+		srcEndpoint = flag.String("src-endpoint", "", "source endpoint")
+		srcInstance = flag.String("src-instance", "", "source instance")
+		srcAkId     = flag.String("src-ak-id", os.Getenv("OTS_SRC_AK_ID"), "source access key id")
+		srcAkSecret = flag.String("src-ak-secret", os.Getenv("OTS_SRC_AK_SECRET"), "source access key secret")
+		srcTable    = flag.String("src-table", "", "source table")
+		dstEndpoint = flag.String("dst-endpoint", "", "target endpoint, defaults to the source endpoint")
+		dstInstance = flag.String("dst-instance", "", "target instance, defaults to the source instance")
+		dstAkId     = flag.String("dst-ak-id", os.Getenv("OTS_DST_AK_ID"), "target access key id, defaults to the source one")
+		dstAkSecret = flag.String("dst-ak-secret", os.Getenv("OTS_DST_AK_SECRET"), "target access key secret, defaults to the source one")
+		dstTable    = flag.String("dst-table", "", "target table, defaults to the source table")
+
+		parallelism   = flag.Int("parallelism", copier.DefaultParallelism, "splits copied at the same time")
+		splitSizeMB   = flag.Int64("split-size-mb", copier.DefaultSplitSize>>20, "approximate split size in MB")
+		batchSize     = flag.Int("batch-size", copier.DefaultBatchSize, "rows per BatchWriteRow")
+		rowsPerSecond = flag.Int("rows-per-second", 0, "throttle, 0 means unlimited")
+		allVersions   = flag.Bool("all-versions", false, "copy every version instead of the latest one")
+		columns       = flag.String("columns", "", "comma separated columns to copy, all when empty")
+		rename        = flag.String("rename", "", "comma separated old=new column renames")
+		checkpoint    = flag.String("checkpoint", "", "checkpoint file used to resume the copy")
+		verify        = flag.Bool("verify", false, "compare the row count and checksum of the target after copying")
+	)
+	flag.Parse()
+
+	if *srcEndpoint == "" || *srcInstance == "" || *srcTable == "" || *srcAkId == "" || *srcAkSecret == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *dstEndpoint == "" {
+		*dstEndpoint = *srcEndpoint
+	}
+	if *dstInstance == "" {
+		*dstInstance = *srcInstance
+	}
+	if *dstAkId == "" {
+		*dstAkId, *dstAkSecret = *srcAkId, *srcAkSecret
+	}
+	if *dstTable == "" {
+		*dstTable = *srcTable
+	}
+
+	renames, err := parseRenames(*rename)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	source := tablestore.NewClient(*srcEndpoint, *srcInstance, *srcAkId, *srcAkSecret)
+	target := tablestore.NewClient(*dstEndpoint, *dstInstance, *dstAkId, *dstAkSecret)
+
+	config := copier.Config{
+		SourceTable:   *srcTable,
+		TargetTable:   *dstTable,
+		Parallelism:   *parallelism,
+		SplitSize:     *splitSizeMB << 20,
+		BatchSize:     *batchSize,
+		RowsPerSecond: *rowsPerSecond,
+		AllVersions:   *allVersions,
+		RenameColumns: renames,
+	}
+	if *columns != "" {
+		config.ColumnsToGet = strings.Split(*columns, ",")
+	}
+	if *checkpoint != "" {
+		config.Checkpoint = copier.NewFileCheckpointStore(*checkpoint)
+	}
+	var mu sync.Mutex
+	var lastLog time.Time
+	config.OnProgress = func(report *copier.Report) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(lastLog) >= 10*time.Second {
+			lastLog = time.Now()
+			log.Println(report)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		log.Println("interrupted, stopping")
+		cancel()
+	}()
+
+	c, err := copier.New(source, target, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report, err := c.Run(ctx)
+	if err != nil {
+		if report != nil {
+			log.Println(report)
+		}
+		log.Fatal(err)
+	}
+	fmt.Println("copied:", report)
+
+	if *verify {
+		result, err := copier.Checksum(ctx, target, copier.Config{
+			SourceTable:  *dstTable,
+			Parallelism:  *parallelism,
+			SplitSize:    *splitSizeMB << 20,
+			AllVersions:  *allVersions,
+			ColumnsToGet: renamed(config.ColumnsToGet, renames),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("target:", result)
+		if result.RowsRead != report.RowsWritten || result.Checksum != report.Checksum {
+			log.Fatal("target does not match the copy, it may have been written to during the copy")
+		}
+	}
+}
+
+func parseRenames(value string) (map[string]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	renames := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid rename %q, want old=new", pair)
+		}
+		renames[parts[0]] = parts[1]
+	}
+	return renames, nil
+}
+
+func renamed(columns []string, renames map[string]string) []string {
+	var result []string
+	for _, col := range columns {
+		if name, ok := renames[col]; ok {
+			col = name
+		}
+		result = append(result, col)
+	}
+	return result
+}
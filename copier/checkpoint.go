@@ -0,0 +1,156 @@
+package copier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// Checkpoint records how far each split of a copy has got. It is saved
+// after every page of rows is written, so a copy that stopped early can be
+// resumed by running it again with the same CheckpointStore.
+type Checkpoint struct {
+	SourceTable string        `json:"sourceTable"`
+	TargetTable string        `json:"targetTable"`
+	AllVersions bool          `json:"allVersions"`
+	Splits      []*SplitState `json:"splits"`
+}
+
+// SplitState is the progress of one split. Next is where reading resumes,
+// it is nil until the first page is written.
+type SplitState struct {
+	Lower primaryKey  `json:"lower"`
+	Upper primaryKey  `json:"upper"`
+	Next  *primaryKey `json:"next,omitempty"`
+	Done  bool        `json:"done"`
+
+	RowsRead    int64  `json:"rowsRead"`
+	RowsWritten int64  `json:"rowsWritten"`
+	RowsSkipped int64  `json:"rowsSkipped"`
+	Checksum    uint64 `json:"checksum"`
+}
+
+type CheckpointStore interface {
+	// Load returns nil and no error when there is no checkpoint yet.
+	Load() (*Checkpoint, error)
+	Save(checkpoint *Checkpoint) error
+}
+
+// FileCheckpointStore keeps the checkpoint as JSON in a local file.
+type FileCheckpointStore struct {
+	Path string
+}
+
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{Path: path}
+}
+
+func (s *FileCheckpointStore) Load() (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := new(Checkpoint)
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCheckpoint, err)
+	}
+	return checkpoint, nil
+}
+
+// Save writes to a temporary file first and renames it, so a crash never
+// leaves a truncated checkpoint behind.
+func (s *FileCheckpointStore) Save(checkpoint *Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// primaryKey gives tablestore.PrimaryKey a JSON form that keeps the value
+// types, which encoding/json would lose through interface{}.
+type primaryKey struct {
+	*tablestore.PrimaryKey
+}
+
+type primaryKeyColumnJSON struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	String string `json:"string,omitempty"`
+	Int    int64  `json:"int,omitempty"`
+	Binary []byte `json:"binary,omitempty"`
+}
+
+func (pk primaryKey) MarshalJSON() ([]byte, error) {
+	var columns []primaryKeyColumnJSON
+	if pk.PrimaryKey != nil {
+		for _, col := range pk.PrimaryKeys {
+			c := primaryKeyColumnJSON{Name: col.ColumnName}
+			switch {
+			case col.PrimaryKeyOption == tablestore.MIN:
+				c.Type = "INF_MIN"
+			case col.PrimaryKeyOption == tablestore.MAX:
+				c.Type = "INF_MAX"
+			default:
+				switch v := col.Value.(type) {
+				case string:
+					c.Type, c.String = "STRING", v
+				case int64:
+					c.Type, c.Int = "INTEGER", v
+				case []byte:
+					c.Type, c.Binary = "BINARY", v
+				default:
+					return nil, fmt.Errorf("unsupported primary key value %T in column %s", col.Value, col.ColumnName)
+				}
+			}
+			columns = append(columns, c)
+		}
+	}
+	return json.Marshal(columns)
+}
+
+func (pk *primaryKey) UnmarshalJSON(data []byte) error {
+	var columns []primaryKeyColumnJSON
+	if err := json.Unmarshal(data, &columns); err != nil {
+		return err
+	}
+	pk.PrimaryKey = new(tablestore.PrimaryKey)
+	for _, c := range columns {
+		switch c.Type {
+		case "INF_MIN":
+			pk.AddPrimaryKeyColumnWithMinValue(c.Name)
+		case "INF_MAX":
+			pk.AddPrimaryKeyColumnWithMaxValue(c.Name)
+		case "STRING":
+			pk.AddPrimaryKeyColumn(c.Name, c.String)
+		case "INTEGER":
+			pk.AddPrimaryKeyColumn(c.Name, c.Int)
+		case "BINARY":
+			pk.AddPrimaryKeyColumn(c.Name, c.Binary)
+		default:
+			return fmt.Errorf("unknown primary key type %q in column %s", c.Type, c.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package copier
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"sort"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// rowChecksum hashes a row independently of the order its attribute columns
+// are listed in. The checksum of a table is the sum of its row checksums,
+// which does not depend on the order rows are read in either, so splits can
+// be summed up separately.
+func rowChecksum(row *tablestore.Row) uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		hashString(h, col.ColumnName)
+		hashValue(h, col.Value, &buf)
+	}
+	h.Write([]byte{0xff})
+
+	columns := make([]*tablestore.AttributeColumn, len(row.Columns))
+	copy(columns, row.Columns)
+	sort.SliceStable(columns, func(i, j int) bool {
+		if columns[i].ColumnName != columns[j].ColumnName {
+			return columns[i].ColumnName < columns[j].ColumnName
+		}
+		return columns[i].Timestamp > columns[j].Timestamp
+	})
+	for _, col := range columns {
+		hashString(h, col.ColumnName)
+		binary.LittleEndian.PutUint64(buf[:], uint64(col.Timestamp))
+		h.Write(buf[:])
+		hashValue(h, col.Value, &buf)
+	}
+	return h.Sum64()
+}
+
+func hashString(h hash.Hash64, s string) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(len(s)))
+	h.Write(buf[:])
+	h.Write([]byte(s))
+}
+
+func hashValue(h hash.Hash64, value interface{}, buf *[8]byte) {
+	switch v := value.(type) {
+	case string:
+		h.Write([]byte{1})
+		hashString(h, v)
+	case int64:
+		h.Write([]byte{2})
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		h.Write(buf[:])
+	case bool:
+		if v {
+			h.Write([]byte{3, 1})
+		} else {
+			h.Write([]byte{3, 0})
+		}
+	case float64:
+		h.Write([]byte{4})
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		h.Write(buf[:])
+	case []byte:
+		h.Write([]byte{5})
+		hashString(h, string(v))
+	}
+}
+
+// checkRow makes sure every value can be written, PutRowChange would panic
+// on the others.
+func checkRow(row *tablestore.Row) error {
+	if row.PrimaryKey == nil || len(row.PrimaryKey.PrimaryKeys) == 0 {
+		return fmt.Errorf("%w: row without primary key", ErrInvalidRow)
+	}
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		switch col.Value.(type) {
+		case string, int64, []byte:
+		default:
+			return fmt.Errorf("%w: primary key %s has unsupported type %T", ErrInvalidRow, col.ColumnName, col.Value)
+		}
+	}
+	for _, col := range row.Columns {
+		switch col.Value.(type) {
+		case string, int64, float64, bool, []byte:
+		default:
+			return fmt.Errorf("%w: column %s has unsupported type %T", ErrInvalidRow, col.ColumnName, col.Value)
+		}
+	}
+	return nil
+}
+
+// rowSize estimates the encoded size of a row, used to keep BatchWriteRow
+// requests under the 4MB limit.
+func rowSize(row *tablestore.Row) int {
+	size := 16
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		size += len(col.ColumnName) + valueSize(col.Value) + 16
+	}
+	for _, col := range row.Columns {
+		size += len(col.ColumnName) + valueSize(col.Value) + 24
+	}
+	return size
+}
+
+func valueSize(value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case bool:
+		return 1
+	default:
+		return 8
+	}
+}
@@ -0,0 +1,441 @@
+// Package copier copies rows from one table to another, possibly on another
+// instance with other credentials.
+//
+// The source table is cut into splits with ComputeSplitPointsBySize and the
+// splits are read in parallel with GetRange. Rows are written to the target
+// with BatchWriteRow. Progress can be saved to a CheckpointStore so an
+// interrupted copy resumes where it stopped, and the final Report carries a
+// row count and an order independent checksum which Checksum can recompute
+// on the target to verify the copy.
+package copier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/internal/batchwrite"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var (
+	ErrInvalidConfig     = errors.New("[copier] invalid config")
+	ErrInvalidCheckpoint = errors.New("[copier] invalid checkpoint")
+	ErrInvalidRow        = errors.New("[copier] invalid row")
+)
+
+const (
+	DefaultParallelism   = 4
+	DefaultSplitSize     = 100 << 20
+	DefaultBatchSize     = 200
+	DefaultMaxBatchBytes = 3 << 20
+	DefaultMaxRetries    = 5
+)
+
+// SourceClient is the part of *tablestore.TableStoreClient used to read.
+type SourceClient interface {
+	ComputeSplitPointsBySize(request *tablestore.ComputeSplitPointsBySizeRequest) (*tablestore.ComputeSplitPointsBySizeResponse, error)
+	GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error)
+}
+
+// TargetClient is the part of *tablestore.TableStoreClient used to write.
+type TargetClient interface {
+	BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error)
+}
+
+type Config struct {
+	SourceTable string
+	// TargetTable defaults to SourceTable.
+	TargetTable string
+
+	// Parallelism is the number of splits copied at the same time.
+	Parallelism int
+	// SplitSize is the approximate size of a split in bytes.
+	SplitSize int64
+	// BatchSize and MaxBatchBytes bound a single BatchWriteRow request.
+	BatchSize     int
+	MaxBatchBytes int
+	// MaxRetries is how many times rows rejected by BatchWriteRow are
+	// written again before the copy fails.
+	MaxRetries int
+
+	// ColumnsToGet limits the columns read from the source, all columns are
+	// read when it is empty. Primary key columns are always read.
+	ColumnsToGet []string
+	// AllVersions copies every version of every column, otherwise only the
+	// latest one. Timestamps are kept either way.
+	AllVersions bool
+
+	// RenameColumns maps source column names to target column names. It
+	// applies to primary key and attribute columns alike.
+	RenameColumns map[string]string
+	// Transform is called with each row after renaming. It may change the
+	// row in place or return another one, returning nil skips the row.
+	Transform func(row *tablestore.Row) (*tablestore.Row, error)
+
+	// RowsPerSecond throttles the copy over all workers, 0 means unlimited.
+	RowsPerSecond int
+	// Checkpoint saves progress, a copy started with a store that already
+	// holds a checkpoint resumes from it.
+	Checkpoint CheckpointStore
+	// OnProgress is called after each page of rows is written.
+	OnProgress func(report *Report)
+}
+
+type Report struct {
+	Splits      int
+	SplitsDone  int
+	RowsRead    int64
+	RowsWritten int64
+	RowsSkipped int64
+	// Checksum is the sum of the checksums of the rows written, see
+	// Checksum for computing the same value from a table.
+	Checksum uint64
+	Duration time.Duration
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("splits %d/%d, rows read %d, written %d, skipped %d, checksum %016x, took %s",
+		r.SplitsDone, r.Splits, r.RowsRead, r.RowsWritten, r.RowsSkipped, r.Checksum, r.Duration)
+}
+
+type Copier struct {
+	source  SourceClient
+	target  TargetClient
+	config  Config
+	limiter *limiter
+
+	mu         sync.Mutex
+	checkpoint *Checkpoint
+	started    time.Time
+}
+
+// New creates a Copier. source and target may be the same client.
+func New(source SourceClient, target TargetClient, config Config) (*Copier, error) {
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("%w: source and target clients are required", ErrInvalidConfig)
+	}
+	return newCopier(source, target, config)
+}
+
+func newCopier(source SourceClient, target TargetClient, config Config) (*Copier, error) {
+	if config.SourceTable == "" {
+		return nil, fmt.Errorf("%w: source table is required", ErrInvalidConfig)
+	}
+	if config.TargetTable == "" {
+		config.TargetTable = config.SourceTable
+	}
+	if config.Parallelism <= 0 {
+		config.Parallelism = DefaultParallelism
+	}
+	if config.SplitSize <= 0 {
+		config.SplitSize = DefaultSplitSize
+	}
+	if config.BatchSize <= 0 || config.BatchSize > DefaultBatchSize {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = DefaultMaxBatchBytes
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = DefaultMaxRetries
+	}
+	return &Copier{
+		source:  source,
+		target:  target,
+		config:  config,
+		limiter: newLimiter(config.RowsPerSecond),
+	}, nil
+}
+
+// Run copies the table and returns the report of the whole copy, including
+// the parts done by earlier runs when resuming from a checkpoint.
+func (c *Copier) Run(ctx context.Context) (*Report, error) {
+	c.started = time.Now()
+	checkpoint, err := c.loadCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	c.checkpoint = checkpoint
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	splits := make(chan *SplitState)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < c.config.Parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for split := range splits {
+				if err := c.copySplit(ctx, split); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, split := range checkpoint.Splits {
+		if split.Done {
+			continue
+		}
+		select {
+		case splits <- split:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(splits)
+	wg.Wait()
+
+	report := c.report()
+	if firstErr != nil {
+		return report, firstErr
+	}
+	return report, ctx.Err()
+}
+
+func (c *Copier) loadCheckpoint() (*Checkpoint, error) {
+	if c.config.Checkpoint != nil {
+		checkpoint, err := c.config.Checkpoint.Load()
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil {
+			if checkpoint.SourceTable != c.config.SourceTable || checkpoint.TargetTable != c.config.TargetTable ||
+				checkpoint.AllVersions != c.config.AllVersions {
+				return nil, fmt.Errorf("%w: checkpoint of %s -> %s does not match the config", ErrInvalidCheckpoint,
+					checkpoint.SourceTable, checkpoint.TargetTable)
+			}
+			return checkpoint, nil
+		}
+	}
+
+	const unit = 1 << 20
+	resp, err := c.source.ComputeSplitPointsBySize(&tablestore.ComputeSplitPointsBySizeRequest{
+		TableName:           c.config.SourceTable,
+		SplitSize:           (c.config.SplitSize + unit - 1) / unit,
+		SplitSizeUnitInByte: proto64(unit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{
+		SourceTable: c.config.SourceTable,
+		TargetTable: c.config.TargetTable,
+		AllVersions: c.config.AllVersions,
+	}
+	for _, split := range resp.Splits {
+		checkpoint.Splits = append(checkpoint.Splits, &SplitState{
+			Lower: primaryKey{split.LowerBound},
+			Upper: primaryKey{split.UpperBound},
+		})
+	}
+	if c.config.Checkpoint != nil {
+		if err := c.config.Checkpoint.Save(checkpoint); err != nil {
+			return nil, err
+		}
+	}
+	return checkpoint, nil
+}
+
+// pageResult is what one GetRange page adds to a split.
+type pageResult struct {
+	read, written, skipped int64
+	checksum               uint64
+}
+
+func (c *Copier) copySplit(ctx context.Context, split *SplitState) error {
+	c.mu.Lock()
+	start := split.Lower.PrimaryKey
+	if split.Next != nil {
+		start = split.Next.PrimaryKey
+	}
+	c.mu.Unlock()
+
+	criteria := &tablestore.RangeRowQueryCriteria{
+		TableName:     c.config.SourceTable,
+		EndPrimaryKey: split.Upper.PrimaryKey,
+		ColumnsToGet:  c.config.ColumnsToGet,
+		MaxVersion:    1,
+		Direction:     tablestore.FORWARD,
+	}
+	if c.config.AllVersions {
+		criteria.MaxVersion = math.MaxInt32
+	}
+
+	for start != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		criteria.StartPrimaryKey = start
+		resp, err := c.source.GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: criteria})
+		if err != nil {
+			return err
+		}
+		result, err := c.copyRows(ctx, resp.Rows)
+		if err != nil {
+			return err
+		}
+		if err := c.commit(split, resp.NextStartPrimaryKey, result); err != nil {
+			return err
+		}
+		start = resp.NextStartPrimaryKey
+	}
+	return nil
+}
+
+func (c *Copier) copyRows(ctx context.Context, rows []*tablestore.Row) (*pageResult, error) {
+	result := &pageResult{read: int64(len(rows))}
+	if err := c.limiter.wait(ctx, len(rows)); err != nil {
+		return nil, err
+	}
+
+	var batch []*tablestore.Row
+	batchBytes := 0
+	for _, row := range rows {
+		row, err := c.convert(row)
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			result.skipped++
+			continue
+		}
+		size := rowSize(row)
+		if len(batch) > 0 && (len(batch) >= c.config.BatchSize || batchBytes+size > c.config.MaxBatchBytes) {
+			if err := c.write(ctx, batch); err != nil {
+				return nil, err
+			}
+			batch, batchBytes = nil, 0
+		}
+		batch = append(batch, row)
+		batchBytes += size
+		result.written++
+		result.checksum += rowChecksum(row)
+	}
+	if len(batch) > 0 {
+		if err := c.write(ctx, batch); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (c *Copier) convert(row *tablestore.Row) (*tablestore.Row, error) {
+	if len(c.config.RenameColumns) > 0 {
+		for _, col := range row.PrimaryKey.PrimaryKeys {
+			if name, ok := c.config.RenameColumns[col.ColumnName]; ok {
+				col.ColumnName = name
+			}
+		}
+		for _, col := range row.Columns {
+			if name, ok := c.config.RenameColumns[col.ColumnName]; ok {
+				col.ColumnName = name
+			}
+		}
+	}
+	if c.config.Transform != nil {
+		var err error
+		if row, err = c.config.Transform(row); err != nil || row == nil {
+			return nil, err
+		}
+	}
+	if err := checkRow(row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// write sends a batch, writing the rows the service rejected again up to
+// MaxRetries times. A target is optional so Checksum can reuse the read
+// path.
+func (c *Copier) write(ctx context.Context, rows []*tablestore.Row) error {
+	if c.target == nil {
+		return nil
+	}
+	changes := make([]tablestore.RowChange, 0, len(rows))
+	for _, row := range rows {
+		change := &tablestore.PutRowChange{TableName: c.config.TargetTable, PrimaryKey: row.PrimaryKey}
+		for _, col := range row.Columns {
+			change.AddColumnWithTimestamp(col.ColumnName, col.Value, col.Timestamp)
+		}
+		change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+		changes = append(changes, change)
+	}
+	if err := batchwrite.Write(ctx, c.target, c.config.TargetTable, changes, c.config.MaxRetries); err != nil {
+		return fmt.Errorf("[copier] write %s: %w", c.config.TargetTable, err)
+	}
+	return nil
+}
+
+func (c *Copier) commit(split *SplitState, next *tablestore.PrimaryKey, result *pageResult) error {
+	c.mu.Lock()
+	split.RowsRead += result.read
+	split.RowsWritten += result.written
+	split.RowsSkipped += result.skipped
+	split.Checksum += result.checksum
+	if next == nil {
+		split.Done = true
+	} else {
+		split.Next = &primaryKey{next}
+	}
+	var err error
+	if c.config.Checkpoint != nil {
+		err = c.config.Checkpoint.Save(c.checkpoint)
+	}
+	c.mu.Unlock()
+
+	if err == nil && c.config.OnProgress != nil {
+		c.config.OnProgress(c.report())
+	}
+	return err
+}
+
+func (c *Copier) report() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := &Report{Splits: len(c.checkpoint.Splits), Duration: time.Since(c.started)}
+	for _, split := range c.checkpoint.Splits {
+		if split.Done {
+			report.SplitsDone++
+		}
+		report.RowsRead += split.RowsRead
+		report.RowsWritten += split.RowsWritten
+		report.RowsSkipped += split.RowsSkipped
+		report.Checksum += split.Checksum
+	}
+	return report
+}
+
+// Checksum reads config.SourceTable and reports its row count and checksum
+// without writing anything. Run it on the target table with the AllVersions
+// and ColumnsToGet of the copy to verify that it matches the Report of Run.
+// RenameColumns, Transform and Checkpoint are ignored.
+func Checksum(ctx context.Context, client SourceClient, config Config) (*Report, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: client is required", ErrInvalidConfig)
+	}
+	config.TargetTable = ""
+	config.RenameColumns = nil
+	config.Transform = nil
+	config.Checkpoint = nil
+	c, err := newCopier(client, nil, config)
+	if err != nil {
+		return nil, err
+	}
+	return c.Run(ctx)
+}
+
+func proto64(v int64) *int64 {
+	return &v
+}
@@ -0,0 +1,304 @@
+package copier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ SourceClient = (*tablestore.TableStoreClient)(nil)
+	_ TargetClient = (*tablestore.TableStoreClient)(nil)
+)
+
+// fakeTable is an in-memory table keyed by a single INTEGER primary key. It
+// serves as both source and target.
+type fakeTable struct {
+	mu       sync.Mutex
+	rows     map[int64]*tablestore.Row
+	pageSize int
+
+	// failRows makes BatchWriteRow reject that many rows, one per request.
+	failRows int
+	// failRangeAfter makes GetRange fail after that many calls when positive.
+	failRangeAfter int
+	rangeCalls     int
+	writeCalls     int
+}
+
+func newFakeTable(n int) *fakeTable {
+	t := &fakeTable{rows: make(map[int64]*tablestore.Row), pageSize: 7}
+	for i := 0; i < n; i++ {
+		pk := new(tablestore.PrimaryKey)
+		pk.AddPrimaryKeyColumn("id", int64(i))
+		row := &tablestore.Row{PrimaryKey: pk}
+		row.Columns = append(row.Columns,
+			&tablestore.AttributeColumn{ColumnName: "name", Value: "row", Timestamp: 2000},
+			&tablestore.AttributeColumn{ColumnName: "name", Value: "old", Timestamp: 1000},
+			&tablestore.AttributeColumn{ColumnName: "score", Value: float64(i) / 2, Timestamp: 1000})
+		t.rows[int64(i)] = row
+	}
+	return t
+}
+
+func keyOf(pk *tablestore.PrimaryKey, def int64) int64 {
+	col := pk.PrimaryKeys[0]
+	switch col.PrimaryKeyOption {
+	case tablestore.MIN:
+		return -1 << 63
+	case tablestore.MAX:
+		return 1<<63 - 1
+	}
+	if v, ok := col.Value.(int64); ok {
+		return v
+	}
+	return def
+}
+
+func (t *fakeTable) ComputeSplitPointsBySize(request *tablestore.ComputeSplitPointsBySizeRequest) (*tablestore.ComputeSplitPointsBySizeResponse, error) {
+	min, mid, max := new(tablestore.PrimaryKey), new(tablestore.PrimaryKey), new(tablestore.PrimaryKey)
+	min.AddPrimaryKeyColumnWithMinValue("id")
+	mid.AddPrimaryKeyColumn("id", int64(50))
+	max.AddPrimaryKeyColumnWithMaxValue("id")
+	return &tablestore.ComputeSplitPointsBySizeResponse{
+		Splits: []*tablestore.Split{{LowerBound: min, UpperBound: mid}, {LowerBound: mid, UpperBound: max}},
+	}, nil
+}
+
+func (t *fakeTable) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rangeCalls++
+	if t.failRangeAfter > 0 && t.rangeCalls > t.failRangeAfter {
+		return nil, errors.New("range failed")
+	}
+
+	criteria := request.RangeRowQueryCriteria
+	start, end := keyOf(criteria.StartPrimaryKey, 0), keyOf(criteria.EndPrimaryKey, 0)
+	var keys []int64
+	for k := range t.rows {
+		if k >= start && k < end {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	resp := new(tablestore.GetRangeResponse)
+	for i, k := range keys {
+		if i == t.pageSize {
+			resp.NextStartPrimaryKey = new(tablestore.PrimaryKey)
+			resp.NextStartPrimaryKey.AddPrimaryKeyColumn("id", k)
+			break
+		}
+		resp.Rows = append(resp.Rows, t.copyRow(t.rows[k], criteria.MaxVersion))
+	}
+	return resp, nil
+}
+
+func (t *fakeTable) copyRow(row *tablestore.Row, maxVersion int32) *tablestore.Row {
+	pk := new(tablestore.PrimaryKey)
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		pk.AddPrimaryKeyColumn(col.ColumnName, col.Value)
+	}
+	result := &tablestore.Row{PrimaryKey: pk}
+	versions := make(map[string]int32)
+	for _, col := range row.Columns {
+		if versions[col.ColumnName] < maxVersion {
+			versions[col.ColumnName]++
+			c := *col
+			result.Columns = append(result.Columns, &c)
+		}
+	}
+	return result
+}
+
+func (t *fakeTable) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writeCalls++
+	resp := &tablestore.BatchWriteRowResponse{TableToRowsResult: make(map[string][]tablestore.RowResult)}
+	for table, changes := range request.RowChangesGroupByTable {
+		for i, change := range changes {
+			result := tablestore.RowResult{TableName: table, IsSucceed: true, Index: int32(i)}
+			if t.failRows > 0 && i == 0 {
+				t.failRows--
+				result.IsSucceed = false
+				result.Error = tablestore.Error{Code: "OTSServerBusy", Message: "busy"}
+			} else {
+				put := change.(*tablestore.PutRowChange)
+				row := &tablestore.Row{PrimaryKey: put.PrimaryKey}
+				for j := range put.Columns {
+					row.Columns = append(row.Columns, &put.Columns[j])
+				}
+				t.rows[keyOf(put.PrimaryKey, int64(len(t.rows)))] = row
+			}
+			resp.TableToRowsResult[table] = append(resp.TableToRowsResult[table], result)
+		}
+	}
+	return resp, nil
+}
+
+func TestCopier_Run(t *testing.T) {
+	source, target := newFakeTable(100), newFakeTable(0)
+	var progress int
+	c, err := New(source, target, Config{SourceTable: "src", TargetTable: "dst", BatchSize: 3, OnProgress: func(*Report) { progress++ }})
+	assert.Nil(t, err)
+	report, err := c.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.SplitsDone)
+	assert.Equal(t, int64(100), report.RowsRead)
+	assert.Equal(t, int64(100), report.RowsWritten)
+	assert.Equal(t, 100, len(target.rows))
+	assert.Equal(t, 16, progress)
+
+	// only the latest version is copied by default
+	assert.Equal(t, 2, len(target.rows[42].Columns))
+
+	verify, err := Checksum(context.Background(), target, Config{SourceTable: "dst"})
+	assert.Nil(t, err)
+	assert.Equal(t, report.RowsWritten, verify.RowsRead)
+	assert.Equal(t, report.Checksum, verify.Checksum)
+
+	source.rows[42].Columns[0].Value = "changed"
+	verify, _ = Checksum(context.Background(), source, Config{SourceTable: "src"})
+	assert.NotEqual(t, report.Checksum, verify.Checksum)
+}
+
+func TestCopier_AllVersionsRenameAndTransform(t *testing.T) {
+	source, target := newFakeTable(20), newFakeTable(0)
+	c, _ := New(source, target, Config{
+		SourceTable:   "src",
+		TargetTable:   "dst",
+		AllVersions:   true,
+		RenameColumns: map[string]string{"name": "title"},
+		Transform: func(row *tablestore.Row) (*tablestore.Row, error) {
+			if row.PrimaryKey.PrimaryKeys[0].Value.(int64)%2 == 1 {
+				return nil, nil
+			}
+			row.Columns = append(row.Columns, &tablestore.AttributeColumn{ColumnName: "copied", Value: true, Timestamp: 3000})
+			return row, nil
+		},
+	})
+	report, err := c.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), report.RowsRead)
+	assert.Equal(t, int64(10), report.RowsWritten)
+	assert.Equal(t, int64(10), report.RowsSkipped)
+
+	row := target.rows[4]
+	assert.Equal(t, 4, len(row.Columns))
+	assert.Equal(t, "title", row.Columns[0].ColumnName)
+	assert.Equal(t, int64(1000), row.Columns[1].Timestamp)
+
+	verify, err := Checksum(context.Background(), target, Config{SourceTable: "dst", AllVersions: true})
+	assert.Nil(t, err)
+	assert.Equal(t, report.Checksum, verify.Checksum)
+}
+
+func TestCopier_InvalidRow(t *testing.T) {
+	c, _ := New(newFakeTable(10), newFakeTable(0), Config{
+		SourceTable: "src",
+		Transform: func(row *tablestore.Row) (*tablestore.Row, error) {
+			row.Columns[0].Value = 1
+			return row, nil
+		},
+	})
+	_, err := c.Run(context.Background())
+	assert.True(t, errors.Is(err, ErrInvalidRow))
+}
+
+func TestCopier_RetryFailedRows(t *testing.T) {
+	source, target := newFakeTable(10), newFakeTable(0)
+	target.failRows = 2
+	c, _ := New(source, target, Config{SourceTable: "src", Parallelism: 1})
+	report, err := c.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), report.RowsWritten)
+	assert.Equal(t, 10, len(target.rows))
+
+	target = newFakeTable(0)
+	target.failRows = 100
+	c, _ = New(source, target, Config{SourceTable: "src", MaxRetries: 1})
+	_, err = c.Run(context.Background())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "OTSServerBusy")
+}
+
+func TestCopier_Resume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copier")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := NewFileCheckpointStore(filepath.Join(dir, "checkpoint.json"))
+	source, target := newFakeTable(100), newFakeTable(0)
+	source.failRangeAfter = 5
+	config := Config{SourceTable: "src", TargetTable: "dst", Parallelism: 1, Checkpoint: store}
+
+	c, _ := New(source, target, config)
+	report, err := c.Run(context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(35), report.RowsWritten)
+
+	checkpoint, err := store.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(35), keyOf(checkpoint.Splits[0].Next.PrimaryKey, -1))
+
+	source.failRangeAfter = 0
+	source.rangeCalls = 0
+	c, _ = New(source, target, config)
+	report, err = c.Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), report.RowsWritten)
+	assert.Equal(t, 2, report.SplitsDone)
+	assert.Equal(t, 11, source.rangeCalls)
+
+	verify, _ := Checksum(context.Background(), target, Config{SourceTable: "dst"})
+	assert.Equal(t, report.Checksum, verify.Checksum)
+
+	config.AllVersions = true
+	c, _ = New(source, target, config)
+	_, err = c.Run(context.Background())
+	assert.True(t, errors.Is(err, ErrInvalidCheckpoint))
+}
+
+func TestCopier_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c, _ := New(newFakeTable(100), newFakeTable(0), Config{SourceTable: "src", OnProgress: func(*Report) { cancel() }})
+	report, err := c.Run(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, report.SplitsDone < 2)
+}
+
+func TestPrimaryKeyJSON(t *testing.T) {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn("s", "a")
+	pk.AddPrimaryKeyColumn("i", int64(-7))
+	pk.AddPrimaryKeyColumn("b", []byte{0, 1})
+	pk.AddPrimaryKeyColumnWithMinValue("min")
+	pk.AddPrimaryKeyColumnWithMaxValue("max")
+
+	data, err := json.Marshal(primaryKey{pk})
+	assert.Nil(t, err)
+	var decoded primaryKey
+	assert.Nil(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, pk, decoded.PrimaryKey)
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1000)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, l.wait(context.Background(), 10))
+	}
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+	assert.Nil(t, newLimiter(0).wait(context.Background(), 10))
+}
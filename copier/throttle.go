@@ -0,0 +1,51 @@
+package copier
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter spreads rows evenly over time. It lets a worker through once the
+// rows before it have had their share of the rate, so bursts of one page are
+// allowed but the average stays at the configured rate.
+type limiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newLimiter(rowsPerSecond int) *limiter {
+	if rowsPerSecond <= 0 {
+		return nil
+	}
+	return &limiter{interval: time.Second / time.Duration(rowsPerSecond)}
+}
+
+func (l *limiter) wait(ctx context.Context, rows int) error {
+	if l == nil || rows == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(time.Duration(rows) * l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,186 @@
+// Package idgen allocates int64 IDs from counter rows of a table.
+//
+// A Generator leases segments of SegmentSize IDs per namespace by
+// incrementing the counter row of the namespace and reading the value after
+// the increment, so no two leases share an ID, across processes and
+// restarts alike. IDs are served from memory and the next segment is
+// leased in the background when the current one runs low.
+//
+// The IDs of a Generator increase for each namespace. Generators in other
+// processes serve their own segments, so IDs are unique but only roughly
+// ordered across processes, and the unused IDs of a segment are skipped
+// when a process stops.
+package idgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var ErrInvalidConfig = errors.New("[idgen] invalid config")
+
+const (
+	NamespaceColumn = "namespace"
+
+	counterColumn = "next"
+)
+
+const (
+	DefaultSegmentSize = 1000
+	// DefaultPrefetchRatio leases the next segment when a tenth of the
+	// current one is left.
+	DefaultPrefetchRatio = 0.1
+)
+
+// Client is the part of *tablestore.TableStoreClient used by a Generator.
+type Client interface {
+	UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error)
+}
+
+type Config struct {
+	// Table has a single STRING primary key column, see NamespaceColumn.
+	Table       string
+	SegmentSize int64
+	// PrefetchRatio is the share of the current segment left when the next
+	// one is leased, between 0 and 1.
+	PrefetchRatio float64
+}
+
+type Generator struct {
+	client Client
+	config Config
+
+	mu         sync.Mutex
+	namespaces map[string]*namespace
+}
+
+// namespace serves the IDs from next to end of the current segment.
+type namespace struct {
+	mu        sync.Mutex
+	next, end int64
+	ready     *segment
+	fetch     *fetch
+}
+
+type segment struct {
+	start, end int64
+}
+
+// fetch is a segment lease in flight.
+type fetch struct {
+	done chan struct{}
+	err  error
+}
+
+func New(client Client, config Config) (*Generator, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: client is required", ErrInvalidConfig)
+	}
+	if config.Table == "" {
+		return nil, fmt.Errorf("%w: table is required", ErrInvalidConfig)
+	}
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = DefaultSegmentSize
+	}
+	if config.PrefetchRatio == 0 {
+		config.PrefetchRatio = DefaultPrefetchRatio
+	}
+	if config.PrefetchRatio < 0 || config.PrefetchRatio > 1 {
+		return nil, fmt.Errorf("%w: prefetch ratio %v is not between 0 and 1", ErrInvalidConfig, config.PrefetchRatio)
+	}
+	return &Generator{client: client, config: config, namespaces: make(map[string]*namespace)}, nil
+}
+
+// Next returns the next ID of a namespace. It only waits for the table
+// when the current segment is used up before the next one was leased.
+func (g *Generator) Next(ctx context.Context, name string) (int64, error) {
+	g.mu.Lock()
+	ns, ok := g.namespaces[name]
+	if !ok {
+		// an empty segment, the first call leases one
+		ns = &namespace{next: 1, end: 0}
+		g.namespaces[name] = ns
+	}
+	g.mu.Unlock()
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	for {
+		if ns.next <= ns.end {
+			id := ns.next
+			ns.next++
+			left := ns.end - ns.next + 1
+			if float64(left) < g.config.PrefetchRatio*float64(g.config.SegmentSize) && ns.ready == nil && ns.fetch == nil {
+				g.startFetch(name, ns)
+			}
+			return id, nil
+		}
+		if ns.ready != nil {
+			ns.next, ns.end = ns.ready.start, ns.ready.end
+			ns.ready = nil
+			continue
+		}
+		if ns.fetch == nil {
+			g.startFetch(name, ns)
+		}
+
+		f := ns.fetch
+		ns.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+		}
+		ns.mu.Lock()
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if f.err != nil {
+			return 0, f.err
+		}
+	}
+}
+
+// startFetch leases a segment in the background, ns.mu must be held.
+func (g *Generator) startFetch(name string, ns *namespace) {
+	f := &fetch{done: make(chan struct{})}
+	ns.fetch = f
+	go func() {
+		seg, err := g.lease(name)
+		ns.mu.Lock()
+		if err == nil {
+			ns.ready = seg
+		}
+		f.err = err
+		ns.fetch = nil
+		ns.mu.Unlock()
+		close(f.done)
+	}()
+}
+
+// lease increments the counter of a namespace by SegmentSize, the segment
+// ends at the value after the increment.
+func (g *Generator) lease(name string) (*segment, error) {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn(NamespaceColumn, name)
+	change := &tablestore.UpdateRowChange{TableName: g.config.Table, PrimaryKey: pk}
+	change.IncrementColumn(counterColumn, g.config.SegmentSize)
+	change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+	change.SetReturnIncrementValue()
+	change.AppendIncrementColumnToReturn(counterColumn)
+	resp, err := g.client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change})
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range resp.Columns {
+		if col.ColumnName == counterColumn {
+			if end, ok := col.Value.(int64); ok {
+				return &segment{start: end - g.config.SegmentSize + 1, end: end}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("[idgen] the counter of %s was not returned", name)
+}
@@ -0,0 +1,181 @@
+package idgen
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+// fakeCounters increments the counter rows by namespace and returns the
+// value after the increment, like the table does.
+type fakeCounters struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	leases   int
+	err      error
+	// block holds the leases until it is closed.
+	block chan struct{}
+}
+
+func newFakeCounters() *fakeCounters {
+	return &fakeCounters{counters: make(map[string]int64)}
+}
+
+func (f *fakeCounters) UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error) {
+	f.mu.Lock()
+	block := f.block
+	f.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	change := request.UpdateRowChange
+	if change.ReturnType != tablestore.ReturnType_RT_AFTER_MODIFY {
+		return nil, errors.New("unexpected return type")
+	}
+	name := change.PrimaryKey.PrimaryKeys[0].Value.(string)
+	resp := &tablestore.UpdateRowResponse{}
+	for _, col := range change.Columns {
+		if col.Type == tablestore.INCREMENT {
+			f.counters[name] += col.Value.(int64)
+			resp.Columns = append(resp.Columns, &tablestore.AttributeColumn{ColumnName: col.ColumnName, Value: f.counters[name]})
+		}
+	}
+	f.leases++
+	return resp, nil
+}
+
+func (f *fakeCounters) leaseCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.leases
+}
+
+func TestNext(t *testing.T) {
+	counters := newFakeCounters()
+	g, err := New(counters, Config{Table: "ids", SegmentSize: 10, PrefetchRatio: 0.5})
+	assert.Nil(t, err)
+	ctx := context.Background()
+
+	for want := int64(1); want <= 25; want++ {
+		id, err := g.Next(ctx, "orders")
+		assert.Nil(t, err)
+		assert.Equal(t, want, id)
+	}
+	id, err := g.Next(ctx, "users")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), id)
+
+	// a restarted generator skips the segments leased before
+	restarted, _ := New(counters, Config{Table: "ids", SegmentSize: 10})
+	id, err = restarted.Next(ctx, "orders")
+	assert.Nil(t, err)
+	assert.True(t, id > 25)
+	assert.Equal(t, int64(1), id%10)
+}
+
+func TestPrefetch(t *testing.T) {
+	counters := newFakeCounters()
+	g, _ := New(counters, Config{Table: "ids", SegmentSize: 10, PrefetchRatio: 0.5})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		_, err := g.Next(ctx, "orders")
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, counters.leaseCount())
+
+	// the sixth ID leaves less than half of the segment
+	_, err := g.Next(ctx, "orders")
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool { return counters.leaseCount() == 2 }, time.Second, time.Millisecond)
+
+	// the prefetched segment is served without another lease
+	for i := 0; i < 4; i++ {
+		_, err := g.Next(ctx, "orders")
+		assert.Nil(t, err)
+	}
+	id, err := g.Next(ctx, "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), id)
+}
+
+func TestConcurrentNext(t *testing.T) {
+	counters := newFakeCounters()
+	a, _ := New(counters, Config{Table: "ids", SegmentSize: 7})
+	b, _ := New(counters, Config{Table: "ids", SegmentSize: 5})
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for _, g := range []*Generator{a, b, a, b} {
+		wg.Add(1)
+		go func(g *Generator) {
+			defer wg.Done()
+			last := int64(0)
+			for i := 0; i < 200; i++ {
+				id, err := g.Next(ctx, "orders")
+				assert.Nil(t, err)
+				mu.Lock()
+				assert.False(t, seen[id], "duplicate id %d", id)
+				seen[id] = true
+				mu.Unlock()
+				assert.True(t, id > last)
+				last = id
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Len(t, seen, 800)
+}
+
+func TestNextErrors(t *testing.T) {
+	counters := newFakeCounters()
+	counters.err = errors.New("connection refused")
+	g, _ := New(counters, Config{Table: "ids"})
+
+	_, err := g.Next(context.Background(), "orders")
+	assert.EqualError(t, err, "connection refused")
+
+	counters.mu.Lock()
+	counters.err = nil
+	counters.block = make(chan struct{})
+	counters.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = g.Next(ctx, "orders")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the lease in flight is picked up by the next call
+	close(counters.block)
+	id, err := g.Next(context.Background(), "orders")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), id)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, Config{Table: "ids"})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = New(newFakeCounters(), Config{})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = New(newFakeCounters(), Config{Table: "ids", PrefetchRatio: 2})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	g, err := New(newFakeCounters(), Config{Table: "ids"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(DefaultSegmentSize), g.config.SegmentSize)
+	assert.Equal(t, DefaultPrefetchRatio, g.config.PrefetchRatio)
+}
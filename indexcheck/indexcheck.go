@@ -0,0 +1,345 @@
+// Package indexcheck verifies that a secondary index holds exactly the rows
+// its main table implies.
+//
+// Check scans the main table and the index at the same time. From each row
+// of the main table it computes the index row the IndexMeta asks for, then
+// compares them with the rows actually found in the index and reports the
+// missing, extra and stale ones. Global indexes are updated asynchronously,
+// so rows written during the check may show up as differences; check again
+// before acting on a small number of them.
+//
+// Both tables are held in memory keyed by index primary key, which bounds
+// the size of the tables a single check can handle.
+package indexcheck
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var (
+	ErrInvalidConfig = errors.New("[indexcheck] invalid config")
+	ErrIndexNotFound = errors.New("[indexcheck] index not found")
+)
+
+// Client is the part of *tablestore.TableStoreClient used by Check.
+type Client interface {
+	DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error)
+	GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error)
+}
+
+type Config struct {
+	Table string
+	// Index is the name of a secondary index of Table, its IndexMeta is
+	// read with DescribeTable.
+	Index string
+	// IndexMeta describes a user-managed index table instead of Index, an
+	// ordinary table the application keeps in sync with Table. IndexName is
+	// the name of that table and Primarykey and DefinedColumns are columns
+	// of Table, as they would be for a secondary index.
+	IndexMeta *tablestore.IndexMeta
+}
+
+// Difference is an index row that does not match the main table. Expected
+// is the row the main table implies and is nil for an extra row, Actual is
+// the row found in the index and is nil for a missing row.
+type Difference struct {
+	Expected *tablestore.Row
+	Actual   *tablestore.Row
+}
+
+type Report struct {
+	Table     string
+	Index     string
+	TableRows int64
+	IndexRows int64
+	// Missing rows are implied by the main table but not in the index.
+	Missing []*Difference
+	// Extra rows are in the index but no row of the main table implies them.
+	Extra []*Difference
+	// Stale rows are in the index with defined columns that differ from the
+	// main table.
+	Stale []*Difference
+}
+
+func (r *Report) Consistent() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.Stale) == 0
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("%s rows %d, %s rows %d, missing %d, extra %d, stale %d",
+		r.Table, r.TableRows, r.Index, r.IndexRows, len(r.Missing), len(r.Extra), len(r.Stale))
+}
+
+// index is the schema of the index rows.
+type index struct {
+	name string
+	// primaryKey is the index primary key followed by the primary key
+	// columns of the main table it leaves out.
+	primaryKey []string
+	// tablePrimaryKey are the primary key columns of the main table.
+	tablePrimaryKey []string
+	columns         []string
+}
+
+// Check compares the index with its main table.
+func Check(ctx context.Context, client Client, config Config) (*Report, error) {
+	if config.Table == "" || (config.Index == "") == (config.IndexMeta == nil) {
+		return nil, fmt.Errorf("%w: a table and either an index or an index meta are required", ErrInvalidConfig)
+	}
+	idx, err := loadIndex(client, config)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{Table: config.Table, Index: idx.name}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	expected := make(map[string]*tablestore.Row)
+	actual := make(map[string]*tablestore.Row)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		err := scan(ctx, client, config.Table, idx.tablePrimaryKey, idx.tableColumns(), func(row *tablestore.Row) {
+			report.TableRows++
+			if indexRow := idx.indexRow(row); indexRow != nil {
+				expected[primaryKeyString(indexRow.PrimaryKey)] = indexRow
+			}
+		})
+		if err != nil {
+			fail(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		err := scan(ctx, client, idx.name, idx.primaryKey, nil, func(row *tablestore.Row) {
+			report.IndexRows++
+			actual[primaryKeyString(row.PrimaryKey)] = row
+		})
+		if err != nil {
+			fail(err)
+		}
+	}()
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	for _, key := range sortedKeys(expected) {
+		row, found := actual[key]
+		switch {
+		case !found:
+			report.Missing = append(report.Missing, &Difference{Expected: expected[key]})
+		case !idx.sameColumns(expected[key], row):
+			report.Stale = append(report.Stale, &Difference{Expected: expected[key], Actual: row})
+		}
+	}
+	for _, key := range sortedKeys(actual) {
+		if _, found := expected[key]; !found {
+			report.Extra = append(report.Extra, &Difference{Actual: actual[key]})
+		}
+	}
+	return report, nil
+}
+
+func loadIndex(client Client, config Config) (*index, error) {
+	resp, err := client.DescribeTable(&tablestore.DescribeTableRequest{TableName: config.Table})
+	if err != nil {
+		return nil, err
+	}
+	meta := config.IndexMeta
+	if meta == nil {
+		for _, m := range resp.IndexMetas {
+			if m.IndexName == config.Index {
+				meta = m
+			}
+		}
+		if meta == nil {
+			return nil, fmt.Errorf("%w: table %s has no index %s", ErrIndexNotFound, config.Table, config.Index)
+		}
+	}
+	if meta.IndexName == "" || len(meta.Primarykey) == 0 {
+		return nil, fmt.Errorf("%w: index meta needs a name and a primary key", ErrInvalidConfig)
+	}
+
+	idx := &index{
+		name:       meta.IndexName,
+		primaryKey: append([]string{}, meta.Primarykey...),
+		columns:    meta.DefinedColumns,
+	}
+	for _, schema := range resp.TableMeta.SchemaEntry {
+		name := *schema.Name
+		idx.tablePrimaryKey = append(idx.tablePrimaryKey, name)
+		if !contains(idx.primaryKey, name) {
+			idx.primaryKey = append(idx.primaryKey, name)
+		}
+	}
+	return idx, nil
+}
+
+// tableColumns are the attribute columns of the main table the index rows
+// are made of, and a primary key column: a range leaves out the rows with
+// none of the requested columns, and an empty list reads every column.
+func (idx *index) tableColumns() []string {
+	var columns []string
+	for _, name := range idx.primaryKey {
+		if !contains(idx.tablePrimaryKey, name) {
+			columns = append(columns, name)
+		}
+	}
+	columns = append(columns, idx.columns...)
+	return append(columns, idx.tablePrimaryKey[0])
+}
+
+// indexRow returns the index row of a row of the main table, nil when the
+// row lacks a column of the index primary key and is not indexed.
+func (idx *index) indexRow(row *tablestore.Row) *tablestore.Row {
+	values := make(map[string]*tablestore.AttributeColumn)
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		values[col.ColumnName] = &tablestore.AttributeColumn{ColumnName: col.ColumnName, Value: col.Value}
+	}
+	for _, col := range row.Columns {
+		// the latest version comes first
+		if _, ok := values[col.ColumnName]; !ok {
+			values[col.ColumnName] = col
+		}
+	}
+
+	indexRow := &tablestore.Row{PrimaryKey: new(tablestore.PrimaryKey)}
+	for _, name := range idx.primaryKey {
+		col, ok := values[name]
+		if !ok {
+			return nil
+		}
+		indexRow.PrimaryKey.AddPrimaryKeyColumn(name, col.Value)
+	}
+	for _, name := range idx.columns {
+		if col, ok := values[name]; ok {
+			indexRow.Columns = append(indexRow.Columns, col)
+		}
+	}
+	return indexRow
+}
+
+func (idx *index) sameColumns(expected, actual *tablestore.Row) bool {
+	want := columnValues(expected, idx.columns)
+	got := columnValues(actual, idx.columns)
+	if len(want) != len(got) {
+		return false
+	}
+	for name, value := range want {
+		if got[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func columnValues(row *tablestore.Row, names []string) map[string]string {
+	values := make(map[string]string)
+	for _, col := range row.Columns {
+		if _, ok := values[col.ColumnName]; !ok && contains(names, col.ColumnName) {
+			values[col.ColumnName] = valueString(col.Value)
+		}
+	}
+	return values
+}
+
+func scan(ctx context.Context, client Client, table string, primaryKey, columns []string, fn func(row *tablestore.Row)) error {
+	start, end := new(tablestore.PrimaryKey), new(tablestore.PrimaryKey)
+	for _, name := range primaryKey {
+		start.AddPrimaryKeyColumnWithMinValue(name)
+		end.AddPrimaryKeyColumnWithMaxValue(name)
+	}
+	criteria := &tablestore.RangeRowQueryCriteria{
+		TableName:       table,
+		StartPrimaryKey: start,
+		EndPrimaryKey:   end,
+		ColumnsToGet:    columns,
+		MaxVersion:      1,
+		Direction:       tablestore.FORWARD,
+	}
+	for criteria.StartPrimaryKey != nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		resp, err := client.GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: criteria})
+		if err != nil {
+			return err
+		}
+		for _, row := range resp.Rows {
+			fn(row)
+		}
+		criteria.StartPrimaryKey = resp.NextStartPrimaryKey
+	}
+	return nil
+}
+
+// primaryKeyString is a map key for a primary key that tells values of
+// different types apart.
+func primaryKeyString(pk *tablestore.PrimaryKey) string {
+	var b strings.Builder
+	for _, col := range pk.PrimaryKeys {
+		b.WriteString(col.ColumnName)
+		b.WriteByte('=')
+		b.WriteString(valueString(col.Value))
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return "s:" + v
+	case int64:
+		return "i:" + strconv.FormatInt(v, 10)
+	case float64:
+		if math.IsNaN(v) {
+			return "d:NaN"
+		}
+		return "d:" + strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return "b:" + strconv.FormatBool(v)
+	case []byte:
+		return "x:" + hex.EncodeToString(v)
+	}
+	return fmt.Sprintf("%T:%v", value, value)
+}
+
+func sortedKeys(rows map[string]*tablestore.Row) []string {
+	keys := make([]string, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
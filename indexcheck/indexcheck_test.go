@@ -0,0 +1,244 @@
+package indexcheck
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+// fakeClient holds the table users with the primary key user and its
+// index idx_city on city with the defined column name. Rows are kept in
+// primary key order and read two at a time.
+type fakeClient struct {
+	mu     sync.Mutex
+	tables map[string][]*tablestore.Row
+	// columns are the ColumnsToGet of the reads by table
+	columns map[string][]string
+}
+
+func newRow(pk []string, columns ...interface{}) *tablestore.Row {
+	row := &tablestore.Row{PrimaryKey: new(tablestore.PrimaryKey)}
+	for i := 0; i < len(pk); i += 2 {
+		row.PrimaryKey.AddPrimaryKeyColumn(pk[i], pk[i+1])
+	}
+	for i := 0; i < len(columns); i += 2 {
+		row.Columns = append(row.Columns, &tablestore.AttributeColumn{ColumnName: columns[i].(string), Value: columns[i+1], Timestamp: 1000})
+	}
+	return row
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		tables: map[string][]*tablestore.Row{
+			"users": {
+				newRow([]string{"user", "a"}, "age", int64(30), "city", "beijing", "name", "Ann"),
+				newRow([]string{"user", "b"}, "city", "hangzhou", "name", "Bob"),
+				// not indexed without a city
+				newRow([]string{"user", "c"}, "name", "Cid"),
+				newRow([]string{"user", "d"}, "city", "shanghai"),
+			},
+			"idx_city": {
+				newRow([]string{"city", "beijing", "user", "a"}, "name", "Ann"),
+				newRow([]string{"city", "hangzhou", "user", "b"}, "name", "Bobby"),
+				newRow([]string{"city", "hangzhou", "user", "e"}, "name", "Eve"),
+			},
+		},
+		columns: make(map[string][]string),
+	}
+}
+
+func (c *fakeClient) DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error) {
+	if request.TableName != "users" {
+		return nil, errors.New("OTSObjectNotExist")
+	}
+	meta := &tablestore.TableMeta{TableName: "users"}
+	meta.AddPrimaryKeyColumn("user", tablestore.PrimaryKeyType_STRING)
+	index := &tablestore.IndexMeta{IndexName: "idx_city"}
+	index.AddPrimaryKeyColumn("city")
+	index.AddDefinedColumn("name")
+	return &tablestore.DescribeTableResponse{TableMeta: meta, IndexMetas: []*tablestore.IndexMeta{index}}, nil
+}
+
+func (c *fakeClient) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	criteria := request.RangeRowQueryCriteria
+	rows, ok := c.tables[criteria.TableName]
+	if !ok {
+		return nil, errors.New("OTSObjectNotExist")
+	}
+	c.columns[criteria.TableName] = criteria.ColumnsToGet
+	start := 0
+	if criteria.StartPrimaryKey.PrimaryKeys[0].PrimaryKeyOption != tablestore.MIN {
+		for i, row := range rows {
+			if primaryKeyString(row.PrimaryKey) == primaryKeyString(criteria.StartPrimaryKey) {
+				start = i
+			}
+		}
+	}
+	end := start + 2
+	resp := new(tablestore.GetRangeResponse)
+	if end < len(rows) {
+		resp.NextStartPrimaryKey = rows[end].PrimaryKey
+	} else {
+		end = len(rows)
+	}
+	for _, row := range rows[start:end] {
+		if row = selectColumns(row, criteria.ColumnsToGet); row != nil {
+			resp.Rows = append(resp.Rows, row)
+		}
+	}
+	return resp, nil
+}
+
+// selectColumns keeps the requested attribute columns of a row. Like the
+// service it returns nil for a row with none of the requested columns.
+func selectColumns(row *tablestore.Row, columnsToGet []string) *tablestore.Row {
+	if len(columnsToGet) == 0 {
+		return row
+	}
+	selected := &tablestore.Row{PrimaryKey: row.PrimaryKey}
+	for _, col := range row.Columns {
+		if contains(columnsToGet, col.ColumnName) {
+			selected.Columns = append(selected.Columns, col)
+		}
+	}
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		if contains(columnsToGet, col.ColumnName) {
+			return selected
+		}
+	}
+	if len(selected.Columns) == 0 {
+		return nil
+	}
+	return selected
+}
+
+func primaryKeyValues(diffs []*Difference, expected bool) [][]interface{} {
+	var keys [][]interface{}
+	for _, diff := range diffs {
+		row := diff.Actual
+		if expected {
+			row = diff.Expected
+		}
+		var key []interface{}
+		for _, col := range row.PrimaryKey.PrimaryKeys {
+			key = append(key, col.Value)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestCheck(t *testing.T) {
+	client := newFakeClient()
+	report, err := Check(context.Background(), client, Config{Table: "users", Index: "idx_city"})
+	assert.Nil(t, err)
+	assert.False(t, report.Consistent())
+	assert.Equal(t, int64(4), report.TableRows)
+	assert.Equal(t, int64(3), report.IndexRows)
+	assert.Equal(t, []string{"city", "name", "user"}, client.columns["users"])
+	assert.Nil(t, client.columns["idx_city"])
+
+	assert.Equal(t, [][]interface{}{{"shanghai", "d"}}, primaryKeyValues(report.Missing, true))
+	assert.Empty(t, report.Missing[0].Expected.Columns)
+	assert.Equal(t, [][]interface{}{{"hangzhou", "e"}}, primaryKeyValues(report.Extra, false))
+	assert.Equal(t, [][]interface{}{{"hangzhou", "b"}}, primaryKeyValues(report.Stale, false))
+	assert.Equal(t, "Bob", report.Stale[0].Expected.Columns[0].Value)
+	assert.Equal(t, "users rows 4, idx_city rows 3, missing 1, extra 1, stale 1", report.String())
+
+	// repaired, the index matches
+	client.tables["idx_city"] = []*tablestore.Row{
+		newRow([]string{"city", "beijing", "user", "a"}, "name", "Ann"),
+		newRow([]string{"city", "hangzhou", "user", "b"}, "name", "Bob"),
+		newRow([]string{"city", "shanghai", "user", "d"}),
+	}
+	report, err = Check(context.Background(), client, Config{Table: "users", Index: "idx_city"})
+	assert.Nil(t, err)
+	assert.True(t, report.Consistent())
+}
+
+func TestCheckUserManagedIndex(t *testing.T) {
+	client := newFakeClient()
+	client.tables["users_by_name"] = []*tablestore.Row{
+		newRow([]string{"name", "Ann", "user", "a"}),
+		newRow([]string{"name", "Bob", "user", "b"}),
+	}
+	meta := &tablestore.IndexMeta{IndexName: "users_by_name"}
+	meta.AddPrimaryKeyColumn("name")
+	report, err := Check(context.Background(), client, Config{Table: "users", IndexMeta: meta})
+	assert.Nil(t, err)
+	assert.Equal(t, "users_by_name", report.Index)
+	assert.Equal(t, [][]interface{}{{"Cid", "c"}}, primaryKeyValues(report.Missing, true))
+	assert.Empty(t, report.Extra)
+	assert.Empty(t, report.Stale)
+}
+
+func TestCheckRowsWithOnlyPrimaryKey(t *testing.T) {
+	client := newFakeClient()
+	client.tables["users"] = append(client.tables["users"], newRow([]string{"user", "f"}))
+	client.tables["users_by_user"] = []*tablestore.Row{
+		newRow([]string{"user", "a"}, "age", int64(30)),
+		newRow([]string{"user", "b"}),
+		newRow([]string{"user", "c"}),
+		newRow([]string{"user", "d"}),
+		newRow([]string{"user", "f"}),
+	}
+	meta := &tablestore.IndexMeta{IndexName: "users_by_user"}
+	meta.AddPrimaryKeyColumn("user")
+	meta.AddDefinedColumn("age")
+	report, err := Check(context.Background(), client, Config{Table: "users", IndexMeta: meta})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), report.TableRows)
+	assert.True(t, report.Consistent(), report.String())
+}
+
+func TestRepairPlan(t *testing.T) {
+	report, err := Check(context.Background(), newFakeClient(), Config{Table: "users", Index: "idx_city"})
+	assert.Nil(t, err)
+	changes := report.RepairPlan()
+	assert.Len(t, changes, 3)
+
+	put := changes[0].(*tablestore.PutRowChange)
+	assert.Equal(t, "idx_city", put.TableName)
+	assert.Equal(t, "shanghai", put.PrimaryKey.PrimaryKeys[0].Value)
+	assert.Empty(t, put.Columns)
+
+	put = changes[1].(*tablestore.PutRowChange)
+	assert.Equal(t, "b", put.PrimaryKey.PrimaryKeys[1].Value)
+	assert.Equal(t, "Bob", put.Columns[0].Value)
+	assert.Equal(t, int64(1000), put.Columns[0].Timestamp)
+
+	del := changes[2].(*tablestore.DeleteRowChange)
+	assert.Equal(t, "e", del.PrimaryKey.PrimaryKeys[1].Value)
+	assert.Equal(t, tablestore.RowExistenceExpectation_IGNORE, del.Condition.RowExistenceExpectation)
+}
+
+func TestCheckErrors(t *testing.T) {
+	client := newFakeClient()
+	ctx := context.Background()
+	_, err := Check(ctx, client, Config{Table: "users"})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = Check(ctx, client, Config{Table: "users", Index: "idx_city", IndexMeta: &tablestore.IndexMeta{}})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = Check(ctx, client, Config{Table: "users", Index: "idx_name"})
+	assert.True(t, errors.Is(err, ErrIndexNotFound))
+	_, err = Check(ctx, client, Config{Table: "users", IndexMeta: &tablestore.IndexMeta{IndexName: "users_by_name"}})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	meta := &tablestore.IndexMeta{IndexName: "no_such_table"}
+	meta.AddPrimaryKeyColumn("name")
+	_, err = Check(ctx, client, Config{Table: "users", IndexMeta: meta})
+	assert.NotNil(t, err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = Check(canceled, client, Config{Table: "users", Index: "idx_city"})
+	assert.Equal(t, context.Canceled, err)
+}
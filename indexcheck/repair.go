@@ -0,0 +1,40 @@
+package indexcheck
+
+import (
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// RepairPlan returns the row changes that make a user-managed index table
+// match its main table: a put of the expected row for each missing and
+// stale row and a delete for each extra row. Secondary indexes maintained
+// by the service cannot be written, rebuild those instead.
+//
+// The puts replace whole rows and keep the timestamps read from the main
+// table. Apply the plan with BatchWriteRow, at most 200 changes a request.
+func (r *Report) RepairPlan() []tablestore.RowChange {
+	var changes []tablestore.RowChange
+	put := func(diff *Difference) {
+		change := &tablestore.PutRowChange{TableName: r.Index, PrimaryKey: diff.Expected.PrimaryKey}
+		for _, col := range diff.Expected.Columns {
+			if col.Timestamp != 0 {
+				change.AddColumnWithTimestamp(col.ColumnName, col.Value, col.Timestamp)
+			} else {
+				change.AddColumn(col.ColumnName, col.Value)
+			}
+		}
+		change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+		changes = append(changes, change)
+	}
+	for _, diff := range r.Missing {
+		put(diff)
+	}
+	for _, diff := range r.Stale {
+		put(diff)
+	}
+	for _, diff := range r.Extra {
+		change := &tablestore.DeleteRowChange{TableName: r.Index, PrimaryKey: diff.Actual.PrimaryKey}
+		change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+		changes = append(changes, change)
+	}
+	return changes
+}
@@ -0,0 +1,58 @@
+// Package batchwrite sends row changes with BatchWriteRow and writes the
+// rows the service rejected again.
+package batchwrite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// Client is the part of *tablestore.TableStoreClient Write uses.
+type Client interface {
+	BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error)
+}
+
+// Write sends the changes of a table in one request and writes the rows the
+// service rejected again, with a growing pause, until they succeed or
+// maxRetries is reached. Rejected rows are often throttled or hit a busy
+// partition, so they are retried alone rather than failing the batch.
+func Write(ctx context.Context, client Client, table string, changes []tablestore.RowChange, maxRetries int) error {
+	pending := changes
+	pause := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		request := new(tablestore.BatchWriteRowRequest)
+		for _, change := range pending {
+			request.AddRowChange(change)
+		}
+		resp, err := client.BatchWriteRow(request)
+		if err != nil {
+			return err
+		}
+
+		var failed []tablestore.RowChange
+		var lastErr tablestore.Error
+		for _, result := range resp.TableToRowsResult[table] {
+			if !result.IsSucceed {
+				failed = append(failed, pending[result.Index])
+				lastErr = result.Error
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		if attempt >= maxRetries {
+			return fmt.Errorf("%d rows failed after %d retries: %s %s", len(failed), attempt, lastErr.Code, lastErr.Message)
+		}
+		pending = failed
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pause):
+		}
+		pause *= 2
+	}
+}
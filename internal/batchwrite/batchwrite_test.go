@@ -0,0 +1,63 @@
+package batchwrite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+// fakeClient rejects the first row of each request until failures runs out.
+type fakeClient struct {
+	failures int
+	requests [][]string
+}
+
+func (c *fakeClient) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	resp := &tablestore.BatchWriteRowResponse{TableToRowsResult: make(map[string][]tablestore.RowResult)}
+	var keys []string
+	for i, change := range request.RowChangesGroupByTable["t"] {
+		key := change.(*tablestore.PutRowChange).PrimaryKey.PrimaryKeys[0].Value.(string)
+		keys = append(keys, key)
+		result := tablestore.RowResult{TableName: "t", IsSucceed: true, Index: int32(i)}
+		if i == 0 && c.failures > 0 {
+			c.failures--
+			result.IsSucceed = false
+			result.Error = tablestore.Error{Code: "OTSServerBusy", Message: "busy"}
+		}
+		resp.TableToRowsResult["t"] = append(resp.TableToRowsResult["t"], result)
+	}
+	c.requests = append(c.requests, keys)
+	return resp, nil
+}
+
+func changes(keys ...string) []tablestore.RowChange {
+	var result []tablestore.RowChange
+	for _, key := range keys {
+		change := &tablestore.PutRowChange{TableName: "t", PrimaryKey: new(tablestore.PrimaryKey)}
+		change.PrimaryKey.AddPrimaryKeyColumn("pk", key)
+		change.AddColumn("col", key)
+		change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+		result = append(result, change)
+	}
+	return result
+}
+
+func TestWrite(t *testing.T) {
+	client := &fakeClient{failures: 1}
+	assert.Nil(t, Write(context.Background(), client, "t", changes("a", "b", "c"), 1))
+	// only the rejected row is written again
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"a"}}, client.requests)
+
+	client = &fakeClient{failures: 3}
+	err := Write(context.Background(), client, "t", changes("a", "b"), 1)
+	assert.EqualError(t, err, "1 rows failed after 1 retries: OTSServerBusy busy")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client = &fakeClient{failures: 1}
+	assert.Equal(t, context.Canceled, Write(ctx, client, "t", changes("a"), 5))
+}
@@ -0,0 +1,46 @@
+// Package cond holds the row conditions and error checks shared by the
+// packages that coordinate through conditional writes.
+package cond
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// Equal matches rows whose latest value of column equals value, a row
+// without the column does not match.
+func Equal(column string, value interface{}) *tablestore.SingleColumnCondition {
+	condition := tablestore.NewSingleColumnCondition(column, tablestore.CT_EQUAL, value)
+	condition.FilterIfMissing = true
+	condition.LatestVersionOnly = true
+	return condition
+}
+
+// And matches rows that match all filters.
+func And(filters ...tablestore.ColumnFilter) tablestore.ColumnFilter {
+	condition := tablestore.NewCompositeColumnCondition(tablestore.LO_AND)
+	for _, filter := range filters {
+		condition.AddFilter(filter)
+	}
+	return condition
+}
+
+// ToMillis returns t in milliseconds since the epoch, the unit times are
+// stored in.
+func ToMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// FromMillis is the reverse of ToMillis.
+func FromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// IsConditionFailed reports whether err is the error of a write whose row
+// condition did not hold.
+func IsConditionFailed(err error) bool {
+	var otsErr *tablestore.OtsError
+	return errors.As(err, &otsErr) && otsErr.Code == "OTSConditionCheckFail"
+}
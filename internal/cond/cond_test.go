@@ -0,0 +1,26 @@
+package cond
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsConditionFailed(t *testing.T) {
+	failed := &tablestore.OtsError{Code: "OTSConditionCheckFail"}
+	assert.True(t, IsConditionFailed(failed))
+	assert.True(t, IsConditionFailed(fmt.Errorf("[blob] put: %w", failed)))
+	assert.False(t, IsConditionFailed(&tablestore.OtsError{Code: "OTSServerBusy"}))
+	assert.False(t, IsConditionFailed(errors.New("OTSConditionCheckFail")))
+	assert.False(t, IsConditionFailed(nil))
+}
+
+func TestMillis(t *testing.T) {
+	now := time.Unix(1600000000, 123456789)
+	assert.Equal(t, int64(1600000000123), ToMillis(now))
+	assert.True(t, FromMillis(ToMillis(now)).Equal(now.Truncate(time.Millisecond)))
+}
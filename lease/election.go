@@ -0,0 +1,53 @@
+package lease
+
+import (
+	"context"
+	"time"
+)
+
+// Elect campaigns for the leadership held by the lease name until ctx is
+// done. Each time this Manager becomes the leader, Elect calls lead with a
+// context that is cancelled when the lease is lost. The lease is released
+// when lead returns, then Elect campaigns again after RetryInterval so that
+// other candidates get a chance.
+//
+// Elect returns the error of ctx, or an error of the client other than the
+// lease being held.
+func (m *Manager) Elect(ctx context.Context, name string, lead func(ctx context.Context, lease *Lease)) error {
+	for {
+		lease, err := m.Wait(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		leaderCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-lease.Lost():
+				cancel()
+			case <-leaderCtx.Done():
+			}
+		}()
+		lead(leaderCtx, lease)
+		cancel()
+		if err := lease.Release(); err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.config.RetryInterval):
+		}
+	}
+}
+
+// Leader returns the owner currently holding the lease name, or "" when
+// nobody does.
+func (m *Manager) Leader(name string) (string, error) {
+	holder, err := m.Holder(name)
+	if err != nil || holder == nil || holder.Expired(m.now()) {
+		return "", err
+	}
+	return holder.Owner, nil
+}
@@ -0,0 +1,380 @@
+// Package lease implements leases, locks and leader election on a table.
+//
+// A lease is a row of the table keyed by the lease name, holding the owner,
+// the expiry time and a fencing token. Acquiring, renewing and releasing a
+// lease are conditional writes on those columns, so at most one owner holds
+// a lease at any time. The fencing token grows by one each time the lease
+// changes owner: pass it along with the writes made under the lease so
+// that their target can reject writes from a holder that has lost it.
+//
+// Expiry times are taken from the local clock of each owner. Keep the TTL
+// well above the clock skew between them.
+package lease
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/internal/cond"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var (
+	ErrInvalidConfig = errors.New("[lease] invalid config")
+	// ErrHeld is returned by Acquire when another owner holds the lease.
+	ErrHeld = errors.New("[lease] held by another owner")
+	// ErrLost is the error of a lease that expired or was taken over.
+	ErrLost = errors.New("[lease] lost")
+	// ErrReleased is the error of a lease released by its owner.
+	ErrReleased = errors.New("[lease] released")
+)
+
+const (
+	NameColumn = "name"
+
+	ownerColumn  = "owner"
+	expiryColumn = "expiry"
+	tokenColumn  = "token"
+)
+
+const (
+	DefaultTTL           = 15 * time.Second
+	DefaultRetryInterval = time.Second
+)
+
+// Client is the part of *tablestore.TableStoreClient used by a Manager.
+type Client interface {
+	GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error)
+	PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error)
+	UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error)
+}
+
+type Config struct {
+	// Table has a single STRING primary key column, see NameColumn.
+	Table string
+	// Owner identifies this process, it defaults to the host name and the
+	// process id.
+	Owner string
+	// TTL is how long a lease lasts without renewal.
+	TTL time.Duration
+	// RenewInterval is how often held leases are renewed in the
+	// background, it defaults to a third of the TTL.
+	RenewInterval time.Duration
+	// RetryInterval is how often Wait and Elect try to acquire a lease.
+	RetryInterval time.Duration
+}
+
+// Holder is the state of a lease as stored in the table.
+type Holder struct {
+	Owner  string
+	Token  int64
+	Expiry time.Time
+}
+
+// Expired tells whether the lease is free to acquire at now.
+func (h *Holder) Expired(now time.Time) bool {
+	return h.Owner == "" || !now.Before(h.Expiry)
+}
+
+type Manager struct {
+	client Client
+	config Config
+	now    func() time.Time
+}
+
+func NewManager(client Client, config Config) (*Manager, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: client is required", ErrInvalidConfig)
+	}
+	if config.Table == "" {
+		return nil, fmt.Errorf("%w: table is required", ErrInvalidConfig)
+	}
+	if config.Owner == "" {
+		host, _ := os.Hostname()
+		config.Owner = host + "-" + strconv.Itoa(os.Getpid())
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+	if config.RenewInterval <= 0 {
+		config.RenewInterval = config.TTL / 3
+	}
+	if config.RenewInterval >= config.TTL {
+		return nil, fmt.Errorf("%w: renew interval %s is not shorter than the TTL %s", ErrInvalidConfig, config.RenewInterval, config.TTL)
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultRetryInterval
+	}
+	return &Manager{client: client, config: config, now: time.Now}, nil
+}
+
+// Owner is the owner name this Manager acquires leases with.
+func (m *Manager) Owner() string {
+	return m.config.Owner
+}
+
+// Holder reads the state of a lease, it returns nil if the lease was never
+// acquired.
+func (m *Manager) Holder(name string) (*Holder, error) {
+	criteria := &tablestore.SingleRowQueryCriteria{
+		TableName:    m.config.Table,
+		PrimaryKey:   m.primaryKey(name),
+		ColumnsToGet: []string{ownerColumn, expiryColumn, tokenColumn},
+		MaxVersion:   1,
+	}
+	resp, err := m.client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: criteria})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Columns) == 0 {
+		return nil, nil
+	}
+	holder := new(Holder)
+	for _, col := range resp.Columns {
+		switch col.ColumnName {
+		case ownerColumn:
+			holder.Owner, _ = col.Value.(string)
+		case expiryColumn:
+			if ms, ok := col.Value.(int64); ok {
+				holder.Expiry = cond.FromMillis(ms)
+			}
+		case tokenColumn:
+			holder.Token, _ = col.Value.(int64)
+		}
+	}
+	return holder, nil
+}
+
+// Acquire tries once to acquire a lease, it fails with ErrHeld when another
+// owner holds it. The returned Lease is renewed in the background until it
+// is released or lost.
+func (m *Manager) Acquire(ctx context.Context, name string) (*Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	holder, err := m.Holder(name)
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	expiry := now.Add(m.config.TTL)
+
+	var token int64 = 1
+	if holder == nil {
+		change := &tablestore.PutRowChange{TableName: m.config.Table, PrimaryKey: m.primaryKey(name)}
+		change.AddColumn(ownerColumn, m.config.Owner)
+		change.AddColumn(expiryColumn, cond.ToMillis(expiry))
+		change.AddColumn(tokenColumn, token)
+		change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST)
+		_, err = m.client.PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+	} else {
+		if !holder.Expired(now) {
+			return nil, fmt.Errorf("%w: %s is held by %s until %s", ErrHeld, name, holder.Owner, holder.Expiry.Format(time.RFC3339))
+		}
+		// the expiry is part of the condition so that a renewal that
+		// raced this read keeps the lease with its holder
+		token = holder.Token + 1
+		change := &tablestore.UpdateRowChange{TableName: m.config.Table, PrimaryKey: m.primaryKey(name)}
+		change.PutColumn(ownerColumn, m.config.Owner)
+		change.PutColumn(expiryColumn, cond.ToMillis(expiry))
+		change.PutColumn(tokenColumn, token)
+		change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+		change.SetColumnCondition(cond.And(
+			cond.Equal(tokenColumn, holder.Token),
+			cond.Equal(expiryColumn, cond.ToMillis(holder.Expiry)),
+		))
+		_, err = m.client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change})
+	}
+	if err != nil {
+		if cond.IsConditionFailed(err) {
+			return nil, fmt.Errorf("%w: %s was acquired concurrently", ErrHeld, name)
+		}
+		return nil, err
+	}
+	return m.newLease(name, token, expiry), nil
+}
+
+// Wait acquires a lease, trying again every RetryInterval while another
+// owner holds it, until ctx is done.
+func (m *Manager) Wait(ctx context.Context, name string) (*Lease, error) {
+	for {
+		lease, err := m.Acquire(ctx, name)
+		if err == nil || !errors.Is(err, ErrHeld) {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(m.config.RetryInterval):
+		}
+	}
+}
+
+// Lease is a lease held by a Manager.
+type Lease struct {
+	manager *Manager
+	name    string
+	token   int64
+
+	mu     sync.Mutex
+	expiry time.Time
+	err    error
+	lost   chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (m *Manager) newLease(name string, token int64, expiry time.Time) *Lease {
+	l := &Lease{
+		manager: m,
+		name:    name,
+		token:   token,
+		expiry:  expiry,
+		lost:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.renewLoop()
+	return l
+}
+
+func (l *Lease) Name() string {
+	return l.name
+}
+
+// Token is the fencing token of the lease.
+func (l *Lease) Token() int64 {
+	return l.token
+}
+
+// Expiry is the time the lease expires unless it is renewed.
+func (l *Lease) Expiry() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiry
+}
+
+// Lost is closed when the lease is lost or released, Err then tells why.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err is nil while the lease is held, then ErrLost or ErrReleased.
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Renew extends the lease by the TTL. The background renewal calls it every
+// RenewInterval, calling it directly is only needed to extend the lease
+// right away.
+func (l *Lease) Renew() error {
+	if err := l.Err(); err != nil {
+		return err
+	}
+	m := l.manager
+	expiry := m.now().Add(m.config.TTL)
+	change := &tablestore.UpdateRowChange{TableName: m.config.Table, PrimaryKey: m.primaryKey(l.name)}
+	change.PutColumn(expiryColumn, cond.ToMillis(expiry))
+	change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+	change.SetColumnCondition(l.held())
+	if _, err := m.client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change}); err != nil {
+		if cond.IsConditionFailed(err) {
+			l.finish(fmt.Errorf("%w: %s was taken over", ErrLost, l.name))
+			return l.Err()
+		}
+		return err
+	}
+	l.mu.Lock()
+	if l.err == nil && expiry.After(l.expiry) {
+		l.expiry = expiry
+	}
+	l.mu.Unlock()
+	return nil
+}
+
+// Release stops the renewal and frees the lease for other owners, keeping
+// its token so that the next owner gets a greater one. Releasing a lease
+// that is already lost or released does nothing.
+func (l *Lease) Release() error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	<-l.done
+	if l.Err() != nil {
+		return nil
+	}
+	l.finish(fmt.Errorf("%w: %s", ErrReleased, l.name))
+
+	m := l.manager
+	change := &tablestore.UpdateRowChange{TableName: m.config.Table, PrimaryKey: m.primaryKey(l.name)}
+	change.PutColumn(ownerColumn, "")
+	change.PutColumn(expiryColumn, int64(0))
+	change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+	change.SetColumnCondition(l.held())
+	if _, err := m.client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change}); err != nil && !cond.IsConditionFailed(err) {
+		return err
+	}
+	return nil
+}
+
+// held is the condition of the row still belonging to this lease.
+func (l *Lease) held() tablestore.ColumnFilter {
+	return cond.And(cond.Equal(ownerColumn, l.manager.config.Owner), cond.Equal(tokenColumn, l.token))
+}
+
+func (l *Lease) finish(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = err
+		close(l.lost)
+	}
+}
+
+// renewLoop renews the lease until it is released or lost. A renewal that
+// fails for another reason than a lost condition is retried on the next
+// tick, the lease is lost once it expires without a successful renewal.
+func (l *Lease) renewLoop() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.manager.config.RenewInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		expiry := l.Expiry()
+		wait := expiry.Sub(l.manager.now())
+		if wait <= 0 {
+			l.finish(fmt.Errorf("%w: %s expired at %s", ErrLost, l.name, expiry.Format(time.RFC3339)))
+			return
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-l.stop:
+			return
+		case <-l.lost:
+			return
+		case <-timer.C:
+		case <-ticker.C:
+			l.Renew()
+		}
+	}
+}
+
+func (m *Manager) primaryKey(name string) *tablestore.PrimaryKey {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn(NameColumn, name)
+	return pk
+}
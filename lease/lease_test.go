@@ -0,0 +1,273 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+// fakeTable keeps the latest value of each column by lease name and checks
+// the row existence and the equality conditions the Manager uses.
+type fakeTable struct {
+	mu   sync.Mutex
+	rows map[string]map[string]interface{}
+	// down makes writes of the listed owners fail as if the network was.
+	down map[string]bool
+}
+
+func newFakeTable() *fakeTable {
+	return &fakeTable{rows: make(map[string]map[string]interface{}), down: make(map[string]bool)}
+}
+
+var conditionFailed = &tablestore.OtsError{Code: "OTSConditionCheckFail", Message: "Condition check failed."}
+
+func match(filter tablestore.ColumnFilter, row map[string]interface{}) bool {
+	switch f := filter.(type) {
+	case nil:
+		return true
+	case *tablestore.SingleColumnCondition:
+		value, ok := row[*f.ColumnName]
+		if !ok {
+			return !f.FilterIfMissing
+		}
+		return value == f.ColumnValue
+	case *tablestore.CompositeColumnValueFilter:
+		for _, sub := range f.Filters {
+			if !match(sub, row) {
+				return false
+			}
+		}
+		return true
+	}
+	panic("unexpected filter")
+}
+
+func (t *fakeTable) check(name string, condition *tablestore.RowCondition) error {
+	row, exists := t.rows[name]
+	switch condition.RowExistenceExpectation {
+	case tablestore.RowExistenceExpectation_EXPECT_EXIST:
+		if !exists {
+			return conditionFailed
+		}
+	case tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST:
+		if exists {
+			return conditionFailed
+		}
+	}
+	if !match(condition.ColumnCondition, row) {
+		return conditionFailed
+	}
+	return nil
+}
+
+func (t *fakeTable) GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	resp := &tablestore.GetRowResponse{}
+	for name, value := range t.rows[request.SingleRowQueryCriteria.PrimaryKey.PrimaryKeys[0].Value.(string)] {
+		resp.Columns = append(resp.Columns, &tablestore.AttributeColumn{ColumnName: name, Value: value})
+	}
+	return resp, nil
+}
+
+func (t *fakeTable) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change := request.PutRowChange
+	name := change.PrimaryKey.PrimaryKeys[0].Value.(string)
+	if err := t.check(name, change.Condition); err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{})
+	for _, col := range change.Columns {
+		row[col.ColumnName] = col.Value
+	}
+	t.rows[name] = row
+	return &tablestore.PutRowResponse{}, nil
+}
+
+func (t *fakeTable) UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	change := request.UpdateRowChange
+	for _, col := range change.Columns {
+		if col.ColumnName == ownerColumn && t.down[col.Value.(string)] {
+			return nil, errors.New("connection refused")
+		}
+	}
+	if c, ok := change.Condition.ColumnCondition.(*tablestore.CompositeColumnValueFilter); ok {
+		for _, sub := range c.Filters {
+			if s := sub.(*tablestore.SingleColumnCondition); *s.ColumnName == ownerColumn && t.down[s.ColumnValue.(string)] {
+				return nil, errors.New("connection refused")
+			}
+		}
+	}
+	name := change.PrimaryKey.PrimaryKeys[0].Value.(string)
+	if err := t.check(name, change.Condition); err != nil {
+		return nil, err
+	}
+	for _, col := range change.Columns {
+		t.rows[name][col.ColumnName] = col.Value
+	}
+	return &tablestore.UpdateRowResponse{}, nil
+}
+
+func (t *fakeTable) set(name, column string, value interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows[name][column] = value
+}
+
+func (t *fakeTable) setDown(owner string, down bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.down[owner] = down
+}
+
+func newManager(t *testing.T, table *fakeTable, owner string) *Manager {
+	m, err := NewManager(table, Config{Table: "leases", Owner: owner, TTL: 300 * time.Millisecond, RenewInterval: 50 * time.Millisecond, RetryInterval: 20 * time.Millisecond})
+	assert.Nil(t, err)
+	return m
+}
+
+func TestAcquireRelease(t *testing.T) {
+	table := newFakeTable()
+	a, b := newManager(t, table, "a"), newManager(t, table, "b")
+	ctx := context.Background()
+
+	lease, err := a.Acquire(ctx, "job")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), lease.Token())
+	assert.Nil(t, lease.Err())
+
+	_, err = b.Acquire(ctx, "job")
+	assert.True(t, errors.Is(err, ErrHeld))
+	leader, err := b.Leader("job")
+	assert.Nil(t, err)
+	assert.Equal(t, "a", leader)
+
+	// the background renewal keeps the lease past its first expiry
+	time.Sleep(500 * time.Millisecond)
+	assert.Nil(t, lease.Err())
+	_, err = b.Acquire(ctx, "job")
+	assert.True(t, errors.Is(err, ErrHeld))
+
+	assert.Nil(t, lease.Release())
+	assert.Nil(t, lease.Release())
+	<-lease.Lost()
+	assert.True(t, errors.Is(lease.Err(), ErrReleased))
+	leader, _ = b.Leader("job")
+	assert.Equal(t, "", leader)
+
+	// the token keeps growing after a release
+	other, err := b.Acquire(ctx, "job")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), other.Token())
+	assert.Nil(t, other.Release())
+}
+
+func TestExpiry(t *testing.T) {
+	table := newFakeTable()
+	a, b := newManager(t, table, "a"), newManager(t, table, "b")
+	ctx := context.Background()
+
+	lease, err := a.Acquire(ctx, "job")
+	assert.Nil(t, err)
+	table.setDown("a", true)
+
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lease not lost after its expiry")
+	}
+	assert.True(t, errors.Is(lease.Err(), ErrLost))
+
+	taken, err := b.Wait(ctx, "job")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), taken.Token())
+
+	// a renewal from the old holder cannot take the lease back
+	table.setDown("a", false)
+	assert.True(t, errors.Is(lease.Renew(), ErrLost))
+	assert.Nil(t, taken.Err())
+	assert.Nil(t, taken.Release())
+}
+
+func TestTakeover(t *testing.T) {
+	table := newFakeTable()
+	a := newManager(t, table, "a")
+	lease, err := a.Acquire(context.Background(), "job")
+	assert.Nil(t, err)
+
+	table.set("job", ownerColumn, "b")
+	table.set("job", tokenColumn, int64(2))
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lease not lost after a takeover")
+	}
+	assert.True(t, errors.Is(lease.Err(), ErrLost))
+	// releasing a lost lease leaves the new holder alone
+	assert.Nil(t, lease.Release())
+	holder, _ := a.Holder("job")
+	assert.Equal(t, "b", holder.Owner)
+}
+
+func TestElect(t *testing.T) {
+	table := newFakeTable()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var leaders []string
+	var wg sync.WaitGroup
+	for _, owner := range []string{"a", "b"} {
+		m := newManager(t, table, owner)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := m.Elect(ctx, "job", func(ctx context.Context, lease *Lease) {
+				mu.Lock()
+				leaders = append(leaders, m.Owner())
+				n := len(leaders)
+				mu.Unlock()
+				if n >= 4 {
+					cancel()
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(50 * time.Millisecond):
+				}
+			})
+			assert.True(t, errors.Is(err, context.Canceled))
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.True(t, len(leaders) >= 4)
+	assert.Contains(t, leaders, "a")
+	assert.Contains(t, leaders, "b")
+}
+
+func TestNewManager(t *testing.T) {
+	_, err := NewManager(nil, Config{Table: "leases"})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = NewManager(newFakeTable(), Config{})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = NewManager(newFakeTable(), Config{Table: "leases", TTL: time.Second, RenewInterval: time.Second})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	m, err := NewManager(newFakeTable(), Config{Table: "leases"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, m.Owner())
+	assert.Equal(t, DefaultTTL/3, m.config.RenewInterval)
+}
@@ -0,0 +1,445 @@
+// Package queue implements a durable work queue on a table.
+//
+// Each item is a row whose primary key is
+//
+//	shard INTEGER | rank INTEGER | due INTEGER | id STRING
+//
+// where shard is a hash of the id that spreads items over partitions, rank
+// orders priorities and due is the time, in milliseconds, the item was first
+// made visible. Within a shard a range scan therefore meets items by
+// priority, then in time order. An item is visible when its visible_at
+// column is not in the future. Dequeue claims a visible item with a
+// conditional UpdateRow that moves visible_at past the visibility timeout
+// and gives the item a new receipt, so one consumer gets it at a time. Ack
+// deletes the item and Nack makes it visible again after a backoff, both only
+// while the receipt is still current.
+//
+// Priorities are exact within a shard. Dequeue looks at the shards in turn
+// starting from a random one, so across shards they are a preference.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/internal/cond"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var (
+	ErrInvalidConfig = errors.New("[queue] invalid config")
+	// ErrEmpty is returned by Dequeue when no item is visible.
+	ErrEmpty = errors.New("[queue] no visible item")
+	// ErrStaleReceipt is returned by Ack and Nack when the visibility
+	// timeout of the message passed and the item was claimed again, acked
+	// or dead-lettered.
+	ErrStaleReceipt = errors.New("[queue] stale receipt")
+)
+
+const (
+	ShardColumn = "shard"
+	RankColumn  = "rank"
+	DueColumn   = "due"
+	IDColumn    = "id"
+
+	bodyColumn      = "body"
+	visibleAtColumn = "visible_at"
+	attemptsColumn  = "attempts"
+	receiptColumn   = "receipt"
+	enqueuedColumn  = "enqueued"
+)
+
+const (
+	// MaxPriority is the highest priority, items with higher priorities
+	// are dequeued first.
+	MaxPriority = 9
+
+	DefaultShards            = 16
+	DefaultVisibilityTimeout = 30 * time.Second
+	DefaultMaxAttempts       = 5
+	DefaultBaseBackoff       = time.Second
+	DefaultMaxBackoff        = 5 * time.Minute
+	DefaultPollInterval      = time.Second
+	DefaultScanLimit         = 16
+)
+
+// Client is the part of *tablestore.TableStoreClient used by a Queue.
+type Client interface {
+	PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error)
+	UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error)
+	DeleteRow(request *tablestore.DeleteRowRequest) (*tablestore.DeleteRowResponse, error)
+	GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error)
+}
+
+type Config struct {
+	Table string
+	// DeadLetterTable receives the items that were delivered MaxAttempts
+	// times without an Ack. It has the layout of Table, so it can be read
+	// with another Queue. Such items are deleted when it is empty.
+	DeadLetterTable string
+	// Shards is the number of hash shards, it must not change once items
+	// were enqueued.
+	Shards int
+	// VisibilityTimeout is how long a dequeued item stays hidden from other
+	// consumers without an Ack or Nack.
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+	// Backoff is the delay before an item is visible again after a Nack,
+	// it defaults to BaseBackoff doubled with each attempt up to MaxBackoff.
+	Backoff     func(attempts int) time.Duration
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// PollInterval is how often Receive scans for visible items.
+	PollInterval time.Duration
+	// ScanLimit is the number of visible items a scan of a shard reads.
+	ScanLimit int
+}
+
+type EnqueueOptions struct {
+	// Delay hides the item for a while after it is enqueued.
+	Delay time.Duration
+	// Priority between 0 and MaxPriority, 0 by default.
+	Priority int
+}
+
+// Message is a dequeued item.
+type Message struct {
+	ID       string
+	Body     []byte
+	Priority int
+	// Attempts counts the deliveries of the item, this one included.
+	Attempts   int
+	EnqueuedAt time.Time
+	// Receipt identifies this delivery, Ack and Nack need it to be current.
+	Receipt string
+
+	shard, rank, due int64
+}
+
+type Queue struct {
+	client Client
+	config Config
+	now    func() time.Time
+
+	mu     sync.Mutex
+	random *mrand.Rand
+}
+
+func New(client Client, config Config) (*Queue, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: client is required", ErrInvalidConfig)
+	}
+	if config.Table == "" {
+		return nil, fmt.Errorf("%w: table is required", ErrInvalidConfig)
+	}
+	if config.DeadLetterTable == config.Table {
+		return nil, fmt.Errorf("%w: the dead letter table is the queue table", ErrInvalidConfig)
+	}
+	if config.Shards <= 0 {
+		config.Shards = DefaultShards
+	}
+	if config.VisibilityTimeout <= 0 {
+		config.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = DefaultBaseBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.Backoff == nil {
+		base, max := config.BaseBackoff, config.MaxBackoff
+		config.Backoff = func(attempts int) time.Duration {
+			delay := base
+			for i := 1; i < attempts && delay < max; i++ {
+				delay *= 2
+			}
+			if delay > max {
+				delay = max
+			}
+			return delay
+		}
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.ScanLimit <= 0 {
+		config.ScanLimit = DefaultScanLimit
+	}
+	return &Queue{
+		client: client,
+		config: config,
+		now:    time.Now,
+		random: mrand.New(mrand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// Enqueue adds an item and returns its id.
+func (q *Queue) Enqueue(body []byte, options *EnqueueOptions) (string, error) {
+	if options == nil {
+		options = &EnqueueOptions{}
+	}
+	if options.Priority < 0 || options.Priority > MaxPriority {
+		return "", fmt.Errorf("%w: priority %d is not between 0 and %d", ErrInvalidConfig, options.Priority, MaxPriority)
+	}
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	now := q.now()
+	due := cond.ToMillis(now.Add(options.Delay))
+	err = q.put(q.config.Table, id, body, options.Priority, due, 0, cond.ToMillis(now))
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (q *Queue) put(table, id string, body []byte, priority int, due int64, attempts int, enqueued int64) error {
+	shard := q.shard(id)
+	change := &tablestore.PutRowChange{TableName: table, PrimaryKey: primaryKey(shard, int64(MaxPriority-priority), due, id)}
+	if body == nil {
+		body = []byte{}
+	}
+	change.AddColumn(bodyColumn, body)
+	change.AddColumn(visibleAtColumn, due)
+	change.AddColumn(attemptsColumn, int64(attempts))
+	change.AddColumn(enqueuedColumn, enqueued)
+	change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST)
+	_, err := q.client.PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+	return err
+}
+
+// Dequeue claims a visible item for VisibilityTimeout, or fails with
+// ErrEmpty. Items that reached MaxAttempts met on the way are moved to the
+// dead letter table.
+func (q *Queue) Dequeue(ctx context.Context) (*Message, error) {
+	q.mu.Lock()
+	first := q.random.Intn(q.config.Shards)
+	q.mu.Unlock()
+	for i := 0; i < q.config.Shards; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		msg, err := q.dequeueShard(int64((first + i) % q.config.Shards))
+		if err != nil || msg != nil {
+			return msg, err
+		}
+	}
+	return nil, ErrEmpty
+}
+
+// Receive waits for an item, scanning every PollInterval until ctx is
+// done.
+func (q *Queue) Receive(ctx context.Context) (*Message, error) {
+	for {
+		msg, err := q.Dequeue(ctx)
+		if !errors.Is(err, ErrEmpty) {
+			return msg, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(q.config.PollInterval):
+		}
+	}
+}
+
+func (q *Queue) dequeueShard(shard int64) (*Message, error) {
+	now := cond.ToMillis(q.now())
+	visible := tablestore.NewSingleColumnCondition(visibleAtColumn, tablestore.CT_LESS_EQUAL, now)
+	visible.FilterIfMissing = true
+	visible.LatestVersionOnly = true
+	criteria := &tablestore.RangeRowQueryCriteria{
+		TableName:       q.config.Table,
+		StartPrimaryKey: boundKey(shard, true),
+		EndPrimaryKey:   boundKey(shard, false),
+		MaxVersion:      1,
+		Direction:       tablestore.FORWARD,
+		Limit:           int32(q.config.ScanLimit),
+		Filter:          visible,
+	}
+	for {
+		resp, err := q.client.GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: criteria})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range resp.Rows {
+			msg, err := q.claim(row, now)
+			if err != nil {
+				return nil, err
+			}
+			if msg != nil {
+				return msg, nil
+			}
+		}
+		// the filter may leave a page empty before the end of the shard
+		if resp.NextStartPrimaryKey == nil || len(resp.Rows) > 0 {
+			return nil, nil
+		}
+		criteria.StartPrimaryKey = resp.NextStartPrimaryKey
+	}
+}
+
+// claim takes an item seen by a scan. It returns nil when another consumer
+// claimed it first or when it was dead-lettered.
+func (q *Queue) claim(row *tablestore.Row, now int64) (*Message, error) {
+	msg, visibleAt, err := parseRow(row)
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	msg.Attempts++
+	msg.Receipt = receipt
+
+	change := &tablestore.UpdateRowChange{TableName: q.config.Table, PrimaryKey: msg.primaryKey()}
+	change.PutColumn(visibleAtColumn, now+int64(q.config.VisibilityTimeout/time.Millisecond))
+	change.PutColumn(attemptsColumn, int64(msg.Attempts))
+	change.PutColumn(receiptColumn, receipt)
+	change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+	change.SetColumnCondition(cond.And(cond.Equal(visibleAtColumn, visibleAt), cond.Equal(attemptsColumn, int64(msg.Attempts-1))))
+	if _, err := q.client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change}); err != nil {
+		if cond.IsConditionFailed(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if msg.Attempts > q.config.MaxAttempts {
+		if err := q.deadLetter(msg); err != nil && !errors.Is(err, ErrStaleReceipt) {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return msg, nil
+}
+
+// Ack deletes a dequeued item.
+func (q *Queue) Ack(msg *Message) error {
+	change := &tablestore.DeleteRowChange{TableName: q.config.Table, PrimaryKey: msg.primaryKey()}
+	change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+	change.SetColumnCondition(cond.Equal(receiptColumn, msg.Receipt))
+	_, err := q.client.DeleteRow(&tablestore.DeleteRowRequest{DeleteRowChange: change})
+	return checkReceipt(err)
+}
+
+// Nack gives a dequeued item back, to be visible again after the backoff
+// of its attempt. An item that reached MaxAttempts is moved to the dead
+// letter table instead.
+func (q *Queue) Nack(msg *Message) error {
+	if msg.Attempts >= q.config.MaxAttempts {
+		return q.deadLetter(msg)
+	}
+	visibleAt := q.now().Add(q.config.Backoff(msg.Attempts))
+	change := &tablestore.UpdateRowChange{TableName: q.config.Table, PrimaryKey: msg.primaryKey()}
+	change.PutColumn(visibleAtColumn, cond.ToMillis(visibleAt))
+	change.DeleteColumn(receiptColumn)
+	change.SetCondition(tablestore.RowExistenceExpectation_EXPECT_EXIST)
+	change.SetColumnCondition(cond.Equal(receiptColumn, msg.Receipt))
+	_, err := q.client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change})
+	return checkReceipt(err)
+}
+
+// deadLetter copies an item to the dead letter table, visible at once and
+// with no attempts, then deletes it. The copy keeps the id and due time, so
+// a retry after a failed delete finds it there.
+func (q *Queue) deadLetter(msg *Message) error {
+	if q.config.DeadLetterTable != "" {
+		err := q.put(q.config.DeadLetterTable, msg.ID, msg.Body, msg.Priority, msg.due, 0, cond.ToMillis(msg.EnqueuedAt))
+		if err != nil && !cond.IsConditionFailed(err) {
+			return err
+		}
+	}
+	return q.Ack(msg)
+}
+
+func checkReceipt(err error) error {
+	if cond.IsConditionFailed(err) {
+		return ErrStaleReceipt
+	}
+	return err
+}
+
+func (q *Queue) shard(id string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int64(h.Sum32() % uint32(q.config.Shards))
+}
+
+func parseRow(row *tablestore.Row) (*Message, int64, error) {
+	if len(row.PrimaryKey.PrimaryKeys) != 4 {
+		return nil, 0, fmt.Errorf("[queue] unexpected primary key with %d columns", len(row.PrimaryKey.PrimaryKeys))
+	}
+	msg := new(Message)
+	pk := row.PrimaryKey.PrimaryKeys
+	msg.shard, _ = pk[0].Value.(int64)
+	msg.rank, _ = pk[1].Value.(int64)
+	msg.due, _ = pk[2].Value.(int64)
+	msg.ID, _ = pk[3].Value.(string)
+	msg.Priority = MaxPriority - int(msg.rank)
+
+	var visibleAt int64
+	for _, col := range row.Columns {
+		switch col.ColumnName {
+		case bodyColumn:
+			msg.Body, _ = col.Value.([]byte)
+		case visibleAtColumn:
+			visibleAt, _ = col.Value.(int64)
+		case attemptsColumn:
+			attempts, _ := col.Value.(int64)
+			msg.Attempts = int(attempts)
+		case enqueuedColumn:
+			ms, _ := col.Value.(int64)
+			msg.EnqueuedAt = cond.FromMillis(ms)
+		}
+	}
+	return msg, visibleAt, nil
+}
+
+func (m *Message) primaryKey() *tablestore.PrimaryKey {
+	return primaryKey(m.shard, m.rank, m.due, m.ID)
+}
+
+func primaryKey(shard, rank, due int64, id string) *tablestore.PrimaryKey {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn(ShardColumn, shard)
+	pk.AddPrimaryKeyColumn(RankColumn, rank)
+	pk.AddPrimaryKeyColumn(DueColumn, due)
+	pk.AddPrimaryKeyColumn(IDColumn, id)
+	return pk
+}
+
+// boundKey is the first or the last primary key of a shard.
+func boundKey(shard int64, first bool) *tablestore.PrimaryKey {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn(ShardColumn, shard)
+	for _, name := range []string{RankColumn, DueColumn, IDColumn} {
+		if first {
+			pk.AddPrimaryKeyColumnWithMinValue(name)
+		} else {
+			pk.AddPrimaryKeyColumnWithMaxValue(name)
+		}
+	}
+	return pk
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,429 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+type itemKey struct {
+	shard, rank, due int64
+	id               string
+}
+
+func (k itemKey) less(o itemKey) bool {
+	if k.shard != o.shard {
+		return k.shard < o.shard
+	}
+	if k.rank != o.rank {
+		return k.rank < o.rank
+	}
+	if k.due != o.due {
+		return k.due < o.due
+	}
+	return k.id < o.id
+}
+
+func keyOf(pk *tablestore.PrimaryKey) itemKey {
+	return itemKey{
+		shard: pk.PrimaryKeys[0].Value.(int64),
+		rank:  pk.PrimaryKeys[1].Value.(int64),
+		due:   pk.PrimaryKeys[2].Value.(int64),
+		id:    pk.PrimaryKeys[3].Value.(string),
+	}
+}
+
+// fakeTables keeps the rows of several tables in the queue layout and
+// evaluates the filters and conditions the Queue uses.
+type fakeTables struct {
+	mu     sync.Mutex
+	tables map[string]map[itemKey]map[string]interface{}
+	// pageSize makes GetRange return at most that many rows before the
+	// filter, like the service does for large ranges.
+	pageSize int
+}
+
+func newFakeTables() *fakeTables {
+	return &fakeTables{tables: make(map[string]map[itemKey]map[string]interface{})}
+}
+
+var conditionFailed = &tablestore.OtsError{Code: "OTSConditionCheckFail", Message: "Condition check failed."}
+
+func match(filter tablestore.ColumnFilter, row map[string]interface{}) bool {
+	switch f := filter.(type) {
+	case nil:
+		return true
+	case *tablestore.SingleColumnCondition:
+		value, ok := row[*f.ColumnName]
+		if !ok {
+			return !f.FilterIfMissing
+		}
+		switch *f.Comparator {
+		case tablestore.CT_EQUAL:
+			return value == f.ColumnValue
+		case tablestore.CT_LESS_EQUAL:
+			return value.(int64) <= f.ColumnValue.(int64)
+		}
+	case *tablestore.CompositeColumnValueFilter:
+		for _, sub := range f.Filters {
+			if !match(sub, row) {
+				return false
+			}
+		}
+		return true
+	}
+	panic("unexpected filter")
+}
+
+func (f *fakeTables) check(table string, key itemKey, condition *tablestore.RowCondition) (map[string]interface{}, error) {
+	row, exists := f.tables[table][key]
+	switch condition.RowExistenceExpectation {
+	case tablestore.RowExistenceExpectation_EXPECT_EXIST:
+		if !exists {
+			return nil, conditionFailed
+		}
+	case tablestore.RowExistenceExpectation_EXPECT_NOT_EXIST:
+		if exists {
+			return nil, conditionFailed
+		}
+	}
+	if !match(condition.ColumnCondition, row) {
+		return nil, conditionFailed
+	}
+	return row, nil
+}
+
+func (f *fakeTables) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change := request.PutRowChange
+	key := keyOf(change.PrimaryKey)
+	if _, err := f.check(change.TableName, key, change.Condition); err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{})
+	for _, col := range change.Columns {
+		row[col.ColumnName] = col.Value
+	}
+	if f.tables[change.TableName] == nil {
+		f.tables[change.TableName] = make(map[itemKey]map[string]interface{})
+	}
+	f.tables[change.TableName][key] = row
+	return &tablestore.PutRowResponse{}, nil
+}
+
+func (f *fakeTables) UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change := request.UpdateRowChange
+	row, err := f.check(change.TableName, keyOf(change.PrimaryKey), change.Condition)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range change.Columns {
+		if col.Type == tablestore.DELETE_ALL_VERSION {
+			delete(row, col.ColumnName)
+		} else {
+			row[col.ColumnName] = col.Value
+		}
+	}
+	return &tablestore.UpdateRowResponse{}, nil
+}
+
+func (f *fakeTables) DeleteRow(request *tablestore.DeleteRowRequest) (*tablestore.DeleteRowResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change := request.DeleteRowChange
+	key := keyOf(change.PrimaryKey)
+	if _, err := f.check(change.TableName, key, change.Condition); err != nil {
+		return nil, err
+	}
+	delete(f.tables[change.TableName], key)
+	return &tablestore.DeleteRowResponse{}, nil
+}
+
+func (f *fakeTables) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	criteria := request.RangeRowQueryCriteria
+	shard := criteria.StartPrimaryKey.PrimaryKeys[0].Value.(int64)
+	var start *itemKey
+	if criteria.StartPrimaryKey.PrimaryKeys[1].PrimaryKeyOption != tablestore.MIN {
+		k := keyOf(criteria.StartPrimaryKey)
+		start = &k
+	}
+	var keys []itemKey
+	for key := range f.tables[criteria.TableName] {
+		if key.shard == shard && (start == nil || !key.less(*start)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+
+	resp := &tablestore.GetRangeResponse{}
+	for i, key := range keys {
+		if f.pageSize > 0 && i == f.pageSize {
+			resp.NextStartPrimaryKey = primaryKey(key.shard, key.rank, key.due, key.id)
+			break
+		}
+		row := f.tables[criteria.TableName][key]
+		if !match(criteria.Filter, row) {
+			continue
+		}
+		if len(resp.Rows) == int(criteria.Limit) {
+			resp.NextStartPrimaryKey = primaryKey(key.shard, key.rank, key.due, key.id)
+			break
+		}
+		r := &tablestore.Row{PrimaryKey: primaryKey(key.shard, key.rank, key.due, key.id)}
+		for name, value := range row {
+			r.Columns = append(r.Columns, &tablestore.AttributeColumn{ColumnName: name, Value: value})
+		}
+		resp.Rows = append(resp.Rows, r)
+	}
+	return resp, nil
+}
+
+func (f *fakeTables) count(table string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.tables[table])
+}
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newQueue(t *testing.T, client Client, c *clock, config Config) *Queue {
+	q, err := New(client, config)
+	assert.Nil(t, err)
+	q.now = c.Now
+	return q
+}
+
+func TestPriorityAndDelay(t *testing.T) {
+	tables := newFakeTables()
+	c := &clock{now: time.Unix(1000, 0)}
+	q := newQueue(t, tables, c, Config{Table: "tasks", Shards: 1})
+	ctx := context.Background()
+
+	low, _ := q.Enqueue([]byte("low"), nil)
+	c.Add(time.Millisecond)
+	high, _ := q.Enqueue([]byte("high"), &EnqueueOptions{Priority: 5})
+	later, _ := q.Enqueue([]byte("later"), &EnqueueOptions{Delay: time.Minute, Priority: MaxPriority})
+	_, err := q.Enqueue(nil, &EnqueueOptions{Priority: MaxPriority + 1})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		msg, err := q.Dequeue(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, msg.Attempts)
+		assert.Nil(t, q.Ack(msg))
+		got = append(got, msg.ID)
+	}
+	assert.Equal(t, []string{high, low}, got)
+
+	_, err = q.Dequeue(ctx)
+	assert.True(t, errors.Is(err, ErrEmpty))
+
+	c.Add(time.Minute)
+	msg, err := q.Dequeue(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, later, msg.ID)
+	assert.Equal(t, []byte("later"), msg.Body)
+	assert.Equal(t, MaxPriority, msg.Priority)
+	assert.Equal(t, time.Unix(1000, int64(time.Millisecond)), msg.EnqueuedAt)
+}
+
+func TestVisibilityTimeout(t *testing.T) {
+	tables := newFakeTables()
+	c := &clock{now: time.Unix(1000, 0)}
+	q := newQueue(t, tables, c, Config{Table: "tasks", Shards: 4, VisibilityTimeout: 10 * time.Second})
+	ctx := context.Background()
+
+	id, _ := q.Enqueue([]byte("job"), nil)
+	first, err := q.Dequeue(ctx)
+	assert.Nil(t, err)
+	_, err = q.Dequeue(ctx)
+	assert.True(t, errors.Is(err, ErrEmpty))
+
+	c.Add(10 * time.Second)
+	second, err := q.Dequeue(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, id, second.ID)
+	assert.Equal(t, 2, second.Attempts)
+
+	assert.True(t, errors.Is(q.Ack(first), ErrStaleReceipt))
+	assert.True(t, errors.Is(q.Nack(first), ErrStaleReceipt))
+	assert.Nil(t, q.Ack(second))
+	assert.Equal(t, 0, tables.count("tasks"))
+}
+
+func TestNackAndDeadLetter(t *testing.T) {
+	tables := newFakeTables()
+	c := &clock{now: time.Unix(1000, 0)}
+	config := Config{Table: "tasks", DeadLetterTable: "dead", Shards: 2, MaxAttempts: 3, BaseBackoff: time.Second}
+	q := newQueue(t, tables, c, config)
+	ctx := context.Background()
+
+	id, _ := q.Enqueue([]byte("job"), &EnqueueOptions{Priority: 3})
+
+	// backoffs of 1s then 2s
+	for attempt, backoff := range []time.Duration{time.Second, 2 * time.Second} {
+		msg, err := q.Dequeue(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, attempt+1, msg.Attempts)
+		assert.Nil(t, q.Nack(msg))
+
+		c.Add(backoff - time.Millisecond)
+		_, err = q.Dequeue(ctx)
+		assert.True(t, errors.Is(err, ErrEmpty))
+		c.Add(time.Millisecond)
+	}
+
+	msg, err := q.Dequeue(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, msg.Attempts)
+	assert.Nil(t, q.Nack(msg))
+	assert.Equal(t, 0, tables.count("tasks"))
+
+	dead := newQueue(t, tables, c, Config{Table: "dead", Shards: 2})
+	msg, err = dead.Dequeue(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, id, msg.ID)
+	assert.Equal(t, 3, msg.Priority)
+	assert.Equal(t, 1, msg.Attempts)
+}
+
+func TestDeadLetterOnTimeout(t *testing.T) {
+	tables := newFakeTables()
+	c := &clock{now: time.Unix(1000, 0)}
+	q := newQueue(t, tables, c, Config{Table: "tasks", Shards: 1, MaxAttempts: 1, VisibilityTimeout: time.Second})
+	ctx := context.Background()
+
+	q.Enqueue([]byte("job"), nil)
+	_, err := q.Dequeue(ctx)
+	assert.Nil(t, err)
+
+	// without a dead letter table the item is dropped
+	c.Add(time.Second)
+	_, err = q.Dequeue(ctx)
+	assert.True(t, errors.Is(err, ErrEmpty))
+	assert.Equal(t, 0, tables.count("tasks"))
+}
+
+func TestFilteredPages(t *testing.T) {
+	tables := newFakeTables()
+	tables.pageSize = 2
+	c := &clock{now: time.Unix(1000, 0)}
+	q := newQueue(t, tables, c, Config{Table: "tasks", Shards: 1})
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		q.Enqueue([]byte(fmt.Sprint(i)), nil)
+		c.Add(time.Millisecond)
+	}
+	// the first four are in flight, the scan pages over them
+	for i := 0; i < 4; i++ {
+		_, err := q.Dequeue(ctx)
+		assert.Nil(t, err)
+	}
+	msg, err := q.Dequeue(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("4"), msg.Body)
+}
+
+func TestConcurrentConsumers(t *testing.T) {
+	tables := newFakeTables()
+	c := &clock{now: time.Unix(1000, 0)}
+	q := newQueue(t, tables, c, Config{Table: "tasks", Shards: 4, ScanLimit: 2})
+
+	ids := make(map[string]bool)
+	for i := 0; i < 60; i++ {
+		id, err := q.Enqueue([]byte(fmt.Sprint(i)), &EnqueueOptions{Priority: i % 3})
+		assert.Nil(t, err)
+		ids[id] = true
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msg, err := q.Dequeue(context.Background())
+				if errors.Is(err, ErrEmpty) {
+					return
+				}
+				assert.Nil(t, err)
+				mu.Lock()
+				seen[msg.ID]++
+				mu.Unlock()
+				assert.Nil(t, q.Ack(msg))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, seen, len(ids))
+	for id, n := range seen {
+		assert.True(t, ids[id])
+		assert.Equal(t, 1, n)
+	}
+}
+
+func TestReceive(t *testing.T) {
+	tables := newFakeTables()
+	q, _ := New(tables, Config{Table: "tasks", Shards: 2, PollInterval: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err := q.Receive(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.Enqueue([]byte("job"), nil)
+	}()
+	msg, err := q.Receive(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("job"), msg.Body)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, Config{Table: "tasks"})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = New(newFakeTables(), Config{})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	_, err = New(newFakeTables(), Config{Table: "tasks", DeadLetterTable: "tasks"})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	q, _ := New(newFakeTables(), Config{Table: "tasks", BaseBackoff: time.Second, MaxBackoff: 5 * time.Second})
+	assert.Equal(t, time.Second, q.config.Backoff(1))
+	assert.Equal(t, 4*time.Second, q.config.Backoff(3))
+	assert.Equal(t, 5*time.Second, q.config.Backoff(10))
+}
@@ -0,0 +1,157 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+const (
+	DefaultPollInterval = 5 * time.Second
+	DefaultTimeout      = 30 * time.Minute
+)
+
+type ApplyOptions struct {
+	// Wait blocks after each change until the table or index it created is
+	// ready: tables accept requests, secondary indexes and search indexes
+	// finished their full sync.
+	Wait bool
+	// PollInterval defaults to DefaultPollInterval.
+	PollInterval time.Duration
+	// Timeout bounds the wait for a single change, it defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+	// OnChange is called before each change is sent.
+	OnChange func(change *Change)
+}
+
+// Apply sends the changes in order and stops at the first failure. The
+// changes before it stay applied, so running Plan and Apply again resumes
+// where the last run stopped.
+func Apply(ctx context.Context, client Client, cs *ChangeSet, options *ApplyOptions) error {
+	if options == nil {
+		options = &ApplyOptions{}
+	}
+	for _, change := range cs.Changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if options.OnChange != nil {
+			options.OnChange(change)
+		}
+		if err := applyChange(client, change); err != nil {
+			return fmt.Errorf("%s: %w", change, err)
+		}
+		if options.Wait {
+			if err := waitChange(ctx, client, change, options); err != nil {
+				return fmt.Errorf("%s: %w", change, err)
+			}
+		}
+	}
+	return nil
+}
+
+func applyChange(client Client, change *Change) error {
+	var err error
+	switch change.Type {
+	case CreateTable:
+		_, err = client.CreateTable(change.CreateTableRequest)
+	case UpdateTable:
+		_, err = client.UpdateTable(change.UpdateTableRequest)
+	case AddDefinedColumn:
+		_, err = client.AddDefinedColumn(change.AddDefinedColumnRequest)
+	case CreateIndex:
+		_, err = client.CreateIndex(change.CreateIndexRequest)
+	case CreateSearchIndex:
+		_, err = client.CreateSearchIndex(change.CreateSearchIndexRequest)
+	default:
+		err = fmt.Errorf("unknown change type %d", int(change.Type))
+	}
+	return err
+}
+
+func waitChange(ctx context.Context, client Client, change *Change, options *ApplyOptions) error {
+	var ready func() (bool, error)
+	switch change.Type {
+	case CreateTable:
+		indexes := change.CreateTableRequest.IndexMetas
+		ready = func() (bool, error) {
+			return tableReady(client, change.TableName, indexes)
+		}
+	case CreateIndex:
+		indexes := []*tablestore.IndexMeta{change.CreateIndexRequest.IndexMeta}
+		ready = func() (bool, error) {
+			return tableReady(client, change.TableName, indexes)
+		}
+	case CreateSearchIndex:
+		ready = func() (bool, error) {
+			return searchIndexReady(client, change.TableName, change.Name)
+		}
+	default:
+		return nil
+	}
+
+	interval := options.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ok, err := ready()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// tableReady reports whether the table answers DescribeTable and the given
+// indexes finished their full sync. A table that was just created may not be
+// visible yet, so OTSObjectNotExist counts as not ready.
+func tableReady(client Client, tableName string, indexes []*tablestore.IndexMeta) (bool, error) {
+	resp, err := client.DescribeTable(&tablestore.DescribeTableRequest{TableName: tableName})
+	if err != nil {
+		var otsErr *tablestore.OtsError
+		if errors.As(err, &otsErr) && otsErr.Code == "OTSObjectNotExist" {
+			return false, nil
+		}
+		return false, err
+	}
+	phases := make(map[string]*tablestore.SyncPhase, len(resp.IndexMetas))
+	for _, meta := range resp.IndexMetas {
+		phases[meta.IndexName] = meta.IndexSyncPhase
+	}
+	for _, index := range indexes {
+		phase, ok := phases[index.IndexName]
+		if !ok || (phase != nil && *phase == tablestore.SyncPhase_FULL) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func searchIndexReady(client Client, tableName, indexName string) (bool, error) {
+	resp, err := client.DescribeSearchIndex(&tablestore.DescribeSearchIndexRequest{TableName: tableName, IndexName: indexName})
+	if err != nil {
+		return false, err
+	}
+	return resp.SyncStat != nil && resp.SyncStat.SyncPhase == tablestore.SyncPhase_INCR, nil
+}
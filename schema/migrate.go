@@ -0,0 +1,414 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var ErrMigrationFailed = errors.New("[schema] search index migration failed")
+
+// DefaultWeightSteps are the percentages of queries sent to the new index
+// before the switch.
+var DefaultWeightSteps = []int32{10, 50}
+
+// MigrateClient is the part of *tablestore.TableStoreClient used by
+// MigrateSearchIndex.
+type MigrateClient interface {
+	DescribeSearchIndex(request *tablestore.DescribeSearchIndexRequest) (*tablestore.DescribeSearchIndexResponse, error)
+	CreateSearchIndex(request *tablestore.CreateSearchIndexRequest) (*tablestore.CreateSearchIndexResponse, error)
+	UpdateSearchIndex(request *tablestore.UpdateSearchIndexRequest) (*tablestore.UpdateSearchIndexResponse, error)
+	DeleteSearchIndex(request *tablestore.DeleteSearchIndexRequest) (*tablestore.DeleteSearchIndexResponse, error)
+}
+
+type MigrateOptions struct {
+	// ShadowIndexName is the name the new index is created with, the index
+	// name with a _shadow suffix by default. After the switch it is the
+	// name of the old index.
+	ShadowIndexName string
+	// WeightSteps are the percentages of queries sent to the new index, one
+	// step after the other, DefaultWeightSteps when nil. An empty slice
+	// switches right after the sync.
+	WeightSteps []int32
+	// StepInterval is how long each step lasts before Check is called.
+	StepInterval time.Duration
+	// Check is called at the end of each step, with all queries on the new
+	// index before the switch and after the switch before the old index is
+	// deleted. An error before the switch rolls the migration back, an
+	// error after it keeps the old index.
+	Check func(ctx context.Context, step *MigrateStep) error
+	// OnStep is called when the migration moves to another step.
+	OnStep func(step *MigrateStep)
+	// KeepOldIndex leaves the old index behind after the switch instead of
+	// deleting it.
+	KeepOldIndex bool
+	// PollInterval defaults to DefaultPollInterval.
+	PollInterval time.Duration
+	// Timeout bounds the wait for the new index to catch up, it defaults
+	// to DefaultTimeout.
+	Timeout time.Duration
+}
+
+type MigratePhase int
+
+const (
+	MigrateCreate MigratePhase = iota
+	MigrateSync
+	MigrateShift
+	MigrateSwitch
+	MigrateSoak
+	MigrateDeleteOld
+	MigrateRollback
+	MigrateDone
+)
+
+func (p MigratePhase) String() string {
+	switch p {
+	case MigrateCreate:
+		return "Create"
+	case MigrateSync:
+		return "Sync"
+	case MigrateShift:
+		return "Shift"
+	case MigrateSwitch:
+		return "Switch"
+	case MigrateSoak:
+		return "Soak"
+	case MigrateDeleteOld:
+		return "DeleteOld"
+	case MigrateRollback:
+		return "Rollback"
+	case MigrateDone:
+		return "Done"
+	}
+	return fmt.Sprintf("MigratePhase(%d)", int(p))
+}
+
+type MigrateStep struct {
+	Phase           MigratePhase
+	TableName       string
+	IndexName       string
+	ShadowIndexName string
+	// Weight is the percentage of queries sent to the new index.
+	Weight int32
+}
+
+func (s *MigrateStep) String() string {
+	return fmt.Sprintf("%s table %s search index %s -> %s, weight %d%%", s.Phase, s.TableName, s.IndexName, s.ShadowIndexName, s.Weight)
+}
+
+// MigrateError is a failure of a migration before the switch. It matches
+// ErrMigrationFailed with errors.Is and unwraps to the failure.
+type MigrateError struct {
+	// Phase is the phase that failed.
+	Phase MigratePhase
+	Err   error
+	// RollbackErr is set when the rollback failed too, the shadow index
+	// may then still exist and receive queries.
+	RollbackErr error
+}
+
+func (e *MigrateError) Error() string {
+	msg := fmt.Sprintf("%s in phase %s: %v", ErrMigrationFailed, e.Phase, e.Err)
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf(", rollback failed: %v", e.RollbackErr)
+	}
+	return msg
+}
+
+func (e *MigrateError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MigrateError) Is(target error) bool {
+	return target == ErrMigrationFailed
+}
+
+type MigrateResult struct {
+	// Diffs are the differences between the desired and the current schema,
+	// nothing is changed when there are none.
+	Diffs []string
+	// Switched is set once the index name serves the desired schema.
+	Switched bool
+	// RolledBack is set when a failure sent all queries back to the old
+	// index and deleted the new one.
+	RolledBack bool
+}
+
+// MigrateSearchIndex changes the schema of a search index, which the
+// service cannot do in place, by blue-green reindexing:
+//
+//  1. a shadow index is created from the current one with the desired
+//     schema and reindexes the table
+//  2. once it reached the incremental sync and caught up with the current
+//     index, queries move over to it following WeightSteps
+//  3. all queries go to the shadow index for a last step, then the index
+//     names are switched, so the index name serves the desired schema and
+//     the shadow name the old one
+//  4. unless KeepOldIndex is set, the old index is deleted after one more
+//     step that passed Check
+//
+// A failure before the switch, including an error of Check, moves all
+// queries back to the current index and deletes the shadow index, and
+// returns a *MigrateError, as does a failure to create the shadow index.
+func MigrateSearchIndex(ctx context.Context, client MigrateClient, tableName, indexName string, desired *tablestore.IndexSchema, options *MigrateOptions) (*MigrateResult, error) {
+	if options == nil {
+		options = &MigrateOptions{}
+	}
+	if desired == nil || len(desired.FieldSchemas) == 0 {
+		return nil, invalidf("search index %s: desired schema has no fields", indexName)
+	}
+	for _, weight := range options.WeightSteps {
+		if weight <= 0 || weight > 100 {
+			return nil, invalidf("weight step %d is not in (0, 100]", weight)
+		}
+	}
+	current, err := client.DescribeSearchIndex(&tablestore.DescribeSearchIndexRequest{TableName: tableName, IndexName: indexName})
+	if err != nil {
+		return nil, err
+	}
+	result := &MigrateResult{Diffs: diffIndexSchema(desired, current.Schema)}
+	if len(result.Diffs) == 0 {
+		return result, nil
+	}
+
+	m := &migration{
+		client:  client,
+		options: options,
+		step: MigrateStep{
+			TableName:       tableName,
+			IndexName:       indexName,
+			ShadowIndexName: options.ShadowIndexName,
+		},
+	}
+	if m.step.ShadowIndexName == "" {
+		m.step.ShadowIndexName = indexName + "_shadow"
+	}
+	if err := m.create(desired, current.TimeToLive); err != nil {
+		// nothing was created, there is nothing to roll back
+		return result, &MigrateError{Phase: MigrateCreate, Err: err}
+	}
+	if err := m.migrate(ctx); err != nil {
+		migrateErr := &MigrateError{Phase: m.step.Phase, Err: err}
+		migrateErr.RollbackErr = m.rollback()
+		result.RolledBack = migrateErr.RollbackErr == nil
+		return result, migrateErr
+	}
+	result.Switched = true
+
+	if !options.KeepOldIndex {
+		m.next(MigrateSoak, m.step.Weight)
+		if err := m.check(ctx); err != nil {
+			return result, fmt.Errorf("check after the switch, old index kept as %s: %w", m.step.ShadowIndexName, err)
+		}
+		m.next(MigrateDeleteOld, m.step.Weight)
+		if _, err := client.DeleteSearchIndex(&tablestore.DeleteSearchIndexRequest{TableName: tableName, IndexName: m.step.ShadowIndexName}); err != nil {
+			return result, fmt.Errorf("delete old index %s: %w", m.step.ShadowIndexName, err)
+		}
+	}
+	m.next(MigrateDone, m.step.Weight)
+	return result, nil
+}
+
+type migration struct {
+	client  MigrateClient
+	options *MigrateOptions
+	step    MigrateStep
+	shifted bool
+}
+
+func (m *migration) next(phase MigratePhase, weight int32) {
+	m.step.Phase = phase
+	m.step.Weight = weight
+	if m.options.OnStep != nil {
+		step := m.step
+		m.options.OnStep(&step)
+	}
+}
+
+func (m *migration) create(desired *tablestore.IndexSchema, timeToLive int32) error {
+	m.next(MigrateCreate, 0)
+	request := &tablestore.CreateSearchIndexRequest{
+		TableName:       m.step.TableName,
+		IndexName:       m.step.ShadowIndexName,
+		IndexSchema:     desired,
+		SourceIndexName: &m.step.IndexName,
+	}
+	if timeToLive != 0 {
+		request.TimeToLive = &timeToLive
+	}
+	_, err := m.client.CreateSearchIndex(request)
+	return err
+}
+
+func (m *migration) migrate(ctx context.Context) error {
+	m.next(MigrateSync, 0)
+	if err := m.waitSync(ctx); err != nil {
+		return err
+	}
+
+	steps := m.options.WeightSteps
+	if steps == nil {
+		steps = DefaultWeightSteps
+	}
+	var weight int32
+	for _, weight = range steps {
+		m.next(MigrateShift, weight)
+		if err := m.setWeight(weight); err != nil {
+			return err
+		}
+		m.shifted = true
+		if err := m.check(ctx); err != nil {
+			return err
+		}
+	}
+
+	// the new index takes all queries before the final check
+	m.next(MigrateSwitch, 100)
+	if weight != 100 {
+		if err := m.setWeight(100); err != nil {
+			return err
+		}
+		m.shifted = true
+	}
+	if err := m.check(ctx); err != nil {
+		return err
+	}
+	_, err := m.client.UpdateSearchIndex(&tablestore.UpdateSearchIndexRequest{
+		TableName:       m.step.TableName,
+		IndexName:       m.step.IndexName,
+		SwitchIndexName: &m.step.ShadowIndexName,
+	})
+	return err
+}
+
+// check waits for the StepInterval and runs Check.
+func (m *migration) check(ctx context.Context) error {
+	if m.options.StepInterval > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.options.StepInterval):
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.options.Check != nil {
+		step := m.step
+		return m.options.Check(ctx, &step)
+	}
+	return nil
+}
+
+func (m *migration) setWeight(weight int32) error {
+	_, err := m.client.UpdateSearchIndex(&tablestore.UpdateSearchIndexRequest{
+		TableName: m.step.TableName,
+		IndexName: m.step.IndexName,
+		QueryFlowWeights: []*tablestore.QueryFlowWeight{
+			{IndexName: m.step.IndexName, Weight: 100 - weight},
+			{IndexName: m.step.ShadowIndexName, Weight: weight},
+		},
+	})
+	return err
+}
+
+// waitSync polls both indexes until the shadow index is in the incremental
+// sync and has reached the point the current index was at on the previous
+// poll.
+func (m *migration) waitSync(ctx context.Context) error {
+	interval := m.options.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	timeout := m.options.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var target *int64
+	for {
+		shadow, err := m.client.DescribeSearchIndex(&tablestore.DescribeSearchIndexRequest{TableName: m.step.TableName, IndexName: m.step.ShadowIndexName})
+		if err != nil {
+			return err
+		}
+		if stat := shadow.SyncStat; stat != nil && stat.SyncPhase == tablestore.SyncPhase_INCR {
+			if stat.CurrentSyncTimestamp == nil {
+				return nil
+			}
+			if target != nil && *stat.CurrentSyncTimestamp >= *target {
+				return nil
+			}
+			current, err := m.client.DescribeSearchIndex(&tablestore.DescribeSearchIndexRequest{TableName: m.step.TableName, IndexName: m.step.IndexName})
+			if err != nil {
+				return err
+			}
+			if current.SyncStat == nil || current.SyncStat.CurrentSyncTimestamp == nil {
+				return nil
+			}
+			target = current.SyncStat.CurrentSyncTimestamp
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollback sends all queries back to the current index and deletes the
+// shadow index. It runs after a failure before the switch, so it ignores
+// the context of the migration, which may be the cause.
+func (m *migration) rollback() error {
+	weight := m.step.Weight
+	m.next(MigrateRollback, weight)
+	if m.shifted {
+		if err := m.setWeight(0); err != nil {
+			return err
+		}
+	}
+	_, err := m.client.DeleteSearchIndex(&tablestore.DeleteSearchIndexRequest{TableName: m.step.TableName, IndexName: m.step.ShadowIndexName})
+	return err
+}
+
+// diffIndexSchema reports how current differs from desired. Unlike Plan it
+// also reports current fields the desired schema drops, and compares the
+// routing fields and index sort when desired sets them.
+func diffIndexSchema(desired, current *tablestore.IndexSchema) []string {
+	if current == nil {
+		current = &tablestore.IndexSchema{}
+	}
+	diffs := diffFields("", desired.FieldSchemas, current.FieldSchemas)
+	wanted := make(map[string]bool, len(desired.FieldSchemas))
+	for _, field := range desired.FieldSchemas {
+		wanted[*field.FieldName] = true
+	}
+	for _, field := range current.FieldSchemas {
+		if field.FieldName != nil && !wanted[*field.FieldName] {
+			diffs = append(diffs, fmt.Sprintf("field %s is dropped", *field.FieldName))
+		}
+	}
+	if desired.IndexSetting != nil && len(desired.IndexSetting.RoutingFields) > 0 {
+		var routing []string
+		if current.IndexSetting != nil {
+			routing = current.IndexSetting.RoutingFields
+		}
+		if !equalStrings(desired.IndexSetting.RoutingFields, routing) {
+			diffs = append(diffs, fmt.Sprintf("routing fields are [%s], want [%s]",
+				strings.Join(routing, ", "), strings.Join(desired.IndexSetting.RoutingFields, ", ")))
+		}
+	}
+	if desired.IndexSort != nil && !reflect.DeepEqual(desired.IndexSort, current.IndexSort) {
+		diffs = append(diffs, "index sort differs")
+	}
+	return diffs
+}
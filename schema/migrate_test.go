@@ -0,0 +1,257 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ MigrateClient = (*tablestore.TableStoreClient)(nil)
+
+// fakeMigrateClient holds the search indexes of one table. A new index
+// reports the full sync on its first DescribeSearchIndex and then the
+// incremental sync, moving its sync timestamp forward by 40 on each call.
+type fakeMigrateClient struct {
+	indexes map[string]*tablestore.DescribeSearchIndexResponse
+	fail    map[string]error
+	calls   []string
+}
+
+func newFakeMigrateClient() *fakeMigrateClient {
+	return &fakeMigrateClient{
+		indexes: map[string]*tablestore.DescribeSearchIndexResponse{
+			"idx": {
+				Schema: &tablestore.IndexSchema{FieldSchemas: []*tablestore.FieldSchema{
+					{FieldName: proto.String("title"), FieldType: tablestore.FieldType_KEYWORD},
+					{FieldName: proto.String("old"), FieldType: tablestore.FieldType_LONG},
+				}},
+				SyncStat:   &tablestore.SyncStat{SyncPhase: tablestore.SyncPhase_INCR, CurrentSyncTimestamp: proto.Int64(100)},
+				TimeToLive: -1,
+			},
+		},
+		fail: make(map[string]error),
+	}
+}
+
+func (c *fakeMigrateClient) call(call string) error {
+	c.calls = append(c.calls, call)
+	return c.fail[call]
+}
+
+func (c *fakeMigrateClient) DescribeSearchIndex(request *tablestore.DescribeSearchIndexRequest) (*tablestore.DescribeSearchIndexResponse, error) {
+	index, ok := c.indexes[request.IndexName]
+	if !ok {
+		return nil, &tablestore.OtsError{Code: "OTSObjectNotExist"}
+	}
+	if index.SyncStat.SyncPhase == tablestore.SyncPhase_FULL {
+		index.SyncStat = &tablestore.SyncStat{SyncPhase: tablestore.SyncPhase_INCR, CurrentSyncTimestamp: proto.Int64(0)}
+		return &tablestore.DescribeSearchIndexResponse{Schema: index.Schema, SyncStat: &tablestore.SyncStat{SyncPhase: tablestore.SyncPhase_FULL}}, nil
+	}
+	if request.IndexName != "idx" {
+		*index.SyncStat.CurrentSyncTimestamp += 40
+	}
+	resp := *index
+	stat := *index.SyncStat
+	resp.SyncStat = &stat
+	return &resp, nil
+}
+
+func (c *fakeMigrateClient) CreateSearchIndex(request *tablestore.CreateSearchIndexRequest) (*tablestore.CreateSearchIndexResponse, error) {
+	if err := c.call(fmt.Sprintf("create %s from %s ttl %d", request.IndexName, *request.SourceIndexName, *request.TimeToLive)); err != nil {
+		return nil, err
+	}
+	c.indexes[request.IndexName] = &tablestore.DescribeSearchIndexResponse{
+		Schema:   request.IndexSchema,
+		SyncStat: &tablestore.SyncStat{SyncPhase: tablestore.SyncPhase_FULL},
+	}
+	return &tablestore.CreateSearchIndexResponse{}, nil
+}
+
+func (c *fakeMigrateClient) UpdateSearchIndex(request *tablestore.UpdateSearchIndexRequest) (*tablestore.UpdateSearchIndexResponse, error) {
+	if request.SwitchIndexName != nil {
+		if err := c.call("switch " + request.IndexName + " " + *request.SwitchIndexName); err != nil {
+			return nil, err
+		}
+		c.indexes[request.IndexName], c.indexes[*request.SwitchIndexName] = c.indexes[*request.SwitchIndexName], c.indexes[request.IndexName]
+		return &tablestore.UpdateSearchIndexResponse{}, nil
+	}
+	call := "weights"
+	for _, weight := range request.QueryFlowWeights {
+		call += fmt.Sprintf(" %s=%d", weight.IndexName, weight.Weight)
+	}
+	if err := c.call(call); err != nil {
+		return nil, err
+	}
+	return &tablestore.UpdateSearchIndexResponse{}, nil
+}
+
+func (c *fakeMigrateClient) DeleteSearchIndex(request *tablestore.DeleteSearchIndexRequest) (*tablestore.DeleteSearchIndexResponse, error) {
+	if err := c.call("delete " + request.IndexName); err != nil {
+		return nil, err
+	}
+	delete(c.indexes, request.IndexName)
+	return &tablestore.DeleteSearchIndexResponse{}, nil
+}
+
+func desiredIndexSchema() *tablestore.IndexSchema {
+	analyzer := tablestore.Analyzer_MaxWord
+	return &tablestore.IndexSchema{FieldSchemas: []*tablestore.FieldSchema{
+		{FieldName: proto.String("title"), FieldType: tablestore.FieldType_TEXT, Analyzer: &analyzer},
+	}}
+}
+
+func TestMigrateSearchIndex(t *testing.T) {
+	client := newFakeMigrateClient()
+	var phases []string
+	var checks []int32
+	result, err := MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), &MigrateOptions{
+		WeightSteps:  []int32{20, 60},
+		PollInterval: time.Millisecond,
+		OnStep: func(step *MigrateStep) {
+			phases = append(phases, step.Phase.String())
+		},
+		Check: func(ctx context.Context, step *MigrateStep) error {
+			checks = append(checks, step.Weight)
+			return nil
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"field title is KEYWORD, want TEXT", "field old is dropped"}, result.Diffs)
+	assert.True(t, result.Switched)
+	assert.False(t, result.RolledBack)
+	assert.Equal(t, []string{
+		"create idx_shadow from idx ttl -1",
+		"weights idx=80 idx_shadow=20",
+		"weights idx=40 idx_shadow=60",
+		"weights idx=0 idx_shadow=100",
+		"switch idx idx_shadow",
+		"delete idx_shadow",
+	}, client.calls)
+	assert.Equal(t, []string{"Create", "Sync", "Shift", "Shift", "Switch", "Soak", "DeleteOld", "Done"}, phases)
+	assert.Equal(t, []int32{20, 60, 100, 100}, checks)
+	// the index name serves the desired schema, caught up with the old index
+	assert.Len(t, client.indexes, 1)
+	assert.Equal(t, desiredIndexSchema(), client.indexes["idx"].Schema)
+	assert.True(t, *client.indexes["idx"].SyncStat.CurrentSyncTimestamp >= 100)
+
+	// nothing left to change
+	client.calls = nil
+	result, err = MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), nil)
+	assert.Nil(t, err)
+	assert.Len(t, result.Diffs, 0)
+	assert.Len(t, client.calls, 0)
+}
+
+func TestMigrateSearchIndex_Rollback(t *testing.T) {
+	client := newFakeMigrateClient()
+	unhealthy := errors.New("latency too high")
+	result, err := MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), &MigrateOptions{
+		ShadowIndexName: "idx_v2",
+		KeepOldIndex:    true,
+		PollInterval:    time.Millisecond,
+		Check: func(ctx context.Context, step *MigrateStep) error {
+			if step.Weight == 50 {
+				return unhealthy
+			}
+			return nil
+		},
+	})
+	assert.True(t, errors.Is(err, ErrMigrationFailed))
+	assert.True(t, errors.Is(err, unhealthy))
+	var migrateErr *MigrateError
+	assert.True(t, errors.As(err, &migrateErr))
+	assert.Equal(t, MigrateShift, migrateErr.Phase)
+	assert.True(t, result.RolledBack)
+	assert.False(t, result.Switched)
+	assert.Equal(t, []string{
+		"create idx_v2 from idx ttl -1",
+		"weights idx=90 idx_v2=10",
+		"weights idx=50 idx_v2=50",
+		"weights idx=100 idx_v2=0",
+		"delete idx_v2",
+	}, client.calls)
+	assert.Len(t, client.indexes, 1)
+
+	// a failed switch rolls back too, and a failed rollback is reported
+	client = newFakeMigrateClient()
+	client.fail["switch idx idx_shadow"] = errors.New("switch failed")
+	client.fail["delete idx_shadow"] = errors.New("delete failed")
+	result, err = MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), &MigrateOptions{
+		WeightSteps:  []int32{},
+		PollInterval: time.Millisecond,
+	})
+	assert.True(t, errors.As(err, &migrateErr))
+	assert.Equal(t, MigrateSwitch, migrateErr.Phase)
+	assert.NotNil(t, migrateErr.RollbackErr)
+	assert.False(t, result.RolledBack)
+	assert.Equal(t, []string{
+		"create idx_shadow from idx ttl -1",
+		"weights idx=0 idx_shadow=100",
+		"switch idx idx_shadow",
+		"weights idx=100 idx_shadow=0",
+		"delete idx_shadow",
+	}, client.calls)
+
+	// a failed create has nothing to roll back
+	client = newFakeMigrateClient()
+	quota := errors.New("too many indexes")
+	client.fail["create idx_shadow from idx ttl -1"] = quota
+	result, err = MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), &MigrateOptions{
+		PollInterval: time.Millisecond,
+	})
+	assert.True(t, errors.Is(err, ErrMigrationFailed))
+	assert.True(t, errors.Is(err, quota))
+	assert.True(t, errors.As(err, &migrateErr))
+	assert.Equal(t, MigrateCreate, migrateErr.Phase)
+	assert.Nil(t, migrateErr.RollbackErr)
+	assert.False(t, result.Switched)
+	assert.Equal(t, []string{"create idx_shadow from idx ttl -1"}, client.calls)
+}
+
+func TestMigrateSearchIndex_Soak(t *testing.T) {
+	client := newFakeMigrateClient()
+	unhealthy := errors.New("errors after the switch")
+	result, err := MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), &MigrateOptions{
+		WeightSteps:  []int32{100},
+		PollInterval: time.Millisecond,
+		Check: func(ctx context.Context, step *MigrateStep) error {
+			if step.Phase == MigrateSoak {
+				return unhealthy
+			}
+			return nil
+		},
+	})
+	assert.True(t, errors.Is(err, unhealthy))
+	assert.False(t, errors.Is(err, ErrMigrationFailed))
+	assert.True(t, result.Switched)
+	// a last step at 100 is not repeated, and the old index is kept
+	assert.Equal(t, []string{
+		"create idx_shadow from idx ttl -1",
+		"weights idx=0 idx_shadow=100",
+		"switch idx idx_shadow",
+	}, client.calls)
+	assert.Len(t, client.indexes, 2)
+}
+
+func TestMigrateSearchIndex_SyncTimeout(t *testing.T) {
+	client := newFakeMigrateClient()
+	// the old index keeps ahead of the new one
+	client.indexes["idx"].SyncStat.CurrentSyncTimestamp = proto.Int64(1 << 40)
+	_, err := MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), &MigrateOptions{
+		PollInterval: time.Millisecond,
+		Timeout:      20 * time.Millisecond,
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Equal(t, []string{"create idx_shadow from idx ttl -1", "delete idx_shadow"}, client.calls)
+
+	_, err = MigrateSearchIndex(context.Background(), client, "orders", "idx", desiredIndexSchema(), &MigrateOptions{WeightSteps: []int32{0}})
+	assert.True(t, errors.Is(err, ErrInvalidSchema))
+	_, err = MigrateSearchIndex(context.Background(), client, "orders", "idx", nil, nil)
+	assert.True(t, errors.Is(err, ErrInvalidSchema))
+}
@@ -0,0 +1,502 @@
+package schema
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// Client is the part of *tablestore.TableStoreClient used by Plan and Apply.
+type Client interface {
+	ListTable() (*tablestore.ListTableResponse, error)
+	DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error)
+	CreateTable(request *tablestore.CreateTableRequest) (*tablestore.CreateTableResponse, error)
+	UpdateTable(request *tablestore.UpdateTableRequest) (*tablestore.UpdateTableResponse, error)
+	AddDefinedColumn(request *tablestore.AddDefinedColumnRequest) (*tablestore.AddDefinedColumnResponse, error)
+	CreateIndex(request *tablestore.CreateIndexRequest) (*tablestore.CreateIndexResponse, error)
+	ListSearchIndex(request *tablestore.ListSearchIndexRequest) (*tablestore.ListSearchIndexResponse, error)
+	DescribeSearchIndex(request *tablestore.DescribeSearchIndexRequest) (*tablestore.DescribeSearchIndexResponse, error)
+	CreateSearchIndex(request *tablestore.CreateSearchIndexRequest) (*tablestore.CreateSearchIndexResponse, error)
+}
+
+type ChangeType int
+
+const (
+	CreateTable ChangeType = iota
+	UpdateTable
+	AddDefinedColumn
+	CreateIndex
+	CreateSearchIndex
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case CreateTable:
+		return "CreateTable"
+	case UpdateTable:
+		return "UpdateTable"
+	case AddDefinedColumn:
+		return "AddDefinedColumn"
+	case CreateIndex:
+		return "CreateIndex"
+	case CreateSearchIndex:
+		return "CreateSearchIndex"
+	default:
+		return fmt.Sprintf("ChangeType(%d)", int(t))
+	}
+}
+
+// Change is one request Apply will send. Exactly one of the request fields
+// is set, matching Type.
+type Change struct {
+	Type        ChangeType
+	TableName   string
+	Name        string // index or search index name
+	Description string
+
+	CreateTableRequest       *tablestore.CreateTableRequest
+	UpdateTableRequest       *tablestore.UpdateTableRequest
+	AddDefinedColumnRequest  *tablestore.AddDefinedColumnRequest
+	CreateIndexRequest       *tablestore.CreateIndexRequest
+	CreateSearchIndexRequest *tablestore.CreateSearchIndexRequest
+}
+
+func (c *Change) String() string {
+	if c.Name != "" {
+		return fmt.Sprintf("%s %s.%s: %s", c.Type, c.TableName, c.Name, c.Description)
+	}
+	return fmt.Sprintf("%s %s: %s", c.Type, c.TableName, c.Description)
+}
+
+// ChangeSet is the result of Plan. Changes are in the order they must be
+// applied. Warnings list differences that cannot be applied in place.
+type ChangeSet struct {
+	Changes  []*Change
+	Warnings []string
+}
+
+// Empty reports whether the instance already matches the schema.
+func (cs *ChangeSet) Empty() bool {
+	return len(cs.Changes) == 0
+}
+
+func (cs *ChangeSet) String() string {
+	var b strings.Builder
+	for _, change := range cs.Changes {
+		b.WriteString("+ ")
+		b.WriteString(change.String())
+		b.WriteString("\n")
+	}
+	for _, warning := range cs.Warnings {
+		b.WriteString("! ")
+		b.WriteString(warning)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (cs *ChangeSet) add(change *Change) {
+	cs.Changes = append(cs.Changes, change)
+}
+
+func (cs *ChangeSet) warnf(format string, args ...interface{}) {
+	cs.Warnings = append(cs.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Plan compares desired with the tables in the instance and returns the
+// changes that bring the instance in line with it. Plan only reads.
+func Plan(ctx context.Context, client Client, desired *Schema) (*ChangeSet, error) {
+	if err := desired.Validate(); err != nil {
+		return nil, err
+	}
+	listResp, err := client.ListTable()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(listResp.TableNames))
+	for _, name := range listResp.TableNames {
+		existing[name] = true
+	}
+
+	cs := new(ChangeSet)
+	for _, table := range desired.Tables {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !existing[table.Name] {
+			if err := planCreateTable(cs, table); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		current, err := client.DescribeTable(&tablestore.DescribeTableRequest{TableName: table.Name})
+		if err != nil {
+			return nil, err
+		}
+		if err := planTable(cs, table, current); err != nil {
+			return nil, err
+		}
+		if err := planSearchIndexes(ctx, cs, client, table); err != nil {
+			return nil, err
+		}
+	}
+	return cs, nil
+}
+
+func planCreateTable(cs *ChangeSet, table *Table) error {
+	request := &tablestore.CreateTableRequest{
+		TableMeta:          &tablestore.TableMeta{TableName: table.Name},
+		TableOption:        &tablestore.TableOption{TimeToAlive: -1, MaxVersion: 1},
+		ReservedThroughput: &tablestore.ReservedThroughput{},
+	}
+	for _, pk := range table.PrimaryKeys {
+		pkType, _ := primaryKeyType(pk.Type)
+		if pk.AutoIncrement {
+			request.TableMeta.AddPrimaryKeyColumnOption(pk.Name, pkType, tablestore.AUTO_INCREMENT)
+		} else {
+			request.TableMeta.AddPrimaryKeyColumn(pk.Name, pkType)
+		}
+	}
+	for _, col := range table.DefinedColumns {
+		colType, _ := definedColumnType(col.Type)
+		request.TableMeta.AddDefinedColumn(col.Name, colType)
+	}
+	if table.TimeToLive != nil {
+		request.TableOption.TimeToAlive = *table.TimeToLive
+	}
+	if table.MaxVersions != nil {
+		request.TableOption.MaxVersion = *table.MaxVersions
+	}
+	if table.DeviationCellVersionInSec != nil {
+		request.TableOption.DeviationCellVersionInSec = *table.DeviationCellVersionInSec
+	}
+	request.TableOption.AllowUpdate = table.AllowUpdate
+	if table.ReservedThroughput != nil {
+		request.ReservedThroughput.Readcap = table.ReservedThroughput.Read
+		request.ReservedThroughput.Writecap = table.ReservedThroughput.Write
+	}
+	if table.Stream != nil && table.Stream.Enabled {
+		request.StreamSpec = streamSpecification(table.Stream)
+	}
+	if table.SSE != nil {
+		request.SSESpecification, _ = table.SSE.specification()
+	}
+	for _, index := range table.Indexes {
+		request.IndexMetas = append(request.IndexMetas, index.indexMeta())
+	}
+
+	description := fmt.Sprintf("create table with %d primary keys", len(table.PrimaryKeys))
+	if len(table.Indexes) > 0 {
+		description += fmt.Sprintf(" and %d secondary indexes", len(table.Indexes))
+	}
+	cs.add(&Change{Type: CreateTable, TableName: table.Name, Description: description, CreateTableRequest: request})
+
+	for _, index := range table.SearchIndexes {
+		if err := planCreateSearchIndex(cs, table.Name, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func planTable(cs *ChangeSet, table *Table, current *tablestore.DescribeTableResponse) error {
+	planPrimaryKeys(cs, table, current.TableMeta)
+	planTableOptions(cs, table, current)
+	if table.SSE != nil {
+		enabled := current.SSEDetails != nil && current.SSEDetails.Enable
+		if enabled != table.SSE.Enabled {
+			cs.warnf("table %s: server side encryption can not be changed after creation", table.Name)
+		}
+	}
+
+	currentColumns := make(map[string]tablestore.DefinedColumnType)
+	for _, col := range current.TableMeta.DefinedColumns {
+		currentColumns[col.Name] = col.ColumnType
+	}
+	addColumns := &tablestore.AddDefinedColumnRequest{TableName: table.Name}
+	var added []string
+	for _, col := range table.DefinedColumns {
+		colType, _ := definedColumnType(col.Type)
+		currentType, ok := currentColumns[col.Name]
+		if !ok {
+			addColumns.AddDefinedColumn(col.Name, colType)
+			added = append(added, col.Name)
+		} else if currentType != colType {
+			cs.warnf("table %s: defined column %s is %s, want %s", table.Name, col.Name, definedColumnTypeName(currentType), strings.ToUpper(col.Type))
+		}
+	}
+	if len(added) > 0 {
+		cs.add(&Change{
+			Type:                    AddDefinedColumn,
+			TableName:               table.Name,
+			Description:             "add defined columns " + strings.Join(added, ", "),
+			AddDefinedColumnRequest: addColumns,
+		})
+	}
+
+	currentIndexes := make(map[string]*tablestore.IndexMeta)
+	for _, meta := range current.IndexMetas {
+		currentIndexes[meta.IndexName] = meta
+	}
+	for _, index := range table.Indexes {
+		want := index.indexMeta()
+		got, ok := currentIndexes[index.Name]
+		if !ok {
+			cs.add(&Change{
+				Type:        CreateIndex,
+				TableName:   table.Name,
+				Name:        index.Name,
+				Description: fmt.Sprintf("create %s index on %s", strings.ToLower(indexTypeName(want.IndexType)), strings.Join(want.Primarykey, ", ")),
+				CreateIndexRequest: &tablestore.CreateIndexRequest{
+					MainTableName:   table.Name,
+					IndexMeta:       want,
+					IncludeBaseData: index.IncludeBaseData == nil || *index.IncludeBaseData,
+				},
+			})
+			continue
+		}
+		if got.IndexType != want.IndexType || !equalStrings(got.Primarykey, want.Primarykey) || !equalStrings(got.DefinedColumns, want.DefinedColumns) {
+			cs.warnf("table %s: index %s differs from the schema and must be recreated", table.Name, index.Name)
+		}
+	}
+	return nil
+}
+
+func planPrimaryKeys(cs *ChangeSet, table *Table, meta *tablestore.TableMeta) {
+	same := len(meta.SchemaEntry) == len(table.PrimaryKeys)
+	for i := 0; same && i < len(table.PrimaryKeys); i++ {
+		want := table.PrimaryKeys[i]
+		got := meta.SchemaEntry[i]
+		wantType, _ := primaryKeyType(want.Type)
+		if got.Name == nil || *got.Name != want.Name || got.Type == nil || *got.Type != wantType {
+			same = false
+		}
+	}
+	if !same {
+		cs.warnf("table %s: primary key differs from the schema and can not be changed", table.Name)
+	}
+}
+
+func planTableOptions(cs *ChangeSet, table *Table, current *tablestore.DescribeTableResponse) {
+	request := &tablestore.UpdateTableRequest{TableName: table.Name}
+	var diffs []string
+
+	option := *current.TableOption
+	optionChanged := false
+	if table.TimeToLive != nil && *table.TimeToLive != option.TimeToAlive {
+		diffs = append(diffs, fmt.Sprintf("timeToLive %d -> %d", option.TimeToAlive, *table.TimeToLive))
+		option.TimeToAlive = *table.TimeToLive
+		optionChanged = true
+	}
+	if table.MaxVersions != nil && *table.MaxVersions != option.MaxVersion {
+		diffs = append(diffs, fmt.Sprintf("maxVersions %d -> %d", option.MaxVersion, *table.MaxVersions))
+		option.MaxVersion = *table.MaxVersions
+		optionChanged = true
+	}
+	if table.DeviationCellVersionInSec != nil && *table.DeviationCellVersionInSec != option.DeviationCellVersionInSec {
+		diffs = append(diffs, fmt.Sprintf("deviationCellVersionInSec %d -> %d", option.DeviationCellVersionInSec, *table.DeviationCellVersionInSec))
+		option.DeviationCellVersionInSec = *table.DeviationCellVersionInSec
+		optionChanged = true
+	}
+	if table.AllowUpdate != nil && (option.AllowUpdate == nil || *option.AllowUpdate != *table.AllowUpdate) {
+		diffs = append(diffs, fmt.Sprintf("allowUpdate -> %t", *table.AllowUpdate))
+		option.AllowUpdate = table.AllowUpdate
+		optionChanged = true
+	}
+	if optionChanged {
+		// only send what the schema manages, UpdateFullRow is not one of them
+		option.UpdateFullRow = nil
+		request.TableOption = &option
+	}
+
+	if want := table.ReservedThroughput; want != nil {
+		got := current.ReservedThroughput
+		if got == nil || got.Readcap != want.Read || got.Writecap != want.Write {
+			if got == nil {
+				got = &tablestore.ReservedThroughput{}
+			}
+			diffs = append(diffs, fmt.Sprintf("reservedThroughput %d/%d -> %d/%d", got.Readcap, got.Writecap, want.Read, want.Write))
+			request.ReservedThroughput = &tablestore.ReservedThroughput{Readcap: want.Read, Writecap: want.Write}
+		}
+	}
+
+	if want := table.Stream; want != nil {
+		got := current.StreamDetails
+		enabled := got != nil && got.EnableStream
+		if enabled != want.Enabled || (want.Enabled && (got.ExpirationTime != want.ExpirationHours || !equalStrings(got.OriginColumnsToGet, want.OriginColumns))) {
+			if want.Enabled {
+				diffs = append(diffs, fmt.Sprintf("stream enabled for %d hours", want.ExpirationHours))
+			} else {
+				diffs = append(diffs, "stream disabled")
+			}
+			request.StreamSpec = streamSpecification(want)
+		}
+	}
+
+	if len(diffs) > 0 {
+		cs.add(&Change{Type: UpdateTable, TableName: table.Name, Description: strings.Join(diffs, ", "), UpdateTableRequest: request})
+	}
+}
+
+func planSearchIndexes(ctx context.Context, cs *ChangeSet, client Client, table *Table) error {
+	if len(table.SearchIndexes) == 0 {
+		return nil
+	}
+	listResp, err := client.ListSearchIndex(&tablestore.ListSearchIndexRequest{TableName: table.Name})
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, info := range listResp.IndexInfo {
+		existing[info.IndexName] = true
+	}
+
+	for _, index := range table.SearchIndexes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !existing[index.Name] {
+			if err := planCreateSearchIndex(cs, table.Name, index); err != nil {
+				return err
+			}
+			continue
+		}
+		current, err := client.DescribeSearchIndex(&tablestore.DescribeSearchIndexRequest{TableName: table.Name, IndexName: index.Name})
+		if err != nil {
+			return err
+		}
+		want, err := index.IndexSchema()
+		if err != nil {
+			return err
+		}
+		var got []*tablestore.FieldSchema
+		if current.Schema != nil {
+			got = current.Schema.FieldSchemas
+		}
+		for _, diff := range diffFields("", want.FieldSchemas, got) {
+			cs.warnf("table %s: search index %s: %s", table.Name, index.Name, diff)
+		}
+		if index.TimeToLive != nil && *index.TimeToLive != current.TimeToLive {
+			cs.warnf("table %s: search index %s: timeToLive is %d, want %d", table.Name, index.Name, current.TimeToLive, *index.TimeToLive)
+		}
+	}
+	return nil
+}
+
+func planCreateSearchIndex(cs *ChangeSet, tableName string, index *SearchIndex) error {
+	indexSchema, err := index.IndexSchema()
+	if err != nil {
+		return err
+	}
+	request := &tablestore.CreateSearchIndexRequest{
+		TableName:   tableName,
+		IndexName:   index.Name,
+		IndexSchema: indexSchema,
+		TimeToLive:  index.TimeToLive,
+	}
+	cs.add(&Change{
+		Type:                     CreateSearchIndex,
+		TableName:                tableName,
+		Name:                     index.Name,
+		Description:              fmt.Sprintf("create search index with %d fields", len(index.Fields)),
+		CreateSearchIndexRequest: request,
+	})
+	return nil
+}
+
+// diffFields reports desired fields that are missing or different in the
+// current search index schema. Only attributes the schema sets are compared
+// because the service fills in defaults for the rest.
+func diffFields(prefix string, want, got []*tablestore.FieldSchema) []string {
+	current := make(map[string]*tablestore.FieldSchema, len(got))
+	for _, field := range got {
+		if field.FieldName != nil {
+			current[*field.FieldName] = field
+		}
+	}
+
+	var diffs []string
+	for _, field := range want {
+		name := prefix + *field.FieldName
+		cur, ok := current[*field.FieldName]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("field %s is missing", name))
+			continue
+		}
+		if cur.FieldType != field.FieldType {
+			diffs = append(diffs, fmt.Sprintf("field %s is %s, want %s", name, cur.FieldType.String(), field.FieldType.String()))
+			continue
+		}
+		if !sameBool(field.Index, cur.Index) || !sameBool(field.EnableSortAndAgg, cur.EnableSortAndAgg) ||
+			!sameBool(field.Store, cur.Store) || !sameBool(field.IsArray, cur.IsArray) ||
+			(field.Analyzer != nil && (cur.Analyzer == nil || *cur.Analyzer != *field.Analyzer)) ||
+			(field.VectorOptions != nil && !reflect.DeepEqual(field.VectorOptions, cur.VectorOptions)) {
+			diffs = append(diffs, fmt.Sprintf("field %s options differ", name))
+		}
+		diffs = append(diffs, diffFields(name+".", field.FieldSchemas, cur.FieldSchemas)...)
+	}
+	return diffs
+}
+
+func (index *Index) indexMeta() *tablestore.IndexMeta {
+	indexType, _ := indexType(index.Type)
+	meta := &tablestore.IndexMeta{IndexName: index.Name, IndexType: indexType}
+	for _, pk := range index.PrimaryKeys {
+		meta.AddPrimaryKeyColumn(pk)
+	}
+	for _, col := range index.DefinedColumns {
+		meta.AddDefinedColumn(col)
+	}
+	return meta
+}
+
+func streamSpecification(stream *Stream) *tablestore.StreamSpecification {
+	return &tablestore.StreamSpecification{
+		EnableStream:       stream.Enabled,
+		ExpirationTime:     stream.ExpirationHours,
+		OriginColumnsToGet: stream.OriginColumns,
+	}
+}
+
+func definedColumnTypeName(t tablestore.DefinedColumnType) string {
+	switch t {
+	case tablestore.DefinedColumn_INTEGER:
+		return "INTEGER"
+	case tablestore.DefinedColumn_DOUBLE:
+		return "DOUBLE"
+	case tablestore.DefinedColumn_BOOLEAN:
+		return "BOOLEAN"
+	case tablestore.DefinedColumn_STRING:
+		return "STRING"
+	case tablestore.DefinedColumn_BINARY:
+		return "BINARY"
+	default:
+		return fmt.Sprintf("DefinedColumnType(%d)", int(t))
+	}
+}
+
+func indexTypeName(t tablestore.IndexType) string {
+	if t == tablestore.IT_LOCAL_INDEX {
+		return "LOCAL"
+	}
+	return "GLOBAL"
+}
+
+func sameBool(want, got *bool) bool {
+	return want == nil || (got != nil && *want == *got)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
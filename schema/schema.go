@@ -0,0 +1,397 @@
+// Package schema describes Tablestore tables, secondary indexes and search
+// indexes as code and reconciles an instance with that description.
+//
+// A Schema is usually loaded from YAML:
+//
+//	tables:
+//	  - name: orders
+//	    primaryKeys:
+//	      - {name: user_id, type: STRING}
+//	      - {name: order_id, type: INTEGER, autoIncrement: true}
+//	    definedColumns:
+//	      - {name: status, type: STRING}
+//	    timeToLive: -1
+//	    maxVersions: 1
+//	    indexes:
+//	      - {name: orders_by_status, primaryKeys: [status, user_id, order_id]}
+//	    searchIndexes:
+//	      - name: orders_search
+//	        fields:
+//	          - {name: status, type: KEYWORD}
+//
+// Plan compares the description with what DescribeTable and
+// DescribeSearchIndex report and returns the ordered changes needed, and
+// Apply carries them out. Nothing is ever dropped: changes the service
+// cannot apply in place, such as a different primary key, are reported as
+// warnings instead. MigrateSearchIndex carries out the search index schema
+// changes Plan warns about by reindexing into a new index and switching
+// over to it.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidSchema = errors.New("[schema] invalid schema")
+
+// Schema is the desired state of a set of tables. Tables that exist in the
+// instance but are not listed here are left alone.
+type Schema struct {
+	Tables []*Table `yaml:"tables" json:"tables"`
+}
+
+// Table describes a table. Optional settings left nil are not managed: they
+// take the service defaults when the table is created and are never changed
+// afterwards.
+type Table struct {
+	Name           string           `yaml:"name" json:"name"`
+	PrimaryKeys    []*PrimaryKey    `yaml:"primaryKeys" json:"primaryKeys"`
+	DefinedColumns []*DefinedColumn `yaml:"definedColumns,omitempty" json:"definedColumns,omitempty"`
+
+	// TimeToLive is in seconds, -1 means data never expires.
+	TimeToLive                *int   `yaml:"timeToLive,omitempty" json:"timeToLive,omitempty"`
+	MaxVersions               *int   `yaml:"maxVersions,omitempty" json:"maxVersions,omitempty"`
+	DeviationCellVersionInSec *int64 `yaml:"deviationCellVersionInSec,omitempty" json:"deviationCellVersionInSec,omitempty"`
+	AllowUpdate               *bool  `yaml:"allowUpdate,omitempty" json:"allowUpdate,omitempty"`
+
+	ReservedThroughput *ReservedThroughput `yaml:"reservedThroughput,omitempty" json:"reservedThroughput,omitempty"`
+	Stream             *Stream             `yaml:"stream,omitempty" json:"stream,omitempty"`
+	SSE                *SSE                `yaml:"sse,omitempty" json:"sse,omitempty"`
+
+	Indexes       []*Index       `yaml:"indexes,omitempty" json:"indexes,omitempty"`
+	SearchIndexes []*SearchIndex `yaml:"searchIndexes,omitempty" json:"searchIndexes,omitempty"`
+}
+
+// PrimaryKey is a primary key column. Type is one of INTEGER, STRING and
+// BINARY.
+type PrimaryKey struct {
+	Name          string `yaml:"name" json:"name"`
+	Type          string `yaml:"type" json:"type"`
+	AutoIncrement bool   `yaml:"autoIncrement,omitempty" json:"autoIncrement,omitempty"`
+}
+
+// DefinedColumn is a predefined attribute column. Type is one of INTEGER,
+// DOUBLE, BOOLEAN, STRING and BINARY.
+type DefinedColumn struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+}
+
+type ReservedThroughput struct {
+	Read  int `yaml:"read" json:"read"`
+	Write int `yaml:"write" json:"write"`
+}
+
+type Stream struct {
+	Enabled         bool     `yaml:"enabled" json:"enabled"`
+	ExpirationHours int32    `yaml:"expirationHours,omitempty" json:"expirationHours,omitempty"`
+	OriginColumns   []string `yaml:"originColumns,omitempty" json:"originColumns,omitempty"`
+}
+
+// SSE is the server side encryption setting. KeyType is KMS_SERVICE or BYOK.
+type SSE struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	KeyType string `yaml:"keyType,omitempty" json:"keyType,omitempty"`
+	KeyId   string `yaml:"keyId,omitempty" json:"keyId,omitempty"`
+	RoleArn string `yaml:"roleArn,omitempty" json:"roleArn,omitempty"`
+}
+
+// Index is a secondary index. Type is GLOBAL (the default) or LOCAL.
+// IncludeBaseData defaults to true.
+type Index struct {
+	Name            string   `yaml:"name" json:"name"`
+	Type            string   `yaml:"type,omitempty" json:"type,omitempty"`
+	PrimaryKeys     []string `yaml:"primaryKeys" json:"primaryKeys"`
+	DefinedColumns  []string `yaml:"definedColumns,omitempty" json:"definedColumns,omitempty"`
+	IncludeBaseData *bool    `yaml:"includeBaseData,omitempty" json:"includeBaseData,omitempty"`
+}
+
+type SearchIndex struct {
+	Name          string   `yaml:"name" json:"name"`
+	Fields        []*Field `yaml:"fields" json:"fields"`
+	RoutingFields []string `yaml:"routingFields,omitempty" json:"routingFields,omitempty"`
+	// TimeToLive is in seconds, nil or -1 means documents never expire.
+	TimeToLive *int32 `yaml:"timeToLive,omitempty" json:"timeToLive,omitempty"`
+}
+
+// Field is a search index field. Type is a tablestore.FieldType name such as
+// KEYWORD or TEXT, Fields holds the children of a NESTED field and Vector is
+// required for VECTOR fields.
+type Field struct {
+	Name             string       `yaml:"name" json:"name"`
+	Type             string       `yaml:"type" json:"type"`
+	Index            *bool        `yaml:"index,omitempty" json:"index,omitempty"`
+	Analyzer         string       `yaml:"analyzer,omitempty" json:"analyzer,omitempty"`
+	EnableSortAndAgg *bool        `yaml:"enableSortAndAgg,omitempty" json:"enableSortAndAgg,omitempty"`
+	Store            *bool        `yaml:"store,omitempty" json:"store,omitempty"`
+	IsArray          *bool        `yaml:"isArray,omitempty" json:"isArray,omitempty"`
+	DateFormats      []string     `yaml:"dateFormats,omitempty" json:"dateFormats,omitempty"`
+	Fields           []*Field     `yaml:"fields,omitempty" json:"fields,omitempty"`
+	Vector           *VectorField `yaml:"vector,omitempty" json:"vector,omitempty"`
+}
+
+// VectorField configures a VECTOR field. Metric is euclidean, cosine or
+// dot_product.
+type VectorField struct {
+	Dimension int32  `yaml:"dimension" json:"dimension"`
+	Metric    string `yaml:"metric" json:"metric"`
+}
+
+// Parse decodes a YAML (or JSON) document into a Schema and validates it.
+func Parse(data []byte) (*Schema, error) {
+	s := new(Schema)
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadFile reads and parses a schema file.
+func LoadFile(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Validate checks the schema for mistakes that can be found without talking
+// to the service.
+func (s *Schema) Validate() error {
+	tables := make(map[string]bool)
+	for _, table := range s.Tables {
+		if table == nil {
+			return invalidf("nil table")
+		}
+		if tables[table.Name] {
+			return invalidf("duplicate table %q", table.Name)
+		}
+		tables[table.Name] = true
+		if err := table.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Table) validate() error {
+	if t.Name == "" {
+		return invalidf("table without name")
+	}
+	if len(t.PrimaryKeys) == 0 || len(t.PrimaryKeys) > 4 {
+		return invalidf("table %q must have 1 to 4 primary keys", t.Name)
+	}
+
+	columns := make(map[string]bool)
+	for i, pk := range t.PrimaryKeys {
+		if pk.Name == "" || columns[pk.Name] {
+			return invalidf("table %q: empty or duplicate primary key %q", t.Name, pk.Name)
+		}
+		columns[pk.Name] = true
+		pkType, err := primaryKeyType(pk.Type)
+		if err != nil {
+			return invalidf("table %q: %v", t.Name, err)
+		}
+		if pk.AutoIncrement && (i == 0 || pkType != tablestore.PrimaryKeyType_INTEGER) {
+			return invalidf("table %q: auto increment primary key %q must be a non-partition INTEGER key", t.Name, pk.Name)
+		}
+	}
+	for _, col := range t.DefinedColumns {
+		if col.Name == "" || columns[col.Name] {
+			return invalidf("table %q: empty or duplicate column %q", t.Name, col.Name)
+		}
+		columns[col.Name] = true
+		if _, err := definedColumnType(col.Type); err != nil {
+			return invalidf("table %q: %v", t.Name, err)
+		}
+	}
+
+	if t.Stream != nil && t.Stream.Enabled && t.Stream.ExpirationHours <= 0 {
+		return invalidf("table %q: stream expirationHours must be positive", t.Name)
+	}
+	if t.SSE != nil {
+		if _, err := t.SSE.specification(); err != nil {
+			return invalidf("table %q: %v", t.Name, err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, index := range t.Indexes {
+		if index.Name == "" || names[index.Name] {
+			return invalidf("table %q: empty or duplicate index %q", t.Name, index.Name)
+		}
+		names[index.Name] = true
+		if _, err := indexType(index.Type); err != nil {
+			return invalidf("index %q: %v", index.Name, err)
+		}
+		if len(index.PrimaryKeys) == 0 {
+			return invalidf("index %q has no primary key", index.Name)
+		}
+		for _, name := range append(append([]string(nil), index.PrimaryKeys...), index.DefinedColumns...) {
+			if !columns[name] {
+				return invalidf("index %q: unknown column %q", index.Name, name)
+			}
+		}
+	}
+
+	names = make(map[string]bool)
+	for _, index := range t.SearchIndexes {
+		if index.Name == "" || names[index.Name] {
+			return invalidf("table %q: empty or duplicate search index %q", t.Name, index.Name)
+		}
+		names[index.Name] = true
+		if len(index.Fields) == 0 {
+			return invalidf("search index %q has no field", index.Name)
+		}
+		if _, err := index.IndexSchema(); err != nil {
+			return invalidf("search index %q: %v", index.Name, err)
+		}
+	}
+	return nil
+}
+
+// IndexSchema converts the search index description to the schema sent in
+// CreateSearchIndex.
+func (s *SearchIndex) IndexSchema() (*tablestore.IndexSchema, error) {
+	fields, err := fieldSchemas(s.Fields)
+	if err != nil {
+		return nil, err
+	}
+	schema := &tablestore.IndexSchema{FieldSchemas: fields}
+	if len(s.RoutingFields) > 0 {
+		schema.IndexSetting = &tablestore.IndexSetting{RoutingFields: s.RoutingFields}
+	}
+	return schema, nil
+}
+
+func fieldSchemas(fields []*Field) ([]*tablestore.FieldSchema, error) {
+	result := make([]*tablestore.FieldSchema, 0, len(fields))
+	for _, field := range fields {
+		if field.Name == "" {
+			return nil, errors.New("field without name")
+		}
+		fieldType, err := tablestore.ToFieldType(field.Type)
+		if err != nil {
+			return nil, err
+		}
+		fs := &tablestore.FieldSchema{
+			FieldName:        &field.Name,
+			FieldType:        fieldType,
+			Index:            field.Index,
+			EnableSortAndAgg: field.EnableSortAndAgg,
+			Store:            field.Store,
+			IsArray:          field.IsArray,
+			DateFormats:      field.DateFormats,
+		}
+		if field.Analyzer != "" {
+			analyzer := tablestore.Analyzer(field.Analyzer)
+			fs.Analyzer = &analyzer
+		}
+		if fieldType == tablestore.FieldType_NESTED {
+			if len(field.Fields) == 0 {
+				return nil, fmt.Errorf("nested field %q has no children", field.Name)
+			}
+			if fs.FieldSchemas, err = fieldSchemas(field.Fields); err != nil {
+				return nil, err
+			}
+		}
+		if fieldType == tablestore.FieldType_VECTOR {
+			if field.Vector == nil || field.Vector.Dimension <= 0 {
+				return nil, fmt.Errorf("vector field %q needs a positive dimension", field.Name)
+			}
+			metric := tablestore.VectorMetricType(strings.ToLower(field.Vector.Metric))
+			switch metric {
+			case tablestore.VectorMetricType_EUCLIDEAN, tablestore.VectorMetricType_COSINE, tablestore.VectorMetricType_DOT_PRODUCT:
+			default:
+				return nil, fmt.Errorf("vector field %q: invalid metric %q", field.Name, field.Vector.Metric)
+			}
+			fs.VectorOptions = &tablestore.VectorOptions{
+				VectorDataType:   tablestore.VectorDataType_FLOAT_32.Enum(),
+				VectorMetricType: metric.Enum(),
+				Dimension:        &field.Vector.Dimension,
+			}
+		}
+		result = append(result, fs)
+	}
+	return result, nil
+}
+
+func primaryKeyType(name string) (tablestore.PrimaryKeyType, error) {
+	switch strings.ToUpper(name) {
+	case "INTEGER":
+		return tablestore.PrimaryKeyType_INTEGER, nil
+	case "STRING":
+		return tablestore.PrimaryKeyType_STRING, nil
+	case "BINARY":
+		return tablestore.PrimaryKeyType_BINARY, nil
+	default:
+		return 0, fmt.Errorf("invalid primary key type %q", name)
+	}
+}
+
+func definedColumnType(name string) (tablestore.DefinedColumnType, error) {
+	switch strings.ToUpper(name) {
+	case "INTEGER":
+		return tablestore.DefinedColumn_INTEGER, nil
+	case "DOUBLE":
+		return tablestore.DefinedColumn_DOUBLE, nil
+	case "BOOLEAN":
+		return tablestore.DefinedColumn_BOOLEAN, nil
+	case "STRING":
+		return tablestore.DefinedColumn_STRING, nil
+	case "BINARY":
+		return tablestore.DefinedColumn_BINARY, nil
+	default:
+		return 0, fmt.Errorf("invalid defined column type %q", name)
+	}
+}
+
+func indexType(name string) (tablestore.IndexType, error) {
+	switch strings.ToUpper(name) {
+	case "", "GLOBAL":
+		return tablestore.IT_GLOBAL_INDEX, nil
+	case "LOCAL":
+		return tablestore.IT_LOCAL_INDEX, nil
+	default:
+		return 0, fmt.Errorf("invalid index type %q", name)
+	}
+}
+
+func (s *SSE) specification() (*tablestore.SSESpecification, error) {
+	spec := &tablestore.SSESpecification{Enable: s.Enabled}
+	if s.Enabled {
+		var keyType tablestore.SSEKeyType
+		switch strings.ToUpper(s.KeyType) {
+		case "", "KMS_SERVICE", "SSE_KMS_SERVICE":
+			keyType = tablestore.SSE_KMS_SERVICE
+		case "BYOK", "SSE_BYOK":
+			keyType = tablestore.SSE_BYOK
+		default:
+			return nil, fmt.Errorf("invalid sse key type %q", s.KeyType)
+		}
+		spec.KeyType = &keyType
+		if s.KeyId != "" {
+			spec.KeyId = &s.KeyId
+		}
+		if s.RoleArn != "" {
+			spec.RoleArn = &s.RoleArn
+		}
+	}
+	if err := spec.CheckArguments(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSchema, fmt.Sprintf(format, args...))
+}
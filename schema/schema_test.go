@@ -0,0 +1,283 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ Client = (*tablestore.TableStoreClient)(nil)
+
+const testSchema = `
+tables:
+  - name: orders
+    primaryKeys:
+      - {name: user_id, type: STRING}
+      - {name: order_id, type: INTEGER, autoIncrement: true}
+    definedColumns:
+      - {name: status, type: STRING}
+      - {name: amount, type: DOUBLE}
+    timeToLive: 86400
+    maxVersions: 2
+    reservedThroughput: {read: 1, write: 2}
+    stream: {enabled: true, expirationHours: 24}
+    indexes:
+      - {name: orders_by_status, primaryKeys: [status, user_id, order_id], definedColumns: [amount]}
+      - {name: orders_local, type: LOCAL, primaryKeys: [user_id, status, order_id]}
+    searchIndexes:
+      - name: orders_search
+        routingFields: [user_id]
+        fields:
+          - {name: status, type: KEYWORD}
+          - {name: amount, type: DOUBLE, enableSortAndAgg: true}
+          - name: items
+            type: NESTED
+            fields:
+              - {name: sku, type: KEYWORD}
+`
+
+type fakeClient struct {
+	tables        map[string]*tablestore.DescribeTableResponse
+	searchIndexes map[string]*tablestore.DescribeSearchIndexResponse
+	calls         []string
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		tables:        make(map[string]*tablestore.DescribeTableResponse),
+		searchIndexes: make(map[string]*tablestore.DescribeSearchIndexResponse),
+	}
+}
+
+func (c *fakeClient) ListTable() (*tablestore.ListTableResponse, error) {
+	resp := new(tablestore.ListTableResponse)
+	for name := range c.tables {
+		resp.TableNames = append(resp.TableNames, name)
+	}
+	return resp, nil
+}
+
+func (c *fakeClient) DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error) {
+	table, ok := c.tables[request.TableName]
+	if !ok {
+		return nil, &tablestore.OtsError{Code: "OTSObjectNotExist"}
+	}
+	return table, nil
+}
+
+func (c *fakeClient) CreateTable(request *tablestore.CreateTableRequest) (*tablestore.CreateTableResponse, error) {
+	c.calls = append(c.calls, "CreateTable "+request.TableMeta.TableName)
+	syncPhase := tablestore.SyncPhase_INCR
+	for _, meta := range request.IndexMetas {
+		meta.IndexSyncPhase = &syncPhase
+	}
+	streamDetails := &tablestore.StreamDetails{}
+	if request.StreamSpec != nil {
+		streamDetails.EnableStream = request.StreamSpec.EnableStream
+		streamDetails.ExpirationTime = request.StreamSpec.ExpirationTime
+	}
+	c.tables[request.TableMeta.TableName] = &tablestore.DescribeTableResponse{
+		TableMeta:          request.TableMeta,
+		TableOption:        request.TableOption,
+		ReservedThroughput: request.ReservedThroughput,
+		StreamDetails:      streamDetails,
+		IndexMetas:         request.IndexMetas,
+		SSEDetails:         &tablestore.SSEDetails{},
+	}
+	return &tablestore.CreateTableResponse{}, nil
+}
+
+func (c *fakeClient) UpdateTable(request *tablestore.UpdateTableRequest) (*tablestore.UpdateTableResponse, error) {
+	c.calls = append(c.calls, "UpdateTable "+request.TableName)
+	table := c.tables[request.TableName]
+	if request.TableOption != nil {
+		table.TableOption = request.TableOption
+	}
+	if request.ReservedThroughput != nil {
+		table.ReservedThroughput = request.ReservedThroughput
+	}
+	return &tablestore.UpdateTableResponse{}, nil
+}
+
+func (c *fakeClient) AddDefinedColumn(request *tablestore.AddDefinedColumnRequest) (*tablestore.AddDefinedColumnResponse, error) {
+	c.calls = append(c.calls, "AddDefinedColumn "+request.TableName)
+	meta := c.tables[request.TableName].TableMeta
+	meta.DefinedColumns = append(meta.DefinedColumns, request.DefinedColumns...)
+	return &tablestore.AddDefinedColumnResponse{}, nil
+}
+
+func (c *fakeClient) CreateIndex(request *tablestore.CreateIndexRequest) (*tablestore.CreateIndexResponse, error) {
+	c.calls = append(c.calls, "CreateIndex "+request.IndexMeta.IndexName)
+	table := c.tables[request.MainTableName]
+	syncPhase := tablestore.SyncPhase_FULL
+	request.IndexMeta.IndexSyncPhase = &syncPhase
+	table.IndexMetas = append(table.IndexMetas, request.IndexMeta)
+	return &tablestore.CreateIndexResponse{}, nil
+}
+
+func (c *fakeClient) ListSearchIndex(request *tablestore.ListSearchIndexRequest) (*tablestore.ListSearchIndexResponse, error) {
+	resp := new(tablestore.ListSearchIndexResponse)
+	for key := range c.searchIndexes {
+		parts := strings.SplitN(key, "/", 2)
+		if parts[0] == request.TableName {
+			resp.IndexInfo = append(resp.IndexInfo, &tablestore.IndexInfo{TableName: parts[0], IndexName: parts[1]})
+		}
+	}
+	return resp, nil
+}
+
+func (c *fakeClient) DescribeSearchIndex(request *tablestore.DescribeSearchIndexRequest) (*tablestore.DescribeSearchIndexResponse, error) {
+	resp, ok := c.searchIndexes[request.TableName+"/"+request.IndexName]
+	if !ok {
+		return nil, &tablestore.OtsError{Code: "OTSObjectNotExist"}
+	}
+	return resp, nil
+}
+
+func (c *fakeClient) CreateSearchIndex(request *tablestore.CreateSearchIndexRequest) (*tablestore.CreateSearchIndexResponse, error) {
+	c.calls = append(c.calls, "CreateSearchIndex "+request.IndexName)
+	c.searchIndexes[request.TableName+"/"+request.IndexName] = &tablestore.DescribeSearchIndexResponse{
+		Schema:   request.IndexSchema,
+		SyncStat: &tablestore.SyncStat{SyncPhase: tablestore.SyncPhase_INCR},
+	}
+	return &tablestore.CreateSearchIndexResponse{}, nil
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(s.Tables))
+	table := s.Tables[0]
+	assert.Equal(t, "orders", table.Name)
+	assert.True(t, table.PrimaryKeys[1].AutoIncrement)
+	assert.Equal(t, 86400, *table.TimeToLive)
+	assert.Equal(t, int32(24), table.Stream.ExpirationHours)
+	assert.Equal(t, "LOCAL", table.Indexes[1].Type)
+
+	indexSchema, err := table.SearchIndexes[0].IndexSchema()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"user_id"}, indexSchema.IndexSetting.RoutingFields)
+	assert.Equal(t, tablestore.FieldType_NESTED, indexSchema.FieldSchemas[2].FieldType)
+	assert.Equal(t, "sku", *indexSchema.FieldSchemas[2].FieldSchemas[0].FieldName)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	cases := []string{
+		`tables: [{name: t, primaryKeys: []}]`,
+		`tables: [{name: t, primaryKeys: [{name: a, type: FLOAT}]}]`,
+		`tables: [{name: t, primaryKeys: [{name: a, type: INTEGER, autoIncrement: true}]}]`,
+		`tables: [{name: t, primaryKeys: [{name: a, type: STRING}]}, {name: t, primaryKeys: [{name: a, type: STRING}]}]`,
+		`tables: [{name: t, primaryKeys: [{name: a, type: STRING}], indexes: [{name: i, primaryKeys: [b]}]}]`,
+		`tables: [{name: t, primaryKeys: [{name: a, type: STRING}], searchIndexes: [{name: s, fields: [{name: n, type: NESTED}]}]}]`,
+		`tables: [{name: t, primaryKeys: [{name: a, type: STRING}], searchIndexes: [{name: s, fields: [{name: v, type: VECTOR, vector: {dimension: 4, metric: manhattan}}]}]}]`,
+		`tables: [{name: t, primaryKeys: [{name: a, type: STRING}], sse: {enabled: true, keyType: BYOK}}]`,
+	}
+	for _, c := range cases {
+		_, err := Parse([]byte(c))
+		assert.True(t, errors.Is(err, ErrInvalidSchema), c)
+	}
+}
+
+func TestPlan_CreateTable(t *testing.T) {
+	s, _ := Parse([]byte(testSchema))
+	client := newFakeClient()
+
+	cs, err := Plan(context.Background(), client, s)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(cs.Warnings))
+	assert.Equal(t, 2, len(cs.Changes))
+	assert.Equal(t, CreateTable, cs.Changes[0].Type)
+	assert.Equal(t, CreateSearchIndex, cs.Changes[1].Type)
+
+	request := cs.Changes[0].CreateTableRequest
+	assert.Equal(t, 2, len(request.TableMeta.SchemaEntry))
+	assert.Equal(t, tablestore.AUTO_INCREMENT, *request.TableMeta.SchemaEntry[1].Option)
+	assert.Equal(t, 86400, request.TableOption.TimeToAlive)
+	assert.Equal(t, 2, request.TableOption.MaxVersion)
+	assert.Equal(t, 2, request.ReservedThroughput.Writecap)
+	assert.True(t, request.StreamSpec.EnableStream)
+	assert.Equal(t, tablestore.IT_LOCAL_INDEX, request.IndexMetas[1].IndexType)
+
+	assert.Nil(t, Apply(context.Background(), client, cs, &ApplyOptions{Wait: true, PollInterval: time.Millisecond}))
+	assert.Equal(t, []string{"CreateTable orders", "CreateSearchIndex orders_search"}, client.calls)
+
+	cs, err = Plan(context.Background(), client, s)
+	assert.Nil(t, err)
+	assert.True(t, cs.Empty(), cs.String())
+	assert.Equal(t, 0, len(cs.Warnings), cs.String())
+}
+
+func TestPlan_UpdateTable(t *testing.T) {
+	s, _ := Parse([]byte(testSchema))
+	client := newFakeClient()
+	cs, _ := Plan(context.Background(), client, s)
+	assert.Nil(t, Apply(context.Background(), client, cs, nil))
+	client.calls = nil
+
+	// the instance drifted from the schema
+	current := client.tables["orders"]
+	current.TableOption.TimeToAlive = -1
+	current.TableMeta.DefinedColumns = current.TableMeta.DefinedColumns[:1]
+	current.IndexMetas = current.IndexMetas[1:]
+	delete(client.searchIndexes, "orders/orders_search")
+
+	// and the schema asks for things that cannot be changed in place
+	s.Tables[0].PrimaryKeys[0].Type = "BINARY"
+	s.Tables[0].DefinedColumns[0].Type = "INTEGER"
+
+	cs, err := Plan(context.Background(), client, s)
+	assert.Nil(t, err)
+	var types []ChangeType
+	for _, change := range cs.Changes {
+		types = append(types, change.Type)
+	}
+	assert.Equal(t, []ChangeType{UpdateTable, AddDefinedColumn, CreateIndex, CreateSearchIndex}, types)
+	assert.Equal(t, "timeToLive -1 -> 86400", cs.Changes[0].Description)
+	assert.Equal(t, 2, cs.Changes[0].UpdateTableRequest.TableOption.MaxVersion)
+	assert.Nil(t, cs.Changes[0].UpdateTableRequest.ReservedThroughput)
+	assert.Equal(t, "amount", cs.Changes[1].AddDefinedColumnRequest.DefinedColumns[0].Name)
+	assert.True(t, cs.Changes[2].CreateIndexRequest.IncludeBaseData)
+	assert.Equal(t, 2, len(cs.Warnings))
+	assert.Contains(t, cs.Warnings[0], "primary key")
+	assert.Contains(t, cs.Warnings[1], "defined column status is STRING, want INTEGER")
+}
+
+func TestPlan_SearchIndexDrift(t *testing.T) {
+	s, _ := Parse([]byte(testSchema))
+	client := newFakeClient()
+	cs, _ := Plan(context.Background(), client, s)
+	assert.Nil(t, Apply(context.Background(), client, cs, nil))
+
+	s.Tables[0].SearchIndexes[0].Fields[0].Type = "TEXT"
+	s.Tables[0].SearchIndexes[0].Fields[2].Fields = append(s.Tables[0].SearchIndexes[0].Fields[2].Fields, &Field{Name: "qty", Type: "LONG"})
+	cs, err := Plan(context.Background(), client, s)
+	assert.Nil(t, err)
+	assert.True(t, cs.Empty())
+	assert.Equal(t, []string{
+		"table orders: search index orders_search: field status is KEYWORD, want TEXT",
+		"table orders: search index orders_search: field items.qty is missing",
+	}, cs.Warnings)
+}
+
+func TestApply_WaitIndexTimeout(t *testing.T) {
+	s, _ := Parse([]byte(testSchema))
+	client := newFakeClient()
+	cs, _ := Plan(context.Background(), client, s)
+	assert.Nil(t, Apply(context.Background(), client, cs, nil))
+	current := client.tables["orders"]
+	current.IndexMetas = current.IndexMetas[1:]
+
+	// the fake never finishes the full sync of indexes created by CreateIndex
+	cs, _ = Plan(context.Background(), client, s)
+	err := Apply(context.Background(), client, cs, &ApplyOptions{Wait: true, PollInterval: time.Millisecond, Timeout: 20 * time.Millisecond})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, Apply(ctx, client, cs, nil))
+}
@@ -0,0 +1,191 @@
+package snapshot
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// csvNull stands for a column without a cell, so that it can be told apart
+// from an empty string.
+const csvNull = `\N`
+
+// CSV header cells are name:TYPE, for example
+//
+//	user_id:STRING,order_id:INTEGER,_timestamp,status:STRING,amount:DOUBLE
+//
+// INTEGER and DOUBLE values are decimal, BOOLEAN values are true or false
+// and BINARY values are base64.
+type csvWriter struct {
+	w      *csv.Writer
+	schema *flatSchema
+	fields []string
+}
+
+func newCSVWriter(w io.Writer, primaryKeys, columns []*Column) (*csvWriter, error) {
+	schema, err := newFlatSchema(primaryKeys, columns)
+	if err != nil {
+		return nil, err
+	}
+	var header []string
+	for _, column := range primaryKeys {
+		header = append(header, column.Name+":"+typeName(column.Type))
+	}
+	header = append(header, TimestampColumn)
+	for _, column := range columns {
+		header = append(header, column.Name+":"+typeName(column.Type))
+	}
+	cw := &csvWriter{w: csv.NewWriter(w), schema: schema, fields: make([]string, len(header))}
+	if err := cw.w.Write(header); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (w *csvWriter) writeRow(row *tablestore.Row) error {
+	records, err := w.schema.records(row)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		fields := w.fields[:0]
+		for _, value := range record.primaryKey {
+			fields = append(fields, formatCSV(value))
+		}
+		fields = append(fields, strconv.FormatInt(record.timestamp, 10))
+		for _, value := range record.columns {
+			fields = append(fields, formatCSV(value))
+		}
+		if err := w.w.Write(fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *csvWriter) close() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func formatCSV(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return csvNull
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func parseCSV(field string, t tablestore.DefinedColumnType) (interface{}, error) {
+	if field == csvNull {
+		return nil, nil
+	}
+	switch t {
+	case tablestore.DefinedColumn_INTEGER:
+		return strconv.ParseInt(field, 10, 64)
+	case tablestore.DefinedColumn_DOUBLE:
+		return strconv.ParseFloat(field, 64)
+	case tablestore.DefinedColumn_BOOLEAN:
+		return strconv.ParseBool(field)
+	case tablestore.DefinedColumn_STRING:
+		return field, nil
+	default:
+		return base64.StdEncoding.DecodeString(field)
+	}
+}
+
+type csvSource struct {
+	r      *csv.Reader
+	schema *flatSchema
+	line   int
+}
+
+func newCSVReader(r io.Reader) (*flatReader, error) {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing csv header", ErrInvalidFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	var primaryKeys, columns []*Column
+	seenTimestamp := false
+	for _, cell := range header {
+		if cell == TimestampColumn {
+			seenTimestamp = true
+			continue
+		}
+		parts := strings.SplitN(cell, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%w: csv header %q is not name:TYPE", ErrInvalidFile, cell)
+		}
+		t, err := ParseType(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		column := &Column{Name: parts[0], Type: t}
+		if seenTimestamp {
+			columns = append(columns, column)
+		} else {
+			primaryKeys = append(primaryKeys, column)
+		}
+	}
+	if !seenTimestamp || len(primaryKeys) == 0 {
+		return nil, fmt.Errorf("%w: csv header needs primary key columns followed by %s", ErrInvalidFile, TimestampColumn)
+	}
+	schema, err := newFlatSchema(primaryKeys, columns)
+	if err != nil {
+		return nil, err
+	}
+	return &flatReader{schema: schema, source: &csvSource{r: cr, schema: schema, line: 1}}, nil
+}
+
+func (s *csvSource) readRecord() (*flatRecord, error) {
+	fields, err := s.r.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	s.line++
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+
+	pkCount := len(s.schema.primaryKeys)
+	record := &flatRecord{columns: make([]interface{}, len(s.schema.columns))}
+	for i, column := range s.schema.primaryKeys {
+		value, err := parseCSV(fields[i], column.Type)
+		if err != nil || value == nil {
+			return nil, fmt.Errorf("%w: line %d: invalid primary key %s %q", ErrInvalidValue, s.line, column.Name, fields[i])
+		}
+		record.primaryKey = append(record.primaryKey, value)
+	}
+	if record.timestamp, err = strconv.ParseInt(fields[pkCount], 10, 64); err != nil {
+		return nil, fmt.Errorf("%w: line %d: invalid timestamp %q", ErrInvalidValue, s.line, fields[pkCount])
+	}
+	for i, column := range s.schema.columns {
+		field := fields[pkCount+1+i]
+		if record.columns[i], err = parseCSV(field, column.Type); err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid %s %s %q", ErrInvalidValue, s.line, typeName(column.Type), column.Name, field)
+		}
+	}
+	return record, nil
+}
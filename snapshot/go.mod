@@ -0,0 +1,12 @@
+module github.com/aliyun/aliyun-tablestore-go-sdk/snapshot
+
+go 1.13
+
+require (
+	github.com/aliyun/aliyun-tablestore-go-sdk v0.0.0
+	github.com/stretchr/testify v1.7.0
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
+)
+
+replace github.com/aliyun/aliyun-tablestore-go-sdk => ../
@@ -0,0 +1,193 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// jsonRow is one line of a JSON Lines file:
+//
+//	{"primaryKey":[{"name":"id","type":"INTEGER","value":1}],
+//	 "columns":[{"name":"title","type":"STRING","value":"a","timestamp":1600000000000}]}
+//
+// BINARY values are base64 strings and non-finite DOUBLE values are the
+// strings "NaN", "+Inf" and "-Inf". A missing timestamp lets the service
+// assign one on import.
+type jsonRow struct {
+	PrimaryKey []*jsonCell `json:"primaryKey"`
+	Columns    []*jsonCell `json:"columns,omitempty"`
+}
+
+type jsonCell struct {
+	Name      string          `json:"name"`
+	Type      string          `json:"type"`
+	Value     json.RawMessage `json:"value"`
+	Timestamp int64           `json:"timestamp,omitempty"`
+}
+
+type jsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	return &jsonWriter{w: bw, enc: enc}
+}
+
+func (w *jsonWriter) writeRow(row *tablestore.Row) error {
+	line := new(jsonRow)
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		cell, err := newJSONCell(col.ColumnName, col.Value, 0)
+		if err != nil {
+			return err
+		}
+		line.PrimaryKey = append(line.PrimaryKey, cell)
+	}
+	for _, col := range row.Columns {
+		cell, err := newJSONCell(col.ColumnName, col.Value, col.Timestamp)
+		if err != nil {
+			return err
+		}
+		line.Columns = append(line.Columns, cell)
+	}
+	return w.enc.Encode(line)
+}
+
+func (w *jsonWriter) close() error {
+	return w.w.Flush()
+}
+
+func newJSONCell(name string, value interface{}, timestamp int64) (*jsonCell, error) {
+	t, ok := valueType(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: column %s has unsupported type %T", ErrInvalidValue, name, value)
+	}
+	var raw []byte
+	var err error
+	switch v := value.(type) {
+	case []byte:
+		raw, err = json.Marshal(base64.StdEncoding.EncodeToString(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			raw, err = json.Marshal(strconv.FormatFloat(v, 'g', -1, 64))
+		} else {
+			raw, err = json.Marshal(v)
+		}
+	default:
+		raw, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &jsonCell{Name: name, Type: typeName(t), Value: raw, Timestamp: timestamp}, nil
+}
+
+func (c *jsonCell) value() (interface{}, error) {
+	t, err := ParseType(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case tablestore.DefinedColumn_INTEGER:
+		var n json.Number
+		if err := json.Unmarshal(c.Value, &n); err != nil {
+			return nil, c.invalid(err)
+		}
+		v, err := strconv.ParseInt(string(n), 10, 64)
+		if err != nil {
+			return nil, c.invalid(err)
+		}
+		return v, nil
+	case tablestore.DefinedColumn_DOUBLE:
+		var v float64
+		if err := json.Unmarshal(c.Value, &v); err == nil {
+			return v, nil
+		}
+		var s string
+		if err := json.Unmarshal(c.Value, &s); err != nil {
+			return nil, c.invalid(err)
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, c.invalid(err)
+		}
+		return v, nil
+	case tablestore.DefinedColumn_BOOLEAN:
+		var v bool
+		if err := json.Unmarshal(c.Value, &v); err != nil {
+			return nil, c.invalid(err)
+		}
+		return v, nil
+	case tablestore.DefinedColumn_STRING:
+		var v string
+		if err := json.Unmarshal(c.Value, &v); err != nil {
+			return nil, c.invalid(err)
+		}
+		return v, nil
+	default:
+		var s string
+		if err := json.Unmarshal(c.Value, &s); err != nil {
+			return nil, c.invalid(err)
+		}
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, c.invalid(err)
+		}
+		return v, nil
+	}
+}
+
+func (c *jsonCell) invalid(err error) error {
+	return fmt.Errorf("%w: %s %s: %v", ErrInvalidValue, c.Type, c.Name, err)
+}
+
+type jsonReader struct {
+	dec  *json.Decoder
+	line int
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	return &jsonReader{dec: json.NewDecoder(bufio.NewReader(r))}
+}
+
+func (r *jsonReader) readRow() (*tablestore.Row, error) {
+	line := new(jsonRow)
+	if err := r.dec.Decode(line); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: row %d: %v", ErrInvalidFile, r.line+1, err)
+	}
+	r.line++
+	if len(line.PrimaryKey) == 0 {
+		return nil, fmt.Errorf("%w: row %d has no primary key", ErrInvalidFile, r.line)
+	}
+
+	pk := new(tablestore.PrimaryKey)
+	for _, cell := range line.PrimaryKey {
+		value, err := cell.value()
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", r.line, err)
+		}
+		pk.AddPrimaryKeyColumn(cell.Name, value)
+	}
+	row := &tablestore.Row{PrimaryKey: pk}
+	for _, cell := range line.Columns {
+		value, err := cell.value()
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", r.line, err)
+		}
+		row.Columns = append(row.Columns, &tablestore.AttributeColumn{ColumnName: cell.Name, Value: value, Timestamp: cell.Timestamp})
+	}
+	return row, nil
+}
@@ -0,0 +1,343 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// parquetReadBatch is the number of rows read from each column at a time.
+const parquetReadBatch = 1024
+
+type parquetWriter struct {
+	w      *writer.CSVWriter
+	schema *flatSchema
+	// int32Columns are the columns stored as INT32, indexed like a record
+	int32Columns []bool
+}
+
+func newParquetWriter(w io.Writer, primaryKeys, columns []*Column) (*parquetWriter, error) {
+	schema, err := newFlatSchema(primaryKeys, columns)
+	if err != nil {
+		return nil, err
+	}
+	var metadata []string
+	var int32Columns []bool
+	for _, column := range primaryKeys {
+		md, int32Column, err := parquetMetadata(column, "REQUIRED")
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, md)
+		int32Columns = append(int32Columns, int32Column)
+	}
+	metadata = append(metadata, "name="+TimestampColumn+", type=INT64, repetitiontype=REQUIRED")
+	int32Columns = append(int32Columns, false)
+	for _, column := range columns {
+		md, int32Column, err := parquetMetadata(column, "OPTIONAL")
+		if err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, md)
+		int32Columns = append(int32Columns, int32Column)
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(metadata, w, 1)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+	}
+	return &parquetWriter{w: pw, schema: schema, int32Columns: int32Columns}, nil
+}
+
+// parquetMetadata maps a column to the schema of parquet-go, following the
+// ParquetDataType mapping of OSS delivery tasks.
+func parquetMetadata(column *Column, repetition string) (string, bool, error) {
+	parquetType := defaultParquetType(column.Type)
+	if column.ParquetType != nil {
+		parquetType = *column.ParquetType
+	}
+	if column.Type == tablestore.DefinedColumn_BINARY {
+		if column.ParquetType != nil {
+			return "", false, fmt.Errorf("%w: BINARY column %s has no parquet type mapping", ErrInvalidOptions, column.Name)
+		}
+		return fmt.Sprintf("name=%s, type=BYTE_ARRAY, repetitiontype=%s", column.Name, repetition), false, nil
+	}
+
+	var physical, converted string
+	int32Column := false
+	switch parquetType {
+	case tablestore.ParquetBool:
+		physical = "BOOLEAN"
+	case tablestore.ParquetInt64:
+		physical = "INT64"
+	case tablestore.ParquetUtf8:
+		physical, converted = "BYTE_ARRAY", "UTF8"
+	case tablestore.ParquetDouble:
+		physical = "DOUBLE"
+	case tablestore.ParquetDate:
+		physical, converted, int32Column = "INT32", "DATE", true
+	case tablestore.ParquetTimeMills:
+		physical, converted, int32Column = "INT32", "TIME_MILLIS", true
+	case tablestore.ParquetTimeMicros:
+		physical, converted = "INT64", "TIME_MICROS"
+	case tablestore.ParquetTimestampMills:
+		physical, converted = "INT64", "TIMESTAMP_MILLIS"
+	case tablestore.ParquetTimestampMicros:
+		physical, converted = "INT64", "TIMESTAMP_MICROS"
+	default:
+		return "", false, fmt.Errorf("%w: parquet type %d of column %s is not supported", ErrInvalidOptions, parquetType, column.Name)
+	}
+	// INTEGER columns may also be dates and times, other types have only
+	// their default mapping
+	integer := physical == "INT32" || physical == "INT64"
+	if parquetType != defaultParquetType(column.Type) && !(column.Type == tablestore.DefinedColumn_INTEGER && integer) {
+		return "", false, fmt.Errorf("%w: parquet type %d does not fit %s column %s", ErrInvalidOptions, parquetType, typeName(column.Type), column.Name)
+	}
+
+	md := fmt.Sprintf("name=%s, type=%s, repetitiontype=%s", column.Name, physical, repetition)
+	if converted != "" {
+		md += ", convertedtype=" + converted
+	}
+	return md, int32Column, nil
+}
+
+func defaultParquetType(t tablestore.DefinedColumnType) tablestore.ParquetDataType {
+	switch t {
+	case tablestore.DefinedColumn_DOUBLE:
+		return tablestore.ParquetDouble
+	case tablestore.DefinedColumn_BOOLEAN:
+		return tablestore.ParquetBool
+	case tablestore.DefinedColumn_STRING:
+		return tablestore.ParquetUtf8
+	default:
+		return tablestore.ParquetInt64
+	}
+}
+
+func (w *parquetWriter) writeRow(row *tablestore.Row) error {
+	records, err := w.schema.records(row)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		// the writer keeps the slice until the row group is flushed
+		values := make([]interface{}, 0, len(w.int32Columns))
+		values = append(values, record.primaryKey...)
+		values = append(values, record.timestamp)
+		values = append(values, record.columns...)
+		for i, value := range values {
+			switch v := value.(type) {
+			case []byte:
+				// parquet-go holds byte arrays as strings
+				values[i] = string(v)
+			case int64:
+				if w.int32Columns[i] {
+					values[i] = int32(v)
+				}
+			}
+		}
+		if err := w.w.Write(values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *parquetWriter) close() error {
+	return w.w.WriteStop()
+}
+
+type parquetSource struct {
+	file   source.ParquetFile
+	reader *reader.ParquetReader
+	schema *flatSchema
+	paths  []string
+	// binary marks the BYTE_ARRAY columns without UTF8 annotation
+	binary []bool
+
+	remaining int64
+	batch     [][]interface{}
+	next      int
+}
+
+func newParquetReader(r io.Reader) (*flatReader, error) {
+	var file source.ParquetFile
+	var err error
+	if f, ok := r.(*os.File); ok {
+		file, err = local.NewLocalFileReader(f.Name())
+	} else {
+		var data []byte
+		if data, err = ioutil.ReadAll(r); err == nil {
+			file = newMemoryFile(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pr, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+	}
+	s := &parquetSource{file: file, reader: pr, remaining: pr.GetNumRows()}
+
+	var primaryKeys, columns []*Column
+	seenTimestamp := false
+	handler := pr.SchemaHandler
+	for _, path := range handler.ValueColumns {
+		index := handler.MapIndex[path]
+		element := handler.SchemaElements[index]
+		name := handler.Infos[index].ExName
+		s.paths = append(s.paths, path)
+		if name == TimestampColumn {
+			seenTimestamp = true
+			s.binary = append(s.binary, false)
+			continue
+		}
+		column := &Column{Name: name}
+		isBinary := false
+		switch element.GetType() {
+		case parquet.Type_BOOLEAN:
+			column.Type = tablestore.DefinedColumn_BOOLEAN
+		case parquet.Type_INT32, parquet.Type_INT64:
+			column.Type = tablestore.DefinedColumn_INTEGER
+		case parquet.Type_FLOAT, parquet.Type_DOUBLE:
+			column.Type = tablestore.DefinedColumn_DOUBLE
+		case parquet.Type_BYTE_ARRAY:
+			if element.ConvertedType != nil && *element.ConvertedType == parquet.ConvertedType_UTF8 {
+				column.Type = tablestore.DefinedColumn_STRING
+			} else {
+				column.Type = tablestore.DefinedColumn_BINARY
+				isBinary = true
+			}
+		default:
+			s.Close()
+			return nil, fmt.Errorf("%w: parquet column %s of type %s is not supported", ErrInvalidFile, name, element.GetType())
+		}
+		s.binary = append(s.binary, isBinary)
+		if seenTimestamp {
+			columns = append(columns, column)
+		} else {
+			primaryKeys = append(primaryKeys, column)
+		}
+	}
+	if !seenTimestamp || len(primaryKeys) == 0 {
+		s.Close()
+		return nil, fmt.Errorf("%w: parquet schema needs primary key columns followed by %s", ErrInvalidFile, TimestampColumn)
+	}
+	if s.schema, err = newFlatSchema(primaryKeys, columns); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &flatReader{schema: s.schema, source: s}, nil
+}
+
+func (s *parquetSource) readRecord() (*flatRecord, error) {
+	if s.batch == nil || s.next >= len(s.batch[0]) {
+		if s.remaining == 0 {
+			s.Close()
+			return nil, io.EOF
+		}
+		n := s.remaining
+		if n > parquetReadBatch {
+			n = parquetReadBatch
+		}
+		s.batch = s.batch[:0]
+		for _, path := range s.paths {
+			values, _, _, err := s.reader.ReadColumnByPath(path, n)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidFile, err)
+			}
+			if int64(len(values)) != n {
+				return nil, fmt.Errorf("%w: column %s has %d values, want %d", ErrInvalidFile, path, len(values), n)
+			}
+			s.batch = append(s.batch, values)
+		}
+		s.remaining -= n
+		s.next = 0
+	}
+
+	pkCount := len(s.schema.primaryKeys)
+	record := &flatRecord{columns: make([]interface{}, len(s.schema.columns))}
+	for i, values := range s.batch {
+		value := s.value(i, values[s.next])
+		switch {
+		case i < pkCount:
+			if value == nil {
+				return nil, fmt.Errorf("%w: null primary key %s", ErrInvalidValue, s.schema.primaryKeys[i].Name)
+			}
+			record.primaryKey = append(record.primaryKey, value)
+		case i == pkCount:
+			ts, ok := value.(int64)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s is not INT64", ErrInvalidFile, TimestampColumn)
+			}
+			record.timestamp = ts
+		default:
+			record.columns[i-pkCount-1] = value
+		}
+	}
+	s.next++
+	return record, nil
+}
+
+func (s *parquetSource) value(column int, value interface{}) interface{} {
+	switch v := value.(type) {
+	case int32:
+		return int64(v)
+	case float32:
+		return float64(v)
+	case string:
+		if s.binary[column] {
+			return []byte(v)
+		}
+	}
+	return value
+}
+
+func (s *parquetSource) Close() error {
+	if s.reader == nil {
+		return nil
+	}
+	s.reader.ReadStop()
+	s.reader = nil
+	return s.file.Close()
+}
+
+// memoryFile lets parquet-go read a file held in memory. Open hands out
+// independent readers since every column is read from its own position.
+type memoryFile struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newMemoryFile(data []byte) *memoryFile {
+	return &memoryFile{Reader: bytes.NewReader(data), data: data}
+}
+
+func (f *memoryFile) Open(name string) (source.ParquetFile, error) {
+	return newMemoryFile(f.data), nil
+}
+
+func (f *memoryFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("memory file is read only")
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	return 0, errors.New("memory file is read only")
+}
+
+func (f *memoryFile) Close() error {
+	return nil
+}
@@ -0,0 +1,222 @@
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+func typeName(t tablestore.DefinedColumnType) string {
+	switch t {
+	case tablestore.DefinedColumn_INTEGER:
+		return "INTEGER"
+	case tablestore.DefinedColumn_DOUBLE:
+		return "DOUBLE"
+	case tablestore.DefinedColumn_BOOLEAN:
+		return "BOOLEAN"
+	case tablestore.DefinedColumn_STRING:
+		return "STRING"
+	case tablestore.DefinedColumn_BINARY:
+		return "BINARY"
+	default:
+		return fmt.Sprintf("DefinedColumnType(%d)", int(t))
+	}
+}
+
+// ParseType parses a column type name such as STRING or INTEGER.
+func ParseType(name string) (tablestore.DefinedColumnType, error) {
+	switch strings.ToUpper(name) {
+	case "INTEGER":
+		return tablestore.DefinedColumn_INTEGER, nil
+	case "DOUBLE":
+		return tablestore.DefinedColumn_DOUBLE, nil
+	case "BOOLEAN":
+		return tablestore.DefinedColumn_BOOLEAN, nil
+	case "STRING":
+		return tablestore.DefinedColumn_STRING, nil
+	case "BINARY":
+		return tablestore.DefinedColumn_BINARY, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown column type %q", ErrInvalidValue, name)
+	}
+}
+
+// valueType reports the column type of a value read from a table.
+func valueType(value interface{}) (tablestore.DefinedColumnType, bool) {
+	switch value.(type) {
+	case int64:
+		return tablestore.DefinedColumn_INTEGER, true
+	case float64:
+		return tablestore.DefinedColumn_DOUBLE, true
+	case bool:
+		return tablestore.DefinedColumn_BOOLEAN, true
+	case string:
+		return tablestore.DefinedColumn_STRING, true
+	case []byte:
+		return tablestore.DefinedColumn_BINARY, true
+	default:
+		return 0, false
+	}
+}
+
+// flatSchema is the layout of CSV and Parquet records: the primary key, the
+// timestamp, then the attribute columns.
+type flatSchema struct {
+	primaryKeys []*Column
+	columns     []*Column
+	index       map[string]int
+}
+
+func newFlatSchema(primaryKeys, columns []*Column) (*flatSchema, error) {
+	s := &flatSchema{primaryKeys: primaryKeys, columns: columns, index: make(map[string]int)}
+	for _, column := range primaryKeys {
+		if column.Type == tablestore.DefinedColumn_DOUBLE || column.Type == tablestore.DefinedColumn_BOOLEAN {
+			return nil, fmt.Errorf("%w: primary key %s can not be %s", ErrInvalidFile, column.Name, typeName(column.Type))
+		}
+	}
+	for i, column := range columns {
+		if column.Name == "" || column.Name == TimestampColumn {
+			return nil, fmt.Errorf("%w: invalid column name %q", ErrInvalidOptions, column.Name)
+		}
+		if _, ok := s.index[column.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate column %s", ErrInvalidOptions, column.Name)
+		}
+		s.index[column.Name] = i
+	}
+	return s, nil
+}
+
+// flatRecord is one record of a CSV or Parquet file. A nil value in columns
+// is a null.
+type flatRecord struct {
+	primaryKey []interface{}
+	timestamp  int64
+	columns    []interface{}
+}
+
+// records splits a row into one record per distinct timestamp, newest first.
+// A row without cells in the exported columns is a single record of nulls.
+func (s *flatSchema) records(row *tablestore.Row) ([]*flatRecord, error) {
+	var primaryKey []interface{}
+	for i, col := range row.PrimaryKey.PrimaryKeys {
+		if i >= len(s.primaryKeys) {
+			return nil, fmt.Errorf("%w: row has more primary key columns than the table", ErrInvalidValue)
+		}
+		if t, ok := valueType(col.Value); !ok || t != s.primaryKeys[i].Type {
+			return nil, fmt.Errorf("%w: primary key %s is %T, want %s", ErrInvalidValue, col.ColumnName, col.Value, typeName(s.primaryKeys[i].Type))
+		}
+		primaryKey = append(primaryKey, col.Value)
+	}
+
+	byTimestamp := make(map[int64]*flatRecord)
+	var records []*flatRecord
+	for _, col := range row.Columns {
+		i, ok := s.index[col.ColumnName]
+		if !ok {
+			continue
+		}
+		if t, ok := valueType(col.Value); !ok || t != s.columns[i].Type {
+			return nil, fmt.Errorf("%w: column %s is %T, want %s", ErrInvalidValue, col.ColumnName, col.Value, typeName(s.columns[i].Type))
+		}
+		record, ok := byTimestamp[col.Timestamp]
+		if !ok {
+			record = &flatRecord{primaryKey: primaryKey, timestamp: col.Timestamp, columns: make([]interface{}, len(s.columns))}
+			byTimestamp[col.Timestamp] = record
+			records = append(records, record)
+		}
+		record.columns[i] = col.Value
+	}
+	if len(records) == 0 {
+		return []*flatRecord{{primaryKey: primaryKey, columns: make([]interface{}, len(s.columns))}}, nil
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].timestamp > records[j].timestamp })
+	return records, nil
+}
+
+// recordSource yields the records of a file in order, io.EOF after the last.
+type recordSource interface {
+	readRecord() (*flatRecord, error)
+}
+
+// flatReader merges consecutive records with the same primary key into one
+// row, which is how records were split on export.
+type flatReader struct {
+	schema  *flatSchema
+	source  recordSource
+	pending *flatRecord
+}
+
+func (r *flatReader) readRow() (*tablestore.Row, error) {
+	record := r.pending
+	r.pending = nil
+	if record == nil {
+		var err error
+		if record, err = r.source.readRecord(); err != nil {
+			return nil, err
+		}
+	}
+
+	pk := new(tablestore.PrimaryKey)
+	for i, value := range record.primaryKey {
+		pk.AddPrimaryKeyColumn(r.schema.primaryKeys[i].Name, value)
+	}
+	row := &tablestore.Row{PrimaryKey: pk}
+	for {
+		for i, value := range record.columns {
+			if value != nil {
+				row.Columns = append(row.Columns, &tablestore.AttributeColumn{
+					ColumnName: r.schema.columns[i].Name,
+					Value:      value,
+					Timestamp:  record.timestamp,
+				})
+			}
+		}
+
+		next, err := r.source.readRecord()
+		if err == io.EOF {
+			// the row is complete and the next call reports io.EOF
+			r.source = errSource{err}
+			return row, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !samePrimaryKey(record.primaryKey, next.primaryKey) {
+			r.pending = next
+			return row, nil
+		}
+		record = next
+	}
+}
+
+type errSource struct {
+	err error
+}
+
+func (s errSource) readRecord() (*flatRecord, error) {
+	return nil, s.err
+}
+
+func samePrimaryKey(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		switch v := a[i].(type) {
+		case []byte:
+			w, ok := b[i].([]byte)
+			if !ok || !bytes.Equal(v, w) {
+				return false
+			}
+		default:
+			if a[i] != b[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
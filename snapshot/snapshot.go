@@ -0,0 +1,406 @@
+// Package snapshot exports a table, or a range of it, to a local file and
+// imports such a file back into a table.
+//
+// Three formats are supported:
+//
+//   - JSON Lines: one JSON object per row. It needs no schema and keeps every
+//     value type, timestamp and version, so it is the lossless choice.
+//   - CSV: a header of name:TYPE cells followed by one record per row and
+//     timestamp, see Column.
+//   - Parquet: the same records as CSV, with column types following the
+//     ParquetDataType mapping of OSS delivery tasks.
+//
+// CSV and Parquet files hold the primary key columns first, then a
+// _timestamp column, then the attribute columns. The cells of a row that
+// share a timestamp form one record, so a row written in one PutRow is one
+// record and older versions follow as further records. A null value means
+// the column has no cell at that timestamp.
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/internal/batchwrite"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var (
+	ErrInvalidOptions = errors.New("[snapshot] invalid options")
+	ErrInvalidFile    = errors.New("[snapshot] invalid file")
+	ErrInvalidValue   = errors.New("[snapshot] invalid value")
+)
+
+const (
+	// TimestampColumn separates the primary key from the attribute columns
+	// in CSV and Parquet files.
+	TimestampColumn = "_timestamp"
+
+	DefaultBatchSize  = 200
+	DefaultMaxRetries = 5
+)
+
+type Format int
+
+const (
+	JSONLines Format = iota
+	CSV
+	Parquet
+)
+
+func (f Format) String() string {
+	switch f {
+	case JSONLines:
+		return "jsonl"
+	case CSV:
+		return "csv"
+	case Parquet:
+		return "parquet"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// ParseFormat accepts jsonl, json, csv and parquet.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "jsonl", "json", "ndjson":
+		return JSONLines, nil
+	case "csv":
+		return CSV, nil
+	case "parquet":
+		return Parquet, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, name)
+	}
+}
+
+// FormatFromPath picks the format from the file extension.
+func FormatFromPath(path string) (Format, error) {
+	return ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// Column is an attribute column of a CSV or Parquet export. ParquetType
+// overrides the Parquet type of the column, it defaults to the mapping of
+// Type: INTEGER to ParquetInt64, DOUBLE to ParquetDouble, BOOLEAN to
+// ParquetBool and STRING to ParquetUtf8. BINARY columns are written as
+// plain byte arrays.
+type Column struct {
+	Name        string
+	Type        tablestore.DefinedColumnType
+	ParquetType *tablestore.ParquetDataType
+}
+
+// ColumnsFromTaskSchema reuses the schema of an OSS delivery task.
+// ParquetDecimal is not supported.
+func ColumnsFromTaskSchema(schema []*tablestore.TaskSchema) ([]*Column, error) {
+	var columns []*Column
+	for _, s := range schema {
+		parquetType := s.Type
+		column := &Column{Name: s.ColumnName, ParquetType: &parquetType}
+		switch s.Type {
+		case tablestore.ParquetBool:
+			column.Type = tablestore.DefinedColumn_BOOLEAN
+		case tablestore.ParquetUtf8:
+			column.Type = tablestore.DefinedColumn_STRING
+		case tablestore.ParquetDouble:
+			column.Type = tablestore.DefinedColumn_DOUBLE
+		case tablestore.ParquetInt64, tablestore.ParquetDate, tablestore.ParquetTimeMills, tablestore.ParquetTimeMicros,
+			tablestore.ParquetTimestampMills, tablestore.ParquetTimestampMicros:
+			column.Type = tablestore.DefinedColumn_INTEGER
+		default:
+			return nil, fmt.Errorf("%w: parquet type %d of column %s is not supported", ErrInvalidOptions, s.Type, s.ColumnName)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// ExportClient is the part of *tablestore.TableStoreClient used by Export.
+type ExportClient interface {
+	DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error)
+	GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error)
+}
+
+// ImportClient is the part of *tablestore.TableStoreClient used by Import.
+type ImportClient interface {
+	BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error)
+}
+
+type ExportOptions struct {
+	Table  string
+	Format Format
+	// Start and End bound the exported range, End is exclusive. They default
+	// to the whole table.
+	Start *tablestore.PrimaryKey
+	End   *tablestore.PrimaryKey
+	// Columns lists the attribute columns to export. It is required for CSV
+	// and Parquet, for JSON Lines it only limits the columns read.
+	Columns []*Column
+	// AllVersions exports every version instead of the latest one.
+	AllVersions bool
+}
+
+type ImportOptions struct {
+	Table  string
+	Format Format
+	// BatchSize is the number of rows in one BatchWriteRow, at most 200.
+	BatchSize int
+	// MaxRetries is how many times rows rejected by BatchWriteRow are
+	// written again before the import fails.
+	MaxRetries int
+}
+
+type Stats struct {
+	Rows     int64
+	Cells    int64
+	Duration time.Duration
+}
+
+func (s *Stats) String() string {
+	return fmt.Sprintf("%d rows, %d cells in %s", s.Rows, s.Cells, s.Duration)
+}
+
+// rowWriter and rowReader hide the file format from Export and Import.
+type rowWriter interface {
+	writeRow(row *tablestore.Row) error
+	close() error
+}
+
+type rowReader interface {
+	// readRow returns io.EOF after the last row.
+	readRow() (*tablestore.Row, error)
+}
+
+// Export reads the range with GetRange and writes it to w.
+func Export(ctx context.Context, client ExportClient, w io.Writer, options *ExportOptions) (*Stats, error) {
+	started := time.Now()
+	if options == nil || options.Table == "" {
+		return nil, fmt.Errorf("%w: table is required", ErrInvalidOptions)
+	}
+	if options.Format != JSONLines && len(options.Columns) == 0 {
+		return nil, fmt.Errorf("%w: %s export needs columns", ErrInvalidOptions, options.Format)
+	}
+
+	describe, err := client.DescribeTable(&tablestore.DescribeTableRequest{TableName: options.Table})
+	if err != nil {
+		return nil, err
+	}
+	var primaryKeys []*Column
+	start, end := options.Start, options.End
+	if start == nil {
+		start = new(tablestore.PrimaryKey)
+	}
+	if end == nil {
+		end = new(tablestore.PrimaryKey)
+	}
+	for _, schema := range describe.TableMeta.SchemaEntry {
+		column, err := primaryKeyColumn(schema)
+		if err != nil {
+			return nil, err
+		}
+		primaryKeys = append(primaryKeys, column)
+		if options.Start == nil {
+			start.AddPrimaryKeyColumnWithMinValue(column.Name)
+		}
+		if options.End == nil {
+			end.AddPrimaryKeyColumnWithMaxValue(column.Name)
+		}
+	}
+
+	var writer rowWriter
+	switch options.Format {
+	case JSONLines:
+		writer = newJSONWriter(w)
+	case CSV:
+		writer, err = newCSVWriter(w, primaryKeys, options.Columns)
+	case Parquet:
+		writer, err = newParquetWriter(w, primaryKeys, options.Columns)
+	default:
+		err = fmt.Errorf("%w: unknown format %d", ErrInvalidOptions, int(options.Format))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	criteria := &tablestore.RangeRowQueryCriteria{
+		TableName:       options.Table,
+		StartPrimaryKey: start,
+		EndPrimaryKey:   end,
+		MaxVersion:      1,
+		Direction:       tablestore.FORWARD,
+	}
+	if options.AllVersions {
+		criteria.MaxVersion = math.MaxInt32
+	}
+	for _, column := range options.Columns {
+		criteria.ColumnsToGet = append(criteria.ColumnsToGet, column.Name)
+	}
+
+	stats := new(Stats)
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		resp, err := client.GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: criteria})
+		if err != nil {
+			return stats, err
+		}
+		for _, row := range resp.Rows {
+			if err := writer.writeRow(row); err != nil {
+				return stats, err
+			}
+			stats.Rows++
+			stats.Cells += int64(len(row.Columns))
+		}
+		if resp.NextStartPrimaryKey == nil {
+			break
+		}
+		criteria.StartPrimaryKey = resp.NextStartPrimaryKey
+	}
+	if err := writer.close(); err != nil {
+		return stats, err
+	}
+	stats.Duration = time.Since(started)
+	return stats, nil
+}
+
+// Import reads rows from r and writes them to the table with BatchWriteRow.
+// Rows are put whole, replacing any row with the same primary key.
+func Import(ctx context.Context, client ImportClient, r io.Reader, options *ImportOptions) (*Stats, error) {
+	started := time.Now()
+	if options == nil || options.Table == "" {
+		return nil, fmt.Errorf("%w: table is required", ErrInvalidOptions)
+	}
+	batchSize := options.BatchSize
+	if batchSize <= 0 || batchSize > DefaultBatchSize {
+		batchSize = DefaultBatchSize
+	}
+	maxRetries := options.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = DefaultMaxRetries
+	}
+
+	var reader rowReader
+	var err error
+	switch options.Format {
+	case JSONLines:
+		reader = newJSONReader(r)
+	case CSV:
+		reader, err = newCSVReader(r)
+	case Parquet:
+		reader, err = newParquetReader(r)
+	default:
+		err = fmt.Errorf("%w: unknown format %d", ErrInvalidOptions, int(options.Format))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stats := new(Stats)
+	var batch []*tablestore.Row
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := writeRows(ctx, client, options.Table, batch, maxRetries); err != nil {
+			return err
+		}
+		for _, row := range batch {
+			stats.Rows++
+			stats.Cells += int64(len(row.Columns))
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		row, err := reader.readRow()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		batch = append(batch, row)
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return stats, err
+	}
+	stats.Duration = time.Since(started)
+	return stats, nil
+}
+
+// ExportFile exports to a new file at path.
+func ExportFile(ctx context.Context, client ExportClient, path string, options *ExportOptions) (*Stats, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := Export(ctx, client, f, options)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return stats, err
+}
+
+// ImportFile imports the file at path.
+func ImportFile(ctx context.Context, client ImportClient, path string, options *ImportOptions) (*Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Import(ctx, client, f, options)
+}
+
+// writeRows puts rows, writing the rows the service rejected again up to
+// maxRetries times.
+func writeRows(ctx context.Context, client ImportClient, table string, rows []*tablestore.Row, maxRetries int) error {
+	changes := make([]tablestore.RowChange, 0, len(rows))
+	for _, row := range rows {
+		change := &tablestore.PutRowChange{TableName: table, PrimaryKey: row.PrimaryKey}
+		for _, col := range row.Columns {
+			if col.Timestamp > 0 {
+				change.AddColumnWithTimestamp(col.ColumnName, col.Value, col.Timestamp)
+			} else {
+				change.AddColumn(col.ColumnName, col.Value)
+			}
+		}
+		change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+		changes = append(changes, change)
+	}
+	if err := batchwrite.Write(ctx, client, table, changes, maxRetries); err != nil {
+		return fmt.Errorf("[snapshot] write %s: %w", table, err)
+	}
+	return nil
+}
+
+func primaryKeyColumn(schema *tablestore.PrimaryKeySchema) (*Column, error) {
+	column := &Column{Name: *schema.Name}
+	switch *schema.Type {
+	case tablestore.PrimaryKeyType_INTEGER:
+		column.Type = tablestore.DefinedColumn_INTEGER
+	case tablestore.PrimaryKeyType_STRING:
+		column.Type = tablestore.DefinedColumn_STRING
+	case tablestore.PrimaryKeyType_BINARY:
+		column.Type = tablestore.DefinedColumn_BINARY
+	default:
+		return nil, fmt.Errorf("%w: unknown type of primary key %s", ErrInvalidValue, column.Name)
+	}
+	return column, nil
+}
@@ -0,0 +1,307 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ ExportClient = (*tablestore.TableStoreClient)(nil)
+	_ ImportClient = (*tablestore.TableStoreClient)(nil)
+)
+
+// fakeTable keeps rows in primary key order. Its primary key is a STRING
+// followed by a BINARY column.
+type fakeTable struct {
+	rows     []*tablestore.Row
+	pageSize int
+	written  []*tablestore.PutRowChange
+	ranges   []*tablestore.RangeRowQueryCriteria
+}
+
+func (t *fakeTable) DescribeTable(request *tablestore.DescribeTableRequest) (*tablestore.DescribeTableResponse, error) {
+	meta := &tablestore.TableMeta{TableName: request.TableName}
+	meta.AddPrimaryKeyColumn("user", tablestore.PrimaryKeyType_STRING)
+	meta.AddPrimaryKeyColumn("key", tablestore.PrimaryKeyType_BINARY)
+	return &tablestore.DescribeTableResponse{TableMeta: meta}, nil
+}
+
+func (t *fakeTable) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	criteria := *request.RangeRowQueryCriteria
+	t.ranges = append(t.ranges, &criteria)
+	start := 0
+	if v, ok := criteria.StartPrimaryKey.PrimaryKeys[0].Value.(string); ok {
+		for start < len(t.rows) && t.rows[start].PrimaryKey.PrimaryKeys[0].Value.(string) < v {
+			start++
+		}
+	}
+	resp := new(tablestore.GetRangeResponse)
+	for i := start; i < len(t.rows); i++ {
+		if i-start == t.pageSize {
+			resp.NextStartPrimaryKey = t.rows[i].PrimaryKey
+			break
+		}
+		row := &tablestore.Row{PrimaryKey: t.rows[i].PrimaryKey}
+		versions := make(map[string]int32)
+		for _, col := range t.rows[i].Columns {
+			if versions[col.ColumnName] < criteria.MaxVersion && wanted(criteria.ColumnsToGet, col.ColumnName) {
+				versions[col.ColumnName]++
+				row.Columns = append(row.Columns, col)
+			}
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp, nil
+}
+
+func wanted(columns []string, name string) bool {
+	if len(columns) == 0 {
+		return true
+	}
+	for _, col := range columns {
+		if col == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTable) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	resp := &tablestore.BatchWriteRowResponse{TableToRowsResult: make(map[string][]tablestore.RowResult)}
+	for table, changes := range request.RowChangesGroupByTable {
+		for i, change := range changes {
+			t.written = append(t.written, change.(*tablestore.PutRowChange))
+			resp.TableToRowsResult[table] = append(resp.TableToRowsResult[table], tablestore.RowResult{IsSucceed: true, Index: int32(i)})
+		}
+	}
+	return resp, nil
+}
+
+// importedRows returns what was imported, in the shape GetRange returns.
+func (t *fakeTable) importedRows() []*tablestore.Row {
+	var rows []*tablestore.Row
+	for _, change := range t.written {
+		row := &tablestore.Row{PrimaryKey: change.PrimaryKey}
+		for i := range change.Columns {
+			row.Columns = append(row.Columns, &change.Columns[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func newTestTable() *fakeTable {
+	t := &fakeTable{pageSize: 2}
+	for i := 0; i < 5; i++ {
+		pk := new(tablestore.PrimaryKey)
+		pk.AddPrimaryKeyColumn("user", fmt.Sprintf("user%d", i))
+		pk.AddPrimaryKeyColumn("key", []byte{byte(i), 0})
+		row := &tablestore.Row{PrimaryKey: pk}
+		if i != 3 {
+			row.Columns = []*tablestore.AttributeColumn{
+				{ColumnName: "amount", Value: float64(i) + 0.25, Timestamp: 2000},
+				{ColumnName: "amount", Value: float64(i), Timestamp: 1000},
+				{ColumnName: "blob", Value: []byte("b,\"\n"), Timestamp: 1000},
+				{ColumnName: "count", Value: int64(-i), Timestamp: 2000},
+				{ColumnName: "flag", Value: i%2 == 0, Timestamp: 2000},
+				{ColumnName: "name", Value: "", Timestamp: 2000},
+			}
+		}
+		t.rows = append(t.rows, row)
+	}
+	return t
+}
+
+var testColumns = []*Column{
+	{Name: "amount", Type: tablestore.DefinedColumn_DOUBLE},
+	{Name: "blob", Type: tablestore.DefinedColumn_BINARY},
+	{Name: "count", Type: tablestore.DefinedColumn_INTEGER},
+	{Name: "flag", Type: tablestore.DefinedColumn_BOOLEAN},
+	{Name: "name", Type: tablestore.DefinedColumn_STRING},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{JSONLines, CSV, Parquet} {
+		for _, allVersions := range []bool{false, true} {
+			name := fmt.Sprintf("%s/allVersions=%t", format, allVersions)
+			source := newTestTable()
+			var b bytes.Buffer
+			stats, err := Export(context.Background(), source, &b, &ExportOptions{
+				Table: "t", Format: format, Columns: testColumns, AllVersions: allVersions,
+			})
+			assert.Nil(t, err, name)
+			assert.Equal(t, int64(5), stats.Rows, name)
+
+			target := &fakeTable{}
+			stats, err = Import(context.Background(), target, &b, &ImportOptions{Table: "t", Format: format, BatchSize: 2})
+			assert.Nil(t, err, name)
+			assert.Equal(t, int64(5), stats.Rows, name)
+
+			maxVersion := int32(1)
+			if allVersions {
+				maxVersion = math.MaxInt32
+			}
+			source.pageSize = len(source.rows)
+			expect, _ := source.GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: &tablestore.RangeRowQueryCriteria{
+				StartPrimaryKey: &tablestore.PrimaryKey{PrimaryKeys: []*tablestore.PrimaryKeyColumn{{PrimaryKeyOption: tablestore.MIN}}},
+				MaxVersion:      maxVersion,
+			}})
+			assert.Equal(t, normalize(expect.Rows), normalize(target.importedRows()), name)
+		}
+	}
+}
+
+// normalize sorts the cells of each row, flat formats group them by
+// timestamp rather than by name.
+func normalize(rows []*tablestore.Row) []string {
+	var result []string
+	for _, row := range rows {
+		var pk, cells []string
+		for _, col := range row.PrimaryKey.PrimaryKeys {
+			pk = append(pk, fmt.Sprintf("%s=%#v", col.ColumnName, col.Value))
+		}
+		for _, col := range row.Columns {
+			cells = append(cells, fmt.Sprintf("%s@%d=%#v", col.ColumnName, col.Timestamp, col.Value))
+		}
+		sort.Strings(cells)
+		result = append(result, strings.Join(pk, ",")+" "+strings.Join(cells, " "))
+	}
+	return result
+}
+
+func TestExport_CSVLayout(t *testing.T) {
+	var b bytes.Buffer
+	_, err := Export(context.Background(), newTestTable(), &b, &ExportOptions{Table: "t", Format: CSV, Columns: testColumns[2:]})
+	assert.Nil(t, err)
+	lines := strings.Split(b.String(), "\n")
+	assert.Equal(t, "user:STRING,key:BINARY,_timestamp,count:INTEGER,flag:BOOLEAN,name:STRING", lines[0])
+	assert.Equal(t, "user0,AAA=,2000,0,true,", lines[1])
+	assert.Equal(t, `user3,AwA=,0,\N,\N,\N`, lines[4])
+}
+
+func TestExport_Range(t *testing.T) {
+	source := newTestTable()
+	start, end := new(tablestore.PrimaryKey), new(tablestore.PrimaryKey)
+	start.AddPrimaryKeyColumn("user", "user2")
+	start.AddPrimaryKeyColumnWithMinValue("key")
+	end.AddPrimaryKeyColumnWithMaxValue("user")
+	end.AddPrimaryKeyColumnWithMaxValue("key")
+	var b bytes.Buffer
+	stats, err := Export(context.Background(), source, &b, &ExportOptions{Table: "t", Start: start, End: end, Columns: testColumns[:1]})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), stats.Rows)
+	assert.Equal(t, start, source.ranges[0].StartPrimaryKey)
+	assert.Equal(t, []string{"amount"}, source.ranges[0].ColumnsToGet)
+	assert.Equal(t, int32(1), source.ranges[0].MaxVersion)
+}
+
+func TestExport_Invalid(t *testing.T) {
+	var b bytes.Buffer
+	_, err := Export(context.Background(), newTestTable(), &b, &ExportOptions{Table: "t", Format: CSV})
+	assert.True(t, errors.Is(err, ErrInvalidOptions))
+
+	columns := []*Column{{Name: "amount", Type: tablestore.DefinedColumn_STRING}}
+	_, err = Export(context.Background(), newTestTable(), &b, &ExportOptions{Table: "t", Format: CSV, Columns: columns})
+	assert.True(t, errors.Is(err, ErrInvalidValue))
+
+	timestamp := tablestore.ParquetTimestampMills
+	columns = []*Column{{Name: "name", Type: tablestore.DefinedColumn_STRING, ParquetType: &timestamp}}
+	_, err = Export(context.Background(), newTestTable(), &b, &ExportOptions{Table: "t", Format: Parquet, Columns: columns})
+	assert.True(t, errors.Is(err, ErrInvalidOptions))
+}
+
+func TestParquet_TaskSchemaTypes(t *testing.T) {
+	columns, err := ColumnsFromTaskSchema([]*tablestore.TaskSchema{
+		{ColumnName: "count", Type: tablestore.ParquetDate},
+		{ColumnName: "name", Type: tablestore.ParquetUtf8},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, tablestore.DefinedColumn_INTEGER, columns[0].Type)
+
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "t.parquet")
+	_, err = ExportFile(context.Background(), newTestTable(), path, &ExportOptions{Table: "t", Format: Parquet, Columns: columns})
+	assert.Nil(t, err)
+	target := &fakeTable{}
+	stats, err := ImportFile(context.Background(), target, path, &ImportOptions{Table: "t", Format: Parquet})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), stats.Rows)
+	assert.Equal(t, int64(-4), target.written[4].Columns[0].Value)
+
+	_, err = ColumnsFromTaskSchema([]*tablestore.TaskSchema{{ColumnName: "d", Type: tablestore.ParquetDecimal}})
+	assert.True(t, errors.Is(err, ErrInvalidOptions))
+}
+
+func TestImport_InvalidFile(t *testing.T) {
+	cases := map[Format]string{
+		JSONLines: `{"primaryKey":[{"name":"id","type":"INTEGER","value":"x"}]}`,
+		CSV:       "id:INTEGER,name:STRING\n1,a\n",
+		Parquet:   "not parquet",
+	}
+	for format, data := range cases {
+		_, err := Import(context.Background(), &fakeTable{}, strings.NewReader(data), &ImportOptions{Table: "t", Format: format})
+		assert.NotNil(t, err, format.String())
+	}
+
+	_, err := Import(context.Background(), &fakeTable{}, strings.NewReader("id:INTEGER,_timestamp\nx,1\n"), &ImportOptions{Table: "t", Format: CSV})
+	assert.True(t, errors.Is(err, ErrInvalidValue))
+}
+
+// failingSource yields its records, then err.
+type failingSource struct {
+	records []*flatRecord
+	err     error
+}
+
+func (s *failingSource) readRecord() (*flatRecord, error) {
+	if len(s.records) == 0 {
+		return nil, s.err
+	}
+	record := s.records[0]
+	s.records = s.records[1:]
+	return record, nil
+}
+
+func TestFlatReader_ReadError(t *testing.T) {
+	schema, err := newFlatSchema(
+		[]*Column{{Name: "id", Type: tablestore.DefinedColumn_INTEGER}},
+		[]*Column{{Name: "name", Type: tablestore.DefinedColumn_STRING}})
+	assert.Nil(t, err)
+	record := &flatRecord{primaryKey: []interface{}{int64(1)}, timestamp: 1, columns: []interface{}{"a"}}
+
+	readErr := errors.New("disk error")
+	r := &flatReader{schema: schema, source: &failingSource{records: []*flatRecord{record}, err: readErr}}
+	row, err := r.readRow()
+	assert.Nil(t, row)
+	assert.Equal(t, readErr, err)
+
+	r = &flatReader{schema: schema, source: &failingSource{records: []*flatRecord{record}, err: io.EOF}}
+	row, err = r.readRow()
+	assert.Nil(t, err)
+	assert.Len(t, row.Columns, 1)
+	_, err = r.readRow()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestFormatFromPath(t *testing.T) {
+	format, err := FormatFromPath("/tmp/a.parquet")
+	assert.Nil(t, err)
+	assert.Equal(t, Parquet, format)
+	_, err = FormatFromPath("a.txt")
+	assert.True(t, errors.Is(err, ErrInvalidOptions))
+}
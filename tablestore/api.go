@@ -161,6 +161,9 @@ func NewClientWithConfig(endPoint, instanceName, accessKeyId, accessKeySecret st
 
 	tableStoreClient.mu = &sync.Mutex{}
 	tableStoreClient.random = rand.New(rand.NewSource(time.Now().Unix()))
+	if tableStoreClient.indexSchemaCache == nil {
+		tableStoreClient.indexSchemaCache = NewIndexSchemaCache(0)
+	}
 
 	return tableStoreClient
 }
@@ -1785,26 +1788,9 @@ func (tableStoreClient *TableStoreClient) PutRow(request *PutRowRequest) (*PutRo
 		return nil, nil
 	}
 
-	req := new(otsprotocol.PutRowRequest)
-	req.TableName = proto.String(request.PutRowChange.TableName)
-	req.Row = request.PutRowChange.Serialize()
-
-	condition := new(otsprotocol.Condition)
-	condition.RowExistence = request.PutRowChange.Condition.buildCondition()
-	if request.PutRowChange.Condition.ColumnCondition != nil {
-		condition.ColumnCondition = request.PutRowChange.Condition.ColumnCondition.Serialize()
-	}
-
-	if request.PutRowChange.ReturnType == ReturnType_RT_PK {
-		content := otsprotocol.ReturnContent{ReturnType: otsprotocol.ReturnType_RT_PK.Enum()}
-		req.ReturnContent = &content
-	}
-
-	if request.PutRowChange.TransactionId != nil {
-		req.TransactionId = request.PutRowChange.TransactionId
-	}
-
-	req.Condition = condition
+	rowBuffer := getRowBuffer(request.PutRowChange.encodedSize())
+	defer putRowBuffer(rowBuffer)
+	req := buildPutRowRequest(request, rowBuffer)
 
 	resp := new(otsprotocol.PutRowResponse)
 	response := &PutRowResponse{}
@@ -1815,6 +1801,7 @@ func (tableStoreClient *TableStoreClient) PutRow(request *PutRowRequest) (*PutRo
 	response.ConsumedCapacityUnit = &ConsumedCapacityUnit{}
 	response.ConsumedCapacityUnit.Read = *resp.Consumed.CapacityUnit.Read
 	response.ConsumedCapacityUnit.Write = *resp.Consumed.CapacityUnit.Write
+	tableStoreClient.recordUsage(request.PutRowChange.TableName, putRowUri, response.ConsumedCapacityUnit)
 
 	if request.PutRowChange.ReturnType == ReturnType_RT_PK {
 		rows, err := readRowsWithHeader(bytes.NewReader(resp.Row))
@@ -1831,13 +1818,42 @@ func (tableStoreClient *TableStoreClient) PutRow(request *PutRowRequest) (*PutRo
 	return response, nil
 }
 
+// buildPutRowRequest serializes the row into rowBuffer, which must outlive
+// the returned request.
+func buildPutRowRequest(request *PutRowRequest, rowBuffer *rowBuffer) *otsprotocol.PutRowRequest {
+	req := new(otsprotocol.PutRowRequest)
+	req.TableName = proto.String(request.PutRowChange.TableName)
+	req.Row = request.PutRowChange.appendTo(rowBuffer.next(request.PutRowChange.encodedSize()))
+
+	condition := new(otsprotocol.Condition)
+	condition.RowExistence = request.PutRowChange.Condition.buildCondition()
+	if request.PutRowChange.Condition.ColumnCondition != nil {
+		condition.ColumnCondition = request.PutRowChange.Condition.ColumnCondition.Serialize()
+	}
+
+	if request.PutRowChange.ReturnType == ReturnType_RT_PK {
+		content := otsprotocol.ReturnContent{ReturnType: otsprotocol.ReturnType_RT_PK.Enum()}
+		req.ReturnContent = &content
+	}
+
+	if request.PutRowChange.TransactionId != nil {
+		req.TransactionId = request.PutRowChange.TransactionId
+	}
+
+	req.Condition = condition
+	return req
+}
+
 // Delete row with pk
 // @param DeleteRowRequest
 func (tableStoreClient *TableStoreClient) DeleteRow(request *DeleteRowRequest) (*DeleteRowResponse, error) {
+	rowBuffer := getRowBuffer(request.DeleteRowChange.encodedSize())
+	defer putRowBuffer(rowBuffer)
+
 	req := new(otsprotocol.DeleteRowRequest)
 	req.TableName = proto.String(request.DeleteRowChange.TableName)
 	req.Condition = request.DeleteRowChange.getCondition()
-	req.PrimaryKey = request.DeleteRowChange.PrimaryKey.Build(true)
+	req.PrimaryKey = request.DeleteRowChange.appendTo(rowBuffer.next(request.DeleteRowChange.encodedSize()))
 
 	if request.DeleteRowChange.TransactionId != nil {
 		req.TransactionId = request.DeleteRowChange.TransactionId
@@ -1852,6 +1868,7 @@ func (tableStoreClient *TableStoreClient) DeleteRow(request *DeleteRowRequest) (
 	response.ConsumedCapacityUnit = &ConsumedCapacityUnit{}
 	response.ConsumedCapacityUnit.Read = *resp.Consumed.CapacityUnit.Read
 	response.ConsumedCapacityUnit.Write = *resp.Consumed.CapacityUnit.Write
+	tableStoreClient.recordUsage(request.DeleteRowChange.TableName, deleteRowUri, response.ConsumedCapacityUnit)
 	return response, nil
 }
 
@@ -1908,6 +1925,7 @@ func (tableStoreClient *TableStoreClient) GetRow(request *GetRowRequest) (*GetRo
 
 	response.ConsumedCapacityUnit.Read = *resp.Consumed.CapacityUnit.Read
 	response.ConsumedCapacityUnit.Write = *resp.Consumed.CapacityUnit.Write
+	tableStoreClient.recordUsage(request.SingleRowQueryCriteria.TableName, getRowUri, response.ConsumedCapacityUnit)
 
 	if len(resp.Row) == 0 {
 		return response, nil
@@ -1934,12 +1952,15 @@ func (tableStoreClient *TableStoreClient) GetRow(request *GetRowRequest) (*GetRo
 // Update row
 // @param UpdateRowRequest
 func (tableStoreClient *TableStoreClient) UpdateRow(request *UpdateRowRequest) (*UpdateRowResponse, error) {
+	rowBuffer := getRowBuffer(request.UpdateRowChange.encodedSize())
+	defer putRowBuffer(rowBuffer)
+
 	req := new(otsprotocol.UpdateRowRequest)
 	resp := new(otsprotocol.UpdateRowResponse)
 
 	req.TableName = proto.String(request.UpdateRowChange.TableName)
 	req.Condition = request.UpdateRowChange.getCondition()
-	req.RowChange = request.UpdateRowChange.Serialize()
+	req.RowChange = request.UpdateRowChange.appendTo(rowBuffer.next(request.UpdateRowChange.encodedSize()))
 	if request.UpdateRowChange.TransactionId != nil {
 		req.TransactionId = request.UpdateRowChange.TransactionId
 	}
@@ -1971,6 +1992,7 @@ func (tableStoreClient *TableStoreClient) UpdateRow(request *UpdateRowRequest) (
 
 	response.ConsumedCapacityUnit.Read = *resp.Consumed.CapacityUnit.Read
 	response.ConsumedCapacityUnit.Write = *resp.Consumed.CapacityUnit.Write
+	tableStoreClient.recordUsage(request.UpdateRowChange.TableName, updateRowUri, response.ConsumedCapacityUnit)
 	return response, nil
 }
 
@@ -2062,59 +2084,16 @@ func (tableStoreClient *TableStoreClient) BatchGetRow(request *BatchGetRowReques
 		}
 
 	}
+	tableStoreClient.recordBatchUsage(batchGetRowUri, response.TableToRowsResult)
 	return response, nil
 }
 
 // Batch Write Row
 // @param BatchWriteRowRequest
 func (tableStoreClient *TableStoreClient) BatchWriteRow(request *BatchWriteRowRequest) (*BatchWriteRowResponse, error) {
-	req := new(otsprotocol.BatchWriteRowRequest)
-
-	var tablesInBatch []*otsprotocol.TableInBatchWriteRowRequest
-
-	for key, value := range request.RowChangesGroupByTable {
-		table := new(otsprotocol.TableInBatchWriteRowRequest)
-		table.TableName = proto.String(key)
-
-		for _, row := range value {
-			rowInBatch := &otsprotocol.RowInBatchWriteRowRequest{}
-			rowInBatch.Condition = row.getCondition()
-			rowInBatch.RowChange = row.Serialize()
-			rowInBatch.Type = row.getOperationType().Enum()
-
-			if *rowInBatch.Type != otsprotocol.OperationType_DELETE {
-				returnType := ReturnType_RT_NONE
-				switch change := row.(type) {
-				case *PutRowChange:
-					returnType = change.ReturnType
-				case *UpdateRowChange:
-					returnType = change.ReturnType
-				}
-				switch returnType {
-				case ReturnType_RT_PK:
-					rowInBatch.ReturnContent = &otsprotocol.ReturnContent{
-						ReturnType: otsprotocol.ReturnType_RT_PK.Enum(),
-					}
-				case ReturnType_RT_AFTER_MODIFY:
-					updateRow, isUpdateRow := row.(*UpdateRowChange)
-					if isUpdateRow {
-						content := otsprotocol.ReturnContent{ReturnType: otsprotocol.ReturnType_RT_AFTER_MODIFY.Enum()}
-						for _, column := range updateRow.ColumnNamesToReturn {
-							content.ReturnColumnNames = append(content.ReturnColumnNames, column)
-						}
-						rowInBatch.ReturnContent = &content
-					}
-				}
-
-			}
-			table.Rows = append(table.Rows, rowInBatch)
-		}
-
-		tablesInBatch = append(tablesInBatch, table)
-	}
-
-	req.Tables = tablesInBatch
-	req.IsAtomic = proto.Bool(request.IsAtomic)
+	rowBuffer := getRowBuffer(request.encodedSize())
+	defer putRowBuffer(rowBuffer)
+	req := buildBatchWriteRowRequest(request, rowBuffer)
 
 	resp := new(otsprotocol.BatchWriteRowResponse)
 	response := &BatchWriteRowResponse{TableToRowsResult: make(map[string][]RowResult)}
@@ -2175,9 +2154,91 @@ func (tableStoreClient *TableStoreClient) BatchWriteRow(request *BatchWriteRowRe
 			response.TableToRowsResult[*table.TableName] = append(response.TableToRowsResult[*table.TableName], *rowResult)
 		}
 	}
+	tableStoreClient.recordBatchUsage(batchWriteRowUri, response.TableToRowsResult)
 	return response, nil
 }
 
+func (request *BatchWriteRowRequest) encodedSize() int {
+	size := 0
+	for _, rows := range request.RowChangesGroupByTable {
+		for _, row := range rows {
+			size += row.encodedSize()
+		}
+	}
+	return size
+}
+
+// buildBatchWriteRowRequest serializes all rows into rowBuffer, which must
+// outlive the returned request. The protobuf messages of the rows are carved
+// out of a few slices instead of being allocated one by one.
+func buildBatchWriteRowRequest(request *BatchWriteRowRequest, rowBuffer *rowBuffer) *otsprotocol.BatchWriteRowRequest {
+	req := new(otsprotocol.BatchWriteRowRequest)
+
+	rowCount := 0
+	for _, value := range request.RowChangesGroupByTable {
+		rowCount += len(value)
+	}
+	tables := make([]otsprotocol.TableInBatchWriteRowRequest, len(request.RowChangesGroupByTable))
+	tableNames := make([]string, len(request.RowChangesGroupByTable))
+	rows := make([]otsprotocol.RowInBatchWriteRowRequest, rowCount)
+	rowPtrs := make([]*otsprotocol.RowInBatchWriteRowRequest, rowCount)
+	operationTypes := make([]otsprotocol.OperationType, rowCount)
+	conditions := make([]otsprotocol.Condition, rowCount)
+	existences := make([]otsprotocol.RowExistenceExpectation, rowCount)
+
+	tablesInBatch := make([]*otsprotocol.TableInBatchWriteRowRequest, 0, len(tables))
+	tableIndex, rowIndex := 0, 0
+	for key, value := range request.RowChangesGroupByTable {
+		table := &tables[tableIndex]
+		tableNames[tableIndex] = key
+		table.TableName = &tableNames[tableIndex]
+		table.Rows = rowPtrs[rowIndex : rowIndex : rowIndex+len(value)]
+		tableIndex++
+
+		for _, row := range value {
+			rowInBatch := &rows[rowIndex]
+			rowInBatch.Condition = row.fillCondition(&conditions[rowIndex], &existences[rowIndex])
+			rowInBatch.RowChange = row.appendTo(rowBuffer.next(row.encodedSize()))
+			operationTypes[rowIndex] = row.getOperationType()
+			rowInBatch.Type = &operationTypes[rowIndex]
+			rowIndex++
+
+			if *rowInBatch.Type != otsprotocol.OperationType_DELETE {
+				returnType := ReturnType_RT_NONE
+				switch change := row.(type) {
+				case *PutRowChange:
+					returnType = change.ReturnType
+				case *UpdateRowChange:
+					returnType = change.ReturnType
+				}
+				switch returnType {
+				case ReturnType_RT_PK:
+					rowInBatch.ReturnContent = &otsprotocol.ReturnContent{
+						ReturnType: otsprotocol.ReturnType_RT_PK.Enum(),
+					}
+				case ReturnType_RT_AFTER_MODIFY:
+					updateRow, isUpdateRow := row.(*UpdateRowChange)
+					if isUpdateRow {
+						content := otsprotocol.ReturnContent{ReturnType: otsprotocol.ReturnType_RT_AFTER_MODIFY.Enum()}
+						for _, column := range updateRow.ColumnNamesToReturn {
+							content.ReturnColumnNames = append(content.ReturnColumnNames, column)
+						}
+						rowInBatch.ReturnContent = &content
+					}
+				}
+
+			}
+			table.Rows = append(table.Rows, rowInBatch)
+		}
+
+		tablesInBatch = append(tablesInBatch, table)
+	}
+
+	req.Tables = tablesInBatch
+	req.IsAtomic = proto.Bool(request.IsAtomic)
+	return req
+}
+
 // Get Range
 // @param GetRangeRequest
 func (tableStoreClient *TableStoreClient) GetRange(request *GetRangeRequest) (*GetRangeResponse, error) {
@@ -2240,6 +2301,7 @@ func (tableStoreClient *TableStoreClient) GetRange(request *GetRangeRequest) (*G
 
 	response.ConsumedCapacityUnit.Read = *resp.Consumed.CapacityUnit.Read
 	response.ConsumedCapacityUnit.Write = *resp.Consumed.CapacityUnit.Write
+	tableStoreClient.recordUsage(request.RangeRowQueryCriteria.TableName, getRangeUri, response.ConsumedCapacityUnit)
 
 	compressType, err := parseProtocolCompressType(resp.GetCompressType())
 	if err != nil {
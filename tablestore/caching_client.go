@@ -0,0 +1,415 @@
+package tablestore
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+)
+
+type CacheConfig struct {
+	// MaxEntries bounds the number of cached rows, the least recently used
+	// ones are evicted first.
+	MaxEntries int
+	// TTL is how long a row is served from the cache.
+	TTL time.Duration
+	// NegativeTTL is how long a row found missing is served from the cache,
+	// 0 does not cache missing rows.
+	NegativeTTL time.Duration
+	// Tables limits caching to these tables, all tables are cached when it
+	// is empty.
+	Tables []string
+}
+
+func NewDefaultCacheConfig() *CacheConfig {
+	return &CacheConfig{
+		MaxEntries:  10000,
+		TTL:         time.Minute,
+		NegativeTTL: 10 * time.Second,
+	}
+}
+
+type CacheStats struct {
+	Hits          int64
+	Misses        int64
+	Evictions     int64
+	Invalidations int64
+}
+
+// CachingClient serves GetRow and BatchGetRow from an LRU cache of rows. A
+// row is cached per table, primary key, columns to get and max version,
+// reads with a filter, a time range or a column range always go to the
+// server. PutRow, UpdateRow, DeleteRow and BatchWriteRow made through the
+// client drop the cached versions of the rows they write, writes made by
+// anyone else show up once the entries expire.
+//
+// A row read while a write to the same row was in flight is not cached, so
+// a read racing a write cannot bring back the old row for a whole TTL.
+// Writes to other rows do not keep a read from caching.
+type CachingClient struct {
+	TableStoreApi
+
+	config CacheConfig
+	tables map[string]bool
+
+	mu    sync.Mutex
+	lru   *simplelru.LRU
+	rows  map[string]map[string]bool // row key to the cache keys of the row
+	stats CacheStats
+	// writes counts the writes started. gens maps a row key to the count
+	// at the last write to the row, genFloor is the highest generation
+	// evicted from gens and stands for the rows not in it. A read caches a
+	// row only when the generation of the row did not change while the
+	// read was running.
+	writes   uint64
+	gens     *simplelru.LRU
+	genFloor uint64
+}
+
+type cacheEntry struct {
+	rowKey  string
+	row     *Row // nil for a missing row
+	expires time.Time
+}
+
+// NewCachingClient wraps client, config nil uses NewDefaultCacheConfig.
+func NewCachingClient(client TableStoreApi, config *CacheConfig) *CachingClient {
+	if config == nil {
+		config = NewDefaultCacheConfig()
+	}
+	c := &CachingClient{
+		TableStoreApi: client,
+		config:        *config,
+		rows:          make(map[string]map[string]bool),
+	}
+	if c.config.MaxEntries <= 0 {
+		c.config.MaxEntries = NewDefaultCacheConfig().MaxEntries
+	}
+	if len(config.Tables) > 0 {
+		c.tables = make(map[string]bool)
+		for _, table := range config.Tables {
+			c.tables[table] = true
+		}
+	}
+	// the size is positive, NewLRU cannot fail
+	c.lru, _ = simplelru.NewLRU(c.config.MaxEntries, c.onEvict)
+	c.gens, _ = simplelru.NewLRU(c.config.MaxEntries, c.onGenEvict)
+	return c
+}
+
+func (c *CachingClient) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Purge drops every cached row.
+func (c *CachingClient) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Purge()
+	c.rows = make(map[string]map[string]bool)
+}
+
+// Invalidate drops the cached versions of a row.
+func (c *CachingClient) Invalidate(tableName string, pk *PrimaryKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidate(cacheRowKey(tableName, pk))
+}
+
+// onEvict runs under c.mu, from the lru calls.
+func (c *CachingClient) onEvict(key interface{}, value interface{}) {
+	entry := value.(*cacheEntry)
+	if keys := c.rows[entry.rowKey]; keys != nil {
+		delete(keys, key.(string))
+		if len(keys) == 0 {
+			delete(c.rows, entry.rowKey)
+		}
+	}
+}
+
+// onGenEvict runs under c.mu, from the gens calls.
+func (c *CachingClient) onGenEvict(key interface{}, value interface{}) {
+	if gen := value.(uint64); gen > c.genFloor {
+		c.genFloor = gen
+	}
+}
+
+// generation runs under c.mu.
+func (c *CachingClient) generation(rowKey string) uint64 {
+	if gen, ok := c.gens.Peek(rowKey); ok {
+		return gen.(uint64)
+	}
+	return c.genFloor
+}
+
+func (c *CachingClient) invalidate(rowKey string) {
+	for key := range c.rows[rowKey] {
+		c.lru.Remove(key)
+		c.stats.Invalidations++
+	}
+}
+
+func (c *CachingClient) cached(tableName string) bool {
+	return c.tables == nil || c.tables[tableName]
+}
+
+func (c *CachingClient) lookup(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if value, ok := c.lru.Get(key); ok {
+		entry := value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.stats.Hits++
+			return entry, true
+		}
+		c.lru.Remove(key)
+	}
+	c.stats.Misses++
+	return nil, false
+}
+
+// readStarted returns the generation of a row before it is read.
+func (c *CachingClient) readStarted(rowKey string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation(rowKey)
+}
+
+// store caches row under key unless a write to the row started after the
+// read did.
+func (c *CachingClient) store(key, rowKey string, row *Row, readStarted uint64) {
+	ttl := c.config.TTL
+	if row == nil {
+		ttl = c.config.NegativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation(rowKey) != readStarted {
+		return
+	}
+	if c.lru.Add(key, &cacheEntry{rowKey: rowKey, row: row, expires: time.Now().Add(ttl)}) {
+		c.stats.Evictions++
+	}
+	if c.rows[rowKey] == nil {
+		c.rows[rowKey] = make(map[string]bool)
+	}
+	c.rows[rowKey][key] = true
+}
+
+// writeRows drops the cached versions of the rows around a write. The
+// rows are dropped again once the write is done, to catch reads that
+// started before the write and finished after it.
+func (c *CachingClient) writeRows(changes []RowChange, write func() error) error {
+	var rowKeys []string
+	for _, change := range changes {
+		var pk *PrimaryKey
+		switch change := change.(type) {
+		case *PutRowChange:
+			pk = change.PrimaryKey
+		case *UpdateRowChange:
+			pk = change.PrimaryKey
+		case *DeleteRowChange:
+			pk = change.PrimaryKey
+		}
+		if pk != nil && c.cached(change.GetTableName()) {
+			rowKeys = append(rowKeys, cacheRowKey(change.GetTableName(), pk))
+		}
+	}
+	drop := func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.writes++
+		for _, rowKey := range rowKeys {
+			c.gens.Add(rowKey, c.writes)
+			c.invalidate(rowKey)
+		}
+	}
+	drop()
+	defer drop()
+	return write()
+}
+
+func (c *CachingClient) GetRow(request *GetRowRequest) (*GetRowResponse, error) {
+	criteria := request.SingleRowQueryCriteria
+	if !c.cached(criteria.TableName) || !cacheable(criteria.TimeRange, criteria.Filter, criteria.StartColumn, criteria.EndColumn) || criteria.TransactionId != nil {
+		return c.TableStoreApi.GetRow(request)
+	}
+	rowKey := cacheRowKey(criteria.TableName, criteria.PrimaryKey)
+	key := cacheKey(rowKey, criteria.ColumnsToGet, int(criteria.MaxVersion))
+	if entry, ok := c.lookup(key); ok {
+		resp := &GetRowResponse{ConsumedCapacityUnit: new(ConsumedCapacityUnit)}
+		if entry.row != nil {
+			row := cloneRow(entry.row)
+			resp.PrimaryKey = *row.PrimaryKey
+			resp.Columns = row.Columns
+		}
+		return resp, nil
+	}
+
+	started := c.readStarted(rowKey)
+	resp, err := c.TableStoreApi.GetRow(request)
+	if err != nil {
+		return nil, err
+	}
+	var row *Row
+	if len(resp.PrimaryKey.PrimaryKeys) > 0 {
+		pk := resp.PrimaryKey
+		row = cloneRow(&Row{PrimaryKey: &pk, Columns: resp.Columns})
+	}
+	c.store(key, rowKey, row, started)
+	return resp, nil
+}
+
+func (c *CachingClient) BatchGetRow(request *BatchGetRowRequest) (*BatchGetRowResponse, error) {
+	resp := &BatchGetRowResponse{TableToRowsResult: make(map[string][]RowResult)}
+	// missed is the request for the rows not in the cache, misses maps its
+	// rows back to the request of the caller by table
+	missed := &BatchGetRowRequest{ExtraRequestInfo: request.ExtraRequestInfo}
+	misses := make(map[string][]cacheMiss)
+
+	for _, criteria := range request.MultiRowQueryCriteria {
+		table := criteria.TableName
+		if !c.cached(table) || !cacheable(criteria.TimeRange, criteria.Filter, criteria.StartColumn, criteria.EndColumn) {
+			missed.MultiRowQueryCriteria = append(missed.MultiRowQueryCriteria, criteria)
+			for i := range criteria.PrimaryKey {
+				misses[table] = append(misses[table], cacheMiss{index: int32(i)})
+			}
+			continue
+		}
+		miss := *criteria
+		miss.PrimaryKey = nil
+		for i, pk := range criteria.PrimaryKey {
+			rowKey := cacheRowKey(table, pk)
+			key := cacheKey(rowKey, criteria.ColumnsToGet, criteria.MaxVersion)
+			entry, ok := c.lookup(key)
+			if !ok {
+				miss.PrimaryKey = append(miss.PrimaryKey, pk)
+				misses[table] = append(misses[table], cacheMiss{index: int32(i), key: key, rowKey: rowKey, started: c.readStarted(rowKey)})
+				continue
+			}
+			result := RowResult{TableName: table, IsSucceed: true, ConsumedCapacityUnit: new(ConsumedCapacityUnit), Index: int32(i)}
+			if entry.row != nil {
+				row := cloneRow(entry.row)
+				result.PrimaryKey = *row.PrimaryKey
+				result.Columns = row.Columns
+			}
+			resp.TableToRowsResult[table] = append(resp.TableToRowsResult[table], result)
+		}
+		if len(miss.PrimaryKey) > 0 {
+			missed.MultiRowQueryCriteria = append(missed.MultiRowQueryCriteria, &miss)
+		}
+	}
+
+	if len(missed.MultiRowQueryCriteria) > 0 {
+		missedResp, err := c.TableStoreApi.BatchGetRow(missed)
+		if err != nil {
+			return nil, err
+		}
+		resp.ResponseInfo = missedResp.ResponseInfo
+		for table, results := range missedResp.TableToRowsResult {
+			for _, result := range results {
+				miss := misses[table][result.Index]
+				result.Index = miss.index
+				if miss.key != "" && result.IsSucceed {
+					var row *Row
+					if len(result.PrimaryKey.PrimaryKeys) > 0 {
+						pk := result.PrimaryKey
+						row = cloneRow(&Row{PrimaryKey: &pk, Columns: result.Columns})
+					}
+					c.store(miss.key, miss.rowKey, row, miss.started)
+				}
+				resp.TableToRowsResult[table] = append(resp.TableToRowsResult[table], result)
+			}
+		}
+	}
+
+	for _, results := range resp.TableToRowsResult {
+		sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	}
+	return resp, nil
+}
+
+// cacheMiss is a row of a BatchGetRow request read from the server. key is
+// empty when the row is not cached, started is the generation of the row
+// before the read.
+type cacheMiss struct {
+	index       int32
+	key, rowKey string
+	started     uint64
+}
+
+func (c *CachingClient) PutRow(request *PutRowRequest) (resp *PutRowResponse, err error) {
+	err = c.writeRows([]RowChange{request.PutRowChange}, func() error {
+		resp, err = c.TableStoreApi.PutRow(request)
+		return err
+	})
+	return resp, err
+}
+
+func (c *CachingClient) UpdateRow(request *UpdateRowRequest) (resp *UpdateRowResponse, err error) {
+	err = c.writeRows([]RowChange{request.UpdateRowChange}, func() error {
+		resp, err = c.TableStoreApi.UpdateRow(request)
+		return err
+	})
+	return resp, err
+}
+
+func (c *CachingClient) DeleteRow(request *DeleteRowRequest) (resp *DeleteRowResponse, err error) {
+	err = c.writeRows([]RowChange{request.DeleteRowChange}, func() error {
+		resp, err = c.TableStoreApi.DeleteRow(request)
+		return err
+	})
+	return resp, err
+}
+
+func (c *CachingClient) BatchWriteRow(request *BatchWriteRowRequest) (resp *BatchWriteRowResponse, err error) {
+	var changes []RowChange
+	for _, tableChanges := range request.RowChangesGroupByTable {
+		changes = append(changes, tableChanges...)
+	}
+	err = c.writeRows(changes, func() error {
+		resp, err = c.TableStoreApi.BatchWriteRow(request)
+		return err
+	})
+	return resp, err
+}
+
+func cacheable(timeRange *TimeRange, filter ColumnFilter, startColumn, endColumn *string) bool {
+	return timeRange == nil && filter == nil && startColumn == nil && endColumn == nil
+}
+
+func cacheRowKey(tableName string, pk *PrimaryKey) string {
+	return tableName + "\x00" + string(pk.Build(false))
+}
+
+// cacheKey adds the columns to get, in any order, and the max version to
+// the row key.
+func cacheKey(rowKey string, columnsToGet []string, maxVersion int) string {
+	columns := append([]string{}, columnsToGet...)
+	sort.Strings(columns)
+	return rowKey + "\x00" + strings.Join(columns, "\x00") + "\x00" + strconv.Itoa(maxVersion)
+}
+
+// cloneRow copies the columns so a caller changing its response does not
+// change the cached row. Values are shared, they are not changed in place.
+func cloneRow(row *Row) *Row {
+	pk := &PrimaryKey{PrimaryKeys: make([]*PrimaryKeyColumn, len(row.PrimaryKey.PrimaryKeys))}
+	for i, col := range row.PrimaryKey.PrimaryKeys {
+		copied := *col
+		pk.PrimaryKeys[i] = &copied
+	}
+	columns := make([]*AttributeColumn, len(row.Columns))
+	for i, col := range row.Columns {
+		copied := *col
+		columns[i] = &copied
+	}
+	return &Row{PrimaryKey: pk, Columns: columns}
+}
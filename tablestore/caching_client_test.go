@@ -0,0 +1,317 @@
+package tablestore
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ TableStoreApi = (*CachingClient)(nil)
+
+// fakeRowStore keeps one row per table and integer id and counts the rows
+// read from it.
+type fakeRowStore struct {
+	TableStoreApi
+	rows     map[string]*Row
+	rowsRead int
+	// onRead runs in the middle of each read
+	onRead func()
+}
+
+func newFakeRowStore() *fakeRowStore {
+	return &fakeRowStore{rows: make(map[string]*Row)}
+}
+
+func fakeRowKey(table string, pk *PrimaryKey) string {
+	return fmt.Sprintf("%s/%v", table, pk.PrimaryKeys[0].Value)
+}
+
+func idKey(id int64) *PrimaryKey {
+	pk := new(PrimaryKey)
+	pk.AddPrimaryKeyColumn("id", id)
+	return pk
+}
+
+func (s *fakeRowStore) read(table string, pk *PrimaryKey, columnsToGet []string) *Row {
+	s.rowsRead++
+	if s.onRead != nil {
+		s.onRead()
+	}
+	row, ok := s.rows[fakeRowKey(table, pk)]
+	if !ok {
+		return nil
+	}
+	copied := &Row{PrimaryKey: row.PrimaryKey}
+	for _, col := range row.Columns {
+		if len(columnsToGet) == 0 || containsString(columnsToGet, col.ColumnName) {
+			c := *col
+			copied.Columns = append(copied.Columns, &c)
+		}
+	}
+	return copied
+}
+
+func (s *fakeRowStore) GetRow(request *GetRowRequest) (*GetRowResponse, error) {
+	criteria := request.SingleRowQueryCriteria
+	resp := &GetRowResponse{ConsumedCapacityUnit: &ConsumedCapacityUnit{Read: 1}}
+	if row := s.read(criteria.TableName, criteria.PrimaryKey, criteria.ColumnsToGet); row != nil {
+		resp.PrimaryKey = *row.PrimaryKey
+		resp.Columns = row.Columns
+	}
+	return resp, nil
+}
+
+func (s *fakeRowStore) BatchGetRow(request *BatchGetRowRequest) (*BatchGetRowResponse, error) {
+	resp := &BatchGetRowResponse{TableToRowsResult: make(map[string][]RowResult)}
+	for _, criteria := range request.MultiRowQueryCriteria {
+		for i, pk := range criteria.PrimaryKey {
+			result := RowResult{TableName: criteria.TableName, IsSucceed: true, Index: int32(i), ConsumedCapacityUnit: &ConsumedCapacityUnit{Read: 1}}
+			if row := s.read(criteria.TableName, pk, criteria.ColumnsToGet); row != nil {
+				result.PrimaryKey = *row.PrimaryKey
+				result.Columns = row.Columns
+			}
+			resp.TableToRowsResult[criteria.TableName] = append(resp.TableToRowsResult[criteria.TableName], result)
+		}
+	}
+	return resp, nil
+}
+
+func (s *fakeRowStore) PutRow(request *PutRowRequest) (*PutRowResponse, error) {
+	change := request.PutRowChange
+	row := &Row{PrimaryKey: change.PrimaryKey}
+	for i := range change.Columns {
+		row.Columns = append(row.Columns, &change.Columns[i])
+	}
+	s.rows[fakeRowKey(change.TableName, change.PrimaryKey)] = row
+	return new(PutRowResponse), nil
+}
+
+func (s *fakeRowStore) UpdateRow(request *UpdateRowRequest) (*UpdateRowResponse, error) {
+	change := request.UpdateRowChange
+	row := s.rows[fakeRowKey(change.TableName, change.PrimaryKey)]
+	for _, col := range change.Columns {
+		row.Columns = append([]*AttributeColumn{{ColumnName: col.ColumnName, Value: col.Value}}, row.Columns...)
+	}
+	return new(UpdateRowResponse), nil
+}
+
+func (s *fakeRowStore) DeleteRow(request *DeleteRowRequest) (*DeleteRowResponse, error) {
+	change := request.DeleteRowChange
+	delete(s.rows, fakeRowKey(change.TableName, change.PrimaryKey))
+	return new(DeleteRowResponse), nil
+}
+
+func (s *fakeRowStore) BatchWriteRow(request *BatchWriteRowRequest) (*BatchWriteRowResponse, error) {
+	for _, changes := range request.RowChangesGroupByTable {
+		for _, change := range changes {
+			if put, ok := change.(*PutRowChange); ok {
+				s.PutRow(&PutRowRequest{PutRowChange: put})
+			}
+		}
+	}
+	return new(BatchWriteRowResponse), nil
+}
+
+func putConfig(t *testing.T, client TableStoreApi, id int64, value string) {
+	change := &PutRowChange{TableName: "config", PrimaryKey: idKey(id)}
+	change.AddColumn("value", value)
+	change.AddColumn("owner", "ops")
+	_, err := client.PutRow(&PutRowRequest{PutRowChange: change})
+	assert.Nil(t, err)
+}
+
+func getConfig(t *testing.T, client TableStoreApi, id int64, columns ...string) *GetRowResponse {
+	resp, err := client.GetRow(&GetRowRequest{SingleRowQueryCriteria: &SingleRowQueryCriteria{
+		TableName: "config", PrimaryKey: idKey(id), ColumnsToGet: columns, MaxVersion: 1,
+	}})
+	assert.Nil(t, err)
+	return resp
+}
+
+func TestCachingClient_GetRow(t *testing.T) {
+	store := newFakeRowStore()
+	client := NewCachingClient(store, nil)
+	putConfig(t, client, 1, "a")
+
+	assert.Equal(t, "a", getConfig(t, client, 1).Columns[0].Value)
+	resp := getConfig(t, client, 1)
+	assert.Equal(t, "a", resp.Columns[0].Value)
+	assert.Equal(t, int64(1), resp.PrimaryKey.PrimaryKeys[0].Value)
+	assert.Equal(t, int32(0), resp.ConsumedCapacityUnit.Read)
+	assert.Equal(t, 1, store.rowsRead)
+
+	// a response changed by the caller leaves the cache as it is
+	resp.Columns[0].Value = "changed"
+	assert.Equal(t, "a", getConfig(t, client, 1).Columns[0].Value)
+
+	// other columns to get are another entry, their order does not matter
+	assert.Len(t, getConfig(t, client, 1, "value", "owner").Columns, 2)
+	assert.Len(t, getConfig(t, client, 1, "owner", "value").Columns, 2)
+	assert.Equal(t, 2, store.rowsRead)
+
+	// missing rows are cached too
+	assert.Empty(t, getConfig(t, client, 2).Columns)
+	assert.Empty(t, getConfig(t, client, 2).PrimaryKey.PrimaryKeys)
+	assert.Equal(t, 3, store.rowsRead)
+	assert.Equal(t, CacheStats{Hits: 4, Misses: 3}, client.Stats())
+
+	// a filter is not cached
+	_, err := client.GetRow(&GetRowRequest{SingleRowQueryCriteria: &SingleRowQueryCriteria{
+		TableName: "config", PrimaryKey: idKey(1), MaxVersion: 1, Filter: NewSingleColumnCondition("value", CT_EQUAL, "a"),
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, store.rowsRead)
+}
+
+func TestCachingClient_WritesInvalidate(t *testing.T) {
+	store := newFakeRowStore()
+	client := NewCachingClient(store, nil)
+	putConfig(t, client, 1, "a")
+	getConfig(t, client, 1)
+	getConfig(t, client, 1, "value")
+	getConfig(t, client, 2)
+
+	putConfig(t, client, 1, "b")
+	assert.Equal(t, "b", getConfig(t, client, 1).Columns[0].Value)
+	assert.Equal(t, "b", getConfig(t, client, 1, "value").Columns[0].Value)
+	assert.Equal(t, int64(2), client.Stats().Invalidations)
+
+	update := &UpdateRowChange{TableName: "config", PrimaryKey: idKey(1)}
+	update.PutColumn("value", "c")
+	_, err := client.UpdateRow(&UpdateRowRequest{UpdateRowChange: update})
+	assert.Nil(t, err)
+	assert.Equal(t, "c", getConfig(t, client, 1).Columns[0].Value)
+
+	batch := new(BatchWriteRowRequest)
+	put := &PutRowChange{TableName: "config", PrimaryKey: idKey(2)}
+	put.AddColumn("value", "x")
+	batch.AddRowChange(put)
+	_, err = client.BatchWriteRow(batch)
+	assert.Nil(t, err)
+	assert.Equal(t, "x", getConfig(t, client, 2).Columns[0].Value)
+
+	_, err = client.DeleteRow(&DeleteRowRequest{DeleteRowChange: &DeleteRowChange{TableName: "config", PrimaryKey: idKey(1)}})
+	assert.Nil(t, err)
+	assert.Empty(t, getConfig(t, client, 1).Columns)
+
+	// a write made elsewhere is seen once the entry is dropped
+	putConfig(t, store, 1, "elsewhere")
+	assert.Empty(t, getConfig(t, client, 1).Columns)
+	client.Invalidate("config", idKey(1))
+	assert.Equal(t, "elsewhere", getConfig(t, client, 1).Columns[0].Value)
+}
+
+func TestCachingClient_ReadRacingWrite(t *testing.T) {
+	store := newFakeRowStore()
+	client := NewCachingClient(store, nil)
+	putConfig(t, client, 1, "a")
+	store.onRead = func() {
+		store.onRead = nil
+		putConfig(t, client, 1, "b")
+	}
+	// the read saw a and the write happened after it: a is not cached
+	getConfig(t, client, 1)
+	assert.Equal(t, "b", getConfig(t, client, 1).Columns[0].Value)
+}
+
+func TestCachingClient_WriteToOtherRow(t *testing.T) {
+	store := newFakeRowStore()
+	client := NewCachingClient(store, nil)
+	putConfig(t, client, 1, "a")
+	store.onRead = func() {
+		store.onRead = nil
+		putConfig(t, client, 2, "b")
+	}
+	// the write went to another row: a is cached
+	getConfig(t, client, 1)
+	getConfig(t, client, 1)
+	assert.Equal(t, 1, store.rowsRead)
+
+	// rows whose generation was evicted are not cached while a write runs
+	client = NewCachingClient(store, &CacheConfig{MaxEntries: 1, TTL: time.Minute})
+	store.rowsRead = 0
+	store.onRead = func() {
+		store.onRead = nil
+		putConfig(t, client, 1, "c")
+		putConfig(t, client, 2, "d")
+	}
+	getConfig(t, client, 1)
+	assert.Equal(t, "c", getConfig(t, client, 1).Columns[0].Value)
+	assert.Equal(t, 2, store.rowsRead)
+}
+
+func TestCachingClient_ExpiryAndEviction(t *testing.T) {
+	store := newFakeRowStore()
+	client := NewCachingClient(store, &CacheConfig{MaxEntries: 2, TTL: 50 * time.Millisecond, Tables: []string{"config"}})
+	for id := int64(1); id <= 3; id++ {
+		putConfig(t, client, id, "a")
+		getConfig(t, client, id)
+	}
+	assert.Equal(t, int64(1), client.Stats().Evictions)
+	getConfig(t, client, 1)
+	assert.Equal(t, 4, store.rowsRead)
+
+	// NegativeTTL 0 does not cache missing rows
+	getConfig(t, client, 9)
+	getConfig(t, client, 9)
+	assert.Equal(t, 6, store.rowsRead)
+
+	time.Sleep(60 * time.Millisecond)
+	getConfig(t, client, 1)
+	assert.Equal(t, 7, store.rowsRead)
+
+	// other tables are not cached
+	for i := 0; i < 2; i++ {
+		_, err := client.GetRow(&GetRowRequest{SingleRowQueryCriteria: &SingleRowQueryCriteria{TableName: "users", PrimaryKey: idKey(1), MaxVersion: 1}})
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 9, store.rowsRead)
+
+	client.Purge()
+	getConfig(t, client, 1)
+	assert.Equal(t, 10, store.rowsRead)
+}
+
+func TestCachingClient_BatchGetRow(t *testing.T) {
+	store := newFakeRowStore()
+	client := NewCachingClient(store, nil)
+	for id := int64(1); id <= 3; id++ {
+		putConfig(t, client, id, fmt.Sprint(id))
+	}
+	getConfig(t, client, 2)
+	getConfig(t, client, 4)
+	assert.Equal(t, 2, store.rowsRead)
+
+	criteria := &MultiRowQueryCriteria{TableName: "config", MaxVersion: 1}
+	for id := int64(1); id <= 4; id++ {
+		criteria.AddRow(idKey(id))
+	}
+	users := &MultiRowQueryCriteria{TableName: "users", MaxVersion: 1}
+	users.AddRow(idKey(1))
+	request := &BatchGetRowRequest{MultiRowQueryCriteria: []*MultiRowQueryCriteria{criteria, users}}
+
+	resp, err := client.BatchGetRow(request)
+	assert.Nil(t, err)
+	// 1 and 3 of config and the users row
+	assert.Equal(t, 5, store.rowsRead)
+	results := resp.TableToRowsResult["config"]
+	assert.Len(t, results, 4)
+	for i, result := range results {
+		assert.Equal(t, int32(i), result.Index)
+		if i < 3 {
+			assert.Equal(t, fmt.Sprint(i+1), result.Columns[0].Value)
+		} else {
+			assert.Empty(t, result.PrimaryKey.PrimaryKeys)
+		}
+	}
+	assert.Len(t, resp.TableToRowsResult["users"], 1)
+
+	// now every row is cached
+	resp, err = client.BatchGetRow(request)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, store.rowsRead)
+	assert.Equal(t, "3", resp.TableToRowsResult["config"][2].Columns[0].Value)
+	assert.Equal(t, 4, len(criteria.PrimaryKey))
+}
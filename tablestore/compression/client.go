@@ -0,0 +1,220 @@
+package compression
+
+import (
+	"fmt"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// Column is a compressed attribute column. Values shorter than MinSize are
+// stored as they are, MinSize 0 means DefaultMinSize.
+type Column struct {
+	Name    string
+	Codec   Codec
+	MinSize int
+}
+
+type Config struct {
+	// Columns lists the compressed columns by table name.
+	Columns map[string][]Column
+}
+
+// Client wraps a tablestore.TableStoreApi. It compresses the configured
+// columns of PutRow, UpdateRow and BatchWriteRow requests and decompresses
+// every compressed value in GetRow, GetRange, BatchGetRow and Search
+// responses, whether the column is configured or not. Every other call goes
+// to the wrapped client unchanged.
+type Client struct {
+	tablestore.TableStoreApi
+
+	columns map[string]map[string]Column
+}
+
+// NewClient wraps client, usually a *tablestore.TableStoreClient.
+func NewClient(client tablestore.TableStoreApi, config Config) (*Client, error) {
+	if client == nil {
+		return nil, fmt.Errorf("%w: client is required", ErrInvalidConfig)
+	}
+	columns := make(map[string]map[string]Column)
+	for table, list := range config.Columns {
+		columns[table] = make(map[string]Column)
+		for _, column := range list {
+			if column.Codec != Gzip && column.Codec != Zstd {
+				return nil, fmt.Errorf("%w: column %s.%s has unknown codec %d", ErrInvalidConfig, table, column.Name, byte(column.Codec))
+			}
+			if column.MinSize <= 0 {
+				column.MinSize = DefaultMinSize
+			}
+			columns[table][column.Name] = column
+		}
+	}
+	return &Client{TableStoreApi: client, columns: columns}, nil
+}
+
+func (c *Client) compress(table, name string, value interface{}) (interface{}, error) {
+	column, ok := c.columns[table][name]
+	if !ok {
+		return value, nil
+	}
+	return Compress(value, column.Codec, column.MinSize)
+}
+
+func (c *Client) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	change, err := c.compressPut(request.PutRowChange)
+	if err != nil {
+		return nil, err
+	}
+	req := *request
+	req.PutRowChange = change
+	return c.TableStoreApi.PutRow(&req)
+}
+
+func (c *Client) UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error) {
+	change, err := c.compressUpdate(request.UpdateRowChange)
+	if err != nil {
+		return nil, err
+	}
+	req := *request
+	req.UpdateRowChange = change
+	return c.TableStoreApi.UpdateRow(&req)
+}
+
+func (c *Client) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	req := *request
+	req.RowChangesGroupByTable = make(map[string][]tablestore.RowChange, len(request.RowChangesGroupByTable))
+	for table, changes := range request.RowChangesGroupByTable {
+		compressed := make([]tablestore.RowChange, len(changes))
+		for i, change := range changes {
+			var err error
+			switch change := change.(type) {
+			case *tablestore.PutRowChange:
+				compressed[i], err = c.compressPut(change)
+			case *tablestore.UpdateRowChange:
+				compressed[i], err = c.compressUpdate(change)
+			default:
+				compressed[i] = change
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		req.RowChangesGroupByTable[table] = compressed
+	}
+	return c.TableStoreApi.BatchWriteRow(&req)
+}
+
+func (c *Client) GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error) {
+	resp, err := c.TableStoreApi.GetRow(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := decompressColumns(resp.Columns); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	resp, err := c.TableStoreApi.GetRange(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range resp.Rows {
+		if err := decompressColumns(row.Columns); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) BatchGetRow(request *tablestore.BatchGetRowRequest) (*tablestore.BatchGetRowResponse, error) {
+	resp, err := c.TableStoreApi.BatchGetRow(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, results := range resp.TableToRowsResult {
+		for _, result := range results {
+			if err := decompressColumns(result.Columns); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) Search(request *tablestore.SearchRequest) (*tablestore.SearchResponse, error) {
+	resp, err := c.TableStoreApi.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	// a hit may share its row with Rows, decompress every row once: a
+	// decompressed value may itself start with a header
+	done := make(map[*tablestore.Row]bool)
+	decompress := func(row *tablestore.Row) error {
+		if row == nil || done[row] {
+			return nil
+		}
+		done[row] = true
+		return decompressColumns(row.Columns)
+	}
+	for _, row := range resp.Rows {
+		if err := decompress(row); err != nil {
+			return nil, err
+		}
+	}
+	for _, hit := range resp.SearchHits {
+		if err := decompress(hit.Row); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// compressPut returns a copy of change with its compressed columns, the
+// caller's change is left as it is.
+func (c *Client) compressPut(change *tablestore.PutRowChange) (*tablestore.PutRowChange, error) {
+	if change == nil || c.columns[change.TableName] == nil {
+		return change, nil
+	}
+	copied := *change
+	copied.Columns = make([]tablestore.AttributeColumn, len(change.Columns))
+	for i, col := range change.Columns {
+		value, err := c.compress(change.TableName, col.ColumnName, col.Value)
+		if err != nil {
+			return nil, err
+		}
+		col.Value = value
+		copied.Columns[i] = col
+	}
+	return &copied, nil
+}
+
+func (c *Client) compressUpdate(change *tablestore.UpdateRowChange) (*tablestore.UpdateRowChange, error) {
+	if change == nil || c.columns[change.TableName] == nil {
+		return change, nil
+	}
+	copied := *change
+	copied.Columns = make([]tablestore.ColumnToUpdate, len(change.Columns))
+	for i, col := range change.Columns {
+		if !col.IgnoreValue && !(col.HasType && col.Type == tablestore.INCREMENT) {
+			value, err := c.compress(change.TableName, col.ColumnName, col.Value)
+			if err != nil {
+				return nil, err
+			}
+			col.Value = value
+		}
+		copied.Columns[i] = col
+	}
+	return &copied, nil
+}
+
+func decompressColumns(columns []*tablestore.AttributeColumn) error {
+	for _, col := range columns {
+		value, err := Decompress(col.Value)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col.ColumnName, err)
+		}
+		col.Value = value
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Package compression compresses large STRING and BINARY column values.
+//
+// A compressed value is a BINARY value that starts with a small header
+// naming the codec and the type of the original value, so any reader of
+// this SDK can restore it: Decompress does it for a single value, a Client
+// for the rows it reads and a tunnel worker started with DecompressRecords
+// for the records it reads.
+// Readers that do not know the header see the raw compressed bytes.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Codec byte
+
+const (
+	Gzip Codec = 1
+	Zstd Codec = 2
+)
+
+func (c Codec) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("Codec(%d)", byte(c))
+}
+
+const (
+	// DefaultMinSize is the smallest value compressed by default, smaller
+	// values rarely shrink by more than the header adds.
+	DefaultMinSize = 1024
+	// MaxDecompressedSize bounds the size of a decompressed value.
+	MaxDecompressedSize = 256 << 20
+)
+
+var (
+	ErrInvalidConfig = errors.New("[compression] invalid config")
+	ErrCorrupted     = errors.New("[compression] corrupted value")
+)
+
+// A compressed value is laid out as
+//
+//	magic 0xff 'O' 'Z' | version 1 | codec | kind | compressed bytes
+//
+// where kind tells whether the original value was a string or binary.
+var magic = []byte{0xff, 'O', 'Z', 1}
+
+const headerSize = 6
+
+const (
+	kindBinary byte = iota
+	kindString
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdCodec returns an encoder and a decoder shared by all goroutines,
+// EncodeAll and DecodeAll are safe for concurrent use.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
+
+// Compress compresses a string or []byte value. It returns the value as it
+// is when it is of another type, shorter than minSize or does not shrink.
+func Compress(value interface{}, codec Codec, minSize int) (interface{}, error) {
+	var data []byte
+	kind := kindBinary
+	switch v := value.(type) {
+	case string:
+		data, kind = []byte(v), kindString
+	case []byte:
+		data = v
+	default:
+		return value, nil
+	}
+	if len(data) < minSize {
+		return value, nil
+	}
+
+	out := append(append([]byte{}, magic...), byte(codec), kind)
+	switch codec {
+	case Gzip:
+		var b bytes.Buffer
+		b.Write(out)
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		out = b.Bytes()
+	case Zstd:
+		encoder, _, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		out = encoder.EncodeAll(data, out)
+	default:
+		return nil, fmt.Errorf("%w: unknown codec %d", ErrInvalidConfig, byte(codec))
+	}
+	if len(out) >= len(data) {
+		return value, nil
+	}
+	return out, nil
+}
+
+// IsCompressed tells whether a value starts with the compression header.
+func IsCompressed(value interface{}) bool {
+	data, ok := value.([]byte)
+	return ok && len(data) >= headerSize && bytes.HasPrefix(data, magic)
+}
+
+// Decompress restores a value returned by Compress, other values are
+// returned as they are.
+func Decompress(value interface{}) (interface{}, error) {
+	if !IsCompressed(value) {
+		return value, nil
+	}
+	data := value.([]byte)
+	codec, kind, payload := Codec(data[len(magic)]), data[len(magic)+1], data[headerSize:]
+
+	var out []byte
+	switch codec {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		out, err = ioutil.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		if len(out) > MaxDecompressedSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", ErrCorrupted, MaxDecompressedSize)
+		}
+	case Zstd:
+		_, decoder, err := zstdCodec()
+		if err != nil {
+			return nil, err
+		}
+		if out, err = decoder.DecodeAll(payload, nil); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown codec %d", ErrCorrupted, byte(codec))
+	}
+
+	switch kind {
+	case kindString:
+		return string(out), nil
+	case kindBinary:
+		return out, nil
+	}
+	return nil, fmt.Errorf("%w: unknown value kind %d", ErrCorrupted, kind)
+}
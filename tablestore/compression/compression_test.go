@@ -0,0 +1,189 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ tablestore.TableStoreApi = (*Client)(nil)
+
+var text = strings.Repeat("the quick brown fox jumps over the lazy dog ", 100)
+
+func TestRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{Gzip, Zstd} {
+		out, err := Compress(text, codec, DefaultMinSize)
+		assert.Nil(t, err, codec.String())
+		assert.True(t, IsCompressed(out), codec.String())
+		assert.True(t, len(out.([]byte)) < len(text), codec.String())
+
+		value, err := Decompress(out)
+		assert.Nil(t, err, codec.String())
+		assert.Equal(t, text, value, codec.String())
+
+		out, err = Compress([]byte(text), codec, DefaultMinSize)
+		assert.Nil(t, err, codec.String())
+		value, err = Decompress(out)
+		assert.Nil(t, err, codec.String())
+		assert.Equal(t, []byte(text), value, codec.String())
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	out, err := Compress("short", Zstd, DefaultMinSize)
+	assert.Nil(t, err)
+	assert.Equal(t, "short", out)
+
+	out, err = Compress(int64(42), Zstd, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), out)
+
+	// random input does not shrink
+	noise := make([]byte, 2048)
+	rand.New(rand.NewSource(1)).Read(noise)
+	out, err = Compress(noise, Gzip, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, noise, out)
+
+	_, err = Compress(text, Codec(9), 0)
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
+
+func TestDecompressPassesThrough(t *testing.T) {
+	for _, value := range []interface{}{"plain", []byte("raw bytes"), int64(1), nil, []byte{0xff, 'O'}} {
+		out, err := Decompress(value)
+		assert.Nil(t, err)
+		assert.Equal(t, value, out)
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+	for _, codec := range []Codec{Gzip, Zstd} {
+		out, _ := Compress(text, codec, 0)
+		data := out.([]byte)
+		broken := append([]byte{}, data[:len(data)/2]...)
+		_, err := Decompress(broken)
+		assert.True(t, errors.Is(err, ErrCorrupted), codec.String())
+	}
+
+	unknown := append(append([]byte{}, magic...), 9, kindBinary, 1, 2, 3)
+	_, err := Decompress(unknown)
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}
+
+// fakeStore keeps the columns written last for every table, it implements
+// the calls the test uses, anything else panics on the nil embedded
+// interface.
+type fakeStore struct {
+	tablestore.TableStoreApi
+	columns map[string][]*tablestore.AttributeColumn
+}
+
+func (s *fakeStore) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	var columns []*tablestore.AttributeColumn
+	for i := range request.PutRowChange.Columns {
+		col := request.PutRowChange.Columns[i]
+		columns = append(columns, &col)
+	}
+	s.columns[request.PutRowChange.TableName] = columns
+	return &tablestore.PutRowResponse{}, nil
+}
+
+func (s *fakeStore) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	for table, changes := range request.RowChangesGroupByTable {
+		var columns []*tablestore.AttributeColumn
+		for _, change := range changes {
+			for _, col := range change.(*tablestore.UpdateRowChange).Columns {
+				columns = append(columns, &tablestore.AttributeColumn{ColumnName: col.ColumnName, Value: col.Value})
+			}
+		}
+		s.columns[table] = columns
+	}
+	return &tablestore.BatchWriteRowResponse{}, nil
+}
+
+func (s *fakeStore) GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error) {
+	var columns []*tablestore.AttributeColumn
+	for _, col := range s.columns[request.SingleRowQueryCriteria.TableName] {
+		c := *col
+		columns = append(columns, &c)
+	}
+	return &tablestore.GetRowResponse{Columns: columns}, nil
+}
+
+func (s *fakeStore) Search(request *tablestore.SearchRequest) (*tablestore.SearchResponse, error) {
+	row := &tablestore.Row{Columns: s.columns[request.TableName]}
+	return &tablestore.SearchResponse{Rows: []*tablestore.Row{row}, SearchHits: []*tablestore.SearchHit{{Row: row}}}, nil
+}
+
+func TestClientSearch(t *testing.T) {
+	// the value written starts with a header, it must come back as it was
+	inner := append(append([]byte{}, magic...), byte(Gzip), kindBinary)
+	inner = append(inner, bytes.Repeat([]byte{1}, 2048)...)
+	outer, err := Compress(inner, Gzip, 0)
+	assert.Nil(t, err)
+	store := &fakeStore{columns: map[string][]*tablestore.AttributeColumn{
+		"docs": {{ColumnName: "raw", Value: outer}},
+	}}
+	client, err := NewClient(store, Config{Columns: map[string][]Column{"docs": {{Name: "raw", Codec: Gzip}}}})
+	assert.Nil(t, err)
+
+	resp, err := client.Search(&tablestore.SearchRequest{TableName: "docs"})
+	assert.Nil(t, err)
+	assert.Equal(t, inner, resp.Rows[0].Columns[0].Value)
+	assert.Equal(t, inner, resp.SearchHits[0].Row.Columns[0].Value)
+}
+
+func TestClient(t *testing.T) {
+	_, err := NewClient(&fakeStore{}, Config{Columns: map[string][]Column{"t": {{Name: "body", Codec: Codec(7)}}}})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	store := &fakeStore{columns: make(map[string][]*tablestore.AttributeColumn)}
+	client, err := NewClient(store, Config{Columns: map[string][]Column{
+		"docs": {{Name: "body", Codec: Zstd}, {Name: "raw", Codec: Gzip, MinSize: 256}},
+	}})
+	assert.Nil(t, err)
+
+	change := &tablestore.PutRowChange{TableName: "docs"}
+	change.AddColumn("body", text)
+	change.AddColumn("raw", bytes.Repeat([]byte{1}, 512))
+	change.AddColumn("title", text)
+	change.AddColumn("small", "tiny")
+	_, err = client.PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+	assert.Nil(t, err)
+
+	// the caller's change is not modified
+	assert.Equal(t, text, change.Columns[0].Value)
+
+	stored := store.columns["docs"]
+	assert.True(t, IsCompressed(stored[0].Value))
+	assert.True(t, IsCompressed(stored[1].Value))
+	assert.Equal(t, text, stored[2].Value)
+	assert.Equal(t, "tiny", stored[3].Value)
+
+	resp, err := client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: &tablestore.SingleRowQueryCriteria{TableName: "docs"}})
+	assert.Nil(t, err)
+	assert.Equal(t, text, resp.Columns[0].Value)
+	assert.Equal(t, bytes.Repeat([]byte{1}, 512), resp.Columns[1].Value)
+	assert.Equal(t, text, resp.Columns[2].Value)
+
+	update := &tablestore.UpdateRowChange{TableName: "docs"}
+	update.PutColumn("body", text)
+	update.IncrementColumn("count", 1)
+	batch := &tablestore.BatchWriteRowRequest{}
+	batch.AddRowChange(update)
+	_, err = client.BatchWriteRow(batch)
+	assert.Nil(t, err)
+	assert.Equal(t, text, update.Columns[0].Value)
+	assert.True(t, IsCompressed(store.columns["docs"][0].Value))
+	assert.Equal(t, int64(1), store.columns["docs"][1].Value)
+
+	store.columns["docs"][0].Value = append(append([]byte{}, magic...), byte(Gzip), kindString, 1, 2, 3)
+	_, err = client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: &tablestore.SingleRowQueryCriteria{TableName: "docs"}})
+	assert.True(t, errors.Is(err, ErrCorrupted))
+}
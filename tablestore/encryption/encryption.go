@@ -0,0 +1,264 @@
+// Package encryption encrypts attribute columns on the client before they
+// are sent to Tablestore.
+//
+// A Client wraps a tablestore.TableStoreApi. The configured columns of
+// PutRow, UpdateRow and BatchWriteRow requests are sealed with AES-GCM and
+// stored as BINARY values, GetRow, GetRange, BatchGetRow and Search
+// responses come back decrypted with their original types. Every other
+// call goes to the wrapped client unchanged.
+//
+// Values are encrypted with data keys from a KeyProvider. Each value
+// carries its data key wrapped with a master key and the id of that key,
+// so values written before a master key rotation stay readable as long as
+// the provider still knows the old key.
+//
+// Encrypted columns cannot be compared on the server: conditions, filters
+// and search queries on them see ciphertext. Primary key columns are never
+// encrypted, but each value is bound to its table, primary key and column:
+// a value copied elsewhere does not decrypt. Rows with encrypted columns
+// cannot be written with an auto increment primary key column, whose
+// value is not known before the write.
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+var (
+	ErrInvalidConfig = errors.New("[encryption] invalid config")
+	ErrInvalidKey    = errors.New("[encryption] invalid key")
+	ErrEncrypt       = errors.New("[encryption] cannot encrypt")
+	ErrDecrypt       = errors.New("[encryption] cannot decrypt")
+)
+
+// DefaultDataKeyMaxAge is how long a data key encrypts values before the
+// client asks the KeyProvider for a new one.
+const DefaultDataKeyMaxAge = 10 * time.Minute
+
+type Config struct {
+	KeyProvider KeyProvider
+	// Columns lists the encrypted attribute columns by table name.
+	Columns map[string][]string
+	// DataKeyMaxAge bounds the use of a data key, and so how long writes
+	// keep using the previous master key after a rotation.
+	DataKeyMaxAge time.Duration
+}
+
+type Client struct {
+	tablestore.TableStoreApi
+
+	columns map[string]map[string]bool
+	keys    *keyring
+}
+
+// NewClient wraps client, usually a *tablestore.TableStoreClient.
+func NewClient(client tablestore.TableStoreApi, config Config) (*Client, error) {
+	if client == nil || config.KeyProvider == nil {
+		return nil, fmt.Errorf("%w: client and key provider are required", ErrInvalidConfig)
+	}
+	if config.DataKeyMaxAge <= 0 {
+		config.DataKeyMaxAge = DefaultDataKeyMaxAge
+	}
+	columns := make(map[string]map[string]bool)
+	for table, names := range config.Columns {
+		columns[table] = make(map[string]bool)
+		for _, name := range names {
+			columns[table][name] = true
+		}
+	}
+	return &Client{
+		TableStoreApi: client,
+		columns:       columns,
+		keys:          newKeyring(config.KeyProvider, config.DataKeyMaxAge),
+	}, nil
+}
+
+func (c *Client) encrypted(table, column string) bool {
+	return c.columns[table][column]
+}
+
+func (c *Client) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	change, err := c.encryptPut(request.PutRowChange)
+	if err != nil {
+		return nil, err
+	}
+	req := *request
+	req.PutRowChange = change
+	return c.TableStoreApi.PutRow(&req)
+}
+
+func (c *Client) UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error) {
+	change, err := c.encryptUpdate(request.UpdateRowChange)
+	if err != nil {
+		return nil, err
+	}
+	req := *request
+	req.UpdateRowChange = change
+	resp, err := c.TableStoreApi.UpdateRow(&req)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.decryptColumns(change.TableName, change.PrimaryKey, resp.Columns); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	req := *request
+	req.RowChangesGroupByTable = make(map[string][]tablestore.RowChange, len(request.RowChangesGroupByTable))
+	for table, changes := range request.RowChangesGroupByTable {
+		encrypted := make([]tablestore.RowChange, len(changes))
+		for i, change := range changes {
+			var err error
+			switch change := change.(type) {
+			case *tablestore.PutRowChange:
+				encrypted[i], err = c.encryptPut(change)
+			case *tablestore.UpdateRowChange:
+				encrypted[i], err = c.encryptUpdate(change)
+			default:
+				encrypted[i] = change
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		req.RowChangesGroupByTable[table] = encrypted
+	}
+	return c.TableStoreApi.BatchWriteRow(&req)
+}
+
+func (c *Client) GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error) {
+	resp, err := c.TableStoreApi.GetRow(request)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.decryptColumns(request.SingleRowQueryCriteria.TableName, &resp.PrimaryKey, resp.Columns); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	resp, err := c.TableStoreApi.GetRange(request)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range resp.Rows {
+		if err := c.decryptColumns(request.RangeRowQueryCriteria.TableName, row.PrimaryKey, row.Columns); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) BatchGetRow(request *tablestore.BatchGetRowRequest) (*tablestore.BatchGetRowResponse, error) {
+	resp, err := c.TableStoreApi.BatchGetRow(request)
+	if err != nil {
+		return nil, err
+	}
+	for table, results := range resp.TableToRowsResult {
+		for _, result := range results {
+			if err := c.decryptColumns(table, &result.PrimaryKey, result.Columns); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) Search(request *tablestore.SearchRequest) (*tablestore.SearchResponse, error) {
+	resp, err := c.TableStoreApi.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	// a hit may share its row with Rows, decrypt every row once
+	done := make(map[*tablestore.Row]bool)
+	decrypt := func(row *tablestore.Row) error {
+		if row == nil || done[row] {
+			return nil
+		}
+		done[row] = true
+		return c.decryptColumns(request.TableName, row.PrimaryKey, row.Columns)
+	}
+	for _, row := range resp.Rows {
+		if err := decrypt(row); err != nil {
+			return nil, err
+		}
+	}
+	for _, hit := range resp.SearchHits {
+		if err := decrypt(hit.Row); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// encryptPut returns a copy of change with its encrypted columns sealed,
+// the caller's change is left as it is.
+func (c *Client) encryptPut(change *tablestore.PutRowChange) (*tablestore.PutRowChange, error) {
+	if change == nil || c.columns[change.TableName] == nil {
+		return change, nil
+	}
+	copied := *change
+	copied.Columns = make([]tablestore.AttributeColumn, len(change.Columns))
+	for i, col := range change.Columns {
+		if c.encrypted(change.TableName, col.ColumnName) {
+			value, err := c.keys.encrypt(change.TableName, change.PrimaryKey, col.ColumnName, col.Value)
+			if err != nil {
+				return nil, err
+			}
+			col.Value = value
+		}
+		copied.Columns[i] = col
+	}
+	return &copied, nil
+}
+
+func (c *Client) encryptUpdate(change *tablestore.UpdateRowChange) (*tablestore.UpdateRowChange, error) {
+	if change == nil || c.columns[change.TableName] == nil {
+		return change, nil
+	}
+	copied := *change
+	copied.Columns = make([]tablestore.ColumnToUpdate, len(change.Columns))
+	for i, col := range change.Columns {
+		if c.encrypted(change.TableName, col.ColumnName) {
+			switch {
+			case col.HasType && col.Type == tablestore.INCREMENT:
+				return nil, fmt.Errorf("%w: column %s: cannot increment an encrypted column", ErrEncrypt, col.ColumnName)
+			case !col.IgnoreValue:
+				value, err := c.keys.encrypt(change.TableName, change.PrimaryKey, col.ColumnName, col.Value)
+				if err != nil {
+					return nil, err
+				}
+				col.Value = value
+			}
+		}
+		copied.Columns[i] = col
+	}
+	return &copied, nil
+}
+
+// decryptColumns decrypts the encrypted columns in place. Values of these
+// columns that were written in plain, before the column was encrypted, are
+// returned as they are.
+func (c *Client) decryptColumns(table string, pk *tablestore.PrimaryKey, columns []*tablestore.AttributeColumn) error {
+	if c.columns[table] == nil {
+		return nil
+	}
+	for _, col := range columns {
+		if !c.encrypted(table, col.ColumnName) || !IsEncrypted(col.Value) {
+			continue
+		}
+		value, err := c.keys.decrypt(table, pk, col.ColumnName, col.Value.([]byte))
+		if err != nil {
+			return err
+		}
+		col.Value = value
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package encryption
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ tablestore.TableStoreApi = (*Client)(nil)
+	_ KeyProvider              = (*LocalKeyProvider)(nil)
+)
+
+// fakeStore keeps the latest version of the rows of any table. It
+// implements the calls the Client wraps, anything else panics on the nil
+// embedded interface.
+type fakeStore struct {
+	tablestore.TableStoreApi
+	rows map[string]*tablestore.Row
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{rows: make(map[string]*tablestore.Row)}
+}
+
+func rowKey(table string, pk *tablestore.PrimaryKey) string {
+	key := table
+	for _, col := range pk.PrimaryKeys {
+		key += fmt.Sprintf("/%v", col.Value)
+	}
+	return key
+}
+
+func (s *fakeStore) put(change *tablestore.PutRowChange) {
+	row := &tablestore.Row{PrimaryKey: change.PrimaryKey}
+	for i := range change.Columns {
+		col := change.Columns[i]
+		row.Columns = append(row.Columns, &col)
+	}
+	s.rows[rowKey(change.TableName, change.PrimaryKey)] = row
+}
+
+func (s *fakeStore) update(change *tablestore.UpdateRowChange) {
+	key := rowKey(change.TableName, change.PrimaryKey)
+	row, ok := s.rows[key]
+	if !ok {
+		row = &tablestore.Row{PrimaryKey: change.PrimaryKey}
+		s.rows[key] = row
+	}
+	for _, col := range change.Columns {
+		var kept []*tablestore.AttributeColumn
+		for _, c := range row.Columns {
+			if c.ColumnName != col.ColumnName {
+				kept = append(kept, c)
+			}
+		}
+		if !col.IgnoreValue {
+			kept = append(kept, &tablestore.AttributeColumn{ColumnName: col.ColumnName, Value: col.Value})
+		}
+		row.Columns = kept
+	}
+}
+
+// get returns a copy, as a response decoded from the wire would be.
+func (s *fakeStore) get(table string, pk *tablestore.PrimaryKey) *tablestore.Row {
+	row, ok := s.rows[rowKey(table, pk)]
+	if !ok {
+		return nil
+	}
+	copied := &tablestore.Row{PrimaryKey: row.PrimaryKey}
+	for _, col := range row.Columns {
+		c := *col
+		copied.Columns = append(copied.Columns, &c)
+	}
+	return copied
+}
+
+func (s *fakeStore) PutRow(request *tablestore.PutRowRequest) (*tablestore.PutRowResponse, error) {
+	s.put(request.PutRowChange)
+	return new(tablestore.PutRowResponse), nil
+}
+
+func (s *fakeStore) UpdateRow(request *tablestore.UpdateRowRequest) (*tablestore.UpdateRowResponse, error) {
+	s.update(request.UpdateRowChange)
+	return new(tablestore.UpdateRowResponse), nil
+}
+
+func (s *fakeStore) BatchWriteRow(request *tablestore.BatchWriteRowRequest) (*tablestore.BatchWriteRowResponse, error) {
+	for _, changes := range request.RowChangesGroupByTable {
+		for _, change := range changes {
+			switch change := change.(type) {
+			case *tablestore.PutRowChange:
+				s.put(change)
+			case *tablestore.UpdateRowChange:
+				s.update(change)
+			}
+		}
+	}
+	return new(tablestore.BatchWriteRowResponse), nil
+}
+
+func (s *fakeStore) GetRow(request *tablestore.GetRowRequest) (*tablestore.GetRowResponse, error) {
+	criteria := request.SingleRowQueryCriteria
+	resp := new(tablestore.GetRowResponse)
+	if row := s.get(criteria.TableName, criteria.PrimaryKey); row != nil {
+		resp.PrimaryKey = *row.PrimaryKey
+		resp.Columns = row.Columns
+	}
+	return resp, nil
+}
+
+func (s *fakeStore) GetRange(request *tablestore.GetRangeRequest) (*tablestore.GetRangeResponse, error) {
+	// the start key is the only row returned
+	criteria := request.RangeRowQueryCriteria
+	resp := new(tablestore.GetRangeResponse)
+	if row := s.get(criteria.TableName, criteria.StartPrimaryKey); row != nil {
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp, nil
+}
+
+func (s *fakeStore) BatchGetRow(request *tablestore.BatchGetRowRequest) (*tablestore.BatchGetRowResponse, error) {
+	resp := &tablestore.BatchGetRowResponse{TableToRowsResult: make(map[string][]tablestore.RowResult)}
+	for _, criteria := range request.MultiRowQueryCriteria {
+		for i, pk := range criteria.PrimaryKey {
+			result := tablestore.RowResult{TableName: criteria.TableName, IsSucceed: true, Index: int32(i)}
+			if row := s.get(criteria.TableName, pk); row != nil {
+				result.PrimaryKey = *row.PrimaryKey
+				result.Columns = row.Columns
+			}
+			resp.TableToRowsResult[criteria.TableName] = append(resp.TableToRowsResult[criteria.TableName], result)
+		}
+	}
+	return resp, nil
+}
+
+// Search returns every row of the table, each both in Rows and in a hit.
+func (s *fakeStore) Search(request *tablestore.SearchRequest) (*tablestore.SearchResponse, error) {
+	resp := new(tablestore.SearchResponse)
+	for key, row := range s.rows {
+		if key != rowKey(request.TableName, row.PrimaryKey) {
+			continue
+		}
+		copied := s.get(request.TableName, row.PrimaryKey)
+		resp.Rows = append(resp.Rows, copied)
+		resp.SearchHits = append(resp.SearchHits, &tablestore.SearchHit{Row: copied})
+	}
+	return resp, nil
+}
+
+func primaryKey(id int64) *tablestore.PrimaryKey {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn("id", id)
+	return pk
+}
+
+func columnValues(columns []*tablestore.AttributeColumn) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, col := range columns {
+		values[col.ColumnName] = col.Value
+	}
+	return values
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "encryption")
+	assert.Nil(t, err)
+	return dir
+}
+
+func newTestClient(t *testing.T, dir string) (*Client, *fakeStore, *LocalKeyProvider) {
+	provider, err := NewLocalKeyProvider(filepath.Join(dir, "keys.json"))
+	assert.Nil(t, err)
+	store := newFakeStore()
+	client, err := NewClient(store, Config{
+		KeyProvider: provider,
+		Columns:     map[string][]string{"users": {"email", "salary", "verified", "photo", "age"}},
+	})
+	assert.Nil(t, err)
+	return client, store, provider
+}
+
+func getRow(t *testing.T, client tablestore.TableStoreApi, id int64) map[string]interface{} {
+	resp, err := client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: &tablestore.SingleRowQueryCriteria{
+		TableName: "users", PrimaryKey: primaryKey(id), MaxVersion: 1,
+	}})
+	assert.Nil(t, err)
+	return columnValues(resp.Columns)
+}
+
+func TestPutAndGetRow(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	client, store, _ := newTestClient(t, dir)
+	change := &tablestore.PutRowChange{TableName: "users", PrimaryKey: primaryKey(1)}
+	change.AddColumn("name", "Ann")
+	change.AddColumn("email", "ann@example.com")
+	change.AddColumn("salary", 1234.5)
+	change.AddColumn("verified", true)
+	change.AddColumn("photo", []byte{1, 2, 3})
+	change.AddColumn("age", int64(30))
+	change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+	_, err := client.PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+	assert.Nil(t, err)
+
+	// the request of the caller keeps the plain values
+	assert.Equal(t, "ann@example.com", change.Columns[1].Value)
+
+	stored := columnValues(store.rows["users/1"].Columns)
+	assert.Equal(t, "Ann", stored["name"])
+	for _, name := range []string{"email", "salary", "verified", "photo", "age"} {
+		assert.True(t, IsEncrypted(stored[name]), name)
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"name":     "Ann",
+		"email":    "ann@example.com",
+		"salary":   1234.5,
+		"verified": true,
+		"photo":    []byte{1, 2, 3},
+		"age":      int64(30),
+	}, getRow(t, client, 1))
+}
+
+func TestUpdateRow(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	client, store, _ := newTestClient(t, dir)
+	change := &tablestore.UpdateRowChange{TableName: "users", PrimaryKey: primaryKey(1)}
+	change.PutColumn("email", "a@example.com")
+	change.PutColumn("name", "Ann")
+	change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+	_, err := client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change})
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(columnValues(store.rows["users/1"].Columns)["email"]))
+	assert.Equal(t, map[string]interface{}{"name": "Ann", "email": "a@example.com"}, getRow(t, client, 1))
+
+	change = &tablestore.UpdateRowChange{TableName: "users", PrimaryKey: primaryKey(1)}
+	change.DeleteColumn("email")
+	change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+	_, err = client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "Ann"}, getRow(t, client, 1))
+
+	change = &tablestore.UpdateRowChange{TableName: "users", PrimaryKey: primaryKey(1)}
+	change.IncrementColumn("age", 1)
+	_, err = client.UpdateRow(&tablestore.UpdateRowRequest{UpdateRowChange: change})
+	assert.True(t, errors.Is(err, ErrEncrypt))
+}
+
+func TestBatchAndReads(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	client, store, _ := newTestClient(t, dir)
+	request := new(tablestore.BatchWriteRowRequest)
+	for id := int64(1); id <= 2; id++ {
+		change := &tablestore.PutRowChange{TableName: "users", PrimaryKey: primaryKey(id)}
+		change.AddColumn("email", fmt.Sprintf("%d@example.com", id))
+		change.SetCondition(tablestore.RowExistenceExpectation_IGNORE)
+		request.AddRowChange(change)
+	}
+	other := &tablestore.PutRowChange{TableName: "orders", PrimaryKey: primaryKey(1)}
+	other.AddColumn("email", "plain@example.com")
+	request.AddRowChange(other)
+	_, err := client.BatchWriteRow(request)
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(store.rows["users/2"].Columns[0].Value))
+	assert.Equal(t, "plain@example.com", store.rows["orders/1"].Columns[0].Value)
+
+	criteria := &tablestore.MultiRowQueryCriteria{TableName: "users", MaxVersion: 1}
+	criteria.AddRow(primaryKey(1))
+	criteria.AddRow(primaryKey(2))
+	batch, err := client.BatchGetRow(&tablestore.BatchGetRowRequest{MultiRowQueryCriteria: []*tablestore.MultiRowQueryCriteria{criteria}})
+	assert.Nil(t, err)
+	assert.Equal(t, "2@example.com", batch.TableToRowsResult["users"][1].Columns[0].Value)
+
+	rng, err := client.GetRange(&tablestore.GetRangeRequest{RangeRowQueryCriteria: &tablestore.RangeRowQueryCriteria{
+		TableName: "users", StartPrimaryKey: primaryKey(1), MaxVersion: 1,
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "1@example.com", rng.Rows[0].Columns[0].Value)
+
+	search, err := client.Search(&tablestore.SearchRequest{TableName: "users"})
+	assert.Nil(t, err)
+	assert.Len(t, search.Rows, 2)
+	for i, row := range search.Rows {
+		assert.False(t, IsEncrypted(row.Columns[0].Value))
+		assert.Equal(t, row.Columns[0].Value, search.SearchHits[i].Row.Columns[0].Value)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	provider, err := NewLocalKeyProvider(path)
+	assert.Nil(t, err)
+	first := provider.CurrentKeyID()
+	store := newFakeStore()
+	client, err := NewClient(store, Config{
+		KeyProvider:   provider,
+		Columns:       map[string][]string{"users": {"email"}},
+		DataKeyMaxAge: time.Nanosecond,
+	})
+	assert.Nil(t, err)
+
+	put := func(id int64, email string) {
+		change := &tablestore.PutRowChange{TableName: "users", PrimaryKey: primaryKey(id)}
+		change.AddColumn("email", email)
+		_, err := client.PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+		assert.Nil(t, err)
+	}
+	put(1, "old@example.com")
+	second, err := provider.Rotate()
+	assert.Nil(t, err)
+	assert.NotEqual(t, first, second)
+	put(2, "new@example.com")
+
+	keyID, ok := KeyID(store.rows["users/1"].Columns[0].Value.([]byte))
+	assert.True(t, ok)
+	assert.Equal(t, first, keyID)
+	keyID, _ = KeyID(store.rows["users/2"].Columns[0].Value.([]byte))
+	assert.Equal(t, second, keyID)
+
+	// a provider loaded from the file reads values of both keys
+	reloaded, err := NewLocalKeyProvider(path)
+	assert.Nil(t, err)
+	assert.Equal(t, second, reloaded.CurrentKeyID())
+	client, err = NewClient(store, Config{KeyProvider: reloaded, Columns: map[string][]string{"users": {"email"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, "old@example.com", getRow(t, client, 1)["email"])
+	assert.Equal(t, "new@example.com", getRow(t, client, 2)["email"])
+
+	// without the old key the old value does not decrypt
+	otherDir := tempDir(t)
+	defer os.RemoveAll(otherDir)
+	other, err := NewLocalKeyProvider(filepath.Join(otherDir, "keys.json"))
+	assert.Nil(t, err)
+	client, err = NewClient(store, Config{KeyProvider: other, Columns: map[string][]string{"users": {"email"}}})
+	assert.Nil(t, err)
+	_, err = client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: &tablestore.SingleRowQueryCriteria{
+		TableName: "users", PrimaryKey: primaryKey(1), MaxVersion: 1,
+	}})
+	assert.True(t, errors.Is(err, ErrDecrypt))
+}
+
+func TestDecryptChecks(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	client, store, _ := newTestClient(t, dir)
+	change := &tablestore.PutRowChange{TableName: "users", PrimaryKey: primaryKey(1)}
+	change.AddColumn("email", "a@example.com")
+	_, err := client.PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+	assert.Nil(t, err)
+
+	// a value moved to another encrypted column does not decrypt
+	store.rows["users/1"].Columns[0].ColumnName = "photo"
+	_, err = client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: &tablestore.SingleRowQueryCriteria{
+		TableName: "users", PrimaryKey: primaryKey(1), MaxVersion: 1,
+	}})
+	assert.True(t, errors.Is(err, ErrDecrypt))
+
+	// nor does a value copied to another row
+	store.rows["users/1"].Columns[0].ColumnName = "email"
+	store.put(&tablestore.PutRowChange{TableName: "users", PrimaryKey: primaryKey(2), Columns: []tablestore.AttributeColumn{*store.rows["users/1"].Columns[0]}})
+	_, err = client.GetRow(&tablestore.GetRowRequest{SingleRowQueryCriteria: &tablestore.SingleRowQueryCriteria{
+		TableName: "users", PrimaryKey: primaryKey(2), MaxVersion: 1,
+	}})
+	assert.True(t, errors.Is(err, ErrDecrypt))
+
+	// a value sealed for the column name only, under another version, is
+	// not decrypted
+	s, err := client.keys.sealer()
+	assert.Nil(t, err)
+	v1 := append([]byte{}, s.header...)
+	v1[len(magic)] = 1
+	nonce := make([]byte, s.aead.NonceSize())
+	v1 = s.aead.Seal(append(v1, nonce...), nonce, []byte("\x00old@example.com"), []byte("email"))
+	assert.False(t, IsEncrypted(v1))
+	store.rows["users/2"].Columns[0].Value = v1
+	assert.Equal(t, v1, getRow(t, client, 2)["email"])
+
+	// auto increment primary keys have no value to bind on write
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumnWithAutoIncrement("id")
+	change = &tablestore.PutRowChange{TableName: "users", PrimaryKey: pk}
+	change.AddColumn("email", "b@example.com")
+	_, err = client.PutRow(&tablestore.PutRowRequest{PutRowChange: change})
+	assert.True(t, errors.Is(err, ErrEncrypt))
+
+	// values written before encryption was turned on read as they are
+	store.rows["users/1"].Columns[0] = &tablestore.AttributeColumn{ColumnName: "email", Value: "legacy@example.com"}
+	assert.Equal(t, "legacy@example.com", getRow(t, client, 1)["email"])
+
+	_, ok := KeyID([]byte("OE\x02\x09short"))
+	assert.False(t, ok)
+}
+
+func TestLocalKeyProviderErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"current":"a","keys":{"a":"AQID"}}`), 0600))
+	_, err := NewLocalKeyProvider(path)
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`{"current":"b","keys":{}}`), 0600))
+	_, err = NewLocalKeyProvider(path)
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+
+	assert.Nil(t, ioutil.WriteFile(path, []byte(`not json`), 0600))
+	_, err = NewLocalKeyProvider(path)
+	assert.True(t, errors.Is(err, ErrInvalidKey))
+
+	_, err = NewClient(newFakeStore(), Config{})
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+}
@@ -0,0 +1,278 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+)
+
+// An encrypted value is a BINARY column laid out as
+//
+//	magic "OE" | version | key id length (1 byte) | key id |
+//	wrapped data key length (2 bytes) | wrapped data key | nonce | ciphertext
+//
+// The plaintext is a type byte followed by the original value, so values
+// of any column type come back with their type. The additional data of
+// the seal binds the value to where it was written, the table name, the
+// primary key and the column name, so a value copied to another column or
+// another row does not decrypt.
+var magic = []byte{'O', 'E'}
+
+const version byte = 2
+
+const (
+	typeString byte = iota
+	typeInteger
+	typeDouble
+	typeBoolean
+	typeBinary
+)
+
+// maxUnwrappedKeys bounds the cache of data keys read back from values.
+const maxUnwrappedKeys = 1024
+
+// keyring encrypts with a data key it renews after maxAge and caches the
+// data keys unwrapped to decrypt.
+type keyring struct {
+	provider KeyProvider
+	maxAge   time.Duration
+
+	mu        sync.Mutex
+	current   *sealer
+	unwrapped map[string]cipher.AEAD
+}
+
+type sealer struct {
+	header  []byte
+	aead    cipher.AEAD
+	created time.Time
+}
+
+func newKeyring(provider KeyProvider, maxAge time.Duration) *keyring {
+	return &keyring{provider: provider, maxAge: maxAge, unwrapped: make(map[string]cipher.AEAD)}
+}
+
+func (k *keyring) sealer() (*sealer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.current != nil && time.Since(k.current.created) < k.maxAge {
+		return k.current, nil
+	}
+	key, err := k.provider.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(key.KeyID) > math.MaxUint8 || len(key.Wrapped) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: key id or wrapped data key too long", ErrInvalidKey)
+	}
+	aead, err := newAEAD(key.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{}, magic...)
+	header = append(header, version, byte(len(key.KeyID)))
+	header = append(header, key.KeyID...)
+	header = append(header, byte(len(key.Wrapped)>>8), byte(len(key.Wrapped)))
+	header = append(header, key.Wrapped...)
+	k.current = &sealer{header: header, aead: aead, created: time.Now()}
+	return k.current, nil
+}
+
+func (k *keyring) opener(keyID string, wrapped []byte) (cipher.AEAD, error) {
+	cacheKey := keyID + "\x00" + string(wrapped)
+	k.mu.Lock()
+	aead, ok := k.unwrapped[cacheKey]
+	k.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+	plaintext, err := k.provider.DecryptDataKey(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = newAEAD(plaintext); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	if len(k.unwrapped) >= maxUnwrappedKeys {
+		k.unwrapped = make(map[string]cipher.AEAD)
+	}
+	k.unwrapped[cacheKey] = aead
+	k.mu.Unlock()
+	return aead, nil
+}
+
+func (k *keyring) encrypt(table string, pk *tablestore.PrimaryKey, column string, value interface{}) ([]byte, error) {
+	plaintext, err := encodeValue(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", ErrEncrypt, column, err)
+	}
+	additionalData, err := sealedFor(table, pk, column)
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", ErrEncrypt, column, err)
+	}
+	s, err := k.sealer()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(s.header), len(s.header)+s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	copy(out, s.header)
+	nonce := out[len(out) : len(out)+s.aead.NonceSize()]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = out[:len(out)+len(nonce)]
+	return s.aead.Seal(out, nonce, plaintext, additionalData), nil
+}
+
+func (k *keyring) decrypt(table string, pk *tablestore.PrimaryKey, column string, data []byte) (interface{}, error) {
+	keyID, wrapped, sealed, ok := parseHeader(data)
+	if !ok {
+		return nil, fmt.Errorf("%w: column %s: malformed value", ErrDecrypt, column)
+	}
+	additionalData, err := sealedFor(table, pk, column)
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", ErrDecrypt, column, err)
+	}
+	aead, err := k.opener(keyID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", ErrDecrypt, column, err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: column %s: malformed value", ErrDecrypt, column)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", ErrDecrypt, column, err)
+	}
+	value, err := decodeValue(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: column %s: %v", ErrDecrypt, column, err)
+	}
+	return value, nil
+}
+
+// IsEncrypted tells whether a column value was written by the encryption
+// layer.
+func IsEncrypted(value interface{}) bool {
+	data, ok := value.([]byte)
+	return ok && len(data) > len(magic) && bytes.HasPrefix(data, magic) && data[len(magic)] == version
+}
+
+// KeyID returns the id of the master key an encrypted value was written
+// with. Rewriting the values of retired keys through a Client encrypts
+// them with the current key.
+func KeyID(value []byte) (string, bool) {
+	keyID, _, _, ok := parseHeader(value)
+	return keyID, ok
+}
+
+func parseHeader(data []byte) (keyID string, wrapped, sealed []byte, ok bool) {
+	if !IsEncrypted(data) {
+		return "", nil, nil, false
+	}
+	data = data[len(magic)+1:]
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", nil, nil, false
+	}
+	keyID, data = string(data[1:1+int(data[0])]), data[1+int(data[0]):]
+	if len(data) < 2 {
+		return "", nil, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return "", nil, nil, false
+	}
+	return keyID, data[2 : 2+n], data[2+n:], true
+}
+
+// sealedFor returns the additional data of a value: the table name, the
+// primary key and the column name, each length prefixed.
+func sealedFor(table string, pk *tablestore.PrimaryKey, column string) ([]byte, error) {
+	if pk == nil || len(pk.PrimaryKeys) == 0 {
+		return nil, fmt.Errorf("no primary key")
+	}
+	b := appendField(nil, []byte(table))
+	for _, col := range pk.PrimaryKeys {
+		b = appendField(b, []byte(col.ColumnName))
+		switch v := col.Value.(type) {
+		case string:
+			b = appendField(append(b, typeString), []byte(v))
+		case int64:
+			b = appendField(append(b, typeInteger), []byte(strconv.FormatInt(v, 10)))
+		case []byte:
+			b = appendField(append(b, typeBinary), v)
+		default:
+			// auto increment columns have no value until the row is written
+			return nil, fmt.Errorf("primary key column %s has no value to bind", col.ColumnName)
+		}
+	}
+	return appendField(b, []byte(column)), nil
+}
+
+func appendField(b, field []byte) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(field)))
+	return append(append(b, n[:]...), field...)
+}
+
+func encodeValue(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case string:
+		return append([]byte{typeString}, v...), nil
+	case int64:
+		b := make([]byte, 9)
+		b[0] = typeInteger
+		binary.BigEndian.PutUint64(b[1:], uint64(v))
+		return b, nil
+	case float64:
+		b := make([]byte, 9)
+		b[0] = typeDouble
+		binary.BigEndian.PutUint64(b[1:], math.Float64bits(v))
+		return b, nil
+	case bool:
+		if v {
+			return []byte{typeBoolean, 1}, nil
+		}
+		return []byte{typeBoolean, 0}, nil
+	case []byte:
+		return append([]byte{typeBinary}, v...), nil
+	}
+	return nil, fmt.Errorf("unsupported value type %T", value)
+}
+
+func decodeValue(b []byte) (interface{}, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty plaintext")
+	}
+	payload := b[1:]
+	switch b[0] {
+	case typeString:
+		return string(payload), nil
+	case typeInteger, typeDouble:
+		if len(payload) != 8 {
+			return nil, fmt.Errorf("bad number length %d", len(payload))
+		}
+		u := binary.BigEndian.Uint64(payload)
+		if b[0] == typeInteger {
+			return int64(u), nil
+		}
+		return math.Float64frombits(u), nil
+	case typeBoolean:
+		if len(payload) != 1 {
+			return nil, fmt.Errorf("bad boolean length %d", len(payload))
+		}
+		return payload[0] == 1, nil
+	case typeBinary:
+		return append([]byte{}, payload...), nil
+	}
+	return nil, fmt.Errorf("unknown value type %d", b[0])
+}
@@ -0,0 +1,193 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DataKey is a key values are encrypted with. Wrapped is Plaintext
+// encrypted with the master key KeyID, it is stored with each value so the
+// data key can be recovered from the master key when reading.
+type DataKey struct {
+	KeyID     string
+	Plaintext []byte
+	Wrapped   []byte
+}
+
+// KeyProvider holds the master keys. Implementations may keep them in a
+// KMS and must be safe for concurrent use.
+type KeyProvider interface {
+	// GenerateDataKey returns a new 32 byte data key wrapped with the
+	// current master key.
+	GenerateDataKey() (*DataKey, error)
+	// DecryptDataKey unwraps a data key wrapped with the master key keyID,
+	// which need not be the current one.
+	DecryptDataKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKeyProvider keeps master keys in a JSON file such as
+//
+//	{"current": "7f3a9c01", "keys": {"7f3a9c01": "<base64 of 32 bytes>"}}
+//
+// Rotate adds a key and makes it current. Keys are never removed, values
+// written with an old key stay readable.
+type LocalKeyProvider struct {
+	path string
+
+	mu   sync.RWMutex
+	file keyFile
+}
+
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+// NewLocalKeyProvider loads the keys in path. A missing file is created
+// with a new key.
+func NewLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{path: path}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		p.file.Keys = make(map[string][]byte)
+		if _, err := p.Rotate(); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.file); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, path, err)
+	}
+	if len(p.file.Keys[p.file.Current]) == 0 {
+		return nil, fmt.Errorf("%w: %s: current key %q not found", ErrInvalidKey, path, p.file.Current)
+	}
+	for id, key := range p.file.Keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("%w: %s: key %q is %d bytes, want 32", ErrInvalidKey, path, id, len(key))
+		}
+	}
+	return p, nil
+}
+
+// Rotate generates a master key, makes it the current one and saves the
+// file. It returns the id of the new key.
+func (p *LocalKeyProvider) Rotate() (string, error) {
+	id := make([]byte, 4)
+	key := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	keyID := hex.EncodeToString(id)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make(map[string][]byte, len(p.file.Keys)+1)
+	for k, v := range p.file.Keys {
+		keys[k] = v
+	}
+	keys[keyID] = key
+	file := keyFile{Current: keyID, Keys: keys}
+	if err := saveKeyFile(p.path, &file); err != nil {
+		return "", err
+	}
+	p.file = file
+	return keyID, nil
+}
+
+// CurrentKeyID returns the id of the key new data keys are wrapped with.
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.file.Current
+}
+
+func (p *LocalKeyProvider) GenerateDataKey() (*DataKey, error) {
+	p.mu.RLock()
+	keyID, master := p.file.Current, p.file.Keys[p.file.Current]
+	p.mu.RUnlock()
+
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrapped := aead.Seal(nonce, nonce, plaintext, []byte(keyID))
+	return &DataKey{KeyID: keyID, Plaintext: plaintext, Wrapped: wrapped}, nil
+}
+
+func (p *LocalKeyProvider) DecryptDataKey(keyID string, wrapped []byte) ([]byte, error) {
+	p.mu.RLock()
+	master, ok := p.file.Keys[keyID]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: master key %q not found", ErrInvalidKey, keyID)
+	}
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: wrapped data key too short", ErrInvalidKey)
+	}
+	nonce, ciphertext := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("%w: cannot unwrap data key with master key %q", ErrInvalidKey, keyID)
+	}
+	return plaintext, nil
+}
+
+// saveKeyFile replaces the file through a rename so a crash does not leave
+// a truncated key file behind.
+func saveKeyFile(path string, file *keyFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,398 @@
+package tablestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+const (
+	defaultHybridSearchLimit      = 10
+	defaultHybridSearchCandidates = 50
+	// defaultRRFK is the k of reciprocal rank fusion, the value the method
+	// was proposed with
+	defaultRRFK = 60
+)
+
+var (
+	errInvalidHybridSearch = func(format string, args ...interface{}) error {
+		return errors.New("[tablestore] invalid hybrid search request: " + fmt.Sprintf(format, args...))
+	}
+)
+
+// Embedder turns the text of a hybrid search into the vector of its KNN
+// query. The vector has to have the dimension of the vector field.
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+// HashEmbedder is a deterministic Embedder for tests. It hashes every word
+// of the text, lower cased, to one of Dimension buckets and normalizes the
+// counts to a unit vector, so texts sharing words have close vectors. It
+// knows nothing of meaning and is no substitute for a model.
+type HashEmbedder struct {
+	Dimension int
+}
+
+func NewHashEmbedder(dimension int) *HashEmbedder {
+	return &HashEmbedder{Dimension: dimension}
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if e.Dimension <= 0 {
+		return nil, errors.New("[tablestore] hash embedder dimension must be positive")
+	}
+	vector := make([]float32, e.Dimension)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		sum := h.Sum32()
+		if sum&1 == 0 {
+			vector[(sum>>1)%uint32(e.Dimension)]++
+		} else {
+			vector[(sum>>1)%uint32(e.Dimension)]--
+		}
+	}
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range vector {
+			vector[i] = float32(float64(vector[i]) / norm)
+		}
+	}
+	return vector, nil
+}
+
+type FusionMethod int
+
+const (
+	// FusionRRF scores a hit by reciprocal rank fusion, the sum over the
+	// sources that returned it of weight / (RRFK + rank). It only uses the
+	// ranks, so scores on different scales need no care.
+	FusionRRF FusionMethod = iota
+	// FusionWeighted scales the scores of each source to [0, 1], the lowest
+	// candidate of the source scoring 0 and the highest 1, and sums them by
+	// weight.
+	FusionWeighted
+)
+
+func (m FusionMethod) String() string {
+	switch m {
+	case FusionRRF:
+		return "RRF"
+	case FusionWeighted:
+		return "Weighted"
+	}
+	return fmt.Sprintf("FusionMethod(%d)", int(m))
+}
+
+// HybridSearchRequest searches an index with a KNN query on a vector field
+// and a text query, and fuses the two lists of hits. Either source can be
+// left out: there is no KNN query without VectorField and no text query
+// without TextField or TextQuery.
+type HybridSearchRequest struct {
+	TableName string
+	IndexName string
+	// Text is what is searched for. It is matched against TextField and
+	// embedded with Embedder for the KNN query.
+	Text string
+	// VectorField is the VECTOR field of the KNN query.
+	VectorField string
+	// Vector is the vector of the KNN query, Text embedded with Embedder
+	// when nil.
+	Vector   []float32
+	Embedder Embedder
+	// TextField is the field Text is matched against with a MatchQuery.
+	TextField string
+	// TextQuery replaces the MatchQuery on TextField.
+	TextQuery search.Query
+	// Filter restricts the hits of both sources. It is the filter of the
+	// KNN query and a filter clause of the text query.
+	Filter search.Query
+	// Candidates is the number of hits read from each source, 50 when 0.
+	// It is the TopK of the KNN query.
+	Candidates int32
+	// Limit is the number of fused hits returned, 10 when 0.
+	Limit  int32
+	Fusion FusionMethod
+	// RRFK is the k of FusionRRF, 60 when 0. A larger k flattens the
+	// difference between the first ranks.
+	RRFK float64
+	// VectorWeight and TextWeight weigh the sources, 1 each when both are 0.
+	VectorWeight float64
+	TextWeight   float64
+	// Parallel sends both searches at the same time.
+	Parallel     bool
+	ColumnsToGet *ColumnsToGet
+	TimeoutMs    *int32
+}
+
+// HybridHit is a row returned by one source of a hybrid search or both.
+type HybridHit struct {
+	Row *Row
+	// Score is the fused score the hits are ordered by.
+	Score float64
+	// VectorScore and TextScore are the scores the sources gave the row,
+	// nil for a source that did not return it.
+	VectorScore *float64
+	TextScore   *float64
+	// VectorRank and TextRank are the positions of the row in each source
+	// starting at 1, 0 for a source that did not return it.
+	VectorRank int
+	TextRank   int
+}
+
+type HybridSearchResponse struct {
+	Hits []*HybridHit
+	// VectorHits and TextHits are the numbers of candidates each source
+	// returned.
+	VectorHits int
+	TextHits   int
+}
+
+// HybridSearch runs the KNN and the text query of request and fuses their
+// hits by primary key.
+func (tableStoreClient *TableStoreClient) HybridSearch(ctx context.Context, request *HybridSearchRequest) (*HybridSearchResponse, error) {
+	return hybridSearch(ctx, tableStoreClient, request)
+}
+
+func hybridSearch(ctx context.Context, client searchClient, request *HybridSearchRequest) (*HybridSearchResponse, error) {
+	hs, err := newHybridSearcher(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var vectorHits, textHits []*SearchHit
+	var vectorErr, textErr error
+	runVector := func() {
+		if hs.vector {
+			vectorHits, vectorErr = hs.searchVector(ctx, client)
+		}
+	}
+	runText := func() {
+		if hs.text != nil {
+			textHits, textErr = hs.search(ctx, client, hs.text)
+		}
+	}
+	if request.Parallel {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runVector()
+		}()
+		runText()
+		wg.Wait()
+	} else {
+		runVector()
+		if vectorErr == nil {
+			runText()
+		}
+	}
+	if vectorErr != nil {
+		return nil, vectorErr
+	}
+	if textErr != nil {
+		return nil, textErr
+	}
+	return hs.fuse(vectorHits, textHits), nil
+}
+
+// hybridSearcher is a HybridSearchRequest with its defaults filled in.
+type hybridSearcher struct {
+	request      *HybridSearchRequest
+	vector       bool
+	text         search.Query
+	candidates   int32
+	limit        int
+	rrfK         float64
+	vectorWeight float64
+	textWeight   float64
+}
+
+func newHybridSearcher(request *HybridSearchRequest) (*hybridSearcher, error) {
+	if request == nil {
+		return nil, errInvalidHybridSearch("request is nil")
+	}
+	hs := &hybridSearcher{
+		request:      request,
+		vector:       request.VectorField != "",
+		text:         request.TextQuery,
+		candidates:   request.Candidates,
+		limit:        int(request.Limit),
+		rrfK:         request.RRFK,
+		vectorWeight: request.VectorWeight,
+		textWeight:   request.TextWeight,
+	}
+	if request.TableName == "" || request.IndexName == "" {
+		return nil, errInvalidHybridSearch("table and index names are required")
+	}
+	if hs.text == nil && request.TextField != "" {
+		hs.text = &search.MatchQuery{FieldName: request.TextField, Text: request.Text}
+	}
+	if !hs.vector && hs.text == nil {
+		return nil, errInvalidHybridSearch("neither VectorField nor TextField or TextQuery is set")
+	}
+	if hs.vector && request.Vector == nil && request.Embedder == nil {
+		return nil, errInvalidHybridSearch("Vector or Embedder is required with VectorField")
+	}
+	if hs.candidates < 0 || hs.limit < 0 || hs.rrfK < 0 {
+		return nil, errInvalidHybridSearch("Candidates, Limit and RRFK must not be negative")
+	}
+	if hs.vectorWeight < 0 || hs.textWeight < 0 {
+		return nil, errInvalidHybridSearch("weights must not be negative")
+	}
+	if request.Fusion != FusionRRF && request.Fusion != FusionWeighted {
+		return nil, errInvalidHybridSearch("unknown fusion method %v", request.Fusion)
+	}
+	if hs.candidates == 0 {
+		hs.candidates = defaultHybridSearchCandidates
+	}
+	if hs.limit == 0 {
+		hs.limit = defaultHybridSearchLimit
+	}
+	if hs.rrfK == 0 {
+		hs.rrfK = defaultRRFK
+	}
+	if hs.vectorWeight == 0 && hs.textWeight == 0 {
+		hs.vectorWeight, hs.textWeight = 1, 1
+	}
+	if request.Filter != nil && hs.text != nil {
+		hs.text = &search.BoolQuery{
+			MustQueries:   []search.Query{hs.text},
+			FilterQueries: []search.Query{request.Filter},
+		}
+	}
+	return hs, nil
+}
+
+func (hs *hybridSearcher) searchVector(ctx context.Context, client searchClient) ([]*SearchHit, error) {
+	vector := hs.request.Vector
+	if vector == nil {
+		var err error
+		if vector, err = hs.request.Embedder.Embed(ctx, hs.request.Text); err != nil {
+			return nil, fmt.Errorf("[tablestore] embed hybrid search text: %w", err)
+		}
+	}
+	return hs.search(ctx, client, &search.KnnVectorQuery{
+		FieldName:          hs.request.VectorField,
+		TopK:               &hs.candidates,
+		Float32QueryVector: vector,
+		Filter:             hs.request.Filter,
+	})
+}
+
+func (hs *hybridSearcher) search(ctx context.Context, client searchClient, query search.Query) ([]*SearchHit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	request := &SearchRequest{
+		TableName: hs.request.TableName,
+		IndexName: hs.request.IndexName,
+		SearchQuery: search.NewSearchQuery().
+			SetQuery(query).
+			SetLimit(hs.candidates).
+			SetSort(&search.Sort{Sorters: []search.Sorter{&search.ScoreSort{}}}),
+		ColumnsToGet: hs.request.ColumnsToGet,
+		TimeoutMs:    hs.request.TimeoutMs,
+	}
+	resp, err := client.Search(request)
+	if err != nil {
+		return nil, err
+	}
+	hits := resp.SearchHits
+	// servers that return no search hits give rows without scores, which
+	// still have their ranks
+	if len(hits) == 0 && len(resp.Rows) > 0 {
+		for _, row := range resp.Rows {
+			hits = append(hits, &SearchHit{Row: row})
+		}
+	}
+	return hits, nil
+}
+
+// fuse merges the hits of both sources by primary key and orders them by
+// fused score, then by their best rank.
+func (hs *hybridSearcher) fuse(vectorHits, textHits []*SearchHit) *HybridSearchResponse {
+	resp := &HybridSearchResponse{VectorHits: len(vectorHits), TextHits: len(textHits)}
+	byKey := make(map[string]*HybridHit)
+	add := func(hits []*SearchHit, weight float64, set func(hit *HybridHit, rank int, score *float64)) {
+		minScore, maxScore := math.Inf(1), math.Inf(-1)
+		for _, h := range hits {
+			s := hitScore(h)
+			minScore, maxScore = math.Min(minScore, s), math.Max(maxScore, s)
+		}
+		for i, h := range hits {
+			if h.Row == nil || h.Row.PrimaryKey == nil {
+				continue
+			}
+			key := string(h.Row.PrimaryKey.Build(false))
+			hit, ok := byKey[key]
+			if !ok {
+				hit = &HybridHit{Row: h.Row}
+				byKey[key] = hit
+				resp.Hits = append(resp.Hits, hit)
+			}
+			set(hit, i+1, h.Score)
+			switch hs.request.Fusion {
+			case FusionRRF:
+				hit.Score += weight / (hs.rrfK + float64(i+1))
+			case FusionWeighted:
+				normalized := 1.0
+				if maxScore > minScore {
+					normalized = (hitScore(h) - minScore) / (maxScore - minScore)
+				}
+				hit.Score += weight * normalized
+			}
+		}
+	}
+	add(vectorHits, hs.vectorWeight, func(hit *HybridHit, rank int, score *float64) {
+		hit.VectorRank, hit.VectorScore = rank, score
+	})
+	add(textHits, hs.textWeight, func(hit *HybridHit, rank int, score *float64) {
+		hit.TextRank, hit.TextScore = rank, score
+	})
+
+	sort.SliceStable(resp.Hits, func(i, j int) bool {
+		a, b := resp.Hits[i], resp.Hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return bestRank(a) < bestRank(b)
+	})
+	if len(resp.Hits) > hs.limit {
+		resp.Hits = resp.Hits[:hs.limit]
+	}
+	return resp
+}
+
+func hitScore(hit *SearchHit) float64 {
+	if hit.Score == nil {
+		return 0
+	}
+	return *hit.Score
+}
+
+func bestRank(hit *HybridHit) int {
+	switch {
+	case hit.VectorRank == 0:
+		return hit.TextRank
+	case hit.TextRank == 0 || hit.VectorRank < hit.TextRank:
+		return hit.VectorRank
+	}
+	return hit.TextRank
+}
@@ -0,0 +1,217 @@
+package tablestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHybridIndex answers KNN queries with vectorHits and any other query
+// with textHits, hits being ids and scores.
+type fakeHybridIndex struct {
+	mu         sync.Mutex
+	queries    []search.Query
+	vectorHits []fakeHit
+	textHits   []fakeHit
+	err        error
+}
+
+type fakeHit struct {
+	id    string
+	score float64
+}
+
+func (f *fakeHybridIndex) Search(request *SearchRequest) (*SearchResponse, error) {
+	data, err := json.Marshal(request.SearchQuery)
+	if err != nil {
+		return nil, err
+	}
+	query := search.NewSearchQuery()
+	if err := json.Unmarshal(data, query); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.queries = append(f.queries, query.Query)
+	f.mu.Unlock()
+
+	hits := f.textHits
+	if _, ok := query.Query.(*search.KnnVectorQuery); ok {
+		if f.err != nil {
+			return nil, f.err
+		}
+		hits = f.vectorHits
+	}
+	resp := &SearchResponse{}
+	for _, h := range hits {
+		pk := new(PrimaryKey)
+		pk.AddPrimaryKeyColumn("id", h.id)
+		row := &Row{PrimaryKey: pk}
+		score := h.score
+		resp.Rows = append(resp.Rows, row)
+		resp.SearchHits = append(resp.SearchHits, &SearchHit{Row: row, Score: &score})
+	}
+	return resp, nil
+}
+
+func newFakeHybridIndex() *fakeHybridIndex {
+	return &fakeHybridIndex{
+		vectorHits: []fakeHit{{"a", 0.9}, {"b", 0.8}, {"c", 0.5}},
+		textHits:   []fakeHit{{"c", 12}, {"d", 6}, {"a", 3}},
+	}
+}
+
+func newTestHybridSearchRequest() *HybridSearchRequest {
+	return &HybridSearchRequest{
+		TableName:   "table",
+		IndexName:   "index",
+		Text:        "red apple",
+		VectorField: "vec",
+		Embedder:    NewHashEmbedder(8),
+		TextField:   "title",
+	}
+}
+
+func hybridIds(resp *HybridSearchResponse) []string {
+	var ids []string
+	for _, hit := range resp.Hits {
+		ids = append(ids, hit.Row.PrimaryKey.PrimaryKeys[0].Value.(string))
+	}
+	return ids
+}
+
+func TestHybridSearch_RRF(t *testing.T) {
+	index := newFakeHybridIndex()
+	request := newTestHybridSearchRequest()
+	request.Filter = &search.TermQuery{FieldName: "city", Term: "hangzhou"}
+	resp, err := hybridSearch(context.Background(), index, request)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"a", "c", "b", "d"}, hybridIds(resp))
+	assert.Equal(t, 3, resp.VectorHits)
+	assert.Equal(t, 3, resp.TextHits)
+	a := resp.Hits[0]
+	assert.InDelta(t, 1.0/61+1.0/63, a.Score, 1e-12)
+	assert.Equal(t, 1, a.VectorRank)
+	assert.Equal(t, 3, a.TextRank)
+	assert.Equal(t, 0.9, *a.VectorScore)
+	assert.Equal(t, 3.0, *a.TextScore)
+	b := resp.Hits[2]
+	assert.Equal(t, 0, b.TextRank)
+	assert.Nil(t, b.TextScore)
+
+	assert.Len(t, index.queries, 2)
+	knn := index.queries[0].(*search.KnnVectorQuery)
+	assert.Equal(t, "vec", knn.FieldName)
+	assert.Equal(t, int32(defaultHybridSearchCandidates), *knn.TopK)
+	assert.Len(t, knn.Float32QueryVector, 8)
+	assert.Equal(t, request.Filter, knn.Filter)
+	assert.Equal(t, &search.BoolQuery{
+		MustQueries:   []search.Query{&search.MatchQuery{FieldName: "title", Text: "red apple"}},
+		FilterQueries: []search.Query{request.Filter},
+	}, index.queries[1])
+}
+
+func TestHybridSearch_Weighted(t *testing.T) {
+	request := newTestHybridSearchRequest()
+	request.Fusion = FusionWeighted
+	request.VectorWeight = 0.3
+	request.TextWeight = 0.7
+	request.Limit = 2
+	request.Parallel = true
+	resp, err := hybridSearch(context.Background(), newFakeHybridIndex(), request)
+	assert.Nil(t, err)
+
+	// c is last of the KNN hits and first of the text hits, a the other way
+	// round: c scores 0.3*0 + 0.7*1, a scores 0.3*1 + 0.7*0, ahead of d at
+	// 0.7*(6-3)/(12-3).
+	assert.Equal(t, []string{"c", "a"}, hybridIds(resp))
+	assert.InDelta(t, 0.7, resp.Hits[0].Score, 1e-12)
+	assert.InDelta(t, 0.3, resp.Hits[1].Score, 1e-12)
+}
+
+func TestHybridSearch_OneSource(t *testing.T) {
+	index := newFakeHybridIndex()
+	request := newTestHybridSearchRequest()
+	request.TextField = ""
+	request.Vector = []float32{1, 0}
+	resp, err := hybridSearch(context.Background(), index, request)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, hybridIds(resp))
+	assert.Equal(t, 0, resp.TextHits)
+	assert.Len(t, index.queries, 1)
+	assert.Equal(t, []float32{1, 0}, index.queries[0].(*search.KnnVectorQuery).Float32QueryVector)
+
+	index = newFakeHybridIndex()
+	request = newTestHybridSearchRequest()
+	request.VectorField = ""
+	request.TextQuery = &search.TermQuery{FieldName: "tag", Term: "x"}
+	resp, err = hybridSearch(context.Background(), index, request)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "d", "a"}, hybridIds(resp))
+	assert.Equal(t, []search.Query{request.TextQuery}, index.queries)
+}
+
+func TestHybridSearch_Errors(t *testing.T) {
+	index := newFakeHybridIndex()
+	index.err = errors.New("boom")
+	_, err := hybridSearch(context.Background(), index, newTestHybridSearchRequest())
+	assert.Equal(t, index.err, err)
+	assert.Len(t, index.queries, 1)
+
+	request := newTestHybridSearchRequest()
+	request.Embedder = embedderFunc(func(ctx context.Context, text string) ([]float32, error) {
+		return nil, errors.New("no model")
+	})
+	_, err = hybridSearch(context.Background(), newFakeHybridIndex(), request)
+	assert.EqualError(t, err, "[tablestore] embed hybrid search text: no model")
+
+	for _, change := range []func(r *HybridSearchRequest){
+		func(r *HybridSearchRequest) { r.IndexName = "" },
+		func(r *HybridSearchRequest) { r.VectorField, r.TextField = "", "" },
+		func(r *HybridSearchRequest) { r.Embedder = nil },
+		func(r *HybridSearchRequest) { r.TextWeight = -1 },
+		func(r *HybridSearchRequest) { r.Fusion = FusionMethod(5) },
+	} {
+		request := newTestHybridSearchRequest()
+		change(request)
+		_, err := hybridSearch(context.Background(), newFakeHybridIndex(), request)
+		assert.Error(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = hybridSearch(ctx, newFakeHybridIndex(), newTestHybridSearchRequest())
+	assert.Equal(t, context.Canceled, err)
+}
+
+type embedderFunc func(ctx context.Context, text string) ([]float32, error)
+
+func (f embedderFunc) Embed(ctx context.Context, text string) ([]float32, error) {
+	return f(ctx, text)
+}
+
+func TestHashEmbedder(t *testing.T) {
+	embedder := NewHashEmbedder(16)
+	a, err := embedder.Embed(context.Background(), "Red apple")
+	assert.Nil(t, err)
+	b, _ := embedder.Embed(context.Background(), "red, APPLE")
+	assert.Equal(t, a, b)
+
+	var norm float64
+	for _, v := range a {
+		norm += float64(v) * float64(v)
+	}
+	assert.InDelta(t, 1, math.Sqrt(norm), 1e-6)
+
+	empty, _ := embedder.Embed(context.Background(), "")
+	assert.Equal(t, make([]float32, 16), empty)
+
+	_, err = NewHashEmbedder(0).Embed(context.Background(), "x")
+	assert.Error(t, err)
+}
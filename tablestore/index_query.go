@@ -0,0 +1,374 @@
+package tablestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// maxBatchGetRows is the most rows a BatchGetRow request reads
+	maxBatchGetRows = 100
+	// maxBatchGetRetries bounds the retries of rows BatchGetRow failed to read
+	maxBatchGetRetries = 3
+)
+
+var (
+	errIndexNotFound = func(table, index string) error {
+		return errors.New("[tablestore] table " + table + " has no secondary index " + index)
+	}
+	errInvalidIndexCondition = func(format string, args ...interface{}) error {
+		return errors.New("[tablestore] invalid index condition: " + fmt.Sprintf(format, args...))
+	}
+)
+
+// IndexCondition restricts one primary key column of a secondary index.
+// The conditions of a query apply to the leading primary key columns of the
+// index, in order: every condition but the last has to be an equality, the
+// last may be a range instead.
+type IndexCondition struct {
+	ColumnName string
+	// Equal is the value of the column. When nil, the column is in
+	// [Lower, Upper), a nil bound leaves that side open.
+	Equal interface{}
+	Lower interface{}
+	Upper interface{}
+}
+
+func NewIndexEqualCondition(columnName string, value interface{}) *IndexCondition {
+	return &IndexCondition{ColumnName: columnName, Equal: value}
+}
+
+func NewIndexRangeCondition(columnName string, lower, upper interface{}) *IndexCondition {
+	return &IndexCondition{ColumnName: columnName, Lower: lower, Upper: upper}
+}
+
+type QueryByIndexOptions struct {
+	// ColumnsToGet are the columns returned besides the primary key, all
+	// columns when empty.
+	ColumnsToGet []string
+	// FetchFromTable reads the columns of ColumnsToGet the index does not
+	// hold from the main table with BatchGetRow, every column of the row
+	// when ColumnsToGet is empty. Rows the main table no longer has, which
+	// a global index may still return for a while, are left out.
+	FetchFromTable bool
+	// Limit is the most rows returned, 0 returns the whole range.
+	Limit     int
+	Direction Direction
+	// StartPrimaryKey continues a query from the NextStartPrimaryKey of its
+	// previous response.
+	StartPrimaryKey *PrimaryKey
+}
+
+type QueryByIndexResponse struct {
+	// Rows have the primary key of the main table. Index primary key
+	// columns that are attribute columns of the main table are columns of
+	// the row.
+	Rows []*Row
+	// NextStartPrimaryKey is set when Limit ended the query before the end
+	// of the range. It is a primary key of the index.
+	NextStartPrimaryKey  *PrimaryKey
+	ConsumedCapacityUnit *ConsumedCapacityUnit
+}
+
+// indexQueryClient is the part of the client QueryByIndex uses.
+type indexQueryClient interface {
+	DescribeTable(request *DescribeTableRequest) (*DescribeTableResponse, error)
+	GetRange(request *GetRangeRequest) (*GetRangeResponse, error)
+	BatchGetRow(request *BatchGetRowRequest) (*BatchGetRowResponse, error)
+}
+
+// QueryByIndex reads rows of a table through one of its secondary indexes.
+// It describes the table to find the index schema, reads the range of the
+// index the conditions select page by page and optionally fetches the
+// columns the index does not hold from the main table.
+func (tableStoreClient *TableStoreClient) QueryByIndex(ctx context.Context, tableName, indexName string, conditions []*IndexCondition, options *QueryByIndexOptions) (*QueryByIndexResponse, error) {
+	return queryByIndex(ctx, tableStoreClient, tableName, indexName, conditions, options)
+}
+
+func queryByIndex(ctx context.Context, client indexQueryClient, tableName, indexName string, conditions []*IndexCondition, options *QueryByIndexOptions) (*QueryByIndexResponse, error) {
+	if options == nil {
+		options = new(QueryByIndexOptions)
+	}
+	describe, err := client.DescribeTable(&DescribeTableRequest{TableName: tableName})
+	if err != nil {
+		return nil, err
+	}
+	var index *IndexMeta
+	for _, meta := range describe.IndexMetas {
+		if meta.IndexName == indexName {
+			index = meta
+		}
+	}
+	if index == nil {
+		return nil, errIndexNotFound(tableName, indexName)
+	}
+
+	var tablePrimaryKey []string
+	for _, schema := range describe.TableMeta.SchemaEntry {
+		tablePrimaryKey = append(tablePrimaryKey, *schema.Name)
+	}
+	indexPrimaryKey := indexPrimaryKeyColumns(index, tablePrimaryKey)
+	start, end, err := indexRange(indexPrimaryKey, conditions, options.Direction)
+	if err != nil {
+		return nil, err
+	}
+	if options.StartPrimaryKey != nil {
+		start = options.StartPrimaryKey
+	}
+
+	criteria := &RangeRowQueryCriteria{
+		TableName:       indexName,
+		StartPrimaryKey: start,
+		EndPrimaryKey:   end,
+		ColumnsToGet:    indexColumnsToGet(index, options.ColumnsToGet),
+		MaxVersion:      1,
+		Direction:       options.Direction,
+	}
+	resp := &QueryByIndexResponse{ConsumedCapacityUnit: new(ConsumedCapacityUnit)}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if options.Limit > 0 {
+			criteria.Limit = int32(options.Limit - len(resp.Rows))
+		}
+		page, err := client.GetRange(&GetRangeRequest{RangeRowQueryCriteria: criteria})
+		if err != nil {
+			return nil, err
+		}
+		addConsumed(resp.ConsumedCapacityUnit, page.ConsumedCapacityUnit)
+		for _, row := range page.Rows {
+			resp.Rows = append(resp.Rows, tableRow(row, tablePrimaryKey))
+		}
+		if page.NextStartPrimaryKey == nil {
+			break
+		}
+		if options.Limit > 0 && len(resp.Rows) >= options.Limit {
+			resp.NextStartPrimaryKey = page.NextStartPrimaryKey
+			break
+		}
+		criteria.StartPrimaryKey = page.NextStartPrimaryKey
+	}
+
+	if options.FetchFromTable {
+		missing, all := missingColumns(index, tablePrimaryKey, options.ColumnsToGet)
+		if all || len(missing) > 0 {
+			if !all {
+				// the same holds for the rows of the main table
+				missing = append(missing, tablePrimaryKey[0])
+			}
+			if resp.Rows, err = fetchFromTable(ctx, client, tableName, resp.Rows, missing, all, resp.ConsumedCapacityUnit); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return resp, nil
+}
+
+// indexPrimaryKeyColumns is the primary key of the index table: the index
+// primary key followed by the primary key columns of the main table the
+// index primary key leaves out.
+func indexPrimaryKeyColumns(index *IndexMeta, tablePrimaryKey []string) []string {
+	columns := append([]string{}, index.Primarykey...)
+	for _, name := range tablePrimaryKey {
+		if !containsString(columns, name) {
+			columns = append(columns, name)
+		}
+	}
+	return columns
+}
+
+func indexRange(columns []string, conditions []*IndexCondition, direction Direction) (*PrimaryKey, *PrimaryKey, error) {
+	if len(conditions) > len(columns) {
+		return nil, nil, errInvalidIndexCondition("%d conditions for %d primary key columns", len(conditions), len(columns))
+	}
+	lower, upper := new(PrimaryKey), new(PrimaryKey)
+	// the columns after an exclusive upper bound are MIN so that the rows
+	// with the bound as the value of the column are left out
+	upperFill := upper.AddPrimaryKeyColumnWithMaxValue
+	for i, name := range columns {
+		if i >= len(conditions) {
+			lower.AddPrimaryKeyColumnWithMinValue(name)
+			upperFill(name)
+			continue
+		}
+		condition := conditions[i]
+		if condition.ColumnName != name {
+			return nil, nil, errInvalidIndexCondition("condition %d is on %s, want index primary key column %s", i, condition.ColumnName, name)
+		}
+		last := i == len(conditions)-1
+		switch {
+		case condition.Equal != nil:
+			if last && i == len(columns)-1 {
+				return nil, nil, errInvalidIndexCondition("conditions fix the whole index primary key, get the row instead")
+			}
+			lower.AddPrimaryKeyColumn(name, condition.Equal)
+			upper.AddPrimaryKeyColumn(name, condition.Equal)
+		case !last:
+			return nil, nil, errInvalidIndexCondition("range on %s has to be the last condition", name)
+		case direction == BACKWARD && i == len(columns)-1:
+			// without a column after it, the bounds of a backward range
+			// would include Upper and leave out Lower
+			return nil, nil, errInvalidIndexCondition("backward range on the last primary key column %s", name)
+		default:
+			if condition.Lower != nil {
+				lower.AddPrimaryKeyColumn(name, condition.Lower)
+			} else {
+				lower.AddPrimaryKeyColumnWithMinValue(name)
+			}
+			if condition.Upper != nil {
+				upper.AddPrimaryKeyColumn(name, condition.Upper)
+				upperFill = upper.AddPrimaryKeyColumnWithMinValue
+			} else {
+				upper.AddPrimaryKeyColumnWithMaxValue(name)
+			}
+		}
+	}
+	if direction == BACKWARD {
+		// a backward range starts at the upper end, the MIN and MAX columns
+		// after the condition keep the bounds in place
+		return upper, lower, nil
+	}
+	return lower, upper, nil
+}
+
+// indexColumnsToGet keeps the requested columns the index holds as
+// attribute columns. Index primary key columns are always returned.
+func indexColumnsToGet(index *IndexMeta, columnsToGet []string) []string {
+	if len(columnsToGet) == 0 {
+		return nil
+	}
+	var columns []string
+	for _, name := range columnsToGet {
+		if containsString(index.DefinedColumns, name) {
+			columns = append(columns, name)
+		}
+	}
+	// a row with none of the requested columns is left out of the range,
+	// asking for a primary key column keeps every row
+	return append(columns, index.Primarykey[0])
+}
+
+// missingColumns lists the requested columns the index does not hold, all
+// is set when every column of the row is requested.
+func missingColumns(index *IndexMeta, tablePrimaryKey, columnsToGet []string) (missing []string, all bool) {
+	if len(columnsToGet) == 0 {
+		return nil, true
+	}
+	for _, name := range columnsToGet {
+		if !containsString(index.Primarykey, name) && !containsString(index.DefinedColumns, name) && !containsString(tablePrimaryKey, name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing, false
+}
+
+// tableRow turns a row of the index into a row with the primary key of the
+// main table.
+func tableRow(indexRow *Row, tablePrimaryKey []string) *Row {
+	row := &Row{PrimaryKey: new(PrimaryKey)}
+	for _, name := range tablePrimaryKey {
+		for _, col := range indexRow.PrimaryKey.PrimaryKeys {
+			if col.ColumnName == name {
+				row.PrimaryKey.PrimaryKeys = append(row.PrimaryKey.PrimaryKeys, col)
+			}
+		}
+	}
+	for _, col := range indexRow.PrimaryKey.PrimaryKeys {
+		if !containsString(tablePrimaryKey, col.ColumnName) {
+			row.Columns = append(row.Columns, &AttributeColumn{ColumnName: col.ColumnName, Value: col.Value})
+		}
+	}
+	row.Columns = append(row.Columns, indexRow.Columns...)
+	return row
+}
+
+// fetchFromTable reads rows from the main table in batches and merges their
+// columns into rows, keeping the order of rows.
+func fetchFromTable(ctx context.Context, client indexQueryClient, tableName string, rows []*Row, columns []string, all bool, consumed *ConsumedCapacityUnit) ([]*Row, error) {
+	found := make([]bool, len(rows))
+	for begin := 0; begin < len(rows); begin += maxBatchGetRows {
+		end := begin + maxBatchGetRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		// pending are the indexes into rows still to read
+		var pending []int
+		for i := begin; i < end; i++ {
+			pending = append(pending, i)
+		}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > 0 {
+				timer := time.NewTimer(time.Duration(attempt) * 100 * time.Millisecond)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, ctx.Err()
+				case <-timer.C:
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			criteria := &MultiRowQueryCriteria{TableName: tableName, ColumnsToGet: columns, MaxVersion: 1}
+			for _, i := range pending {
+				criteria.PrimaryKey = append(criteria.PrimaryKey, rows[i].PrimaryKey)
+			}
+			resp, err := client.BatchGetRow(&BatchGetRowRequest{MultiRowQueryCriteria: []*MultiRowQueryCriteria{criteria}})
+			if err != nil {
+				return nil, err
+			}
+			var failed []int
+			var lastError Error
+			for _, result := range resp.TableToRowsResult[tableName] {
+				i := pending[result.Index]
+				addConsumed(consumed, result.ConsumedCapacityUnit)
+				if !result.IsSucceed {
+					failed = append(failed, i)
+					lastError = result.Error
+					continue
+				}
+				if len(result.PrimaryKey.PrimaryKeys) == 0 {
+					// the main table has no such row
+					continue
+				}
+				found[i] = true
+				if all {
+					rows[i].Columns = result.Columns
+				} else {
+					rows[i].Columns = append(rows[i].Columns, result.Columns...)
+				}
+			}
+			if len(failed) > 0 && attempt == maxBatchGetRetries {
+				return nil, &OtsError{Code: lastError.Code, Message: lastError.Message}
+			}
+			pending = failed
+		}
+	}
+
+	kept := rows[:0]
+	for i, row := range rows {
+		if found[i] {
+			kept = append(kept, row)
+		}
+	}
+	return kept, nil
+}
+
+func addConsumed(total, cu *ConsumedCapacityUnit) {
+	if cu != nil {
+		total.Read += cu.Read
+		total.Write += cu.Write
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
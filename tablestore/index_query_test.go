@@ -0,0 +1,368 @@
+package tablestore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ indexQueryClient = (*TableStoreClient)(nil)
+
+// fakeIndexTable is a table with the primary key (user, id) and a global
+// index idx_city with the primary key city and the defined column name.
+type fakeIndexTable struct {
+	rows     map[string]*Row // by user
+	index    []*Row          // sorted by (city, user, id)
+	pageSize int
+	// failOnce makes BatchGetRow fail the rows of these users once
+	failOnce map[string]bool
+
+	ranges       []*RangeRowQueryCriteria
+	batches      [][]*PrimaryKey
+	batchColumns [][]string
+}
+
+func newFakeIndexTable() *fakeIndexTable {
+	f := &fakeIndexTable{rows: make(map[string]*Row), pageSize: 2, failOnce: make(map[string]bool)}
+	for _, r := range []struct {
+		user, city, name string
+		age              int64
+	}{
+		{"a", "beijing", "Ann", 30},
+		{"b", "hangzhou", "Bob", 40},
+		{"c", "hangzhou", "Cid", 50},
+		{"d", "hangzhou", "Dan", 60},
+		{"e", "shanghai", "Eve", 70},
+	} {
+		pk := new(PrimaryKey)
+		pk.AddPrimaryKeyColumn("user", r.user)
+		pk.AddPrimaryKeyColumn("id", int64(1))
+		f.rows[r.user] = &Row{PrimaryKey: pk, Columns: []*AttributeColumn{
+			{ColumnName: "age", Value: r.age},
+			{ColumnName: "city", Value: r.city},
+			{ColumnName: "name", Value: r.name},
+		}}
+		indexPk := new(PrimaryKey)
+		indexPk.AddPrimaryKeyColumn("city", r.city)
+		indexPk.AddPrimaryKeyColumn("user", r.user)
+		indexPk.AddPrimaryKeyColumn("id", int64(1))
+		f.index = append(f.index, &Row{PrimaryKey: indexPk, Columns: []*AttributeColumn{{ColumnName: "name", Value: r.name}}})
+	}
+	return f
+}
+
+func (f *fakeIndexTable) DescribeTable(request *DescribeTableRequest) (*DescribeTableResponse, error) {
+	meta := &TableMeta{TableName: request.TableName}
+	meta.AddPrimaryKeyColumn("user", PrimaryKeyType_STRING)
+	meta.AddPrimaryKeyColumn("id", PrimaryKeyType_INTEGER)
+	meta.AddDefinedColumn("city", DefinedColumn_STRING)
+	meta.AddDefinedColumn("name", DefinedColumn_STRING)
+	index := &IndexMeta{IndexName: "idx_city", IndexType: IT_GLOBAL_INDEX}
+	index.AddPrimaryKeyColumn("city")
+	index.AddDefinedColumn("name")
+	return &DescribeTableResponse{TableMeta: meta, IndexMetas: []*IndexMeta{index}}, nil
+}
+
+func comparePrimaryKeyColumn(a, b *PrimaryKeyColumn) int {
+	rank := func(c *PrimaryKeyColumn) int {
+		switch c.PrimaryKeyOption {
+		case MIN:
+			return -1
+		case MAX:
+			return 1
+		}
+		return 0
+	}
+	if rank(a) != rank(b) || rank(a) != 0 {
+		return rank(a) - rank(b)
+	}
+	switch v := a.Value.(type) {
+	case string:
+		w := b.Value.(string)
+		if v < w {
+			return -1
+		} else if v > w {
+			return 1
+		}
+	case int64:
+		w := b.Value.(int64)
+		if v < w {
+			return -1
+		} else if v > w {
+			return 1
+		}
+	}
+	return 0
+}
+
+func comparePrimaryKey(a, b *PrimaryKey) int {
+	for i := range a.PrimaryKeys {
+		if c := comparePrimaryKeyColumn(a.PrimaryKeys[i], b.PrimaryKeys[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (f *fakeIndexTable) GetRange(request *GetRangeRequest) (*GetRangeResponse, error) {
+	criteria := *request.RangeRowQueryCriteria
+	f.ranges = append(f.ranges, &criteria)
+	var matched []*Row
+	for _, row := range f.index {
+		if criteria.Direction == FORWARD {
+			if comparePrimaryKey(row.PrimaryKey, criteria.StartPrimaryKey) >= 0 && comparePrimaryKey(row.PrimaryKey, criteria.EndPrimaryKey) < 0 {
+				matched = append(matched, row)
+			}
+		} else if comparePrimaryKey(row.PrimaryKey, criteria.StartPrimaryKey) <= 0 && comparePrimaryKey(row.PrimaryKey, criteria.EndPrimaryKey) > 0 {
+			matched = append([]*Row{row}, matched...)
+		}
+	}
+	n := f.pageSize
+	if criteria.Limit > 0 && int(criteria.Limit) < n {
+		n = int(criteria.Limit)
+	}
+	resp := &GetRangeResponse{ConsumedCapacityUnit: &ConsumedCapacityUnit{Read: 1}}
+	if len(matched) > n {
+		resp.NextStartPrimaryKey = matched[n].PrimaryKey
+		matched = matched[:n]
+	}
+	for _, row := range matched {
+		if columns, ok := selectColumns(row, criteria.ColumnsToGet); ok {
+			resp.Rows = append(resp.Rows, &Row{PrimaryKey: row.PrimaryKey, Columns: columns})
+		}
+	}
+	return resp, nil
+}
+
+// selectColumns keeps the requested attribute columns of a row. Like the
+// service it leaves out a row that has none of the requested columns.
+func selectColumns(row *Row, columnsToGet []string) ([]*AttributeColumn, bool) {
+	if len(columnsToGet) == 0 {
+		return row.Columns, true
+	}
+	var columns []*AttributeColumn
+	for _, col := range row.Columns {
+		if containsString(columnsToGet, col.ColumnName) {
+			columns = append(columns, col)
+		}
+	}
+	if len(columns) > 0 {
+		return columns, true
+	}
+	for _, col := range row.PrimaryKey.PrimaryKeys {
+		if containsString(columnsToGet, col.ColumnName) {
+			return nil, true
+		}
+	}
+	return nil, false
+}
+
+func (f *fakeIndexTable) BatchGetRow(request *BatchGetRowRequest) (*BatchGetRowResponse, error) {
+	criteria := request.MultiRowQueryCriteria[0]
+	f.batches = append(f.batches, criteria.PrimaryKey)
+	f.batchColumns = append(f.batchColumns, criteria.ColumnsToGet)
+	resp := &BatchGetRowResponse{TableToRowsResult: make(map[string][]RowResult)}
+	for i, pk := range criteria.PrimaryKey {
+		user := pk.PrimaryKeys[0].Value.(string)
+		result := RowResult{TableName: criteria.TableName, IsSucceed: true, Index: int32(i), ConsumedCapacityUnit: &ConsumedCapacityUnit{Read: 1}}
+		if f.failOnce[user] {
+			delete(f.failOnce, user)
+			result.IsSucceed = false
+			result.Error = Error{Code: "OTSServerBusy", Message: "busy"}
+		} else if row, ok := f.rows[user]; ok {
+			if columns, ok := selectColumns(row, criteria.ColumnsToGet); ok {
+				result.PrimaryKey = *row.PrimaryKey
+				result.Columns = columns
+			}
+		}
+		resp.TableToRowsResult[criteria.TableName] = append(resp.TableToRowsResult[criteria.TableName], result)
+	}
+	return resp, nil
+}
+
+func rowUsers(rows []*Row) []string {
+	var users []string
+	for _, row := range rows {
+		users = append(users, row.PrimaryKey.PrimaryKeys[0].Value.(string))
+	}
+	return users
+}
+
+func columnValues(row *Row) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, col := range row.Columns {
+		values[col.ColumnName] = col.Value
+	}
+	return values
+}
+
+func TestQueryByIndexEqual(t *testing.T) {
+	f := newFakeIndexTable()
+	conditions := []*IndexCondition{NewIndexEqualCondition("city", "hangzhou")}
+	resp, err := queryByIndex(context.Background(), f, "users", "idx_city", conditions, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "d"}, rowUsers(resp.Rows))
+	assert.Nil(t, resp.NextStartPrimaryKey)
+	assert.Len(t, f.ranges, 2)
+	assert.Equal(t, "idx_city", f.ranges[0].TableName)
+	assert.Empty(t, f.batches)
+	assert.Equal(t, int32(2), resp.ConsumedCapacityUnit.Read)
+
+	row := resp.Rows[0]
+	assert.Equal(t, []string{"user", "id"}, []string{row.PrimaryKey.PrimaryKeys[0].ColumnName, row.PrimaryKey.PrimaryKeys[1].ColumnName})
+	assert.Equal(t, map[string]interface{}{"city": "hangzhou", "name": "Bob"}, columnValues(row))
+}
+
+func TestQueryByIndexRangeAndBackward(t *testing.T) {
+	f := newFakeIndexTable()
+	conditions := []*IndexCondition{NewIndexRangeCondition("city", "beijing", "shanghai")}
+	resp, err := queryByIndex(context.Background(), f, "users", "idx_city", conditions, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, rowUsers(resp.Rows))
+
+	resp, err = queryByIndex(context.Background(), f, "users", "idx_city", conditions, &QueryByIndexOptions{Direction: BACKWARD})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "c", "b", "a"}, rowUsers(resp.Rows))
+
+	conditions = []*IndexCondition{NewIndexRangeCondition("city", "hangzhou", nil)}
+	resp, err = queryByIndex(context.Background(), f, "users", "idx_city", conditions, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "d", "e"}, rowUsers(resp.Rows))
+}
+
+func TestQueryByIndexLimit(t *testing.T) {
+	f := newFakeIndexTable()
+	options := &QueryByIndexOptions{Limit: 3}
+	resp, err := queryByIndex(context.Background(), f, "users", "idx_city", nil, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, rowUsers(resp.Rows))
+	assert.NotNil(t, resp.NextStartPrimaryKey)
+	assert.Equal(t, int32(1), f.ranges[1].Limit)
+
+	options.StartPrimaryKey = resp.NextStartPrimaryKey
+	resp, err = queryByIndex(context.Background(), f, "users", "idx_city", nil, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"d", "e"}, rowUsers(resp.Rows))
+	assert.Nil(t, resp.NextStartPrimaryKey)
+}
+
+func TestQueryByIndexFetchFromTable(t *testing.T) {
+	f := newFakeIndexTable()
+	// the index still has a row the main table deleted
+	delete(f.rows, "c")
+	f.failOnce["d"] = true
+	conditions := []*IndexCondition{NewIndexEqualCondition("city", "hangzhou")}
+
+	options := &QueryByIndexOptions{ColumnsToGet: []string{"name", "age"}, FetchFromTable: true}
+	resp, err := queryByIndex(context.Background(), f, "users", "idx_city", conditions, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "d"}, rowUsers(resp.Rows))
+	assert.Equal(t, []string{"name", "city"}, f.ranges[0].ColumnsToGet)
+	assert.Equal(t, []string{"age", "user"}, f.batchColumns[0])
+	assert.Equal(t, map[string]interface{}{"city": "hangzhou", "name": "Dan", "age": int64(60)}, columnValues(resp.Rows[1]))
+	// the first batch reads all three rows, the retry only the failed one
+	assert.Len(t, f.batches, 2)
+	assert.Len(t, f.batches[1], 1)
+
+	f.batches = nil
+	options = &QueryByIndexOptions{ColumnsToGet: []string{"name"}, FetchFromTable: true}
+	resp, err = queryByIndex(context.Background(), f, "users", "idx_city", conditions, options)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Rows, 3)
+	assert.Empty(t, f.batches)
+
+	options = &QueryByIndexOptions{FetchFromTable: true}
+	resp, err = queryByIndex(context.Background(), f, "users", "idx_city", conditions, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "d"}, rowUsers(resp.Rows))
+	assert.Equal(t, map[string]interface{}{"city": "hangzhou", "name": "Bob", "age": int64(40)}, columnValues(resp.Rows[0]))
+	assert.Len(t, resp.Rows[0].Columns, 3)
+}
+
+func TestQueryByIndexRowsWithoutColumns(t *testing.T) {
+	f := newFakeIndexTable()
+	// a row with neither a name nor an age
+	pk := new(PrimaryKey)
+	pk.AddPrimaryKeyColumn("user", "f")
+	pk.AddPrimaryKeyColumn("id", int64(1))
+	f.rows["f"] = &Row{PrimaryKey: pk, Columns: []*AttributeColumn{{ColumnName: "city", Value: "hangzhou"}}}
+	indexPk := new(PrimaryKey)
+	indexPk.AddPrimaryKeyColumn("city", "hangzhou")
+	indexPk.AddPrimaryKeyColumn("user", "f")
+	indexPk.AddPrimaryKeyColumn("id", int64(1))
+	f.index = append(f.index[:4], append([]*Row{{PrimaryKey: indexPk}}, f.index[4:]...)...)
+	f.pageSize = 10
+	conditions := []*IndexCondition{NewIndexEqualCondition("city", "hangzhou")}
+
+	resp, err := queryByIndex(context.Background(), f, "users", "idx_city", conditions, &QueryByIndexOptions{ColumnsToGet: []string{"name"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "d", "f"}, rowUsers(resp.Rows))
+	assert.Equal(t, map[string]interface{}{"city": "hangzhou"}, columnValues(resp.Rows[3]))
+
+	options := &QueryByIndexOptions{ColumnsToGet: []string{"name", "age"}, FetchFromTable: true}
+	resp, err = queryByIndex(context.Background(), f, "users", "idx_city", conditions, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c", "d", "f"}, rowUsers(resp.Rows))
+	assert.Equal(t, map[string]interface{}{"city": "hangzhou"}, columnValues(resp.Rows[3]))
+}
+
+// busyIndexTable fails every BatchGetRow row.
+type busyIndexTable struct {
+	*fakeIndexTable
+}
+
+func (f busyIndexTable) BatchGetRow(request *BatchGetRowRequest) (*BatchGetRowResponse, error) {
+	for _, pk := range request.MultiRowQueryCriteria[0].PrimaryKey {
+		f.failOnce[pk.PrimaryKeys[0].Value.(string)] = true
+	}
+	return f.fakeIndexTable.BatchGetRow(request)
+}
+
+func TestQueryByIndexFetchFromTableCanceled(t *testing.T) {
+	f := busyIndexTable{newFakeIndexTable()}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := fetchFromTable(ctx, f, "users", []*Row{f.rows["a"]}, nil, true, new(ConsumedCapacityUnit))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// the backoff before a retry ends with the context
+	assert.True(t, time.Since(start) < 90*time.Millisecond)
+	assert.Len(t, f.batches, 1)
+}
+
+func TestQueryByIndexInvalid(t *testing.T) {
+	f := newFakeIndexTable()
+	ctx := context.Background()
+	_, err := queryByIndex(ctx, f, "users", "idx_name", nil, nil)
+	assert.NotNil(t, err)
+
+	_, err = queryByIndex(ctx, f, "users", "idx_city", []*IndexCondition{NewIndexEqualCondition("user", "a")}, nil)
+	assert.NotNil(t, err)
+
+	_, err = queryByIndex(ctx, f, "users", "idx_city", []*IndexCondition{
+		NewIndexRangeCondition("city", "a", "b"),
+		NewIndexEqualCondition("user", "a"),
+	}, nil)
+	assert.NotNil(t, err)
+
+	_, err = queryByIndex(ctx, f, "users", "idx_city", []*IndexCondition{
+		NewIndexEqualCondition("city", "hangzhou"),
+		NewIndexEqualCondition("user", "b"),
+		NewIndexEqualCondition("id", int64(1)),
+	}, nil)
+	assert.NotNil(t, err)
+
+	_, err = queryByIndex(ctx, f, "users", "idx_city", []*IndexCondition{
+		NewIndexEqualCondition("city", "hangzhou"),
+		NewIndexEqualCondition("user", "b"),
+		NewIndexRangeCondition("id", int64(0), int64(2)),
+	}, &QueryByIndexOptions{Direction: BACKWARD})
+	assert.NotNil(t, err)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = queryByIndex(canceled, f, "users", "idx_city", nil, nil)
+	assert.Equal(t, context.Canceled, err)
+}
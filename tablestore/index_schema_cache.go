@@ -0,0 +1,126 @@
+package tablestore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+// DefaultIndexSchemaTTL is how long an IndexSchemaCache keeps the schema of
+// a search index.
+const DefaultIndexSchemaTTL = 5 * time.Minute
+
+// indexDescriber is the part of *TableStoreClient an IndexSchemaCache reads
+// schemas with.
+type indexDescriber interface {
+	DescribeSearchIndex(request *DescribeSearchIndexRequest) (*DescribeSearchIndexResponse, error)
+}
+
+// IndexSchemaCache keeps the schemas of search indexes for a TTL, so that
+// search queries can be validated without a DescribeSearchIndex call each.
+// Schema changes made elsewhere are seen once the entry expires, or right
+// away after Invalidate. IndexSchemaCache is safe for concurrent use.
+type IndexSchemaCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[indexSchemaKey]*indexSchemaEntry
+}
+
+type indexSchemaKey struct {
+	table string
+	index string
+}
+
+type indexSchemaEntry struct {
+	schema  *IndexSchema
+	expires time.Time
+}
+
+// NewIndexSchemaCache keeps schemas for ttl, zero means
+// DefaultIndexSchemaTTL.
+func NewIndexSchemaCache(ttl time.Duration) *IndexSchemaCache {
+	if ttl <= 0 {
+		ttl = DefaultIndexSchemaTTL
+	}
+	return &IndexSchemaCache{ttl: ttl, now: time.Now, entries: make(map[indexSchemaKey]*indexSchemaEntry)}
+}
+
+// Invalidate drops the schema of an index, the next read describes it again.
+func (c *IndexSchemaCache) Invalidate(tableName, indexName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, indexSchemaKey{table: tableName, index: indexName})
+}
+
+// Purge drops every schema.
+func (c *IndexSchemaCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[indexSchemaKey]*indexSchemaEntry)
+}
+
+func (c *IndexSchemaCache) schema(client indexDescriber, tableName, indexName string) (*IndexSchema, error) {
+	key := indexSchemaKey{table: tableName, index: indexName}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.schema, nil
+	}
+
+	resp, err := client.DescribeSearchIndex(&DescribeSearchIndexRequest{TableName: tableName, IndexName: indexName})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = &indexSchemaEntry{schema: resp.Schema, expires: c.now().Add(c.ttl)}
+	return resp.Schema, nil
+}
+
+// SetIndexSchemaCache replaces the cache IndexFields and
+// ValidateSearchRequest read schemas from, clients share a cache this way.
+func SetIndexSchemaCache(cache *IndexSchemaCache) ClientOption {
+	return func(client *TableStoreClient) {
+		client.indexSchemaCache = cache
+	}
+}
+
+// IndexSchemaCache returns the schema cache of the client.
+func (tableStoreClient *TableStoreClient) IndexSchemaCache() *IndexSchemaCache {
+	return tableStoreClient.indexSchemaCache
+}
+
+// IndexFields returns the fields of a search index for search.Validate,
+// from the schema cache of the client.
+func (tableStoreClient *TableStoreClient) IndexFields(tableName, indexName string) (search.IndexFields, error) {
+	schema, err := tableStoreClient.indexSchema(tableName, indexName)
+	if err != nil || schema == nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// ValidateSearchRequest checks the query of a search request against the
+// cached schema of its index, see search.Validate.
+func (tableStoreClient *TableStoreClient) ValidateSearchRequest(request *SearchRequest) error {
+	fields, err := tableStoreClient.IndexFields(request.TableName, request.IndexName)
+	if err != nil {
+		return err
+	}
+	return search.Validate(request.SearchQuery, fields)
+}
+
+func (tableStoreClient *TableStoreClient) indexSchema(tableName, indexName string) (*IndexSchema, error) {
+	if tableStoreClient.indexSchemaCache == nil {
+		resp, err := tableStoreClient.DescribeSearchIndex(&DescribeSearchIndexRequest{TableName: tableName, IndexName: indexName})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Schema, nil
+	}
+	return tableStoreClient.indexSchemaCache.schema(tableStoreClient, tableName, indexName)
+}
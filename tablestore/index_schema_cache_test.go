@@ -0,0 +1,84 @@
+package tablestore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDescriber returns a schema with a KEYWORD field per index and counts
+// the calls.
+type fakeDescriber struct {
+	calls int
+	err   error
+}
+
+func (d *fakeDescriber) DescribeSearchIndex(request *DescribeSearchIndexRequest) (*DescribeSearchIndexResponse, error) {
+	d.calls++
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &DescribeSearchIndexResponse{Schema: &IndexSchema{FieldSchemas: []*FieldSchema{
+		{FieldName: proto.String(request.IndexName + "_field"), FieldType: FieldType_KEYWORD},
+	}}}, nil
+}
+
+func TestIndexSchemaCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cache := NewIndexSchemaCache(time.Minute)
+	cache.now = func() time.Time { return now }
+	describer := &fakeDescriber{}
+
+	schema, err := cache.schema(describer, "t", "a")
+	assert.Nil(t, err)
+	info, ok := schema.FieldInfo("a_field")
+	assert.True(t, ok)
+	assert.Equal(t, "KEYWORD", info.Type)
+	cache.schema(describer, "t", "a")
+	cache.schema(describer, "t", "b")
+	assert.Equal(t, 2, describer.calls)
+
+	cache.Invalidate("t", "a")
+	cache.schema(describer, "t", "a")
+	assert.Equal(t, 3, describer.calls)
+
+	now = now.Add(time.Minute)
+	cache.schema(describer, "t", "b")
+	assert.Equal(t, 4, describer.calls)
+
+	cache.Purge()
+	describer.err = errors.New("no index")
+	_, err = cache.schema(describer, "t", "a")
+	assert.Equal(t, describer.err, err)
+	// errors are not cached
+	describer.err = nil
+	_, err = cache.schema(describer, "t", "a")
+	assert.Nil(t, err)
+	assert.Equal(t, 6, describer.calls)
+}
+
+func TestClientIndexSchemaCache(t *testing.T) {
+	cache := NewIndexSchemaCache(0)
+	client := NewClient("endpoint", "instance", "id", "secret", SetIndexSchemaCache(cache))
+	assert.Equal(t, cache, client.IndexSchemaCache())
+	assert.NotNil(t, NewClient("endpoint", "instance", "id", "secret").IndexSchemaCache())
+
+	// a cached schema answers without a call to the server
+	cache.entries[indexSchemaKey{table: "t", index: "a"}] = &indexSchemaEntry{
+		schema:  &IndexSchema{FieldSchemas: []*FieldSchema{{FieldName: proto.String("city"), FieldType: FieldType_KEYWORD}}},
+		expires: time.Now().Add(time.Hour),
+	}
+	fields, err := client.IndexFields("t", "a")
+	assert.Nil(t, err)
+	_, ok := fields.FieldInfo("city")
+	assert.True(t, ok)
+
+	request := &SearchRequest{TableName: "t", IndexName: "a", SearchQuery: search.NewSearchQuery().SetQuery(&search.TermQuery{FieldName: "city", Term: "hangzhou"})}
+	assert.Nil(t, client.ValidateSearchRequest(request))
+	request.SearchQuery = search.NewSearchQuery().SetQuery(&search.TermQuery{FieldName: "missing", Term: "x"})
+	assert.Error(t, client.ValidateSearchRequest(request))
+}
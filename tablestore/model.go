@@ -40,6 +40,9 @@ type internalClient struct {
 	credentialsProvider     common.CredentialsProvider
 
 	RetryNotify RetryNotify
+
+	usageAccountant  *UsageAccountant
+	indexSchemaCache *IndexSchemaCache
 }
 
 const initMapLen int = 8
@@ -605,8 +608,11 @@ type RowResult struct {
 
 type RowChange interface {
 	Serialize() []byte
+	encodedSize() int
+	appendTo(buf []byte) []byte
 	getOperationType() otsprotocol.OperationType
 	getCondition() *otsprotocol.Condition
+	fillCondition(condition *otsprotocol.Condition, existence *otsprotocol.RowExistenceExpectation) *otsprotocol.Condition
 	GetTableName() string
 }
 
@@ -0,0 +1,313 @@
+package tablestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+const (
+	// defaultParallelScanRestarts bounds how often a scan starts over after
+	// its session expired
+	defaultParallelScanRestarts = 3
+)
+
+var (
+	errInvalidParallelScanOptions = func(format string, args ...interface{}) error {
+		return errors.New("[tablestore] invalid parallel scan options: " + fmt.Sprintf(format, args...))
+	}
+)
+
+type ParallelScanOptions struct {
+	TableName string
+	IndexName string
+	// Query selects the rows, all rows of the index when nil.
+	Query search.Query
+	// ColumnsToGet are the columns returned besides the primary key, only
+	// the primary key when nil. ReturnAll is not allowed, use
+	// ReturnAllFromIndex instead.
+	ColumnsToGet *ColumnsToGet
+	// Workers is the number of parallel ids read at the same time. It is
+	// the MaxParallel ComputeSplits returns when 0 or larger.
+	Workers int
+	// Limit is the number of rows of a page, the server default when 0.
+	Limit int32
+	// AliveTime is how long in seconds the session and the tokens stay
+	// valid between two pages, 60 seconds when 0. A consumer slower than
+	// that lets the session expire.
+	AliveTime int32
+	TimeoutMs int32
+	// MaxRestarts is how often the scan starts over with a new session
+	// after the session expired, 3 when 0 and never when negative.
+	MaxRestarts int
+	// OnProgress is called after each page is delivered, from the worker
+	// that read it.
+	OnProgress func(progress *ParallelScanProgress)
+}
+
+type ParallelScanProgress struct {
+	// MaxParallel is the number of parallel ids of the current session.
+	MaxParallel int32
+	// Done is the number of parallel ids read to the end, including those
+	// finished before a restart that are not read again.
+	Done  int
+	Pages int64
+	// Rows is the number of rows delivered, rows delivered again after a
+	// restart are counted twice.
+	Rows     int64
+	Restarts int
+	Duration time.Duration
+}
+
+func (p *ParallelScanProgress) String() string {
+	return fmt.Sprintf("parallel ids %d/%d, pages %d, rows %d, restarts %d, took %s",
+		p.Done, p.MaxParallel, p.Pages, p.Rows, p.Restarts, p.Duration)
+}
+
+// parallelScanClient is the part of the client a ParallelScanner uses.
+type parallelScanClient interface {
+	ComputeSplits(request *ComputeSplitsRequest) (*ComputeSplitsResponse, error)
+	ParallelScan(request *ParallelScanRequest) (*ParallelScanResponse, error)
+}
+
+// ParallelScanner reads all rows a query matches from a search index with
+// ParallelScan. It creates a session with ComputeSplits, reads every
+// parallel id of the session on its own worker, following the NextToken of
+// each page, and starts over with a new session when the session expired.
+//
+// A new session with as many parallel ids as the expired one reads only
+// the ids that were not read to the end. The rows an unfinished id
+// delivered before the restart are delivered again, all of them when the
+// number of parallel ids changed, so whatever consumes them has to be
+// idempotent or deduplicate by primary key.
+type ParallelScanner struct {
+	client  parallelScanClient
+	options ParallelScanOptions
+
+	mu       sync.Mutex
+	progress ParallelScanProgress
+	started  time.Time
+	// finished holds the parallel ids read to the end out of finishedOf
+	finished   map[int32]bool
+	finishedOf int32
+}
+
+// parallelScanSessionExpired marks a ParallelScan error that expired the
+// session, as opposed to the same error returned by the consumer.
+type parallelScanSessionExpired struct {
+	err error
+}
+
+func (e *parallelScanSessionExpired) Error() string {
+	return e.err.Error()
+}
+
+func (tableStoreClient *TableStoreClient) NewParallelScanner(options ParallelScanOptions) (*ParallelScanner, error) {
+	return newParallelScanner(tableStoreClient, options)
+}
+
+func newParallelScanner(client parallelScanClient, options ParallelScanOptions) (*ParallelScanner, error) {
+	if options.TableName == "" || options.IndexName == "" {
+		return nil, errInvalidParallelScanOptions("table and index name are required")
+	}
+	if options.ColumnsToGet != nil && options.ColumnsToGet.ReturnAll {
+		return nil, errInvalidParallelScanOptions("ReturnAll is not allowed, use ReturnAllFromIndex")
+	}
+	if options.Workers < 0 || options.Limit < 0 || options.AliveTime < 0 {
+		return nil, errInvalidParallelScanOptions("negative Workers, Limit or AliveTime")
+	}
+	if options.Query == nil {
+		options.Query = &search.MatchAllQuery{}
+	}
+	if options.MaxRestarts == 0 {
+		options.MaxRestarts = defaultParallelScanRestarts
+	}
+	return &ParallelScanner{client: client, options: options}, nil
+}
+
+// Scan calls fn with every row. fn is called from several workers at the
+// same time, an error it returns stops the scan and is returned. The
+// progress is returned either way.
+func (s *ParallelScanner) Scan(ctx context.Context, fn func(row *Row) error) (*ParallelScanProgress, error) {
+	s.mu.Lock()
+	s.progress = ParallelScanProgress{}
+	s.started = time.Now()
+	s.finished, s.finishedOf = nil, 0
+	s.mu.Unlock()
+
+	for {
+		err := s.scanSession(ctx, fn)
+		expired, ok := err.(*parallelScanSessionExpired)
+		if !ok {
+			return s.report(), err
+		}
+		s.mu.Lock()
+		restarts := s.progress.Restarts
+		if restarts < s.options.MaxRestarts {
+			s.progress.Restarts++
+		}
+		s.mu.Unlock()
+		if restarts >= s.options.MaxRestarts {
+			return s.report(), fmt.Errorf("[tablestore] parallel scan session expired after %d restarts: %w", restarts, expired.err)
+		}
+	}
+}
+
+// ScanToChannel sends every row to rows and closes it at the end of the
+// scan, see Scan.
+func (s *ParallelScanner) ScanToChannel(ctx context.Context, rows chan<- *Row) (*ParallelScanProgress, error) {
+	defer close(rows)
+	return s.Scan(ctx, func(row *Row) error {
+		select {
+		case rows <- row:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+func (s *ParallelScanner) scanSession(ctx context.Context, fn func(row *Row) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	request := new(ComputeSplitsRequest).
+		SetTableName(s.options.TableName).
+		SetSearchIndexSplitsOptions(SearchIndexSplitsOptions{IndexName: s.options.IndexName})
+	splits, err := s.client.ComputeSplits(request)
+	if err != nil {
+		return err
+	}
+	maxParallel := splits.SplitsSize
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	workers := int(maxParallel)
+	if s.options.Workers > 0 && s.options.Workers < workers {
+		workers = s.options.Workers
+	}
+	s.mu.Lock()
+	if maxParallel != s.finishedOf {
+		s.finished, s.finishedOf = make(map[int32]bool), maxParallel
+	}
+	var pending []int32
+	for id := int32(0); id < maxParallel; id++ {
+		if !s.finished[id] {
+			pending = append(pending, id)
+		}
+	}
+	s.progress.MaxParallel = maxParallel
+	s.progress.Done = len(s.finished)
+	s.mu.Unlock()
+	if len(pending) < workers {
+		workers = len(pending)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	ids := make(chan int32)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				if err := s.scanParallelId(ctx, splits.SessionId, maxParallel, id, fn); err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, id := range pending {
+		select {
+		case ids <- id:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(ids)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func (s *ParallelScanner) scanParallelId(ctx context.Context, sessionId []byte, maxParallel, id int32, fn func(row *Row) error) error {
+	var token []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		query := search.NewScanQuery().
+			SetQuery(s.options.Query).
+			SetCurrentParallelID(id).
+			SetMaxParallel(maxParallel).
+			SetToken(token)
+		if s.options.Limit > 0 {
+			query.SetLimit(s.options.Limit)
+		}
+		if s.options.AliveTime > 0 {
+			query.SetAliveTime(s.options.AliveTime)
+		}
+		request := &ParallelScanRequest{
+			TableName:    s.options.TableName,
+			IndexName:    s.options.IndexName,
+			ScanQuery:    query,
+			ColumnsToGet: s.options.ColumnsToGet,
+			SessionId:    sessionId,
+		}
+		if s.options.TimeoutMs > 0 {
+			request.SetTimeoutMs(s.options.TimeoutMs)
+		}
+		resp, err := s.client.ParallelScan(request)
+		if err != nil {
+			if isSearchTokenExpired(err) {
+				return &parallelScanSessionExpired{err: err}
+			}
+			return err
+		}
+		for _, row := range resp.Rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		token = resp.NextToken
+
+		s.mu.Lock()
+		s.progress.Pages++
+		s.progress.Rows += int64(len(resp.Rows))
+		if len(token) == 0 {
+			s.finished[id] = true
+			s.progress.Done = len(s.finished)
+		}
+		s.mu.Unlock()
+		if s.options.OnProgress != nil {
+			s.options.OnProgress(s.report())
+		}
+		if len(token) == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *ParallelScanner) report() *ParallelScanProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	progress := s.progress
+	progress.Duration = time.Since(s.started)
+	return &progress
+}
@@ -0,0 +1,229 @@
+package tablestore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ parallelScanClient = (*TableStoreClient)(nil)
+
+// fakeScanIndex holds rows 0..total-1, parallel id p of a session reads the
+// rows i with i % maxParallel == p, pageSize at a time.
+type fakeScanIndex struct {
+	mu          sync.Mutex
+	total       int
+	maxParallel int32
+	pageSize    int
+	sessions    int
+	// expireSessions makes the second page of these sessions fail as
+	// expired, for the parallel ids in expireIds only when it is set
+	expireSessions map[int]bool
+	expireIds      map[int32]bool
+
+	queries []*otsprotocol.ScanQuery
+}
+
+func (f *fakeScanIndex) ComputeSplits(request *ComputeSplitsRequest) (*ComputeSplitsResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sessions++
+	return &ComputeSplitsResponse{SessionId: []byte(strconv.Itoa(f.sessions)), SplitsSize: f.maxParallel}, nil
+}
+
+func (f *fakeScanIndex) ParallelScan(request *ParallelScanRequest) (*ParallelScanResponse, error) {
+	data, err := request.ScanQuery.Serialize()
+	if err != nil {
+		return nil, err
+	}
+	query := new(otsprotocol.ScanQuery)
+	if err := proto.Unmarshal(data, query); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries = append(f.queries, query)
+
+	session, _ := strconv.Atoi(string(request.SessionId))
+	if session != f.sessions {
+		return nil, &OtsError{Code: "OTSSessionExpired", Message: "session expired"}
+	}
+	start := 0
+	if len(query.Token) > 0 {
+		start, _ = strconv.Atoi(string(query.Token))
+		if f.expireSessions[session] && (f.expireIds == nil || f.expireIds[query.GetCurrentParallelId()]) {
+			return nil, &OtsError{Code: "OTSSessionExpired", Message: "session expired"}
+		}
+	}
+	var ids []int
+	for i := int(query.GetCurrentParallelId()); i < f.total; i += int(query.GetMaxParallel()) {
+		ids = append(ids, i)
+	}
+	resp := &ParallelScanResponse{}
+	end := start + f.pageSize
+	if end >= len(ids) {
+		end = len(ids)
+	} else {
+		resp.NextToken = []byte(strconv.Itoa(end))
+	}
+	for _, i := range ids[start:end] {
+		pk := new(PrimaryKey)
+		pk.AddPrimaryKeyColumn("id", int64(i))
+		resp.Rows = append(resp.Rows, &Row{PrimaryKey: pk})
+	}
+	return resp, nil
+}
+
+func scanIds(s *ParallelScanner) ([]int, *ParallelScanProgress, error) {
+	var mu sync.Mutex
+	var ids []int
+	progress, err := s.Scan(context.Background(), func(row *Row) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, int(row.PrimaryKey.PrimaryKeys[0].Value.(int64)))
+		return nil
+	})
+	sort.Ints(ids)
+	return ids, progress, err
+}
+
+func TestParallelScanner_Scan(t *testing.T) {
+	f := &fakeScanIndex{total: 20, maxParallel: 3, pageSize: 2}
+	var mu sync.Mutex
+	var calls int
+	s, err := newParallelScanner(f, ParallelScanOptions{
+		TableName: "table",
+		IndexName: "index",
+		Workers:   2,
+		Limit:     2,
+		AliveTime: 30,
+		OnProgress: func(progress *ParallelScanProgress) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+		},
+	})
+	assert.Nil(t, err)
+
+	ids, progress, err := scanIds(s)
+	assert.Nil(t, err)
+	assert.Len(t, ids, 20)
+	for i, id := range ids {
+		assert.Equal(t, i, id)
+	}
+	assert.Equal(t, int32(3), progress.MaxParallel)
+	assert.Equal(t, 3, progress.Done)
+	assert.Equal(t, int64(20), progress.Rows)
+	assert.Equal(t, 0, progress.Restarts)
+	assert.Equal(t, int(progress.Pages), calls)
+
+	for _, query := range f.queries {
+		assert.Equal(t, int32(3), query.GetMaxParallel())
+		assert.Equal(t, int32(2), query.GetLimit())
+		assert.Equal(t, int32(30), query.GetAliveTime())
+		assert.NotNil(t, query.Query)
+	}
+}
+
+func TestParallelScanner_Restart(t *testing.T) {
+	f := &fakeScanIndex{total: 10, maxParallel: 2, pageSize: 2, expireSessions: map[int]bool{1: true}}
+	s, err := newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index"})
+	assert.Nil(t, err)
+
+	ids, progress, err := scanIds(s)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, f.sessions)
+	assert.Equal(t, 1, progress.Restarts)
+	// the rows of the first pages are delivered twice
+	assert.True(t, len(ids) > 10)
+	assert.Equal(t, int64(len(ids)), progress.Rows)
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+	assert.Len(t, seen, 10)
+
+	f = &fakeScanIndex{total: 10, maxParallel: 2, pageSize: 2, expireSessions: map[int]bool{1: true, 2: true}}
+	s, _ = newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index", MaxRestarts: 1})
+	_, progress, err = scanIds(s)
+	var otsErr *OtsError
+	assert.True(t, errors.As(err, &otsErr))
+	assert.Equal(t, "OTSSessionExpired", otsErr.Code)
+	assert.Equal(t, 1, progress.Restarts)
+
+	f = &fakeScanIndex{total: 10, maxParallel: 2, pageSize: 2, expireSessions: map[int]bool{1: true}}
+	s, _ = newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index", MaxRestarts: -1})
+	_, _, err = scanIds(s)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, f.sessions)
+}
+
+func TestParallelScanner_RestartUnfinished(t *testing.T) {
+	// one worker reads parallel id 0 to the end, then id 1 expires the
+	// session
+	f := &fakeScanIndex{total: 10, maxParallel: 2, pageSize: 2, expireSessions: map[int]bool{1: true}, expireIds: map[int32]bool{1: true}}
+	s, err := newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index", Workers: 1})
+	assert.Nil(t, err)
+
+	ids, progress, err := scanIds(s)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, f.sessions)
+	assert.Equal(t, 1, progress.Restarts)
+	assert.Equal(t, 2, progress.Done)
+	// only the first page of id 1 is delivered twice
+	assert.Equal(t, []int{0, 1, 1, 2, 3, 3, 4, 5, 6, 7, 8, 9}, ids)
+
+	// the new session reads the 3 pages of id 1 only
+	assert.Len(t, f.queries, 3+2+3)
+	for _, query := range f.queries[5:] {
+		assert.Equal(t, int32(1), query.GetCurrentParallelId())
+	}
+}
+
+func TestParallelScanner_Errors(t *testing.T) {
+	f := &fakeScanIndex{total: 10, maxParallel: 4, pageSize: 1}
+	s, _ := newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index"})
+	// an expired error of the consumer does not restart the scan
+	stop := &OtsError{Code: "OTSSessionExpired"}
+	_, err := s.Scan(context.Background(), func(row *Row) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, 1, f.sessions)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Scan(ctx, func(row *Row) error { return nil })
+	assert.Equal(t, context.Canceled, err)
+
+	_, err = newParallelScanner(f, ParallelScanOptions{TableName: "table"})
+	assert.NotNil(t, err)
+	_, err = newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index", ColumnsToGet: &ColumnsToGet{ReturnAll: true}})
+	assert.NotNil(t, err)
+	_, err = newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index", Workers: -1})
+	assert.NotNil(t, err)
+}
+
+func TestParallelScanner_ScanToChannel(t *testing.T) {
+	f := &fakeScanIndex{total: 50, maxParallel: 5, pageSize: 3}
+	s, _ := newParallelScanner(f, ParallelScanOptions{TableName: "table", IndexName: "index"})
+	rows := make(chan *Row)
+	done := make(chan error)
+	go func() {
+		_, err := s.ScanToChannel(context.Background(), rows)
+		done <- err
+	}()
+	n := 0
+	for range rows {
+		n++
+	}
+	assert.Nil(t, <-done)
+	assert.Equal(t, 50, n)
+}
@@ -0,0 +1,350 @@
+package tablestore
+
+import (
+	"math"
+	"sync"
+)
+
+// The functions in this file serialize rows straight into a preallocated byte
+// slice. Sizes are computed up front and checksums are folded in while the
+// bytes are written, so encoding a row needs no intermediate cell objects.
+
+const (
+	cellNameOverhead     = 1 + 1 + LITTLE_ENDIAN_32_SIZE // TAG_CELL + TAG_CELL_NAME + length
+	cellValueOverhead    = 1 + LITTLE_ENDIAN_32_SIZE + 1 // TAG_CELL_VALUE + length + type
+	cellChecksumOverhead = 2                             // TAG_CELL_CHECKSUM + checksum
+	rowOverhead          = LITTLE_ENDIAN_32_SIZE + 1 + 2 // header + TAG_ROW_PK + TAG_ROW_CHECKSUM + checksum
+
+	maxPooledRowBufferSize = 4 << 20
+)
+
+var rowBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &rowBuffer{}
+	},
+}
+
+// rowBuffer is a pooled slab that holds the serialized rows of one request.
+// It must not be released before the request that references it is done.
+type rowBuffer struct {
+	buf []byte
+}
+
+func getRowBuffer(size int) *rowBuffer {
+	b := rowBufferPool.Get().(*rowBuffer)
+	if cap(b.buf) < size {
+		b.buf = make([]byte, 0, size)
+	}
+	b.buf = b.buf[:0]
+	return b
+}
+
+func putRowBuffer(b *rowBuffer) {
+	if b == nil || cap(b.buf) > maxPooledRowBufferSize {
+		return
+	}
+	rowBufferPool.Put(b)
+}
+
+// next carves the next size bytes out of the slab. The returned slice has its
+// capacity capped so appending to it can not clobber the following row.
+func (b *rowBuffer) next(size int) []byte {
+	start := len(b.buf)
+	if cap(b.buf)-start < size {
+		return make([]byte, 0, size)
+	}
+	b.buf = b.buf[:start+size]
+	return b.buf[start : start : start+size]
+}
+
+func appendRawLittleEndian32(buf []byte, value int32) []byte {
+	return append(buf, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+func appendRawLittleEndian64(buf []byte, value int64) []byte {
+	return append(buf, byte(value), byte(value>>8), byte(value>>16), byte(value>>24),
+		byte(value>>32), byte(value>>40), byte(value>>48), byte(value>>56))
+}
+
+func crc8String(crc byte, in string) byte {
+	for i := 0; i < len(in); i++ {
+		crc = crc8Table[crc^in[i]]
+	}
+	return crc
+}
+
+// attributeValueSize returns the encoded size of TAG_CELL_VALUE and the value.
+// value only support int64,string,bool,float64,[]byte. other type will get panic
+func attributeValueSize(value interface{}) int {
+	switch v := value.(type) {
+	case nil:
+		return 1
+	case string:
+		return cellValueOverhead + LITTLE_ENDIAN_32_SIZE + len(v)
+	case int64, float64:
+		return cellValueOverhead + LITTLE_ENDIAN_64_SIZE
+	case bool:
+		return cellValueOverhead + 1
+	case []byte:
+		return cellValueOverhead + LITTLE_ENDIAN_32_SIZE + len(v)
+	default:
+		panic(errInvalidInput)
+	}
+}
+
+func appendAttributeValue(buf []byte, crc byte, value interface{}) ([]byte, byte) {
+	buf = append(buf, TAG_CELL_VALUE)
+	switch v := value.(type) {
+	case string:
+		buf = appendRawLittleEndian32(buf, int32(LITTLE_ENDIAN_32_SIZE+1+len(v)))
+		buf = append(buf, VT_STRING)
+		buf = appendRawLittleEndian32(buf, int32(len(v)))
+		buf = append(buf, v...)
+		crc = crc8Byte(crc, VT_STRING)
+		crc = crc8Int32(crc, int32(len(v)))
+		crc = crc8String(crc, v)
+	case int64:
+		buf = appendRawLittleEndian32(buf, LITTLE_ENDIAN_64_SIZE+1)
+		buf = append(buf, VT_INTEGER)
+		buf = appendRawLittleEndian64(buf, v)
+		crc = crc8Byte(crc, VT_INTEGER)
+		crc = crc8Int64(crc, v)
+	case bool:
+		b := byte(0x0)
+		if v {
+			b = 0x1
+		}
+		buf = appendRawLittleEndian32(buf, 2)
+		buf = append(buf, VT_BOOLEAN, b)
+		crc = crc8Byte(crc, VT_BOOLEAN)
+		crc = crc8Byte(crc, b)
+	case float64:
+		bits := int64(math.Float64bits(v))
+		buf = appendRawLittleEndian32(buf, LITTLE_ENDIAN_64_SIZE+1)
+		buf = append(buf, VT_DOUBLE)
+		buf = appendRawLittleEndian64(buf, bits)
+		crc = crc8Byte(crc, VT_DOUBLE)
+		crc = crc8Int64(crc, bits)
+	case []byte:
+		buf = appendRawLittleEndian32(buf, int32(LITTLE_ENDIAN_32_SIZE+1+len(v)))
+		buf = append(buf, VT_BLOB)
+		buf = appendRawLittleEndian32(buf, int32(len(v)))
+		buf = append(buf, v...)
+		crc = crc8Byte(crc, VT_BLOB)
+		crc = crc8Int32(crc, int32(len(v)))
+		crc = crc8Bytes(crc, v)
+	}
+	return buf, crc
+}
+
+func primaryKeyColumnSize(pk *PrimaryKeyColumn) int {
+	size := cellNameOverhead + len(pk.ColumnName) + cellChecksumOverhead
+	if pk.PrimaryKeyOption != NONE {
+		return size + cellValueOverhead
+	}
+	switch v := pk.Value.(type) {
+	case string:
+		return size + cellValueOverhead + LITTLE_ENDIAN_32_SIZE + len(v)
+	case int64:
+		return size + cellValueOverhead + LITTLE_ENDIAN_64_SIZE
+	case []byte:
+		return size + cellValueOverhead + LITTLE_ENDIAN_32_SIZE + len(v)
+	default:
+		panic(errInvalidInput)
+	}
+}
+
+func appendPrimaryKeyColumn(buf []byte, pk *PrimaryKeyColumn) ([]byte, byte) {
+	buf = append(buf, TAG_CELL, TAG_CELL_NAME)
+	buf = appendRawLittleEndian32(buf, int32(len(pk.ColumnName)))
+	buf = append(buf, pk.ColumnName...)
+	crc := crc8String(byte(0x0), pk.ColumnName)
+
+	var vt byte
+	switch pk.PrimaryKeyOption {
+	case AUTO_INCREMENT:
+		vt = VT_AUTO_INCREMENT
+	case MIN:
+		vt = VT_INF_MIN
+	case MAX:
+		vt = VT_INF_MAX
+	default:
+		buf, crc = appendAttributeValue(buf, crc, pk.Value)
+		return append(buf, TAG_CELL_CHECKSUM, crc), crc
+	}
+
+	buf = append(buf, TAG_CELL_VALUE)
+	buf = appendRawLittleEndian32(buf, 1)
+	buf = append(buf, vt)
+	crc = crc8Byte(crc, vt)
+	return append(buf, TAG_CELL_CHECKSUM, crc), crc
+}
+
+func (pk *PrimaryKey) primaryKeyCellsSize() int {
+	size := 0
+	for _, column := range pk.PrimaryKeys {
+		size += primaryKeyColumnSize(column)
+	}
+	return size
+}
+
+func (pk *PrimaryKey) appendPrimaryKeyCells(buf []byte, rowChecksum byte) ([]byte, byte) {
+	var cellChecksum byte
+	for _, column := range pk.PrimaryKeys {
+		buf, cellChecksum = appendPrimaryKeyColumn(buf, column)
+		rowChecksum = crc8Byte(rowChecksum, cellChecksum)
+	}
+	return buf, rowChecksum
+}
+
+func (pk *PrimaryKey) encodedSize(isDelete bool) int {
+	size := rowOverhead + pk.primaryKeyCellsSize()
+	if isDelete {
+		size++
+	}
+	return size
+}
+
+func (pk *PrimaryKey) appendTo(buf []byte, isDelete bool) []byte {
+	buf = appendRawLittleEndian32(buf, HEADER)
+	buf = append(buf, TAG_ROW_PK)
+	buf, rowChecksum := pk.appendPrimaryKeyCells(buf, byte(0x0))
+
+	// 没有deleteMarker, 要与0x0做crc.
+	if isDelete {
+		buf = append(buf, TAG_DELETE_ROW_MARKER)
+		rowChecksum = crc8Byte(rowChecksum, byte(0x1))
+	} else {
+		rowChecksum = crc8Byte(rowChecksum, byte(0x0))
+	}
+	return append(buf, TAG_ROW_CHECKSUM, rowChecksum)
+}
+
+func attributeColumnSize(column *AttributeColumn) int {
+	size := cellNameOverhead + len(column.ColumnName) + attributeValueSize(column.Value) + cellChecksumOverhead
+	if column.Timestamp != 0 {
+		size += 1 + LITTLE_ENDIAN_64_SIZE
+	}
+	return size
+}
+
+func appendAttributeColumn(buf []byte, column *AttributeColumn) ([]byte, byte) {
+	buf = append(buf, TAG_CELL, TAG_CELL_NAME)
+	buf = appendRawLittleEndian32(buf, int32(len(column.ColumnName)))
+	buf = append(buf, column.ColumnName...)
+	crc := crc8String(byte(0x0), column.ColumnName)
+
+	buf, crc = appendAttributeValue(buf, crc, column.Value)
+	if column.Timestamp != 0 {
+		buf = append(buf, TAG_CELL_TIMESTAMP)
+		buf = appendRawLittleEndian64(buf, column.Timestamp)
+		crc = crc8Int64(crc, column.Timestamp)
+	}
+	return append(buf, TAG_CELL_CHECKSUM, crc), crc
+}
+
+func columnToUpdateSize(column *ColumnToUpdate) int {
+	size := cellNameOverhead + len(column.ColumnName) + cellChecksumOverhead
+	if !column.IgnoreValue {
+		size += attributeValueSize(column.Value)
+	} else if column.Value != nil {
+		// keep the panic on unsupported values even if the value is not written
+		attributeValueSize(column.Value)
+	}
+	if column.HasType {
+		size += 2
+	}
+	if column.HasTimestamp {
+		size += 1 + LITTLE_ENDIAN_64_SIZE
+	}
+	return size
+}
+
+func appendColumnToUpdate(buf []byte, column *ColumnToUpdate) ([]byte, byte) {
+	buf = append(buf, TAG_CELL, TAG_CELL_NAME)
+	buf = appendRawLittleEndian32(buf, int32(len(column.ColumnName)))
+	buf = append(buf, column.ColumnName...)
+	crc := crc8String(byte(0x0), column.ColumnName)
+
+	if !column.IgnoreValue {
+		buf, crc = appendAttributeValue(buf, crc, column.Value)
+	}
+	if column.HasType {
+		buf = append(buf, TAG_CELL_TYPE, column.Type)
+	}
+	if column.HasTimestamp {
+		buf = append(buf, TAG_CELL_TIMESTAMP)
+		buf = appendRawLittleEndian64(buf, column.Timestamp)
+		crc = crc8Int64(crc, column.Timestamp)
+	}
+	if column.HasType {
+		crc = crc8Byte(crc, column.Type)
+	}
+	return append(buf, TAG_CELL_CHECKSUM, crc), crc
+}
+
+func (rowchange *PutRowChange) encodedSize() int {
+	size := rowOverhead + rowchange.PrimaryKey.primaryKeyCellsSize()
+	if len(rowchange.Columns) > 0 {
+		size++
+		for i := range rowchange.Columns {
+			size += attributeColumnSize(&rowchange.Columns[i])
+		}
+	}
+	return size
+}
+
+func (rowchange *PutRowChange) appendTo(buf []byte) []byte {
+	buf = appendRawLittleEndian32(buf, HEADER)
+	buf = append(buf, TAG_ROW_PK)
+	buf, rowChecksum := rowchange.PrimaryKey.appendPrimaryKeyCells(buf, byte(0x0))
+
+	if len(rowchange.Columns) > 0 {
+		buf = append(buf, TAG_ROW_DATA)
+		var cellChecksum byte
+		for i := range rowchange.Columns {
+			buf, cellChecksum = appendAttributeColumn(buf, &rowchange.Columns[i])
+			rowChecksum = crc8Byte(rowChecksum, cellChecksum)
+		}
+	}
+
+	rowChecksum = crc8Byte(rowChecksum, byte(0x0))
+	return append(buf, TAG_ROW_CHECKSUM, rowChecksum)
+}
+
+func (rowchange *UpdateRowChange) encodedSize() int {
+	size := rowOverhead + rowchange.PrimaryKey.primaryKeyCellsSize()
+	if len(rowchange.Columns) > 0 {
+		size++
+		for i := range rowchange.Columns {
+			size += columnToUpdateSize(&rowchange.Columns[i])
+		}
+	}
+	return size
+}
+
+func (rowchange *UpdateRowChange) appendTo(buf []byte) []byte {
+	buf = appendRawLittleEndian32(buf, HEADER)
+	buf = append(buf, TAG_ROW_PK)
+	buf, rowChecksum := rowchange.PrimaryKey.appendPrimaryKeyCells(buf, byte(0x0))
+
+	if len(rowchange.Columns) > 0 {
+		buf = append(buf, TAG_ROW_DATA)
+		var cellChecksum byte
+		for i := range rowchange.Columns {
+			buf, cellChecksum = appendColumnToUpdate(buf, &rowchange.Columns[i])
+			rowChecksum = crc8Byte(rowChecksum, cellChecksum)
+		}
+	}
+
+	rowChecksum = crc8Byte(rowChecksum, byte(0x0))
+	return append(buf, TAG_ROW_CHECKSUM, rowChecksum)
+}
+
+func (rowchange *DeleteRowChange) encodedSize() int {
+	return rowchange.PrimaryKey.encodedSize(true)
+}
+
+func (rowchange *DeleteRowChange) appendTo(buf []byte) []byte {
+	return rowchange.PrimaryKey.appendTo(buf, true)
+}
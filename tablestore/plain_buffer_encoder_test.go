@@ -0,0 +1,242 @@
+package tablestore
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/plainbuffer"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// legacyPrimaryKeyBuild is the PrimaryKey.Build implementation the single
+// pass encoder replaced. It is kept here as the reference output.
+func legacyPrimaryKeyBuild(pk *PrimaryKey, isDelete bool) []byte {
+	var b bytes.Buffer
+	writeHeader(&b)
+	writeTag(&b, TAG_ROW_PK)
+
+	rowChecksum := byte(0x0)
+	var cellChecksum byte
+
+	for _, column := range pk.PrimaryKeys {
+		primaryKeyColumn := NewPrimaryKeyColumn([]byte(column.ColumnName), column.Value, column.PrimaryKeyOption)
+
+		cellChecksum = crc8Bytes(byte(0x0), []byte(primaryKeyColumn.Name))
+		cellChecksum = primaryKeyColumn.getCheckSum(cellChecksum)
+		rowChecksum = crc8Byte(rowChecksum, cellChecksum)
+		primaryKeyColumn.writePrimaryKeyColumn(&b)
+
+		writeTag(&b, TAG_CELL_CHECKSUM)
+		writeRawByte(&b, cellChecksum)
+	}
+
+	if isDelete {
+		writeTag(&b, TAG_DELETE_ROW_MARKER)
+		rowChecksum = crc8Byte(rowChecksum, byte(0x1))
+	} else {
+		rowChecksum = crc8Byte(rowChecksum, byte(0x0))
+	}
+	writeTag(&b, TAG_ROW_CHECKSUM)
+	writeRawByte(&b, rowChecksum)
+
+	return b.Bytes()
+}
+
+func newEncodingTestPrimaryKey(i int) *PrimaryKey {
+	pk := new(PrimaryKey)
+	pk.AddPrimaryKeyColumn("pk1", fmt.Sprintf("user_%08d", i))
+	pk.AddPrimaryKeyColumn("pk2", int64(i))
+	pk.AddPrimaryKeyColumn("pk3", []byte{byte(i), 0x1, 0x2})
+	return pk
+}
+
+func newEncodingTestPutRowChange(i int) *PutRowChange {
+	change := new(PutRowChange)
+	change.TableName = "table"
+	change.PrimaryKey = newEncodingTestPrimaryKey(i)
+	change.AddColumn("string", "some attribute value")
+	change.AddColumn("integer", int64(i))
+	change.AddColumn("boolean", i%2 == 0)
+	change.AddColumn("double", float64(i)/3)
+	change.AddColumn("blob", []byte("binary attribute value"))
+	change.AddColumnWithTimestamp("versioned", "v", 1500000000000)
+	change.SetCondition(RowExistenceExpectation_IGNORE)
+	return change
+}
+
+func newEncodingTestUpdateRowChange(i int) *UpdateRowChange {
+	change := new(UpdateRowChange)
+	change.TableName = "table"
+	change.PrimaryKey = newEncodingTestPrimaryKey(i)
+	change.PutColumn("string", "some attribute value")
+	change.PutColumnWithTimestamp("double", 1.5, 1500000000000)
+	change.DeleteColumn("deleted")
+	change.DeleteColumnWithTimestamp("deleted_version", 1500000000000)
+	change.IncrementColumn("counter", 1)
+	change.SetCondition(RowExistenceExpectation_EXPECT_EXIST)
+	return change
+}
+
+func TestPrimaryKeyBuild_MatchesLegacy(t *testing.T) {
+	pk := newEncodingTestPrimaryKey(1)
+	pk.AddPrimaryKeyColumnWithMinValue("pk4")
+	pk.AddPrimaryKeyColumnWithMaxValue("pk5")
+	pk.AddPrimaryKeyColumnWithAutoIncrement("pk6")
+
+	for _, isDelete := range []bool{true, false} {
+		data := pk.Build(isDelete)
+		assert.Equal(t, legacyPrimaryKeyBuild(pk, isDelete), data)
+		assert.Equal(t, pk.encodedSize(isDelete), len(data))
+
+		rows, err := plainbuffer.DecodeRows(data)
+		assert.Nil(t, err)
+		assert.Equal(t, isDelete, rows[0].HasDeleteMarker)
+		assert.Equal(t, 6, len(rows[0].PrimaryKey))
+	}
+}
+
+func TestPutRowChangeSerialize_MatchesLegacy(t *testing.T) {
+	change := newEncodingTestPutRowChange(7)
+	data := change.Serialize()
+	assert.Equal(t, buildRowPutChange(change.PrimaryKey, change.Columns).Build(), data)
+	assert.Equal(t, change.encodedSize(), len(data))
+
+	rows, err := plainbuffer.DecodeRows(data)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(rows[0].Cells))
+
+	empty := &PutRowChange{PrimaryKey: newEncodingTestPrimaryKey(8)}
+	assert.Equal(t, buildRowPutChange(empty.PrimaryKey, empty.Columns).Build(), empty.Serialize())
+}
+
+func TestUpdateRowChangeSerialize_MatchesLegacy(t *testing.T) {
+	change := newEncodingTestUpdateRowChange(7)
+	data := change.Serialize()
+	assert.Equal(t, buildRowUpdateChange(change.PrimaryKey, change.Columns).Build(), data)
+	assert.Equal(t, change.encodedSize(), len(data))
+
+	rows, err := plainbuffer.DecodeRows(data)
+	assert.Nil(t, err)
+	assert.Equal(t, plainbuffer.CellType_DELETE_ONE_VERSION, rows[0].Cells[3].Type)
+	assert.Equal(t, plainbuffer.CellType_INCREMENT, rows[0].Cells[4].Type)
+}
+
+func TestRowChangeSerialize_InvalidValue(t *testing.T) {
+	change := new(PutRowChange)
+	change.PrimaryKey = newEncodingTestPrimaryKey(1)
+	change.AddColumn("int", 1)
+	assert.Panics(t, func() { change.Serialize() })
+
+	pk := new(PrimaryKey)
+	pk.AddPrimaryKeyColumn("pk", 1.5)
+	assert.Panics(t, func() { pk.Build(false) })
+}
+
+func TestBuildBatchWriteRowRequest(t *testing.T) {
+	request := newEncodingTestBatchWriteRowRequest(10)
+	rowBuffer := getRowBuffer(request.encodedSize())
+	defer putRowBuffer(rowBuffer)
+
+	req := buildBatchWriteRowRequest(request, rowBuffer)
+	assert.Equal(t, 1, len(req.Tables))
+	assert.Equal(t, "table", req.Tables[0].GetTableName())
+	assert.Equal(t, 30, len(req.Tables[0].Rows))
+	assert.Equal(t, request.encodedSize(), len(rowBuffer.buf))
+
+	for i, row := range req.Tables[0].Rows {
+		change := request.RowChangesGroupByTable["table"][i]
+		assert.Equal(t, change.Serialize(), row.RowChange)
+		assert.Equal(t, change.getOperationType(), row.GetType())
+		assert.Equal(t, change.getCondition(), row.Condition)
+	}
+}
+
+func newEncodingTestBatchWriteRowRequest(n int) *BatchWriteRowRequest {
+	request := new(BatchWriteRowRequest)
+	for i := 0; i < n; i++ {
+		request.AddRowChange(newEncodingTestPutRowChange(i))
+		request.AddRowChange(newEncodingTestUpdateRowChange(i))
+		deleteChange := &DeleteRowChange{TableName: "table", PrimaryKey: newEncodingTestPrimaryKey(i)}
+		deleteChange.SetCondition(RowExistenceExpectation_IGNORE)
+		request.AddRowChange(deleteChange)
+	}
+	return request
+}
+
+// legacyBatchWriteRowRequest builds the request the way BatchWriteRow did
+// before rows were encoded in a single pass.
+func legacyBatchWriteRowRequest(request *BatchWriteRowRequest) *otsprotocol.BatchWriteRowRequest {
+	req := new(otsprotocol.BatchWriteRowRequest)
+	for key, value := range request.RowChangesGroupByTable {
+		table := new(otsprotocol.TableInBatchWriteRowRequest)
+		table.TableName = proto.String(key)
+		for _, row := range value {
+			rowInBatch := &otsprotocol.RowInBatchWriteRowRequest{}
+			rowInBatch.Condition = row.getCondition()
+			switch change := row.(type) {
+			case *PutRowChange:
+				rowInBatch.RowChange = buildRowPutChange(change.PrimaryKey, change.Columns).Build()
+			case *UpdateRowChange:
+				rowInBatch.RowChange = buildRowUpdateChange(change.PrimaryKey, change.Columns).Build()
+			case *DeleteRowChange:
+				rowInBatch.RowChange = legacyPrimaryKeyBuild(change.PrimaryKey, true)
+			}
+			rowInBatch.Type = row.getOperationType().Enum()
+			table.Rows = append(table.Rows, rowInBatch)
+		}
+		req.Tables = append(req.Tables, table)
+	}
+	req.IsAtomic = proto.Bool(request.IsAtomic)
+	return req
+}
+
+func BenchmarkPutRowEncoding(b *testing.B) {
+	request := &PutRowRequest{PutRowChange: newEncodingTestPutRowChange(1)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rowBuffer := getRowBuffer(request.PutRowChange.encodedSize())
+		if _, err := proto.Marshal(buildPutRowRequest(request, rowBuffer)); err != nil {
+			b.Fatal(err)
+		}
+		putRowBuffer(rowBuffer)
+	}
+}
+
+func BenchmarkPutRowEncodingLegacy(b *testing.B) {
+	change := newEncodingTestPutRowChange(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		req := new(otsprotocol.PutRowRequest)
+		req.TableName = proto.String(change.TableName)
+		req.Row = buildRowPutChange(change.PrimaryKey, change.Columns).Build()
+		req.Condition = change.getCondition()
+		if _, err := proto.Marshal(req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBatchWriteRowEncoding(b *testing.B) {
+	request := newEncodingTestBatchWriteRowRequest(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rowBuffer := getRowBuffer(request.encodedSize())
+		if _, err := proto.Marshal(buildBatchWriteRowRequest(request, rowBuffer)); err != nil {
+			b.Fatal(err)
+		}
+		putRowBuffer(rowBuffer)
+	}
+}
+
+func BenchmarkBatchWriteRowEncodingLegacy(b *testing.B) {
+	request := newEncodingTestBatchWriteRowRequest(100)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := proto.Marshal(legacyBatchWriteRowRequest(request)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package plainbuffer
+
+const spaceSize = 256
+
+var crc8Table = make([]byte, spaceSize)
+
+func init() {
+	for i := 0; i < spaceSize; i++ {
+		x := byte(i)
+		for j := 8; j > 0; j-- {
+			if (x & 0x80) != 0 {
+				x = (x << 1) ^ 0x07
+			} else {
+				x = x << 1
+			}
+		}
+		crc8Table[i] = x
+	}
+}
+
+func crc8Byte(crc, in byte) byte {
+	return crc8Table[crc^in]
+}
+
+func crc8Int32(crc byte, in int32) byte {
+	for i := 0; i < 4; i++ {
+		crc = crc8Byte(crc, byte(in))
+		in >>= 8
+	}
+	return crc
+}
+
+func crc8Int64(crc byte, in int64) byte {
+	for i := 0; i < 8; i++ {
+		crc = crc8Byte(crc, byte(in))
+		in >>= 8
+	}
+	return crc
+}
+
+func crc8Bytes(crc byte, in []byte) byte {
+	for _, b := range in {
+		crc = crc8Table[crc^b]
+	}
+	return crc
+}
+
+func crc8String(crc byte, in string) byte {
+	for i := 0; i < len(in); i++ {
+		crc = crc8Table[crc^in[i]]
+	}
+	return crc
+}
@@ -0,0 +1,455 @@
+package plainbuffer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+const maxPreallocSize = 64 * 1024
+
+// Decoder reads rows in PlainBuffer format from an io.Reader one at a time.
+// The header is read and checked before the first row.
+type Decoder struct {
+	r              *bufio.Reader
+	offset         int64
+	headerRead     bool
+	verifyChecksum bool
+	scratch        [littleEndian64Size]byte
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{r: br, verifyChecksum: true}
+}
+
+// SetVerifyChecksum controls whether cell and row checksums are validated.
+// Validation is on by default.
+func (d *Decoder) SetVerifyChecksum(verify bool) {
+	d.verifyChecksum = verify
+}
+
+// Offset returns the number of bytes consumed so far.
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// Decode reads the next row. It returns io.EOF when the input ends cleanly
+// between rows and a *DecodeError for malformed input.
+func (d *Decoder) Decode() (*Row, error) {
+	if !d.headerRead {
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := d.r.Peek(1); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	return d.readRow()
+}
+
+// DecodeRows decodes a complete PlainBuffer payload.
+func DecodeRows(data []byte) ([]*Row, error) {
+	dec := NewDecoder(bytes.NewReader(data))
+	rows := make([]*Row, 0, 1)
+	for {
+		row, err := dec.Decode()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func (d *Decoder) readHeader() error {
+	start := d.offset
+	h, err := d.readUint32()
+	if err != nil {
+		if err == io.ErrUnexpectedEOF && d.offset == start {
+			return io.EOF
+		}
+		return err
+	}
+	if h != Header {
+		return &DecodeError{Offset: start, Err: ErrInvalidHeader, Msg: fmt.Sprintf("got 0x%x", h)}
+	}
+	d.headerRead = true
+	return nil
+}
+
+func (d *Decoder) readRow() (*Row, error) {
+	row := new(Row)
+	var rowCrc byte
+
+	if err := d.expectTag(tagRowPk); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if row.PrimaryKey, rowCrc, err = d.readCells(rowCrc); err != nil {
+		return nil, err
+	}
+
+	tag, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+
+	if tag == tagRowData {
+		if row.Cells, rowCrc, err = d.readCells(rowCrc); err != nil {
+			return nil, err
+		}
+		if tag, err = d.readByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	if tag == tagDeleteRowMarker {
+		row.HasDeleteMarker = true
+		rowCrc = crc8Byte(rowCrc, 0x1)
+		if tag, err = d.readByte(); err != nil {
+			return nil, err
+		}
+	} else {
+		rowCrc = crc8Byte(rowCrc, 0x0)
+	}
+
+	if tag == tagExtension {
+		if row.Extension, err = d.readExtension(); err != nil {
+			return nil, err
+		}
+		if tag, err = d.readByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	if tag != tagRowChecksum {
+		return nil, d.unexpectedTag(tagRowChecksum, tag)
+	}
+	crc, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if d.verifyChecksum && crc != rowCrc {
+		return nil, &DecodeError{Offset: d.offset - 1, Err: ErrChecksumMismatch,
+			Msg: fmt.Sprintf("row checksum expect 0x%x, got 0x%x", rowCrc, crc)}
+	}
+	return row, nil
+}
+
+// readCells reads consecutive cells and folds their checksums into rowCrc.
+func (d *Decoder) readCells(rowCrc byte) ([]*Cell, byte, error) {
+	var cells []*Cell
+	for {
+		next, err := d.peekByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		if next != tagCell {
+			return cells, rowCrc, nil
+		}
+		d.readByte()
+
+		cell, cellCrc, err := d.readCell()
+		if err != nil {
+			return nil, 0, err
+		}
+		cells = append(cells, cell)
+		rowCrc = crc8Byte(rowCrc, cellCrc)
+	}
+}
+
+func (d *Decoder) readCell() (*Cell, byte, error) {
+	cell := new(Cell)
+	var crc byte
+
+	if err := d.expectTag(tagCellName); err != nil {
+		return nil, 0, err
+	}
+	name, err := d.readLengthPrefixed()
+	if err != nil {
+		return nil, 0, err
+	}
+	cell.Name = string(name)
+	crc = crc8Bytes(crc, name)
+
+	tag, err := d.readByte()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if tag == tagCellValue {
+		if cell.Value, crc, err = d.readValue(crc); err != nil {
+			return nil, 0, err
+		}
+		if tag, err = d.readByte(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	hasType := false
+	if tag == tagCellType {
+		t, err := d.readByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		cell.Type = CellType(t)
+		hasType = true
+		if tag, err = d.readByte(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if tag == tagCellTimestamp {
+		ts, err := d.readUint64()
+		if err != nil {
+			return nil, 0, err
+		}
+		v := int64(ts)
+		cell.Timestamp = &v
+		crc = crc8Int64(crc, v)
+		if tag, err = d.readByte(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if hasType {
+		crc = crc8Byte(crc, byte(cell.Type))
+	}
+
+	if tag != tagCellChecksum {
+		return nil, 0, d.unexpectedTag(tagCellChecksum, tag)
+	}
+	got, err := d.readByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.verifyChecksum && got != crc {
+		return nil, 0, &DecodeError{Offset: d.offset - 1, Err: ErrChecksumMismatch,
+			Msg: fmt.Sprintf("cell %q checksum expect 0x%x, got 0x%x", cell.Name, crc, got)}
+	}
+	return cell, got, nil
+}
+
+func (d *Decoder) readValue(crc byte) (*ColumnValue, byte, error) {
+	start := d.offset
+	size, err := d.readUint32()
+	if err != nil {
+		return nil, 0, err
+	}
+	vt, err := d.readByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	crc = crc8Byte(crc, vt)
+
+	value := new(ColumnValue)
+	expectSize := uint32(1)
+	switch vt {
+	case vtInteger:
+		v, err := d.readUint64()
+		if err != nil {
+			return nil, 0, err
+		}
+		value.Type = ColumnType_INTEGER
+		value.Value = int64(v)
+		crc = crc8Int64(crc, int64(v))
+		expectSize += littleEndian64Size
+	case vtDouble:
+		v, err := d.readUint64()
+		if err != nil {
+			return nil, 0, err
+		}
+		value.Type = ColumnType_DOUBLE
+		value.Value = math.Float64frombits(v)
+		crc = crc8Int64(crc, int64(v))
+		expectSize += littleEndian64Size
+	case vtBoolean:
+		b, err := d.readByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		value.Type = ColumnType_BOOLEAN
+		value.Value = b != 0
+		crc = crc8Byte(crc, b)
+		expectSize++
+	case vtString, vtBlob:
+		data, err := d.readLengthPrefixed()
+		if err != nil {
+			return nil, 0, err
+		}
+		crc = crc8Int32(crc, int32(len(data)))
+		crc = crc8Bytes(crc, data)
+		if vt == vtString {
+			value.Type = ColumnType_STRING
+			value.Value = string(data)
+		} else {
+			value.Type = ColumnType_BINARY
+			value.Value = data
+		}
+		expectSize += littleEndian32Size + uint32(len(data))
+	case vtInfMin:
+		value.Type = ColumnType_INF_MIN
+	case vtInfMax:
+		value.Type = ColumnType_INF_MAX
+	case vtAutoIncrement:
+		value.Type = ColumnType_AUTO_INCREMENT
+	case vtNull:
+		value.Type = ColumnType_NULL
+	default:
+		return nil, 0, &DecodeError{Offset: d.offset - 1, Err: ErrInvalidValue, Msg: fmt.Sprintf("unknown variant type 0x%x", vt)}
+	}
+
+	if size != expectSize {
+		return nil, 0, &DecodeError{Offset: start, Err: ErrInvalidValue,
+			Msg: fmt.Sprintf("value size expect %d, got %d", expectSize, size)}
+	}
+	return value, crc, nil
+}
+
+func (d *Decoder) readExtension() (*SequenceInfo, error) {
+	// the length prefixes are redundant, every field is tagged
+	if _, err := d.readUint32(); err != nil {
+		return nil, err
+	}
+	if err := d.expectTag(tagSeqInfo); err != nil {
+		return nil, err
+	}
+	if _, err := d.readUint32(); err != nil {
+		return nil, err
+	}
+
+	info := new(SequenceInfo)
+	if err := d.expectTag(tagSeqInfoEpoch); err != nil {
+		return nil, err
+	}
+	epoch, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	info.Epoch = int32(epoch)
+
+	if err := d.expectTag(tagSeqInfoTs); err != nil {
+		return nil, err
+	}
+	ts, err := d.readUint64()
+	if err != nil {
+		return nil, err
+	}
+	info.Timestamp = int64(ts)
+
+	if err := d.expectTag(tagSeqInfoRowIndex); err != nil {
+		return nil, err
+	}
+	rowIndex, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	info.RowIndex = int32(rowIndex)
+	return info, nil
+}
+
+func (d *Decoder) expectTag(expect byte) error {
+	tag, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if tag != expect {
+		return d.unexpectedTag(expect, tag)
+	}
+	return nil
+}
+
+func (d *Decoder) unexpectedTag(expect, got byte) error {
+	return &DecodeError{Offset: d.offset - 1, Err: ErrUnexpectedTag, Msg: fmt.Sprintf("expect 0x%x, got 0x%x", expect, got)}
+}
+
+func (d *Decoder) peekByte() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		return 0, d.eofToUnexpected(err)
+	}
+	return b[0], nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, d.eofToUnexpected(err)
+	}
+	d.offset++
+	return b, nil
+}
+
+func (d *Decoder) readUint32() (uint32, error) {
+	b := d.scratch[:littleEndian32Size]
+	if err := d.readFull(b); err != nil {
+		return 0, err
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24, nil
+}
+
+func (d *Decoder) readUint64() (uint64, error) {
+	b := d.scratch[:littleEndian64Size]
+	if err := d.readFull(b); err != nil {
+		return 0, err
+	}
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56, nil
+}
+
+func (d *Decoder) readLengthPrefixed() ([]byte, error) {
+	start := d.offset
+	size, err := d.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if int32(size) < 0 {
+		return nil, &DecodeError{Offset: start, Err: ErrInvalidValue, Msg: fmt.Sprintf("negative length %d", int32(size))}
+	}
+	if size <= maxPreallocSize {
+		b := make([]byte, size)
+		if err := d.readFull(b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	// grow the buffer as data arrives so that a corrupt length can not
+	// force a huge allocation up front
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, d.r, int64(size))
+	d.offset += n
+	if err != nil {
+		return nil, d.eofToUnexpected(err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (d *Decoder) readFull(b []byte) error {
+	n, err := io.ReadFull(d.r, b)
+	d.offset += int64(n)
+	if err != nil {
+		return d.eofToUnexpected(err)
+	}
+	return nil
+}
+
+func (d *Decoder) eofToUnexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
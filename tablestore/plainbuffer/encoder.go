@@ -0,0 +1,238 @@
+package plainbuffer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Encoder writes rows in PlainBuffer format to an io.Writer. The header is
+// written in front of the first row.
+type Encoder struct {
+	w             io.Writer
+	buf           []byte
+	headerWritten bool
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes one row. Checksums are computed while the row is serialized.
+func (e *Encoder) Encode(row *Row) error {
+	if row == nil {
+		return fmt.Errorf("%w: nil row", ErrInvalidValue)
+	}
+
+	buf := e.buf[:0]
+	if !e.headerWritten {
+		buf = appendUint32(buf, Header)
+	}
+
+	buf, err := appendRow(buf, row)
+	if err != nil {
+		return err
+	}
+	e.buf = buf
+
+	if _, err := e.w.Write(buf); err != nil {
+		return err
+	}
+	e.headerWritten = true
+	return nil
+}
+
+// EncodeRows serializes rows into a single PlainBuffer payload.
+func EncodeRows(rows ...*Row) ([]byte, error) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return nil, err
+		}
+	}
+	if !enc.headerWritten {
+		return appendUint32(nil, Header), nil
+	}
+	return b.Bytes(), nil
+}
+
+func appendRow(buf []byte, row *Row) ([]byte, error) {
+	var err error
+	var rowCrc, cellCrc byte
+
+	buf = append(buf, tagRowPk)
+	for _, cell := range row.PrimaryKey {
+		if buf, cellCrc, err = appendCell(buf, cell); err != nil {
+			return nil, err
+		}
+		rowCrc = crc8Byte(rowCrc, cellCrc)
+	}
+
+	if len(row.Cells) > 0 {
+		buf = append(buf, tagRowData)
+		for _, cell := range row.Cells {
+			if buf, cellCrc, err = appendCell(buf, cell); err != nil {
+				return nil, err
+			}
+			rowCrc = crc8Byte(rowCrc, cellCrc)
+		}
+	}
+
+	if row.HasDeleteMarker {
+		buf = append(buf, tagDeleteRowMarker)
+		rowCrc = crc8Byte(rowCrc, 0x1)
+	} else {
+		rowCrc = crc8Byte(rowCrc, 0x0)
+	}
+
+	if row.Extension != nil {
+		buf = append(buf, tagExtension)
+		buf = appendUint32(buf, extensionPayloadSize)
+		buf = append(buf, tagSeqInfo)
+		buf = appendUint32(buf, seqInfoPayloadSize)
+		buf = append(buf, tagSeqInfoEpoch)
+		buf = appendUint32(buf, uint32(row.Extension.Epoch))
+		buf = append(buf, tagSeqInfoTs)
+		buf = appendUint64(buf, uint64(row.Extension.Timestamp))
+		buf = append(buf, tagSeqInfoRowIndex)
+		buf = appendUint32(buf, uint32(row.Extension.RowIndex))
+	}
+
+	buf = append(buf, tagRowChecksum, rowCrc)
+	return buf, nil
+}
+
+func appendCell(buf []byte, cell *Cell) ([]byte, byte, error) {
+	if cell == nil {
+		return nil, 0, fmt.Errorf("%w: nil cell", ErrInvalidValue)
+	}
+
+	var crc byte
+	var err error
+
+	buf = append(buf, tagCell, tagCellName)
+	buf = appendUint32(buf, uint32(len(cell.Name)))
+	buf = append(buf, cell.Name...)
+	crc = crc8String(crc, cell.Name)
+
+	if cell.Value != nil {
+		if buf, crc, err = appendValue(buf, crc, cell.Value); err != nil {
+			return nil, 0, fmt.Errorf("%w: column %q", err, cell.Name)
+		}
+	}
+
+	if cell.Type != CellType_PUT {
+		buf = append(buf, tagCellType, byte(cell.Type))
+	}
+
+	if cell.Timestamp != nil {
+		buf = append(buf, tagCellTimestamp)
+		buf = appendUint64(buf, uint64(*cell.Timestamp))
+		crc = crc8Int64(crc, *cell.Timestamp)
+	}
+
+	if cell.Type != CellType_PUT {
+		crc = crc8Byte(crc, byte(cell.Type))
+	}
+
+	buf = append(buf, tagCellChecksum, crc)
+	return buf, crc, nil
+}
+
+func appendValue(buf []byte, crc byte, value *ColumnValue) ([]byte, byte, error) {
+	buf = append(buf, tagCellValue)
+
+	switch value.Type {
+	case ColumnType_STRING:
+		v, ok := value.Value.(string)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: expect string, got %T", ErrInvalidValue, value.Value)
+		}
+		buf = appendUint32(buf, uint32(littleEndian32Size+1+len(v)))
+		buf = append(buf, vtString)
+		buf = appendUint32(buf, uint32(len(v)))
+		buf = append(buf, v...)
+		crc = crc8Byte(crc, vtString)
+		crc = crc8Int32(crc, int32(len(v)))
+		crc = crc8String(crc, v)
+	case ColumnType_INTEGER:
+		v, ok := value.Value.(int64)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: expect int64, got %T", ErrInvalidValue, value.Value)
+		}
+		buf = appendUint32(buf, littleEndian64Size+1)
+		buf = append(buf, vtInteger)
+		buf = appendUint64(buf, uint64(v))
+		crc = crc8Byte(crc, vtInteger)
+		crc = crc8Int64(crc, v)
+	case ColumnType_BOOLEAN:
+		v, ok := value.Value.(bool)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: expect bool, got %T", ErrInvalidValue, value.Value)
+		}
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		buf = appendUint32(buf, 2)
+		buf = append(buf, vtBoolean, b)
+		crc = crc8Byte(crc, vtBoolean)
+		crc = crc8Byte(crc, b)
+	case ColumnType_DOUBLE:
+		v, ok := value.Value.(float64)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: expect float64, got %T", ErrInvalidValue, value.Value)
+		}
+		bits := math.Float64bits(v)
+		buf = appendUint32(buf, littleEndian64Size+1)
+		buf = append(buf, vtDouble)
+		buf = appendUint64(buf, bits)
+		crc = crc8Byte(crc, vtDouble)
+		crc = crc8Int64(crc, int64(bits))
+	case ColumnType_BINARY:
+		v, ok := value.Value.([]byte)
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: expect []byte, got %T", ErrInvalidValue, value.Value)
+		}
+		buf = appendUint32(buf, uint32(littleEndian32Size+1+len(v)))
+		buf = append(buf, vtBlob)
+		buf = appendUint32(buf, uint32(len(v)))
+		buf = append(buf, v...)
+		crc = crc8Byte(crc, vtBlob)
+		crc = crc8Int32(crc, int32(len(v)))
+		crc = crc8Bytes(crc, v)
+	case ColumnType_INF_MIN, ColumnType_INF_MAX, ColumnType_AUTO_INCREMENT, ColumnType_NULL:
+		vt := variantTypeOf(value.Type)
+		buf = appendUint32(buf, 1)
+		buf = append(buf, vt)
+		crc = crc8Byte(crc, vt)
+	default:
+		return nil, 0, fmt.Errorf("%w: unknown column type %s", ErrInvalidValue, value.Type)
+	}
+
+	return buf, crc, nil
+}
+
+func variantTypeOf(t ColumnType) byte {
+	switch t {
+	case ColumnType_INF_MIN:
+		return vtInfMin
+	case ColumnType_INF_MAX:
+		return vtInfMax
+	case ColumnType_AUTO_INCREMENT:
+		return vtAutoIncrement
+	default:
+		return vtNull
+	}
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24),
+		byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
@@ -0,0 +1,162 @@
+// Package plainbuffer implements the PlainBuffer row format used by TableStore
+// for row data, stream records and tunnel binary records.
+//
+// PlainBuffer 是表格存储用于行数据、Stream 记录以及通道二进制记录的编码格式。
+//
+// The client keeps the PlainBuffer code of packages tablestore,
+// tablestore/search/model and tunnel/protocol for its own requests and
+// responses. Those decoders export their own row types and skip the
+// checksums Decoder verifies, so moving them onto this package would turn
+// responses they accept into errors.
+package plainbuffer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Header is the magic number written in front of every PlainBuffer payload.
+const Header = 0x75
+
+const (
+	// tag type
+	tagRowPk           = 0x1
+	tagRowData         = 0x2
+	tagCell            = 0x3
+	tagCellName        = 0x4
+	tagCellValue       = 0x5
+	tagCellType        = 0x6
+	tagCellTimestamp   = 0x7
+	tagDeleteRowMarker = 0x8
+	tagRowChecksum     = 0x9
+	tagCellChecksum    = 0x0A
+	tagExtension       = 0x0B
+	tagSeqInfo         = 0x0C
+	tagSeqInfoEpoch    = 0x0D
+	tagSeqInfoTs       = 0x0E
+	tagSeqInfoRowIndex = 0x0F
+
+	// variant type
+	vtInteger       = 0x0
+	vtDouble        = 0x1
+	vtBoolean       = 0x2
+	vtString        = 0x3
+	vtNull          = 0x6
+	vtBlob          = 0x7
+	vtInfMin        = 0x9
+	vtInfMax        = 0xa
+	vtAutoIncrement = 0xb
+
+	littleEndian32Size = 4
+	littleEndian64Size = 8
+
+	// tag + epoch, tag + timestamp, tag + row index
+	seqInfoPayloadSize = 1 + littleEndian32Size + 1 + littleEndian64Size + 1 + littleEndian32Size
+	// tag + length + sequence info
+	extensionPayloadSize = 1 + littleEndian32Size + seqInfoPayloadSize
+)
+
+var (
+	ErrInvalidHeader    = errors.New("[plainbuffer] invalid header")
+	ErrUnexpectedTag    = errors.New("[plainbuffer] unexpected tag")
+	ErrChecksumMismatch = errors.New("[plainbuffer] checksum mismatch")
+	ErrInvalidValue     = errors.New("[plainbuffer] invalid value")
+)
+
+type ColumnType int
+
+const (
+	ColumnType_STRING         ColumnType = 1
+	ColumnType_INTEGER        ColumnType = 2
+	ColumnType_BOOLEAN        ColumnType = 3
+	ColumnType_DOUBLE         ColumnType = 4
+	ColumnType_BINARY         ColumnType = 5
+	ColumnType_INF_MIN        ColumnType = 6
+	ColumnType_INF_MAX        ColumnType = 7
+	ColumnType_AUTO_INCREMENT ColumnType = 8
+	ColumnType_NULL           ColumnType = 9
+)
+
+func (t ColumnType) String() string {
+	switch t {
+	case ColumnType_STRING:
+		return "STRING"
+	case ColumnType_INTEGER:
+		return "INTEGER"
+	case ColumnType_BOOLEAN:
+		return "BOOLEAN"
+	case ColumnType_DOUBLE:
+		return "DOUBLE"
+	case ColumnType_BINARY:
+		return "BINARY"
+	case ColumnType_INF_MIN:
+		return "INF_MIN"
+	case ColumnType_INF_MAX:
+		return "INF_MAX"
+	case ColumnType_AUTO_INCREMENT:
+		return "AUTO_INCREMENT"
+	case ColumnType_NULL:
+		return "NULL"
+	default:
+		return fmt.Sprintf("ColumnType(%d)", int(t))
+	}
+}
+
+// ColumnValue holds a typed cell value. Value is a string for STRING, int64 for
+// INTEGER, bool for BOOLEAN, float64 for DOUBLE, []byte for BINARY and nil for
+// the other types.
+type ColumnValue struct {
+	Type  ColumnType
+	Value interface{}
+}
+
+// CellType marks the operation a cell carries in an update or stream record.
+type CellType byte
+
+const (
+	CellType_PUT                CellType = 0x0
+	CellType_DELETE_ALL_VERSION CellType = 0x1
+	CellType_DELETE_ONE_VERSION CellType = 0x3
+	CellType_INCREMENT          CellType = 0x4
+)
+
+type Cell struct {
+	Name string
+	// Value is nil for cells without a value, e.g. deletions.
+	Value *ColumnValue
+	// Timestamp is nil when the cell has no version.
+	Timestamp *int64
+	Type      CellType
+}
+
+// SequenceInfo is the row extension attached to stream and tunnel records.
+type SequenceInfo struct {
+	Epoch     int32
+	Timestamp int64
+	RowIndex  int32
+}
+
+type Row struct {
+	PrimaryKey      []*Cell
+	Cells           []*Cell
+	HasDeleteMarker bool
+	Extension       *SequenceInfo
+}
+
+// DecodeError describes where in the input a decoding error happened.
+type DecodeError struct {
+	Offset int64
+	Err    error
+	Msg    string
+}
+
+func (e *DecodeError) Error() string {
+	if e.Msg == "" {
+		return fmt.Sprintf("%s at offset %d", e.Err.Error(), e.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d: %s", e.Err.Error(), e.Offset, e.Msg)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,142 @@
+package plainbuffer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fixtureRow is a row serialized by the original tunnel protocol writer.
+var fixtureRow = []byte{0x75, 0x0, 0x0, 0x0, 0x1, 0x3, 0x4, 0x2, 0x0, 0x0, 0x0, 0x70, 0x6b, 0x5, 0x6, 0x0, 0x0, 0x0, 0x3, 0x1,
+	0x0, 0x0, 0x0, 0x61, 0xa, 0xd4, 0x3, 0x4, 0x2, 0x0, 0x0, 0x0, 0x69, 0x64, 0x5, 0x9, 0x0, 0x0, 0x0, 0x0, 0x7, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0xa, 0x60, 0x2, 0x3, 0x4, 0x2, 0x0, 0x0, 0x0, 0x63, 0x31, 0x5, 0x9, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0xf8, 0x3f, 0x7, 0xe8, 0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xa, 0xc1, 0x3, 0x4, 0x2, 0x0, 0x0, 0x0, 0x63,
+	0x32, 0x6, 0x1, 0xa, 0xac, 0x9, 0xb5}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func fixtureRowValue() *Row {
+	return &Row{
+		PrimaryKey: []*Cell{
+			{Name: "pk", Value: &ColumnValue{Type: ColumnType_STRING, Value: "a"}},
+			{Name: "id", Value: &ColumnValue{Type: ColumnType_INTEGER, Value: int64(7)}},
+		},
+		Cells: []*Cell{
+			{Name: "c1", Value: &ColumnValue{Type: ColumnType_DOUBLE, Value: 1.5}, Timestamp: int64Ptr(1000)},
+			{Name: "c2", Type: CellType_DELETE_ALL_VERSION},
+		},
+	}
+}
+
+func TestEncoder_MatchesLegacyWriter(t *testing.T) {
+	data, err := EncodeRows(fixtureRowValue())
+	assert.Nil(t, err)
+	assert.Equal(t, fixtureRow, data)
+}
+
+func TestDecoder_Fixture(t *testing.T) {
+	rows, err := DecodeRows(fixtureRow)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Row{fixtureRowValue()}, rows)
+}
+
+func TestRoundTrip_AllTypes(t *testing.T) {
+	rows := []*Row{
+		{
+			PrimaryKey: []*Cell{
+				{Name: "pk1", Value: &ColumnValue{Type: ColumnType_BINARY, Value: []byte{0, 1, 2}}},
+				{Name: "pk2", Value: &ColumnValue{Type: ColumnType_INF_MIN}},
+				{Name: "pk3", Value: &ColumnValue{Type: ColumnType_AUTO_INCREMENT}},
+			},
+			Cells: []*Cell{
+				{Name: "b", Value: &ColumnValue{Type: ColumnType_BOOLEAN, Value: true}},
+				{Name: "s", Value: &ColumnValue{Type: ColumnType_STRING, Value: "中文"}, Timestamp: int64Ptr(42)},
+				{Name: "i", Value: &ColumnValue{Type: ColumnType_INTEGER, Value: int64(-1)}, Type: CellType_INCREMENT},
+				{Name: "d", Type: CellType_DELETE_ONE_VERSION, Timestamp: int64Ptr(43)},
+			},
+		},
+		{
+			PrimaryKey:      []*Cell{{Name: "pk1", Value: &ColumnValue{Type: ColumnType_INF_MAX}}},
+			HasDeleteMarker: true,
+			Extension:       &SequenceInfo{Epoch: 1, Timestamp: 1600000000000000, RowIndex: 3},
+		},
+	}
+
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	for _, row := range rows {
+		assert.Nil(t, enc.Encode(row))
+	}
+
+	dec := NewDecoder(&b)
+	for _, expect := range rows {
+		row, err := dec.Decode()
+		assert.Nil(t, err)
+		assert.Equal(t, expect, row)
+	}
+	_, err := dec.Decode()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecoder_Empty(t *testing.T) {
+	rows, err := DecodeRows(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rows))
+
+	rows, err = DecodeRows([]byte{0x75, 0, 0, 0})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(rows))
+}
+
+func TestDecoder_InvalidHeader(t *testing.T) {
+	_, err := DecodeRows([]byte{0x76, 0, 0, 0, tagRowPk})
+	assert.True(t, errors.Is(err, ErrInvalidHeader))
+}
+
+func TestDecoder_ChecksumMismatch(t *testing.T) {
+	data := append([]byte(nil), fixtureRow...)
+	data[len(data)-1] ^= 0xff
+	_, err := DecodeRows(data)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	var decodeErr *DecodeError
+	assert.True(t, errors.As(err, &decodeErr))
+	assert.Equal(t, int64(len(data)-1), decodeErr.Offset)
+
+	// corrupt the value of "pk"
+	data = append([]byte(nil), fixtureRow...)
+	data[23] = 'b'
+	_, err = DecodeRows(data)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	dec := NewDecoder(bytes.NewReader(data))
+	dec.SetVerifyChecksum(false)
+	row, err := dec.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, "b", row.PrimaryKey[0].Value.Value)
+}
+
+func TestDecoder_Truncated(t *testing.T) {
+	for i := 5; i < len(fixtureRow); i++ {
+		_, err := DecodeRows(fixtureRow[:i])
+		assert.Equal(t, io.ErrUnexpectedEOF, err, "truncated at %d", i)
+	}
+}
+
+func TestDecoder_UnexpectedTag(t *testing.T) {
+	data := append([]byte(nil), fixtureRow...)
+	data[4] = tagRowData
+	_, err := DecodeRows(data)
+	assert.True(t, errors.Is(err, ErrUnexpectedTag))
+}
+
+func TestEncoder_InvalidValue(t *testing.T) {
+	row := &Row{PrimaryKey: []*Cell{{Name: "pk", Value: &ColumnValue{Type: ColumnType_INTEGER, Value: 1}}}}
+	_, err := EncodeRows(row)
+	assert.True(t, errors.Is(err, ErrInvalidValue))
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -57,3 +58,27 @@ func (a *AvgAggregation) Missing(missing interface{}) *AvgAggregation {
 	a.MissingValue = missing
 	return a
 }
+
+func (a *AvgAggregation) MarshalJSON() ([]byte, error) {
+	type AvgAggregationAlias AvgAggregation
+	return json.Marshal(&struct {
+		*AvgAggregationAlias
+		MissingValue jsonValue
+	}{
+		(*AvgAggregationAlias)(a),
+		jsonValue{a.MissingValue},
+	})
+}
+
+func (a *AvgAggregation) UnmarshalJSON(data []byte) error {
+	type AvgAggregationAlias AvgAggregation
+	aux := &struct {
+		*AvgAggregationAlias
+		MissingValue jsonValue
+	}{AvgAggregationAlias: (*AvgAggregationAlias)(a)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	a.MissingValue = aux.MissingValue.value
+	return nil
+}
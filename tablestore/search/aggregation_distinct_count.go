@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -57,3 +58,27 @@ func (a *DistinctCountAggregation) Missing(missing interface{}) *DistinctCountAg
 	a.MissingValue = missing
 	return a
 }
+
+func (a *DistinctCountAggregation) MarshalJSON() ([]byte, error) {
+	type DistinctCountAggregationAlias DistinctCountAggregation
+	return json.Marshal(&struct {
+		*DistinctCountAggregationAlias
+		MissingValue jsonValue
+	}{
+		(*DistinctCountAggregationAlias)(a),
+		jsonValue{a.MissingValue},
+	})
+}
+
+func (a *DistinctCountAggregation) UnmarshalJSON(data []byte) error {
+	type DistinctCountAggregationAlias DistinctCountAggregation
+	aux := &struct {
+		*DistinctCountAggregationAlias
+		MissingValue jsonValue
+	}{DistinctCountAggregationAlias: (*DistinctCountAggregationAlias)(a)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	a.MissingValue = aux.MissingValue.value
+	return nil
+}
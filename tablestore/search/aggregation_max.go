@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -57,3 +58,27 @@ func (a *MaxAggregation) Missing(missing interface{}) *MaxAggregation {
 	a.MissingValue = missing
 	return a
 }
+
+func (a *MaxAggregation) MarshalJSON() ([]byte, error) {
+	type MaxAggregationAlias MaxAggregation
+	return json.Marshal(&struct {
+		*MaxAggregationAlias
+		MissingValue jsonValue
+	}{
+		(*MaxAggregationAlias)(a),
+		jsonValue{a.MissingValue},
+	})
+}
+
+func (a *MaxAggregation) UnmarshalJSON(data []byte) error {
+	type MaxAggregationAlias MaxAggregation
+	aux := &struct {
+		*MaxAggregationAlias
+		MissingValue jsonValue
+	}{MaxAggregationAlias: (*MaxAggregationAlias)(a)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	a.MissingValue = aux.MissingValue.value
+	return nil
+}
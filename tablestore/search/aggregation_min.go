@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -57,3 +58,27 @@ func (a *MinAggregation) Missing(missing interface{}) *MinAggregation {
 	a.MissingValue = missing
 	return a
 }
+
+func (a *MinAggregation) MarshalJSON() ([]byte, error) {
+	type MinAggregationAlias MinAggregation
+	return json.Marshal(&struct {
+		*MinAggregationAlias
+		MissingValue jsonValue
+	}{
+		(*MinAggregationAlias)(a),
+		jsonValue{a.MissingValue},
+	})
+}
+
+func (a *MinAggregation) UnmarshalJSON(data []byte) error {
+	type MinAggregationAlias MinAggregation
+	aux := &struct {
+		*MinAggregationAlias
+		MissingValue jsonValue
+	}{MinAggregationAlias: (*MinAggregationAlias)(a)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	a.MissingValue = aux.MissingValue.value
+	return nil
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -67,3 +68,27 @@ func (a *PercentilesAggregation) SetMissing(missing interface{}) *PercentilesAgg
 	a.MissingValue = missing
 	return a
 }
+
+func (a *PercentilesAggregation) MarshalJSON() ([]byte, error) {
+	type PercentilesAggregationAlias PercentilesAggregation
+	return json.Marshal(&struct {
+		*PercentilesAggregationAlias
+		MissingValue jsonValue
+	}{
+		(*PercentilesAggregationAlias)(a),
+		jsonValue{a.MissingValue},
+	})
+}
+
+func (a *PercentilesAggregation) UnmarshalJSON(data []byte) error {
+	type PercentilesAggregationAlias PercentilesAggregation
+	aux := &struct {
+		*PercentilesAggregationAlias
+		MissingValue jsonValue
+	}{PercentilesAggregationAlias: (*PercentilesAggregationAlias)(a)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	a.MissingValue = aux.MissingValue.value
+	return nil
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -57,3 +58,27 @@ func (a *SumAggregation) Missing(missing interface{}) *SumAggregation {
 	a.MissingValue = missing
 	return a
 }
+
+func (a *SumAggregation) MarshalJSON() ([]byte, error) {
+	type SumAggregationAlias SumAggregation
+	return json.Marshal(&struct {
+		*SumAggregationAlias
+		MissingValue jsonValue
+	}{
+		(*SumAggregationAlias)(a),
+		jsonValue{a.MissingValue},
+	})
+}
+
+func (a *SumAggregation) UnmarshalJSON(data []byte) error {
+	type SumAggregationAlias SumAggregation
+	aux := &struct {
+		*SumAggregationAlias
+		MissingValue jsonValue
+	}{SumAggregationAlias: (*SumAggregationAlias)(a)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	a.MissingValue = aux.MissingValue.value
+	return nil
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
@@ -48,6 +49,32 @@ func (a GroupByType) String() string {
 	}
 }
 
+func UnmarshalGroupBy(name string, data json.RawMessage) (GroupBy, error) {
+	var groupBy GroupBy
+	switch name {
+	case "group_by_field":
+		groupBy = &GroupByField{}
+	case "group_by_range":
+		groupBy = &GroupByRange{}
+	case "group_by_filter":
+		groupBy = &GroupByFilter{}
+	case "group_by_geo_distance":
+		groupBy = &GroupByGeoDistance{}
+	case "group_by_histogram":
+		groupBy = &GroupByHistogram{}
+	case "group_by_date_histogram":
+		groupBy = &GroupByDateHistogram{}
+	case "group_by_geo_grid":
+		groupBy = &GroupByGeoGrid{}
+	case "group_by_composite":
+		groupBy = &GroupByComposite{}
+	default:
+		return nil, errors.New("Unknown group by type: " + name + ".")
+	}
+	err := json.Unmarshal(data, groupBy)
+	return groupBy, err
+}
+
 func (g GroupByType) ToPB() *otsprotocol.GroupByType {
 	switch g {
 	case GroupByFieldType:
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -117,3 +118,35 @@ func (g *GroupByComposite) SubAggregation(subAggregation Aggregation) *GroupByCo
 	g.SubAggList = append(g.SubAggList, subAggregation)
 	return g
 }
+
+func (g *GroupByComposite) MarshalJSON() ([]byte, error) {
+	type GroupByCompositeAlias GroupByComposite
+	return json.Marshal(&struct {
+		*GroupByCompositeAlias
+		SourceGroupByList []groupByAlias
+		SubAggList        []aggregationAlias
+		SubGroupByList    []groupByAlias
+	}{
+		(*GroupByCompositeAlias)(g),
+		newGroupByAliases(g.SourceGroupByList),
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByComposite) UnmarshalJSON(data []byte) error {
+	type GroupByCompositeAlias GroupByComposite
+	aux := &struct {
+		*GroupByCompositeAlias
+		SourceGroupByList []groupByAlias
+		SubAggList        []aggregationAlias
+		SubGroupByList    []groupByAlias
+	}{GroupByCompositeAlias: (*GroupByCompositeAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.SourceGroupByList = groupBysFromAliases(aux.SourceGroupByList)
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
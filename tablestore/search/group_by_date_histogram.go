@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search/model"
@@ -178,3 +179,47 @@ func (g *GroupByDateHistogram) SetGroupBySorters(sorters []GroupBySorter) *Group
 	g.Sorters = sorters
 	return g
 }
+
+func (g *GroupByDateHistogram) MarshalJSON() ([]byte, error) {
+	type GroupByDateHistogramAlias GroupByDateHistogram
+	sorters, err := newGroupBySorterAliases(g.Sorters)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		*GroupByDateHistogramAlias
+		Missing        jsonValue
+		FieldRange     fieldRangeJSON
+		Sorters        []groupBySorterAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{
+		(*GroupByDateHistogramAlias)(g),
+		jsonValue{g.Missing},
+		fieldRangeJSON{jsonValue{g.FieldRange.Min}, jsonValue{g.FieldRange.Max}},
+		sorters,
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByDateHistogram) UnmarshalJSON(data []byte) error {
+	type GroupByDateHistogramAlias GroupByDateHistogram
+	aux := &struct {
+		*GroupByDateHistogramAlias
+		Missing        jsonValue
+		FieldRange     fieldRangeJSON
+		Sorters        []groupBySorterAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{GroupByDateHistogramAlias: (*GroupByDateHistogramAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.Missing = aux.Missing.value
+	g.FieldRange.Min, g.FieldRange.Max = aux.FieldRange.Min.value, aux.FieldRange.Max.value
+	g.Sorters = groupBySortersFromAliases(aux.Sorters)
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
@@ -115,3 +116,39 @@ func (g *GroupByField) GroupBySorters(sorters []GroupBySorter) *GroupByField {
 	g.Sorters = sorters
 	return g
 }
+
+func (g *GroupByField) MarshalJSON() ([]byte, error) {
+	type GroupByFieldAlias GroupByField
+	sorters, err := newGroupBySorterAliases(g.Sorters)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		*GroupByFieldAlias
+		Sorters        []groupBySorterAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{
+		(*GroupByFieldAlias)(g),
+		sorters,
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByField) UnmarshalJSON(data []byte) error {
+	type GroupByFieldAlias GroupByField
+	aux := &struct {
+		*GroupByFieldAlias
+		Sorters        []groupBySorterAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{GroupByFieldAlias: (*GroupByFieldAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.Sorters = groupBySortersFromAliases(aux.Sorters)
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
@@ -92,3 +93,35 @@ func (g *GroupByFilter) Query(query Query) *GroupByFilter {
 	g.Queries = append(g.Queries, query)
 	return g
 }
+
+func (g *GroupByFilter) MarshalJSON() ([]byte, error) {
+	type GroupByFilterAlias GroupByFilter
+	return json.Marshal(&struct {
+		*GroupByFilterAlias
+		Queries        []queryAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{
+		(*GroupByFilterAlias)(g),
+		newQueryAliases(g.Queries),
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByFilter) UnmarshalJSON(data []byte) error {
+	type GroupByFilterAlias GroupByFilter
+	aux := &struct {
+		*GroupByFilterAlias
+		Queries        []queryAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{GroupByFilterAlias: (*GroupByFilterAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.Queries = queriesFromAliases(aux.Queries)
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
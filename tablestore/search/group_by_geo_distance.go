@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -110,3 +111,31 @@ func (g *GroupByGeoDistance) Range(fromInclusive float64, toExclusive float64) *
 		Range{from: fromInclusive, to: toExclusive})
 	return g
 }
+
+func (g *GroupByGeoDistance) MarshalJSON() ([]byte, error) {
+	type GroupByGeoDistanceAlias GroupByGeoDistance
+	return json.Marshal(&struct {
+		*GroupByGeoDistanceAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{
+		(*GroupByGeoDistanceAlias)(g),
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByGeoDistance) UnmarshalJSON(data []byte) error {
+	type GroupByGeoDistanceAlias GroupByGeoDistance
+	aux := &struct {
+		*GroupByGeoDistanceAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{GroupByGeoDistanceAlias: (*GroupByGeoDistanceAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
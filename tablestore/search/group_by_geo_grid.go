@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search/model"
 	"github.com/golang/protobuf/proto"
@@ -111,4 +112,32 @@ func (g *GroupByGeoGrid) SetPrecision(precision model.GeoHashPrecision) *GroupBy
 func (g *GroupByGeoGrid) SetSize(size int64) *GroupByGeoGrid {
 	g.Size = size
 	return g
-}
\ No newline at end of file
+}
+
+func (g *GroupByGeoGrid) MarshalJSON() ([]byte, error) {
+	type GroupByGeoGridAlias GroupByGeoGrid
+	return json.Marshal(&struct {
+		*GroupByGeoGridAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{
+		(*GroupByGeoGridAlias)(g),
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByGeoGrid) UnmarshalJSON(data []byte) error {
+	type GroupByGeoGridAlias GroupByGeoGrid
+	aux := &struct {
+		*GroupByGeoGridAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{GroupByGeoGridAlias: (*GroupByGeoGridAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
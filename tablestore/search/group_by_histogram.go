@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search/model"
@@ -165,3 +166,51 @@ func (g *GroupByHistogram) SetGroupBySorters(sorters []GroupBySorter) *GroupByHi
 	g.Sorters = sorters
 	return g
 }
+
+func (g *GroupByHistogram) MarshalJSON() ([]byte, error) {
+	type GroupByHistogramAlias GroupByHistogram
+	sorters, err := newGroupBySorterAliases(g.Sorters)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		*GroupByHistogramAlias
+		Interval       jsonValue
+		Missing        jsonValue
+		FieldRange     fieldRangeJSON
+		Sorters        []groupBySorterAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{
+		(*GroupByHistogramAlias)(g),
+		jsonValue{g.Interval},
+		jsonValue{g.Missing},
+		fieldRangeJSON{jsonValue{g.FieldRange.Min}, jsonValue{g.FieldRange.Max}},
+		sorters,
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByHistogram) UnmarshalJSON(data []byte) error {
+	type GroupByHistogramAlias GroupByHistogram
+	aux := &struct {
+		*GroupByHistogramAlias
+		Interval       jsonValue
+		Missing        jsonValue
+		FieldRange     fieldRangeJSON
+		Sorters        []groupBySorterAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{GroupByHistogramAlias: (*GroupByHistogramAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.Interval = aux.Interval.value
+	g.Missing = aux.Missing.value
+	g.FieldRange.Min, g.FieldRange.Max = aux.FieldRange.Min.value, aux.FieldRange.Max.value
+	g.Sorters = groupBySortersFromAliases(aux.Sorters)
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
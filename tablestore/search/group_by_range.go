@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -98,3 +99,31 @@ func (g *GroupByRange) Range(fromInclusive float64, toExclusive float64) *GroupB
 		Range{from: fromInclusive, to: toExclusive})
 	return g
 }
+
+func (g *GroupByRange) MarshalJSON() ([]byte, error) {
+	type GroupByRangeAlias GroupByRange
+	return json.Marshal(&struct {
+		*GroupByRangeAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{
+		(*GroupByRangeAlias)(g),
+		newAggregationAliases(g.SubAggList),
+		newGroupByAliases(g.SubGroupByList),
+	})
+}
+
+func (g *GroupByRange) UnmarshalJSON(data []byte) error {
+	type GroupByRangeAlias GroupByRange
+	aux := &struct {
+		*GroupByRangeAlias
+		SubAggList     []aggregationAlias
+		SubGroupByList []groupByAlias
+	}{GroupByRangeAlias: (*GroupByRangeAlias)(g)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	g.SubAggList = aggregationsFromAliases(aux.SubAggList)
+	g.SubGroupByList = groupBysFromAliases(aux.SubGroupByList)
+	return nil
+}
@@ -1,6 +1,9 @@
 package search
 
 import (
+	"encoding/json"
+	"errors"
+
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 )
 
@@ -27,3 +30,90 @@ func (s *GroupBySort) ProtoBuffer() (*otsprotocol.GroupBySort, error) {
 	pbGroupBySort.Sorters = pbGroupBySorters
 	return pbGroupBySort, nil
 }
+
+type groupBySorterAlias struct {
+	Name   string
+	Sorter GroupBySorter
+}
+
+func newGroupBySorterAliases(sorters []GroupBySorter) ([]groupBySorterAlias, error) {
+	if sorters == nil {
+		return nil, nil
+	}
+	aliases := make([]groupBySorterAlias, 0, len(sorters))
+	for _, sorter := range sorters {
+		name := ""
+		switch sorter.(type) {
+		case *GroupKeyGroupBySort:
+			name = "GroupKeyGroupBySort"
+		case *RowCountGroupBySort:
+			name = "RowCountGroupBySort"
+		case *SubAggGroupBySort:
+			name = "SubAggGroupBySort"
+		default:
+			return nil, errors.New("Unknown group by sorter type.")
+		}
+		aliases = append(aliases, groupBySorterAlias{Name: name, Sorter: sorter})
+	}
+	return aliases, nil
+}
+
+func groupBySortersFromAliases(aliases []groupBySorterAlias) []GroupBySorter {
+	if aliases == nil {
+		return nil
+	}
+	sorters := make([]GroupBySorter, 0, len(aliases))
+	for _, alias := range aliases {
+		sorters = append(sorters, alias.Sorter)
+	}
+	return sorters
+}
+
+func (s *groupBySorterAlias) UnmarshalJSON(data []byte) (err error) {
+	rawData := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &rawData)
+	if err != nil {
+		return
+	}
+	nameRawMessage, hasName := rawData["Name"]
+	sorterRawMessage, hasData := rawData["Sorter"]
+	if !hasName || !hasData {
+		return errors.New("Group by sorter is invalid.")
+	}
+	err = json.Unmarshal(nameRawMessage, &s.Name)
+	if err != nil {
+		return
+	}
+	switch s.Name {
+	case "GroupKeyGroupBySort":
+		s.Sorter = &GroupKeyGroupBySort{}
+	case "RowCountGroupBySort":
+		s.Sorter = &RowCountGroupBySort{}
+	case "SubAggGroupBySort":
+		s.Sorter = &SubAggGroupBySort{}
+	default:
+		return errors.New("Unknown group by sorter type: " + s.Name)
+	}
+	return json.Unmarshal(sorterRawMessage, s.Sorter)
+}
+
+func (s *GroupBySort) MarshalJSON() ([]byte, error) {
+	sorters, err := newGroupBySorterAliases(s.Sorters)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&struct {
+		Sorters []groupBySorterAlias
+	}{sorters})
+}
+
+func (s *GroupBySort) UnmarshalJSON(data []byte) error {
+	aux := &struct {
+		Sorters []groupBySorterAlias
+	}{}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	s.Sorters = groupBySortersFromAliases(aux.Sorters)
+	return nil
+}
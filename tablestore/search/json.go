@@ -0,0 +1,157 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// jsonValue keeps the type of a term, bound or missing value through JSON,
+// integral float64 values are written with a decimal point and numbers
+// without one are read back as int64.
+type jsonValue struct {
+	value interface{}
+}
+
+func (v jsonValue) MarshalJSON() ([]byte, error) {
+	if f, ok := v.value.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1e21 {
+		return []byte(strconv.FormatFloat(f, 'f', 1, 64)), nil
+	}
+	return json.Marshal(v.value)
+}
+
+func (v *jsonValue) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		v.value = value
+		return nil
+	}
+	if !strings.ContainsAny(string(number), ".eE") {
+		if i, err := number.Int64(); err == nil {
+			v.value = i
+			return nil
+		}
+	}
+	f, err := number.Float64()
+	if err != nil {
+		return err
+	}
+	v.value = f
+	return nil
+}
+
+func newJSONValues(values []interface{}) []jsonValue {
+	if values == nil {
+		return nil
+	}
+	jsonValues := make([]jsonValue, 0, len(values))
+	for _, value := range values {
+		jsonValues = append(jsonValues, jsonValue{value})
+	}
+	return jsonValues
+}
+
+func valuesFromJSON(jsonValues []jsonValue) []interface{} {
+	if jsonValues == nil {
+		return nil
+	}
+	values := make([]interface{}, 0, len(jsonValues))
+	for _, v := range jsonValues {
+		values = append(values, v.value)
+	}
+	return values
+}
+
+func newQueryAlias(query Query) *queryAlias {
+	if query == nil {
+		return nil
+	}
+	return &queryAlias{Name: query.Type().String(), Query: query}
+}
+
+func queryFromAlias(alias *queryAlias) Query {
+	if alias == nil {
+		return nil
+	}
+	return alias.Query
+}
+
+func newQueryAliases(queries []Query) []queryAlias {
+	if queries == nil {
+		return nil
+	}
+	aliases := make([]queryAlias, 0, len(queries))
+	for _, query := range queries {
+		aliases = append(aliases, queryAlias{Name: query.Type().String(), Query: query})
+	}
+	return aliases
+}
+
+func queriesFromAliases(aliases []queryAlias) []Query {
+	if aliases == nil {
+		return nil
+	}
+	queries := make([]Query, 0, len(aliases))
+	for _, alias := range aliases {
+		queries = append(queries, alias.Query)
+	}
+	return queries
+}
+
+func newAggregationAliases(aggs []Aggregation) []aggregationAlias {
+	if aggs == nil {
+		return nil
+	}
+	aliases := make([]aggregationAlias, 0, len(aggs))
+	for _, agg := range aggs {
+		aliases = append(aliases, aggregationAlias{Name: agg.GetType().String(), Aggregation: agg})
+	}
+	return aliases
+}
+
+func aggregationsFromAliases(aliases []aggregationAlias) []Aggregation {
+	if aliases == nil {
+		return nil
+	}
+	aggs := make([]Aggregation, 0, len(aliases))
+	for _, alias := range aliases {
+		aggs = append(aggs, alias.Aggregation)
+	}
+	return aggs
+}
+
+func newGroupByAliases(groupBys []GroupBy) []groupByAlias {
+	if groupBys == nil {
+		return nil
+	}
+	aliases := make([]groupByAlias, 0, len(groupBys))
+	for _, groupBy := range groupBys {
+		aliases = append(aliases, groupByAlias{Name: groupBy.GetType().String(), GroupBy: groupBy})
+	}
+	return aliases
+}
+
+func groupBysFromAliases(aliases []groupByAlias) []GroupBy {
+	if aliases == nil {
+		return nil
+	}
+	groupBys := make([]GroupBy, 0, len(aliases))
+	for _, alias := range aliases {
+		groupBys = append(groupBys, alias.GroupBy)
+	}
+	return groupBys
+}
+
+// fieldRangeJSON is the JSON form of model.FiledRange.
+type fieldRangeJSON struct {
+	Min jsonValue
+	Max jsonValue
+}
@@ -0,0 +1,167 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search/model"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func allQueries() []Query {
+	return []Query{
+		&MatchQuery{FieldName: "title", Text: "hello", MinimumShouldMatch: proto.Int32(1), Operator: QueryOperator_AND.Enum()},
+		&MatchPhraseQuery{FieldName: "title", Text: "hello world"},
+		&TermQuery{FieldName: "count", Term: int64(3)},
+		&TermQuery{FieldName: "price", Term: 3.0},
+		&TermsQuery{FieldName: "tags", Terms: []interface{}{"a", int64(1), 2.0, true}},
+		&RangeQuery{FieldName: "price", From: 1.0, To: int64(100), IncludeLower: true},
+		&PrefixQuery{FieldName: "name", Prefix: "jo"},
+		&WildcardQuery{FieldName: "name", Value: "j?n*"},
+		&MatchAllQuery{},
+		&ExistsQuery{FieldName: "name"},
+		&GeoBoundingBoxQuery{FieldName: "geo", TopLeft: "10,0", BottomRight: "0,10"},
+		&GeoDistanceQuery{FieldName: "geo", CenterPoint: "5,5", DistanceInMeter: 1000},
+		&GeoPolygonQuery{FieldName: "geo", Points: []string{"0,0", "0,10", "10,10"}},
+		&BoolQuery{
+			MustQueries:        []Query{&TermQuery{FieldName: "a", Term: "x"}},
+			MustNotQueries:     []Query{&ExistsQuery{FieldName: "b"}},
+			ShouldQueries:      []Query{&MatchAllQuery{}},
+			MinimumShouldMatch: proto.Int32(1),
+		},
+		&ConstScoreQuery{Filter: &TermQuery{FieldName: "a", Term: int64(1)}},
+		&FunctionScoreQuery{Query: &MatchAllQuery{}, FieldValueFactor: &FieldValueFactor{FieldName: "score"}},
+		&NestedQuery{
+			Path:      "items",
+			Query:     &TermQuery{FieldName: "items.id", Term: int64(7)},
+			ScoreMode: ScoreMode_Max,
+			InnerHits: &InnerHits{Limit: proto.Int32(3), Sort: &Sort{Sorters: []Sorter{&ScoreSort{Order: SortOrder_DESC.Enum()}}}},
+		},
+		&KnnVectorQuery{FieldName: "vector", TopK: proto.Int32(10), Float32QueryVector: []float32{0.5, 1}, Filter: &TermQuery{FieldName: "a", Term: int64(1)}},
+		NewFunctionsScoreQuery().
+			SetQuery(&MatchAllQuery{}).
+			AddFunction(NewScoreFunction().
+				SetDecayFunction(NewDecayFunction().
+					SetFieldName("date").
+					SetDecayParam(NewDecayFuncDateParam().
+						SetOriginLong(1000).
+						SetScale(&model.DateTimeValue{Value: proto.Int32(1), Unit: model.DateTimeUnit_DAY.Enum()}))).
+				SetFilter(&ExistsQuery{FieldName: "date"})).
+			AddFunction(NewScoreFunction().
+				SetDecayFunction(NewDecayFunction().
+					SetFieldName("price").
+					SetDecayParam(NewDecayFuncNumericParam().SetOrigin(1).SetScale(2)))).
+			SetScoreMode(SM_SUM),
+	}
+}
+
+func allAggregations() []Aggregation {
+	return []Aggregation{
+		NewAvgAggregation("avg", "price").Missing(int64(0)),
+		NewDistinctCountAggregation("distinct", "tag").Missing("none"),
+		NewMaxAggregation("max", "price").Missing(1.0),
+		NewMinAggregation("min", "price"),
+		NewSumAggregation("sum", "price").Missing(int64(5)),
+		NewCountAggregation("count", "price"),
+		NewTopRowsAggregation("top").SetLimit(3).SetSort(&Sort{Sorters: []Sorter{&FieldSort{FieldName: "price", Order: SortOrder_DESC.Enum(), MissingValue: LastWhenMissing}}}),
+		NewPercentilesAggregation("percentiles", "price").SetPercents([]float64{50, 99}).SetMissing(int64(0)),
+	}
+}
+
+func allGroupBys() []GroupBy {
+	return []GroupBy{
+		NewGroupByField("field", "tag").Size(10).
+			GroupBySorters([]GroupBySorter{
+				&GroupKeyGroupBySort{Order: SortOrder_ASC.Enum()},
+				&RowCountGroupBySort{Order: SortOrder_DESC.Enum()},
+				&SubAggGroupBySort{Order: SortOrder_DESC.Enum(), SubAggName: "sum"},
+			}).
+			SubAggregations(allAggregations()...).
+			SubGroupBy(NewGroupByRange("range", "price").Range(NegInf, 10).Range(10, Inf)),
+		NewGroupByFilter("filter").Query(&TermQuery{FieldName: "a", Term: int64(1)}).Query(&ExistsQuery{FieldName: "b"}),
+		NewGroupByGeoDistance("distance", "geo", GeoPoint{Lat: 1, Lon: 2}).Range(0, 1000).Range(1000, Inf),
+		NewGroupByHistogram("histogram", "price").SetInterval(int64(10)).SetMissing(0.5).SetFiledRange(int64(0), int64(100)).SetMinDocCount(1).
+			SetGroupBySorters([]GroupBySorter{&GroupKeyGroupBySort{Order: SortOrder_ASC.Enum()}}),
+		NewGroupByDateHistogram("date", "date").SetInterval(model.DateTimeValue{Value: proto.Int32(1), Unit: model.DateTimeUnit_HOUR.Enum()}).
+			SetTimeZone("+08:00").SetMissing("2020-01-01").SetFiledRange("2020-01-01", "2021-01-01"),
+		NewGroupByGeoGrid("grid", "geo").SetPrecision(model.GHP_156KM_156KM_3).SetSize(100),
+		NewGroupByComposite("composite").SourceGroupBys(NewGroupByField("tag", "tag")).SetSize(10).SetNextToken(proto.String("token")),
+	}
+}
+
+func TestSearchQuery_JSONRoundTrip(t *testing.T) {
+	for _, query := range allQueries() {
+		sq := NewSearchQuery().
+			SetQuery(query).
+			SetOffset(10).
+			SetLimit(20).
+			SetGetTotalCount(true).
+			SetCollapse(&Collapse{FieldName: "user"}).
+			SetHighlight(&Highlight{
+				HighlightEncoder: HtmlMode.Enum(),
+				FieldHighlightParameters: map[string]*HighlightParameter{
+					"title": {NumberOfFragments: proto.Int32(2), PreTag: proto.String("<b>"), PostTag: proto.String("</b>")},
+				},
+			}).
+			SetSort(&Sort{Sorters: []Sorter{
+				&FieldSort{FieldName: "price", Order: SortOrder_ASC.Enum(), MissingValue: int64(0),
+					NestedFilter: &NestedFilter{Path: "items", Filter: &ExistsQuery{FieldName: "items.id"}}},
+				&GeoDistanceSort{FieldName: "geo", Points: []string{"0,0"}},
+				&PrimaryKeySort{Order: SortOrder_DESC.Enum()},
+			}}).
+			Aggregation(allAggregations()...).
+			GroupBy(allGroupBys()...)
+
+		data, err := json.Marshal(sq)
+		assert.Nil(t, err, query.Type().String())
+		decoded := NewSearchQuery()
+		assert.Nil(t, json.Unmarshal(data, decoded), query.Type().String())
+		assert.Equal(t, sq, decoded, query.Type().String())
+
+		// the decoded query sends the same request
+		want, err := sq.Serialize()
+		assert.Nil(t, err)
+		got, err := decoded.Serialize()
+		assert.Nil(t, err)
+		assert.Equal(t, want, got, query.Type().String())
+
+		// and writes the same JSON again
+		again, err := json.Marshal(decoded)
+		assert.Nil(t, err)
+		assert.Equal(t, string(data), string(again))
+	}
+}
+
+func TestSearchQuery_JSONToken(t *testing.T) {
+	sq := NewSearchQuery().SetToken([]byte("next"))
+	data, err := json.Marshal(sq)
+	assert.Nil(t, err)
+	decoded := NewSearchQuery()
+	assert.Nil(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, sq, decoded)
+}
+
+func TestJSONValue(t *testing.T) {
+	for _, value := range []interface{}{int64(3), int64(-1 << 62), 3.0, 3.5, 1e300, "3", true, nil} {
+		data, err := json.Marshal(jsonValue{value})
+		assert.Nil(t, err)
+		var decoded jsonValue
+		assert.Nil(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, value, decoded.value, string(data))
+	}
+	data, _ := json.Marshal(jsonValue{2.0})
+	assert.Equal(t, "2.0", string(data))
+}
+
+func TestUnmarshalUnknownTypes(t *testing.T) {
+	_, err := UnmarshalQuery("NoQuery", []byte("{}"))
+	assert.NotNil(t, err)
+	agg, err := UnmarshalAggregation("no_agg", []byte("{}"))
+	assert.Nil(t, err)
+	assert.Nil(t, agg)
+	_, err = UnmarshalGroupBy("no_group_by", []byte("{}"))
+	assert.NotNil(t, err)
+	_, err = newGroupBySorterAliases([]GroupBySorter{nil})
+	assert.NotNil(t, err)
+}
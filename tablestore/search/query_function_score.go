@@ -49,6 +49,7 @@ func (q *FunctionScoreQuery) UnmarshalJSON(data []byte) (err error) {
 	}
 
 	q.Query = bqAlias.QueryAlias.Query
+	q.FieldValueFactor = bqAlias.FieldValueFactor
 	return
 }
 
@@ -3,18 +3,19 @@ package search
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search/model"
 	"github.com/golang/protobuf/proto"
 )
 
 type FunctionsScoreQuery struct {
-	Query       Query            `json:"-"`
-	Functions   []*ScoreFunction `json:"-"`
-	ScoreMode   *ScoreMode       `json:"-"`
-	CombineMode *CombineMode     `json:"-"`
-	MinScore    *float32         `json:"-"`
-	MaxScore    *float32         `json:"-"`
+	Query       Query `json:"-"`
+	Functions   []*ScoreFunction
+	ScoreMode   *ScoreMode
+	CombineMode *CombineMode
+	MinScore    *float32
+	MaxScore    *float32
 	// for json marshal and unmarshal
 	QueryAlias queryAlias `json:"Query"`
 }
@@ -81,6 +82,11 @@ func (q *FunctionsScoreQuery) UnmarshalJSON(data []byte) (err error) {
 	}
 
 	q.Query = bqAlias.QueryAlias.Query
+	q.Functions = bqAlias.Functions
+	q.ScoreMode = bqAlias.ScoreMode
+	q.CombineMode = bqAlias.CombineMode
+	q.MinScore = bqAlias.MinScore
+	q.MaxScore = bqAlias.MaxScore
 	return
 }
 
@@ -316,6 +322,32 @@ type DecayFunction struct {
 	MultiValueMode *MultiValueMode
 }
 
+func (f *DecayFunction) UnmarshalJSON(data []byte) error {
+	type DecayFunctionAlias DecayFunction
+	aux := &struct {
+		*DecayFunctionAlias
+		DecayParam json.RawMessage
+	}{DecayFunctionAlias: (*DecayFunctionAlias)(f)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	f.DecayParam = nil
+	if len(aux.DecayParam) == 0 || string(aux.DecayParam) == "null" {
+		return nil
+	}
+	switch f.ParamType {
+	case PT_DATE:
+		f.DecayParam = &DecayFuncDateParam{}
+	case PT_GEO:
+		f.DecayParam = &DecayFuncGeoParam{}
+	case PT_NUMERIC:
+		f.DecayParam = &DecayFuncNumericParam{}
+	default:
+		return errors.New("unknown decay param type")
+	}
+	return json.Unmarshal(aux.DecayParam, f.DecayParam)
+}
+
 func NewDecayFunction() *DecayFunction {
 	return &DecayFunction{}
 }
@@ -422,6 +454,31 @@ func (t ParamType) Enum() *ParamType {
 	return p
 }
 
+// UnmarshalJSON accepts the number a ParamType is written as and the
+// names "date", "geo" and "numeric" of older documents.
+func (t *ParamType) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var n int32
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*t = ParamType(n)
+		return nil
+	}
+	switch strings.ToLower(name) {
+	case "date":
+		*t = PT_DATE
+	case "geo":
+		*t = PT_GEO
+	case "numeric":
+		*t = PT_NUMERIC
+	default:
+		return errors.New("unknown decay param type " + name)
+	}
+	return nil
+}
+
 func (t *ParamType) ProtoBuffer() *otsprotocol.DecayFuncParamType {
 	if t == nil {
 		return nil
@@ -728,3 +785,45 @@ func (m *CombineMode) ProtoBuffer() *otsprotocol.FunctionCombineMode {
 		return nil
 	}
 }
+
+func (f *ScoreFunction) MarshalJSON() ([]byte, error) {
+	type ScoreFunctionAlias ScoreFunction
+	return json.Marshal(&struct {
+		*ScoreFunctionAlias
+		Filter *queryAlias
+	}{
+		(*ScoreFunctionAlias)(f),
+		newQueryAlias(f.Filter),
+	})
+}
+
+// UnmarshalJSON reads the filter written by MarshalJSON. Older documents
+// hold the bare filter query, without its name: its type is unknown and
+// such a document is rejected.
+func (f *ScoreFunction) UnmarshalJSON(data []byte) error {
+	type ScoreFunctionAlias ScoreFunction
+	aux := &struct {
+		*ScoreFunctionAlias
+		Filter json.RawMessage
+	}{ScoreFunctionAlias: (*ScoreFunctionAlias)(f)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	f.Filter = nil
+	if len(aux.Filter) == 0 || string(aux.Filter) == "null" {
+		return nil
+	}
+	var named struct{ Name *string }
+	if err := json.Unmarshal(aux.Filter, &named); err != nil {
+		return err
+	}
+	if named.Name == nil {
+		return errors.New("ScoreFunction: filter has no query name")
+	}
+	filter := &queryAlias{}
+	if err := json.Unmarshal(aux.Filter, filter); err != nil {
+		return err
+	}
+	f.Filter = filter.Query
+	return nil
+}
@@ -1,6 +1,8 @@
 package search
 
 import (
+    "bytes"
+    "encoding/json"
     "github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
     "github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search/model"
     "github.com/golang/protobuf/proto"
@@ -136,22 +138,90 @@ func TestFunctionsScoreQuery_MarshalJSON(t *testing.T) {
         SetMinScore(0).
         SetScoreMode(SM_MAX).
         SetCombineMode(CM_MAX)
-    json, err := functionsScore.MarshalJSON()
+    data, err := functionsScore.MarshalJSON()
     assert.Nil(t, err)
-    expected := []byte(`{"Query":{"Name":"MatchAllQuery","Query":{}}}`)
-    assert.Equal(t, expected, json)
+
+    // the functions and modes survive a round trip
+    decoded := NewFunctionsScoreQuery()
+    err = decoded.UnmarshalJSON(data)
+    assert.Nil(t, err)
+    assert.Equal(t, functionsScore, decoded)
 }
 
 func TestFunctionsScoreQuery_UnmarshalJSON(t *testing.T) {
     data := []byte(`{"Functions":[{"FieldValueFactorFunction":null,"DecayFunction":{"FieldName":"Col_GeoPoint","ParamType":"geo","DecayParam":{"Origin":"30.137817,120.08681","Scale":1000,"Offset":0},"MathFunction":0,"Decay":0.6,"MultiValueMode":2},"RandomFunction":null,"Weight":2,"Filter":{"FieldName":"Col_GeoPoint"}},{"FieldValueFactorFunction":null,"DecayFunction":null,"RandomFunction":{},"Weight":null,"Filter":null},{"FieldValueFactorFunction":{"FieldName":"Col_Double","Factor":1.1,"Modifier":4,"Missing":1},"DecayFunction":null,"RandomFunction":null,"Weight":null,"Filter":null}],"ScoreMode":1,"CombineMode":2,"MinScore":0,"MaxScore":1000,"Query":{"Name":"MatchAllQuery","Query":{}}}`)
     functionScore := NewFunctionsScoreQuery()
     err := functionScore.UnmarshalJSON(data)
+    // the bare filter of the first function has no query name
+    assert.NotNil(t, err)
+
+    data = bytes.Replace(data, []byte(`"Filter":{"FieldName":"Col_GeoPoint"}`), []byte(`"Filter":null`), 1)
+    functionScore = NewFunctionsScoreQuery()
+    err = functionScore.UnmarshalJSON(data)
+    assert.Nil(t, err)
+    expected := NewFunctionsScoreQuery().
+        SetQuery(&MatchAllQuery{}).
+        AddFunction(NewScoreFunction().
+            SetDecayFunction(NewDecayFunction().
+                SetFieldName("Col_GeoPoint").
+                SetMathFunction(GAUSS).
+                SetDecayParam(NewDecayFuncGeoParam().
+                    SetOrigin("30.137817,120.08681").
+                    SetScale(1000).
+                    SetOffset(0)).
+                SetDecay(0.6).
+                SetMultiValueMode(MVM_SUM)).
+            SetWeight(2)).
+        AddFunction(NewScoreFunction().
+            SetRandomFunction(NewRandomFunction())).
+        AddFunction(NewScoreFunction().
+            SetFieldValueFactorFunction(NewFieldValueFactorFunction().
+                SetFieldName("Col_Double").
+                SetFactor(1.1).
+                SetFunctionModifier(LN).
+                SetMissing(1.0))).
+        SetMaxScore(1000).
+        SetMinScore(0).
+        SetScoreMode(SM_MAX).
+        SetCombineMode(CM_MAX)
+    assert.Equal(t, expected, functionScore)
+}
+
+func TestFunctionsScoreQuery_UnmarshalJSONNamedFilter(t *testing.T) {
+    data := []byte(`{"Functions":[{"FieldValueFactorFunction":null,"DecayFunction":{"FieldName":"Col_GeoPoint","ParamType":1,"DecayParam":{"Origin":"30.137817,120.08681","Scale":1000,"Offset":0},"MathFunction":0,"Decay":0.6,"MultiValueMode":2},"RandomFunction":null,"Weight":2,"Filter":{"Name":"ExistsQuery","Query":{"FieldName":"Col_GeoPoint"}}}],"ScoreMode":1,"MaxScore":1000,"Query":{"Name":"MatchAllQuery","Query":{}}}`)
+    functionScore := NewFunctionsScoreQuery()
+    err := functionScore.UnmarshalJSON(data)
     assert.Nil(t, err)
     expected := NewFunctionsScoreQuery().
-        SetQuery(&MatchAllQuery{})
+        SetQuery(&MatchAllQuery{}).
+        AddFunction(NewScoreFunction().
+            SetDecayFunction(NewDecayFunction().
+                SetFieldName("Col_GeoPoint").
+                SetMathFunction(GAUSS).
+                SetDecayParam(NewDecayFuncGeoParam().
+                    SetOrigin("30.137817,120.08681").
+                    SetScale(1000).
+                    SetOffset(0)).
+                SetDecay(0.6).
+                SetMultiValueMode(MVM_SUM)).
+            SetFilter(&ExistsQuery{FieldName: "Col_GeoPoint"}).
+            SetWeight(2)).
+        SetMaxScore(1000).
+        SetScoreMode(SM_MAX)
     assert.Equal(t, expected, functionScore)
 }
 
+func TestParamType_UnmarshalJSON(t *testing.T) {
+    var paramType ParamType
+    assert.Nil(t, json.Unmarshal([]byte(`"numeric"`), &paramType))
+    assert.Equal(t, PT_NUMERIC, paramType)
+    assert.Nil(t, json.Unmarshal([]byte(`"DATE"`), &paramType))
+    assert.Equal(t, PT_DATE, paramType)
+    assert.Nil(t, json.Unmarshal([]byte(`1`), &paramType))
+    assert.Equal(t, PT_GEO, paramType)
+    assert.NotNil(t, json.Unmarshal([]byte(`"time"`), &paramType))
+}
+
 func TestFunctionsScoreQuery_Type(t *testing.T) {
     functionsScoreQuery := NewFunctionsScoreQuery()
     assert.Equal(t, QueryType_FunctionsScoreQuery, functionsScoreQuery.Type())
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -37,3 +38,27 @@ func (q *KnnVectorQuery) Serialize() ([]byte, error) {
 func (q *KnnVectorQuery) ProtoBuffer() (*otsprotocol.Query, error) {
 	return BuildPBForQuery(q)
 }
+
+func (q *KnnVectorQuery) MarshalJSON() ([]byte, error) {
+	type KnnVectorQueryAlias KnnVectorQuery
+	return json.Marshal(&struct {
+		*KnnVectorQueryAlias
+		Filter *queryAlias
+	}{
+		(*KnnVectorQueryAlias)(q),
+		newQueryAlias(q.Filter),
+	})
+}
+
+func (q *KnnVectorQuery) UnmarshalJSON(data []byte) error {
+	type KnnVectorQueryAlias KnnVectorQuery
+	aux := &struct {
+		*KnnVectorQueryAlias
+		Filter *queryAlias
+	}{KnnVectorQueryAlias: (*KnnVectorQueryAlias)(q)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	q.Filter = queryFromAlias(aux.Filter)
+	return nil
+}
@@ -81,7 +81,10 @@ func (q *NestedQuery) UnmarshalJSON(data []byte) (err error) {
 		return
 	}
 
+	q.Path = bqAlias.Path
 	q.Query = bqAlias.QueryAlias.Query
+	q.ScoreMode = bqAlias.ScoreMode
+	q.InnerHits = bqAlias.InnerHits
 	return
 }
 
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
@@ -73,3 +74,31 @@ func (q *RangeQuery) Serialize() ([]byte, error) {
 func (q *RangeQuery) ProtoBuffer() (*otsprotocol.Query, error) {
 	return BuildPBForQuery(q)
 }
+
+func (q *RangeQuery) MarshalJSON() ([]byte, error) {
+	type RangeQueryAlias RangeQuery
+	return json.Marshal(&struct {
+		*RangeQueryAlias
+		From jsonValue
+		To   jsonValue
+	}{
+		(*RangeQueryAlias)(q),
+		jsonValue{q.From},
+		jsonValue{q.To},
+	})
+}
+
+func (q *RangeQuery) UnmarshalJSON(data []byte) error {
+	type RangeQueryAlias RangeQuery
+	aux := &struct {
+		*RangeQueryAlias
+		From jsonValue
+		To   jsonValue
+	}{RangeQueryAlias: (*RangeQueryAlias)(q)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	q.From = aux.From.value
+	q.To = aux.To.value
+	return nil
+}
@@ -0,0 +1,507 @@
+package search
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// QueryStringError is returned by ParseQueryString for malformed input.
+type QueryStringError struct {
+	Query  string
+	Offset int
+	Reason string
+}
+
+func (e *QueryStringError) Error() string {
+	return fmt.Sprintf("invalid query string at offset %d: %s", e.Offset, e.Reason)
+}
+
+// ParseQueryString builds a query from an expression such as
+//
+//	title:"hello world" AND price:[10 TO 100] AND NOT status:deleted AND tags:(a OR b) AND name:jo*
+//
+// Clauses are field:value pairs combined with AND, OR and NOT (also &&, ||
+// and !, or a leading -), adjacent clauses meaning AND, grouped with
+// parentheses. A value is one of
+//
+//	word        TermQuery, or MatchQuery on TEXT fields
+//	"a phrase"  MatchPhraseQuery, or TermQuery on non-TEXT fields
+//	[1 TO 5}    RangeQuery, [ and ] including the bound, * leaving it open
+//	>=1, <5     RangeQuery
+//	jo*         PrefixQuery
+//	j?n*e       WildcardQuery
+//	*           ExistsQuery, also written _exists_:field
+//	(a OR b)    the values combined on the same field, terms of an OR
+//	            becoming a single TermsQuery
+//
+// A backslash escapes the next character. The optional schema picks term or
+// match queries by field type and converts values to the type of the field,
+// and wraps clauses on fields inside NESTED fields in a NestedQuery on each
+// of them, the only way the service matches such fields. Without it, unquoted integers, floats and true/false are typed, anything
+// else is a string. An empty expression matches all rows.
+func ParseQueryString(query string, schema ...IndexFields) (Query, error) {
+	p := &queryStringParser{query: query, input: []rune(query)}
+	if len(schema) > 0 {
+		p.schema = schema[0]
+	}
+	p.skipSpace()
+	if p.eof() {
+		return &MatchAllQuery{}, nil
+	}
+	q, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return q, nil
+}
+
+type queryStringParser struct {
+	query  string
+	input  []rune
+	pos    int
+	schema IndexFields
+}
+
+func (p *queryStringParser) errorf(format string, args ...interface{}) error {
+	return &QueryStringError{Query: p.query, Offset: p.pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *queryStringParser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *queryStringParser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// keyword consumes one of the operators when it stands as a whole word.
+func (p *queryStringParser) keyword(words ...string) bool {
+	p.skipSpace()
+	for _, word := range words {
+		end := p.pos + len(word)
+		if end > len(p.input) || string(p.input[p.pos:end]) != word {
+			continue
+		}
+		if end < len(p.input) && isWordRune(p.input[end]) && isWordRune(p.input[end-1]) {
+			continue
+		}
+		p.pos = end
+		return true
+	}
+	return false
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// parseOr parses clauses joined by OR. field is the field of an enclosing
+// field:(...) group, or "" at the top.
+func (p *queryStringParser) parseOr(field string) (Query, error) {
+	var clauses []Query
+	for {
+		q, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, q)
+		if !p.keyword("OR", "||") {
+			break
+		}
+	}
+	if len(clauses) == 1 {
+		return clauses[0], nil
+	}
+	if terms := mergeTerms(clauses); terms != nil {
+		return terms, nil
+	}
+	one := int32(1)
+	return &BoolQuery{ShouldQueries: clauses, MinimumShouldMatch: &one}, nil
+}
+
+// mergeTerms turns term queries on a single field into a TermsQuery.
+func mergeTerms(clauses []Query) *TermsQuery {
+	terms := &TermsQuery{}
+	for _, q := range clauses {
+		switch q := q.(type) {
+		case *TermQuery:
+			if terms.FieldName != "" && terms.FieldName != q.FieldName {
+				return nil
+			}
+			terms.FieldName = q.FieldName
+			terms.Terms = append(terms.Terms, q.Term)
+		case *TermsQuery:
+			if terms.FieldName != "" && terms.FieldName != q.FieldName {
+				return nil
+			}
+			terms.FieldName = q.FieldName
+			terms.Terms = append(terms.Terms, q.Terms...)
+		default:
+			return nil
+		}
+	}
+	return terms
+}
+
+// parseAnd parses clauses joined by AND or placed next to each other.
+func (p *queryStringParser) parseAnd(field string) (Query, error) {
+	bq := &BoolQuery{}
+	for {
+		q, negated, err := p.parseUnary(field)
+		if err != nil {
+			return nil, err
+		}
+		if negated {
+			bq.MustNotQueries = append(bq.MustNotQueries, q)
+		} else if inner, ok := q.(*BoolQuery); ok && isConjunction(inner) {
+			bq.MustQueries = append(bq.MustQueries, inner.MustQueries...)
+			bq.MustNotQueries = append(bq.MustNotQueries, inner.MustNotQueries...)
+		} else {
+			bq.MustQueries = append(bq.MustQueries, q)
+		}
+
+		if p.keyword("AND", "&&") {
+			continue
+		}
+		p.skipSpace()
+		if p.eof() || p.input[p.pos] == ')' || p.peekKeyword("OR", "||") {
+			break
+		}
+	}
+	if len(bq.MustQueries) == 1 && len(bq.MustNotQueries) == 0 {
+		return bq.MustQueries[0], nil
+	}
+	return bq, nil
+}
+
+func isConjunction(q *BoolQuery) bool {
+	return len(q.FilterQueries) == 0 && len(q.ShouldQueries) == 0 && q.MinimumShouldMatch == nil
+}
+
+func (p *queryStringParser) peekKeyword(words ...string) bool {
+	pos := p.pos
+	ok := p.keyword(words...)
+	p.pos = pos
+	return ok
+}
+
+// parseUnary parses a clause and tells whether it is negated.
+func (p *queryStringParser) parseUnary(field string) (Query, bool, error) {
+	p.skipSpace()
+	negated := false
+	for {
+		if p.keyword("NOT") {
+			negated = !negated
+			continue
+		}
+		if !p.eof() && (p.input[p.pos] == '!' || p.input[p.pos] == '-') {
+			p.pos++
+			negated = !negated
+			continue
+		}
+		break
+	}
+	q, err := p.parsePrimary(field)
+	return q, negated, err
+}
+
+func (p *queryStringParser) parsePrimary(field string) (Query, error) {
+	p.skipSpace()
+	if p.eof() {
+		return nil, p.errorf("unexpected end of query")
+	}
+	if p.input[p.pos] == '(' {
+		return p.parseGroup(field)
+	}
+	if field != "" {
+		return p.parseValue(field)
+	}
+
+	start := p.pos
+	name, _, err := p.readTerm(":")
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	if p.eof() || p.input[p.pos] != ':' {
+		p.pos = start
+		return nil, p.errorf("field name required before %q", name)
+	}
+	p.pos++
+	switch name {
+	case "*":
+		if p.eof() || p.input[p.pos] != '*' {
+			return nil, p.errorf("expected * after *:")
+		}
+		p.pos++
+		return &MatchAllQuery{}, nil
+	case "_exists_":
+		fieldName, _, err := p.readTerm("")
+		if err != nil {
+			return nil, err
+		}
+		if fieldName == "" {
+			return nil, p.errorf("field name required after _exists_:")
+		}
+		return p.nested(fieldName, &ExistsQuery{FieldName: fieldName}), nil
+	}
+	q, err := p.parseValue(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.nested(name, q), nil
+}
+
+// nested wraps the query of a field clause in a NestedQuery on every NESTED
+// field the field is in, the innermost first.
+func (p *queryStringParser) nested(field string, q Query) Query {
+	for i := strings.LastIndex(field, "."); i > 0; i = strings.LastIndex(field[:i], ".") {
+		if p.fieldType(field[:i]) == "NESTED" {
+			q = &NestedQuery{Path: field[:i], Query: q, ScoreMode: ScoreMode_Avg}
+		}
+	}
+	return q
+}
+
+func (p *queryStringParser) parseGroup(field string) (Query, error) {
+	p.pos++
+	q, err := p.parseOr(field)
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.eof() || p.input[p.pos] != ')' {
+		return nil, p.errorf("expected )")
+	}
+	p.pos++
+	return q, nil
+}
+
+func (p *queryStringParser) parseValue(field string) (Query, error) {
+	if p.eof() {
+		return nil, p.errorf("value required for field %s", field)
+	}
+	switch c := p.input[p.pos]; c {
+	case '(':
+		return p.parseGroup(field)
+	case '"':
+		text, err := p.readQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return p.termQuery(field, text, true)
+	case '[', '{':
+		return p.parseRange(field)
+	case '>', '<':
+		p.pos++
+		inclusive := !p.eof() && p.input[p.pos] == '='
+		if inclusive {
+			p.pos++
+		}
+		value, err := p.readBound(field, "")
+		if err != nil {
+			return nil, err
+		}
+		q := &RangeQuery{FieldName: field}
+		if c == '>' {
+			q.From, q.IncludeLower = value, inclusive
+		} else {
+			q.To, q.IncludeUpper = value, inclusive
+		}
+		return q, nil
+	}
+
+	text, wildcards, err := p.readTerm("")
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, p.errorf("value required for field %s", field)
+	}
+	switch {
+	case text == "*" && len(wildcards) == 1:
+		return &ExistsQuery{FieldName: field}, nil
+	case len(wildcards) == 1 && wildcards[0] == len([]rune(text))-1 && strings.HasSuffix(text, "*"):
+		return &PrefixQuery{FieldName: field, Prefix: strings.TrimSuffix(text, "*")}, nil
+	case len(wildcards) > 0:
+		return &WildcardQuery{FieldName: field, Value: text}, nil
+	}
+	return p.termQuery(field, text, false)
+}
+
+func (p *queryStringParser) parseRange(field string) (Query, error) {
+	q := &RangeQuery{FieldName: field, IncludeLower: p.input[p.pos] == '['}
+	p.pos++
+	p.skipSpace()
+	from, err := p.readBound(field, "]}")
+	if err != nil {
+		return nil, err
+	}
+	if !p.keyword("TO") {
+		return nil, p.errorf("expected TO in range")
+	}
+	p.skipSpace()
+	to, err := p.readBound(field, "]}")
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.eof() || (p.input[p.pos] != ']' && p.input[p.pos] != '}') {
+		return nil, p.errorf("expected ] or } to close the range")
+	}
+	q.IncludeUpper = p.input[p.pos] == ']'
+	p.pos++
+	q.From, q.To = from, to
+	if from == nil {
+		q.IncludeLower = false
+	}
+	if to == nil {
+		q.IncludeUpper = false
+	}
+	return q, nil
+}
+
+// readBound reads a range bound, nil for *.
+func (p *queryStringParser) readBound(field string, stop string) (interface{}, error) {
+	if !p.eof() && p.input[p.pos] == '"' {
+		text, err := p.readQuoted()
+		if err != nil {
+			return nil, err
+		}
+		return text, nil
+	}
+	text, wildcards, err := p.readTerm(stop)
+	if err != nil {
+		return nil, err
+	}
+	if text == "" {
+		return nil, p.errorf("range bound required for field %s", field)
+	}
+	if text == "*" && len(wildcards) == 1 {
+		return nil, nil
+	}
+	return p.convert(field, text, false)
+}
+
+// readTerm reads a bare word up to a space, a parenthesis, a quote or one of
+// stop, and returns the offsets of its unescaped wildcards.
+func (p *queryStringParser) readTerm(stop string) (string, []int, error) {
+	var b strings.Builder
+	var wildcards []int
+	n := 0
+	for !p.eof() {
+		c := p.input[p.pos]
+		if unicode.IsSpace(c) || c == '(' || c == ')' || c == '"' || strings.ContainsRune(stop, c) {
+			break
+		}
+		if c == '\\' {
+			p.pos++
+			if p.eof() {
+				return "", nil, p.errorf("escape at end of query")
+			}
+			c = p.input[p.pos]
+		} else if c == '*' || c == '?' {
+			wildcards = append(wildcards, n)
+		}
+		b.WriteRune(c)
+		n++
+		p.pos++
+	}
+	return b.String(), wildcards, nil
+}
+
+func (p *queryStringParser) readQuoted() (string, error) {
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for !p.eof() {
+		c := p.input[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				break
+			}
+			c = p.input[p.pos]
+			p.pos++
+		}
+		b.WriteRune(c)
+	}
+	p.pos = start
+	return "", p.errorf("unterminated quote")
+}
+
+func (p *queryStringParser) fieldType(field string) string {
+	if p.schema == nil {
+		return ""
+	}
+	info, _ := p.schema.FieldInfo(field)
+	return strings.ToUpper(info.Type)
+}
+
+func (p *queryStringParser) termQuery(field, text string, quoted bool) (Query, error) {
+	switch typ := p.fieldType(field); {
+	case typ == "TEXT":
+		if quoted {
+			return &MatchPhraseQuery{FieldName: field, Text: text}, nil
+		}
+		return &MatchQuery{FieldName: field, Text: text}, nil
+	case typ == "" && quoted:
+		return &MatchPhraseQuery{FieldName: field, Text: text}, nil
+	}
+	value, err := p.convert(field, text, quoted)
+	if err != nil {
+		return nil, err
+	}
+	return &TermQuery{FieldName: field, Term: value}, nil
+}
+
+// convert turns a value into the type of its field.
+func (p *queryStringParser) convert(field, text string, quoted bool) (interface{}, error) {
+	switch typ := p.fieldType(field); typ {
+	case "LONG":
+		v, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, p.errorf("%q is not a LONG for field %s", text, field)
+		}
+		return v, nil
+	case "DOUBLE":
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, p.errorf("%q is not a DOUBLE for field %s", text, field)
+		}
+		return v, nil
+	case "BOOLEAN":
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, p.errorf("%q is not a BOOLEAN for field %s", text, field)
+		}
+		return v, nil
+	case "":
+		if quoted {
+			return text, nil
+		}
+		if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseFloat(text, 64); err == nil && strings.ContainsAny(text, "0123456789") {
+			return v, nil
+		}
+		if text == "true" || text == "false" {
+			return text == "true", nil
+		}
+	}
+	return text, nil
+}
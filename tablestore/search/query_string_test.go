@@ -0,0 +1,164 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapSchema map[string]string
+
+func (s mapSchema) FieldInfo(field string) (FieldInfo, bool) {
+	typ, ok := s[field]
+	return FieldInfo{Type: typ, Index: true}, ok
+}
+
+func TestParseQueryString(t *testing.T) {
+	q, err := ParseQueryString(`title:"hello world" AND price:[10 TO 100] AND NOT status:deleted AND tags:(a OR b) AND name:jo*`)
+	assert.Nil(t, err)
+	assert.Equal(t, &BoolQuery{
+		MustQueries: []Query{
+			&MatchPhraseQuery{FieldName: "title", Text: "hello world"},
+			&RangeQuery{FieldName: "price", From: int64(10), To: int64(100), IncludeLower: true, IncludeUpper: true},
+			&TermsQuery{FieldName: "tags", Terms: []interface{}{"a", "b"}},
+			&PrefixQuery{FieldName: "name", Prefix: "jo"},
+		},
+		MustNotQueries: []Query{
+			&TermQuery{FieldName: "status", Term: "deleted"},
+		},
+	}, q)
+}
+
+func TestParseQueryString_Values(t *testing.T) {
+	cases := []struct {
+		query string
+		want  Query
+	}{
+		{"", &MatchAllQuery{}},
+		{"*:*", &MatchAllQuery{}},
+		{"a:1", &TermQuery{FieldName: "a", Term: int64(1)}},
+		{"a:1.5", &TermQuery{FieldName: "a", Term: 1.5}},
+		{"a:true", &TermQuery{FieldName: "a", Term: true}},
+		{"a:nan", &TermQuery{FieldName: "a", Term: "nan"}},
+		{`a:x\:y\ z`, &TermQuery{FieldName: "a", Term: "x:y z"}},
+		{"a:10:30", &TermQuery{FieldName: "a", Term: "10:30"}},
+		{"a:*", &ExistsQuery{FieldName: "a"}},
+		{"_exists_:a", &ExistsQuery{FieldName: "a"}},
+		{"a:j?n*e", &WildcardQuery{FieldName: "a", Value: "j?n*e"}},
+		{`a:jo\*`, &TermQuery{FieldName: "a", Term: "jo*"}},
+		{"a:{1 TO *]", &RangeQuery{FieldName: "a", From: int64(1)}},
+		{"a:[* TO 5}", &RangeQuery{FieldName: "a", To: int64(5)}},
+		{`a:["a" TO "b"]`, &RangeQuery{FieldName: "a", From: "a", To: "b", IncludeLower: true, IncludeUpper: true}},
+		{"a:>=3", &RangeQuery{FieldName: "a", From: int64(3), IncludeLower: true}},
+		{"a:<3", &RangeQuery{FieldName: "a", To: int64(3)}},
+		{"NOT a:1", &BoolQuery{MustNotQueries: []Query{&TermQuery{FieldName: "a", Term: int64(1)}}}},
+		{"NOT NOT a:1", &TermQuery{FieldName: "a", Term: int64(1)}},
+		{"a:x OR a:y OR a:(z)", &TermsQuery{FieldName: "a", Terms: []interface{}{"x", "y", "z"}}},
+	}
+	for _, c := range cases {
+		q, err := ParseQueryString(c.query)
+		assert.Nil(t, err, c.query)
+		assert.Equal(t, c.want, q, c.query)
+	}
+}
+
+func TestParseQueryString_Operators(t *testing.T) {
+	one := int32(1)
+	a := &TermQuery{FieldName: "a", Term: int64(1)}
+	b := &TermQuery{FieldName: "b", Term: int64(2)}
+	c := &TermQuery{FieldName: "c", Term: int64(3)}
+
+	q, err := ParseQueryString("a:1 b:2 || c:3")
+	assert.Nil(t, err)
+	assert.Equal(t, &BoolQuery{
+		ShouldQueries: []Query{
+			&BoolQuery{MustQueries: []Query{a, b}},
+			c,
+		},
+		MinimumShouldMatch: &one,
+	}, q)
+
+	q, err = ParseQueryString("a:1 && (b:2 AND -c:3)")
+	assert.Nil(t, err)
+	assert.Equal(t, &BoolQuery{MustQueries: []Query{a, b}, MustNotQueries: []Query{c}}, q)
+
+	q, err = ParseQueryString("!a:1 AND (b:2 OR c:3)")
+	assert.Nil(t, err)
+	assert.Equal(t, &BoolQuery{
+		MustQueries:    []Query{&BoolQuery{ShouldQueries: []Query{b, c}, MinimumShouldMatch: &one}},
+		MustNotQueries: []Query{a},
+	}, q)
+
+	// words starting like an operator are values
+	q, err = ParseQueryString("ORDER:NOTE")
+	assert.Nil(t, err)
+	assert.Equal(t, &TermQuery{FieldName: "ORDER", Term: "NOTE"}, q)
+}
+
+func TestParseQueryString_Schema(t *testing.T) {
+	schema := mapSchema{"title": "TEXT", "code": "KEYWORD", "price": "DOUBLE", "count": "LONG", "on": "BOOLEAN"}
+	one := int32(1)
+
+	q, err := ParseQueryString(`title:hello title:"hello world" code:"a b" code:007 price:[1 TO 2] count:3 on:false`, schema)
+	assert.Nil(t, err)
+	assert.Equal(t, &BoolQuery{MustQueries: []Query{
+		&MatchQuery{FieldName: "title", Text: "hello"},
+		&MatchPhraseQuery{FieldName: "title", Text: "hello world"},
+		&TermQuery{FieldName: "code", Term: "a b"},
+		&TermQuery{FieldName: "code", Term: "007"},
+		&RangeQuery{FieldName: "price", From: 1.0, To: 2.0, IncludeLower: true, IncludeUpper: true},
+		&TermQuery{FieldName: "count", Term: int64(3)},
+		&TermQuery{FieldName: "on", Term: false},
+	}}, q)
+
+	q, err = ParseQueryString("title:(go OR rust)", schema)
+	assert.Nil(t, err)
+	assert.Equal(t, &BoolQuery{
+		ShouldQueries: []Query{
+			&MatchQuery{FieldName: "title", Text: "go"},
+			&MatchQuery{FieldName: "title", Text: "rust"},
+		},
+		MinimumShouldMatch: &one,
+	}, q)
+
+	_, err = ParseQueryString("count:many", schema)
+	assert.EqualError(t, err, `invalid query string at offset 10: "many" is not a LONG for field count`)
+}
+
+func TestParseQueryString_Nested(t *testing.T) {
+	schema := mapSchema{"items": "NESTED", "items.sku": "KEYWORD", "items.parts": "NESTED", "items.parts.qty": "LONG"}
+	q, err := ParseQueryString("items.sku:(a OR b) AND NOT _exists_:items.parts.qty", schema)
+	assert.Nil(t, err)
+	assert.Equal(t, &BoolQuery{
+		MustQueries: []Query{
+			&NestedQuery{Path: "items", Query: &TermsQuery{FieldName: "items.sku", Terms: []interface{}{"a", "b"}}, ScoreMode: ScoreMode_Avg},
+		},
+		MustNotQueries: []Query{
+			&NestedQuery{Path: "items", Query: &NestedQuery{Path: "items.parts", Query: &ExistsQuery{FieldName: "items.parts.qty"}, ScoreMode: ScoreMode_Avg}, ScoreMode: ScoreMode_Avg},
+		},
+	}, q)
+
+	// without a schema nothing is known to be nested
+	q, err = ParseQueryString("items.sku:a")
+	assert.Nil(t, err)
+	assert.Equal(t, &TermQuery{FieldName: "items.sku", Term: "a"}, q)
+}
+
+func TestParseQueryString_Errors(t *testing.T) {
+	for _, query := range []string{
+		"hello",
+		"a:",
+		"a:(b",
+		`a:"b`,
+		"a:[1 2]",
+		"a:[1 TO 2",
+		"a:1 )",
+		"a:1 AND",
+		`a:b\`,
+		"*:a",
+	} {
+		_, err := ParseQueryString(query)
+		_, ok := err.(*QueryStringError)
+		assert.True(t, ok, query)
+	}
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -29,3 +30,27 @@ func (q *TermQuery) Serialize() ([]byte, error) {
 func (q *TermQuery) ProtoBuffer() (*otsprotocol.Query, error) {
 	return BuildPBForQuery(q)
 }
+
+func (q *TermQuery) MarshalJSON() ([]byte, error) {
+	type TermQueryAlias TermQuery
+	return json.Marshal(&struct {
+		*TermQueryAlias
+		Term jsonValue
+	}{
+		(*TermQueryAlias)(q),
+		jsonValue{q.Term},
+	})
+}
+
+func (q *TermQuery) UnmarshalJSON(data []byte) error {
+	type TermQueryAlias TermQuery
+	aux := &struct {
+		*TermQueryAlias
+		Term jsonValue
+	}{TermQueryAlias: (*TermQueryAlias)(q)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	q.Term = aux.Term.value
+	return nil
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"github.com/golang/protobuf/proto"
 )
@@ -33,3 +34,27 @@ func (q *TermsQuery) Serialize() ([]byte, error) {
 func (q *TermsQuery) ProtoBuffer() (*otsprotocol.Query, error) {
 	return BuildPBForQuery(q)
 }
+
+func (q *TermsQuery) MarshalJSON() ([]byte, error) {
+	type TermsQueryAlias TermsQuery
+	return json.Marshal(&struct {
+		*TermsQueryAlias
+		Terms []jsonValue
+	}{
+		(*TermsQueryAlias)(q),
+		newJSONValues(q.Terms),
+	})
+}
+
+func (q *TermsQuery) UnmarshalJSON(data []byte) error {
+	type TermsQueryAlias TermsQuery
+	aux := &struct {
+		*TermsQueryAlias
+		Terms []jsonValue
+	}{TermsQueryAlias: (*TermsQueryAlias)(q)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	q.Terms = valuesFromJSON(aux.Terms)
+	return nil
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 	"math"
@@ -31,3 +32,35 @@ func BuildPBForRanges(ranges []Range) ([]*otsprotocol.Range, error) {
 	}
 	return pbRanges, nil
 }
+
+// rangeJSON leaves out infinite bounds, which JSON cannot hold.
+type rangeJSON struct {
+	From *float64
+	To   *float64
+}
+
+func (r Range) MarshalJSON() ([]byte, error) {
+	aux := rangeJSON{}
+	if !math.IsInf(r.from, -1) {
+		aux.From = &r.from
+	}
+	if !math.IsInf(r.to, 1) {
+		aux.To = &r.to
+	}
+	return json.Marshal(aux)
+}
+
+func (r *Range) UnmarshalJSON(data []byte) error {
+	aux := rangeJSON{}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.from, r.to = NegInf, Inf
+	if aux.From != nil {
+		r.from = *aux.From
+	}
+	if aux.To != nil {
+		r.to = *aux.To
+	}
+	return nil
+}
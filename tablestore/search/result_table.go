@@ -0,0 +1,260 @@
+package search
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ResultTable is aggregation and group-by results flattened into a table
+// with one row per bucket path, see FlattenResults.
+type ResultTable struct {
+	Columns []string
+	// Rows hold a value for each column, nil when the row has no value for
+	// it. Values are int64, float64 or string, or the value of a histogram
+	// key or percentile.
+	Rows [][]interface{}
+
+	index map[string]int
+}
+
+// FlattenResults walks the group-bys of a search response down to their
+// innermost buckets and returns one row for each, with a column for the key
+// and the row count of every group-by on the way and a column for each
+// aggregation:
+//
+//   - the key column of a group-by is named after it, range and geo
+//     distance group-bys have a name.from and a name.to column instead, nil
+//     for an open bound, a filter group-by keys its buckets by the index of
+//     their filter and a composite group-by has a name.source column for
+//     each of its sources
+//   - the row count of a bucket is in the name.row_count column
+//   - a sub-aggregation is in the group-by name.aggregation name column, a
+//     top-level aggregation in a column of its own name. Avg, min and max
+//     without a value are nil and percentiles have a name.pN column for
+//     each percent. Top rows aggregations are left out.
+//
+// The values of an outer bucket and of the top-level aggregations repeat in
+// every row below them. Sibling group-bys yield rows of their own, with nil
+// in the columns of the others. Group-bys and aggregations of the same
+// level are walked by name so the columns keep their order across calls.
+func FlattenResults(aggregations AggregationResults, groupBys GroupByResults) *ResultTable {
+	f := &flattener{table: &ResultTable{index: make(map[string]int)}}
+	f.walk("", nil, aggregations, groupBys)
+	for i, row := range f.rows {
+		f.table.Rows = append(f.table.Rows, make([]interface{}, len(f.table.Columns)))
+		for _, c := range row {
+			f.table.Rows[i][f.table.index[c.column]] = c.value
+		}
+	}
+	return f.table
+}
+
+// Column is the index of a column, -1 when there is no such column.
+func (t *ResultTable) Column(name string) int {
+	if i, ok := t.index[name]; ok {
+		return i
+	}
+	return -1
+}
+
+// Value is the value of a column in a row, nil when there is no such
+// column.
+func (t *ResultTable) Value(row int, column string) interface{} {
+	i := t.Column(column)
+	if i < 0 {
+		return nil
+	}
+	return t.Rows[row][i]
+}
+
+// WriteCSV writes the table as CSV with a header line. Nil values are
+// empty and floats have the fewest digits that read back the same value.
+func (t *ResultTable) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(t.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, value := range row {
+			record[i] = csvValue(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+type resultCell struct {
+	column string
+	value  interface{}
+}
+
+// resultBucket is a group-by bucket with its key columns.
+type resultBucket struct {
+	keys            []resultCell
+	rowCount        int64
+	subAggregations AggregationResults
+	subGroupBys     GroupByResults
+}
+
+type flattener struct {
+	table *ResultTable
+	rows  [][]resultCell
+}
+
+func (f *flattener) column(name string) {
+	if _, ok := f.table.index[name]; !ok {
+		f.table.index[name] = len(f.table.Columns)
+		f.table.Columns = append(f.table.Columns, name)
+	}
+}
+
+func (f *flattener) add(cells []resultCell, cell ...resultCell) []resultCell {
+	for _, c := range cell {
+		f.column(c.column)
+	}
+	added := make([]resultCell, 0, len(cells)+len(cell))
+	return append(append(added, cells...), cell...)
+}
+
+func (f *flattener) walk(prefix string, cells []resultCell, aggregations AggregationResults, groupBys GroupByResults) {
+	cells = f.add(cells, aggregationCells(prefix, aggregations)...)
+
+	results := groupBys.GetRawResults()
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	walked := false
+	for _, name := range names {
+		for _, bucket := range resultBuckets(name, results[name]) {
+			bucketCells := f.add(cells, bucket.keys...)
+			bucketCells = f.add(bucketCells, resultCell{name + ".row_count", bucket.rowCount})
+			f.walk(name+".", bucketCells, bucket.subAggregations, bucket.subGroupBys)
+			walked = true
+		}
+	}
+	if !walked && len(cells) > 0 {
+		f.rows = append(f.rows, cells)
+	}
+}
+
+func aggregationCells(prefix string, aggregations AggregationResults) []resultCell {
+	results := aggregations.GetRawResults()
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var cells []resultCell
+	for _, name := range names {
+		column := prefix + name
+		switch result := results[name].(type) {
+		case *AvgAggregationResult:
+			cells = append(cells, resultCell{column, floatOrNil(result.Value, result.HasValue())})
+		case *MaxAggregationResult:
+			cells = append(cells, resultCell{column, floatOrNil(result.Value, result.HasValue())})
+		case *MinAggregationResult:
+			cells = append(cells, resultCell{column, floatOrNil(result.Value, result.HasValue())})
+		case *SumAggregationResult:
+			cells = append(cells, resultCell{column, result.Value})
+		case *CountAggregationResult:
+			cells = append(cells, resultCell{column, result.Value})
+		case *DistinctCountAggregationResult:
+			cells = append(cells, resultCell{column, result.Value})
+		case *PercentilesAggregationResult:
+			for _, item := range result.PercentilesAggregationItems {
+				cells = append(cells, resultCell{column + ".p" + strconv.FormatFloat(item.Key, 'f', -1, 64), item.Value.Value})
+			}
+		}
+	}
+	return cells
+}
+
+func floatOrNil(value float64, ok bool) interface{} {
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func boundOrNil(value float64) interface{} {
+	if math.IsInf(value, 0) {
+		return nil
+	}
+	return value
+}
+
+func resultBuckets(name string, result GroupByResult) []resultBucket {
+	var buckets []resultBucket
+	switch result := result.(type) {
+	case *GroupByFieldResult:
+		for _, item := range result.Items {
+			buckets = append(buckets, resultBucket{[]resultCell{{name, item.Key}}, item.RowCount, item.SubAggregations, item.SubGroupBys})
+		}
+	case *GroupByRangeResult:
+		for _, item := range result.Items {
+			keys := []resultCell{{name + ".from", boundOrNil(item.From)}, {name + ".to", boundOrNil(item.To)}}
+			buckets = append(buckets, resultBucket{keys, item.RowCount, item.SubAggregations, item.SubGroupBys})
+		}
+	case *GroupByGeoDistanceResult:
+		for _, item := range result.Items {
+			keys := []resultCell{{name + ".from", boundOrNil(item.From)}, {name + ".to", boundOrNil(item.To)}}
+			buckets = append(buckets, resultBucket{keys, item.RowCount, item.SubAggregations, item.SubGroupBys})
+		}
+	case *GroupByFilterResult:
+		for i, item := range result.Items {
+			buckets = append(buckets, resultBucket{[]resultCell{{name, int64(i)}}, item.RowCount, item.SubAggregations, item.SubGroupBys})
+		}
+	case *GroupByHistogramResult:
+		for _, item := range result.Items {
+			buckets = append(buckets, resultBucket{[]resultCell{{name, item.Key.Value}}, item.Value, item.SubAggregations, item.SubGroupBys})
+		}
+	case *GroupByDateHistogramResult:
+		for _, item := range result.Items {
+			buckets = append(buckets, resultBucket{[]resultCell{{name, item.Timestamp}}, item.RowCount, item.SubAggregations, item.SubGroupBys})
+		}
+	case *GroupByGeoGridResult:
+		for _, item := range result.Items {
+			buckets = append(buckets, resultBucket{[]resultCell{{name, item.Key}}, item.RowCount, item.SubAggregations, item.SubGroupBys})
+		}
+	case *GroupByCompositeResult:
+		for _, item := range result.Items {
+			keys := make([]resultCell, 0, len(result.SourceGroupByNames))
+			for i, source := range result.SourceGroupByNames {
+				var key interface{}
+				if i < len(item.Keys) && item.Keys[i] != nil {
+					key = *item.Keys[i]
+				}
+				keys = append(keys, resultCell{name + "." + source, key})
+			}
+			buckets = append(buckets, resultBucket{keys, item.RowCount, item.SubAggregations, item.SubGroupBys})
+		}
+	}
+	return buckets
+}
@@ -0,0 +1,94 @@
+package search
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlattenResults(t *testing.T) {
+	var aggs AggregationResults
+	aggs.Put("total", &CountAggregationResult{Name: "total", Value: 10})
+
+	var yearAggs AggregationResults
+	yearAggs.Put("avg", &AvgAggregationResult{Name: "avg", Value: 2.5})
+	var emptyYearAggs AggregationResults
+	emptyYearAggs.Put("avg", &AvgAggregationResult{Name: "avg", Value: math.Inf(1)})
+	var years GroupByResults
+	years.Put("year", &GroupByHistogramResult{Name: "year", Items: []GroupByHistogramItem{
+		{Key: model.ColumnValue{Value: int64(2020)}, Value: 4, SubAggregations: yearAggs},
+		{Key: model.ColumnValue{Value: int64(2021)}, Value: 2, SubAggregations: emptyYearAggs},
+	}})
+
+	var cityAggs AggregationResults
+	cityAggs.Put("p", &PercentilesAggregationResult{Name: "p", PercentilesAggregationItems: []PercentilesAggregationItem{
+		{Key: 50, Value: model.ColumnValue{Value: 1.5}},
+		{Key: 99.9, Value: model.ColumnValue{Value: 3.0}},
+	}})
+	var groupBys GroupByResults
+	groupBys.Put("city", &GroupByFieldResult{Name: "city", Items: []GroupByFieldResultItem{
+		{Key: "beijing", RowCount: 6, SubAggregations: cityAggs, SubGroupBys: years},
+		{Key: "hangzhou", RowCount: 4},
+	}})
+	groupBys.Put("price", &GroupByRangeResult{Name: "price", Items: []GroupByRangeResultItem{
+		{From: math.Inf(-1), To: 10, RowCount: 3},
+		{From: 10, To: math.Inf(1), RowCount: 7},
+	}})
+
+	table := FlattenResults(aggs, groupBys)
+	assert.Equal(t, []string{"total", "city", "city.row_count", "city.p.p50", "city.p.p99.9", "year", "year.row_count", "year.avg",
+		"price.from", "price.to", "price.row_count"}, table.Columns)
+	assert.Equal(t, [][]interface{}{
+		{int64(10), "beijing", int64(6), 1.5, 3.0, int64(2020), int64(4), 2.5, nil, nil, nil},
+		{int64(10), "beijing", int64(6), 1.5, 3.0, int64(2021), int64(2), nil, nil, nil, nil},
+		{int64(10), "hangzhou", int64(4), nil, nil, nil, nil, nil, nil, nil, nil},
+		{int64(10), nil, nil, nil, nil, nil, nil, nil, nil, 10.0, int64(3)},
+		{int64(10), nil, nil, nil, nil, nil, nil, nil, 10.0, nil, int64(7)},
+	}, table.Rows)
+	assert.Equal(t, int64(2021), table.Value(1, "year"))
+	assert.Nil(t, table.Value(1, "nothing"))
+	assert.Equal(t, -1, table.Column("nothing"))
+
+	var buf bytes.Buffer
+	assert.Nil(t, table.WriteCSV(&buf))
+	assert.Equal(t, `total,city,city.row_count,city.p.p50,city.p.p99.9,year,year.row_count,year.avg,price.from,price.to,price.row_count
+10,beijing,6,1.5,3,2020,4,2.5,,,
+10,beijing,6,1.5,3,2021,2,,,,
+10,hangzhou,4,,,,,,,,
+10,,,,,,,,,10,3
+10,,,,,,,,10,,7
+`, buf.String())
+}
+
+func TestFlattenResults_GroupByTypes(t *testing.T) {
+	var groupBys GroupByResults
+	groupBys.Put("f", &GroupByFilterResult{Items: []GroupByFilterResultItem{{RowCount: 1}, {RowCount: 2}}})
+	groupBys.Put("d", &GroupByDateHistogramResult{Items: []GroupByDateHistogramItem{{Timestamp: 1000, RowCount: 3}}})
+	groupBys.Put("g", &GroupByGeoGridResult{Items: []GroupByGeoGridResultItem{{Key: "wx4g", RowCount: 4}}})
+	groupBys.Put("gd", &GroupByGeoDistanceResult{Items: []GroupByGeoDistanceResultItem{{From: 0, To: 100, RowCount: 5}}})
+	a, b := "a", "b"
+	groupBys.Put("c", &GroupByCompositeResult{SourceGroupByNames: []string{"x", "y"}, Items: []GroupByCompositeResultItem{
+		{Keys: []*string{&a, &b}, RowCount: 6},
+		{Keys: []*string{&a, nil}, RowCount: 7},
+	}})
+
+	table := FlattenResults(AggregationResults{}, groupBys)
+	assert.Equal(t, []string{"c.x", "c.y", "c.row_count", "d", "d.row_count", "f", "f.row_count", "g", "g.row_count",
+		"gd.from", "gd.to", "gd.row_count"}, table.Columns)
+	assert.Len(t, table.Rows, 7)
+	assert.Equal(t, []interface{}{"a", "b", int64(6)}, table.Rows[0][:3])
+	assert.Equal(t, []interface{}{"a", nil, int64(7)}, table.Rows[1][:3])
+	assert.Equal(t, int64(1000), table.Value(2, "d"))
+	assert.Equal(t, int64(1), table.Value(4, "f"))
+	assert.Equal(t, int64(2), table.Value(4, "f.row_count"))
+	assert.Equal(t, "wx4g", table.Value(5, "g"))
+	assert.Equal(t, 0.0, table.Value(6, "gd.from"))
+	assert.Equal(t, 100.0, table.Value(6, "gd.to"))
+
+	empty := FlattenResults(AggregationResults{}, GroupByResults{})
+	assert.Len(t, empty.Columns, 0)
+	assert.Len(t, empty.Rows, 0)
+}
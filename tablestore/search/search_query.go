@@ -21,6 +21,11 @@ type aggregationAlias struct {
 	Aggregation Aggregation
 }
 
+type groupByAlias struct {
+	Name    string
+	GroupBy GroupBy
+}
+
 type searchQuery struct {
 	Offset        *int32
 	Limit         *int32
@@ -36,6 +41,7 @@ type searchQuery struct {
 	// for json marshal and unmarshal
 	QueryAlias       queryAlias         `json:"Query"`
 	AggregationAlias []aggregationAlias `json:"Aggregations"`
+	GroupByAlias     []groupByAlias     `json:"GroupBys"`
 }
 
 func (q *searchQuery) MarshalJSON() (data []byte, err error) {
@@ -59,6 +65,7 @@ func (q *searchQuery) MarshalJSON() (data []byte, err error) {
 
 		query.AggregationAlias = aggs
 	}
+	query.GroupByAlias = newGroupByAliases(q.GroupBys)
 
 	data, err = json.Marshal(query)
 	return
@@ -75,6 +82,7 @@ func (q *searchQuery) UnmarshalJSON(data []byte) (err error) {
 	q.Offset = sqAlias.Offset
 	q.Limit = sqAlias.Limit
 	q.Query = sqAlias.QueryAlias.Query
+	q.Highlight = sqAlias.Highlight
 	q.Collapse = sqAlias.Collapse
 	q.Sort = sqAlias.Sort
 	q.GetTotalCount = sqAlias.GetTotalCount
@@ -89,6 +97,7 @@ func (q *searchQuery) UnmarshalJSON(data []byte) (err error) {
 
 		q.Aggregations = aggs
 	}
+	q.GroupBys = groupBysFromAliases(sqAlias.GroupByAlias)
 
 	return
 }
@@ -117,6 +126,10 @@ func (q *queryAlias) UnmarshalJSON(data []byte) (err error) {
 		err = errors.New("Field 'Query' is missing.")
 		return
 	}
+	// an unset query is written with an empty name
+	if name == "" && string(query) == "null" {
+		return
+	}
 
 	q.Name = name
 	q.Query, err = UnmarshalQuery(name, query)
@@ -161,6 +174,36 @@ func (q *aggregationAlias) UnmarshalJSON(data []byte) (err error) {
 	return
 }
 
+func (g *groupByAlias) UnmarshalJSON(data []byte) (err error) {
+	jm := make(map[string]json.RawMessage)
+	err = json.Unmarshal(data, &jm)
+	if err != nil {
+		return
+	}
+
+	nameRM, ok := jm["Name"]
+	if !ok {
+		err = errors.New("Field 'Name' is missing.")
+		return
+	}
+
+	var name string
+	err = json.Unmarshal(nameRM, &name)
+	if err != nil {
+		return
+	}
+
+	groupBy, ok := jm["GroupBy"]
+	if !ok {
+		err = errors.New("Field 'GroupBy' is missing.")
+		return
+	}
+
+	g.Name = name
+	g.GroupBy, err = UnmarshalGroupBy(name, groupBy)
+	return
+}
+
 func NewSearchQuery() *searchQuery {
 	return &searchQuery{
 		GetTotalCount: false,
@@ -332,6 +375,65 @@ func (s *searchQuery) SetToken(token []byte) *searchQuery {
 	return s
 }
 
+// PageQuery copies a query built with NewSearchQuery to read one page of its
+// matches. With a token it reads the page after the one that returned it:
+// the token carries the sort and the position, and only the first page
+// computes aggregations and group-bys, so the copy has neither, nor an
+// offset. A positive maxLimit caps the limit of the page.
+func PageQuery(query SearchQuery, token []byte, maxLimit int32) (SearchQuery, error) {
+	q, ok := query.(*searchQuery)
+	if !ok {
+		return nil, errors.New("search query must be built with NewSearchQuery")
+	}
+	page := *q
+	if len(token) > 0 {
+		page.Token = token
+		page.Sort = nil
+		page.Offset = nil
+		page.Aggregations = nil
+		page.GroupBys = nil
+	}
+	if maxLimit > 0 && page.Limit != nil && *page.Limit > maxLimit {
+		page.Limit = &maxLimit
+	}
+	return &page, nil
+}
+
+// SearchQueryParts are the parts of a query built with NewSearchQuery.
+type SearchQueryParts struct {
+	Offset        *int32
+	Limit         *int32
+	Query         Query
+	Highlight     *Highlight
+	Collapse      *Collapse
+	Sort          *Sort
+	GetTotalCount bool
+	Token         []byte
+	Aggregations  []Aggregation
+	GroupBys      []GroupBy
+}
+
+// QueryParts returns the parts of a query built with NewSearchQuery, for
+// code that runs queries itself rather than sending them to the service.
+func QueryParts(query SearchQuery) (*SearchQueryParts, error) {
+	q, ok := query.(*searchQuery)
+	if !ok {
+		return nil, errors.New("search query must be built with NewSearchQuery")
+	}
+	return &SearchQueryParts{
+		Offset:        q.Offset,
+		Limit:         q.Limit,
+		Query:         q.Query,
+		Highlight:     q.Highlight,
+		Collapse:      q.Collapse,
+		Sort:          q.Sort,
+		GetTotalCount: q.GetTotalCount,
+		Token:         q.Token,
+		Aggregations:  q.Aggregations,
+		GroupBys:      q.GroupBys,
+	}, nil
+}
+
 func (s *searchQuery) Serialize() ([]byte, error) {
 	searchQuery := &otsprotocol.SearchQuery{}
 	if s.Offset != nil {
@@ -0,0 +1,63 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type otherSearchQuery struct{}
+
+func (otherSearchQuery) Serialize() ([]byte, error) { return nil, nil }
+
+func TestPageQuery(t *testing.T) {
+	sort := &Sort{Sorters: []Sorter{&ScoreSort{Order: SortOrder_DESC.Enum()}}}
+	query := NewSearchQuery().
+		SetQuery(&MatchAllQuery{}).
+		SetOffset(5).
+		SetLimit(50).
+		SetSort(sort).
+		Aggregation(NewCountAggregation("count", "a")).
+		GroupBy(NewGroupByField("field", "a"))
+
+	page, err := PageQuery(query, nil, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, query, page)
+	assert.False(t, query == page)
+
+	page, err = PageQuery(query, nil, 20)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(20), *page.(*searchQuery).Limit)
+	assert.Equal(t, int32(50), *query.Limit)
+
+	page, err = PageQuery(query, []byte("token"), 100)
+	assert.Nil(t, err)
+	assert.Equal(t, NewSearchQuery().SetQuery(&MatchAllQuery{}).SetLimit(50).SetToken([]byte("token")), page)
+	// the original query is left alone
+	assert.Equal(t, sort, query.Sort)
+	assert.Len(t, query.Aggregations, 1)
+
+	_, err = PageQuery(otherSearchQuery{}, nil, 0)
+	assert.NotNil(t, err)
+}
+
+func TestQueryParts(t *testing.T) {
+	query := NewSearchQuery().
+		SetQuery(&MatchAllQuery{}).
+		SetOffset(5).
+		SetLimit(50).
+		SetGetTotalCount(true).
+		Aggregation(NewCountAggregation("count", "a"))
+	parts, err := QueryParts(query)
+	assert.Nil(t, err)
+	assert.Equal(t, &SearchQueryParts{
+		Offset:        query.Offset,
+		Limit:         query.Limit,
+		Query:         &MatchAllQuery{},
+		GetTotalCount: true,
+		Aggregations:  query.Aggregations,
+	}, parts)
+
+	_, err = QueryParts(otherSearchQuery{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,302 @@
+package searchtest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+const defaultGroupBySize = 10
+
+func (ix *Index) aggregate(aggs []search.Aggregation, docs []*document) (search.AggregationResults, error) {
+	var results search.AggregationResults
+	for _, agg := range aggs {
+		result, err := ix.aggregation(agg, docs)
+		if err != nil {
+			return results, err
+		}
+		results.Put(agg.GetName(), result)
+	}
+	return results, nil
+}
+
+func (ix *Index) aggregation(agg search.Aggregation, docs []*document) (search.AggregationResult, error) {
+	switch agg := agg.(type) {
+	case *search.AvgAggregation:
+		numbers, err := ix.numbers(agg.Field, agg.MissingValue, docs)
+		if err != nil {
+			return nil, err
+		}
+		result := &search.AvgAggregationResult{Name: agg.AggName, Value: math.Inf(1)}
+		if len(numbers) > 0 {
+			result.Value = sum(numbers) / float64(len(numbers))
+		}
+		return result, nil
+	case *search.MaxAggregation:
+		numbers, err := ix.numbers(agg.Field, agg.MissingValue, docs)
+		if err != nil {
+			return nil, err
+		}
+		result := &search.MaxAggregationResult{Name: agg.AggName, Value: math.Inf(-1)}
+		for _, n := range numbers {
+			result.Value = math.Max(result.Value, n)
+		}
+		return result, nil
+	case *search.MinAggregation:
+		numbers, err := ix.numbers(agg.Field, agg.MissingValue, docs)
+		if err != nil {
+			return nil, err
+		}
+		result := &search.MinAggregationResult{Name: agg.AggName, Value: math.Inf(1)}
+		for _, n := range numbers {
+			result.Value = math.Min(result.Value, n)
+		}
+		return result, nil
+	case *search.SumAggregation:
+		numbers, err := ix.numbers(agg.Field, agg.MissingValue, docs)
+		if err != nil {
+			return nil, err
+		}
+		return &search.SumAggregationResult{Name: agg.AggName, Value: sum(numbers)}, nil
+	case *search.CountAggregation:
+		values, err := ix.aggValues(agg.Field, nil, docs)
+		if err != nil {
+			return nil, err
+		}
+		return &search.CountAggregationResult{Name: agg.AggName, Value: int64(len(values))}, nil
+	case *search.DistinctCountAggregation:
+		values, err := ix.aggValues(agg.Field, agg.MissingValue, docs)
+		if err != nil {
+			return nil, err
+		}
+		distinct := make(map[string]bool)
+		for _, v := range values {
+			distinct[groupKey(v)] = true
+		}
+		return &search.DistinctCountAggregationResult{Name: agg.AggName, Value: int64(len(distinct))}, nil
+	}
+	return nil, unsupportedf("aggregation %s", agg.GetType().String())
+}
+
+// aggValues are the values of a field of docs, missing for the docs
+// without one when it is not nil.
+func (ix *Index) aggValues(name string, missing interface{}, docs []*document) ([]interface{}, error) {
+	if err := ix.checkAggField(name); err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, doc := range docs {
+		v := doc.values[name]
+		if len(v) == 0 && missing != nil {
+			v = []interface{}{missing}
+		}
+		values = append(values, v...)
+	}
+	return values, nil
+}
+
+// checkAggField fails for fields aggregations and group-bys cannot use.
+func (ix *Index) checkAggField(name string) error {
+	field, err := ix.field(name)
+	if err != nil {
+		return err
+	}
+	if field.FieldType == tablestore.FieldType_NESTED || field.FieldType == tablestore.FieldType_TEXT {
+		return invalidQueryf("field %s of type %s cannot be aggregated", name, field.FieldType.String())
+	}
+	for _, top := range ix.schema.FieldSchemas {
+		if *top.FieldName == name {
+			return nil
+		}
+	}
+	return unsupportedf("aggregation of the nested field %s", name)
+}
+
+func (ix *Index) numbers(name string, missing interface{}, docs []*document) ([]float64, error) {
+	values, err := ix.aggValues(name, missing, docs)
+	if err != nil {
+		return nil, err
+	}
+	numbers := make([]float64, 0, len(values))
+	for _, v := range values {
+		n, ok := toFloat(v)
+		if !ok {
+			return nil, invalidQueryf("field %s is not numeric", name)
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, nil
+}
+
+func sum(numbers []float64) float64 {
+	var s float64
+	for _, n := range numbers {
+		s += n
+	}
+	return s
+}
+
+// groupKey is the key of a value in a group-by result, as the service
+// writes it.
+func groupKey(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+func (ix *Index) groupBy(groupBys []search.GroupBy, docs []*document) (search.GroupByResults, error) {
+	var results search.GroupByResults
+	for _, groupBy := range groupBys {
+		field, ok := groupBy.(*search.GroupByField)
+		if !ok {
+			return results, unsupportedf("group by %s", groupBy.GetType().String())
+		}
+		result, err := ix.groupByField(field, docs)
+		if err != nil {
+			return results, err
+		}
+		results.Put(field.AggName, result)
+	}
+	return results, nil
+}
+
+type group struct {
+	value interface{}
+	docs  []*document
+	item  search.GroupByFieldResultItem
+}
+
+func (ix *Index) groupByField(g *search.GroupByField, docs []*document) (*search.GroupByFieldResult, error) {
+	if err := ix.checkAggField(g.Field); err != nil {
+		return nil, err
+	}
+	var groups []*group
+	byKey := make(map[string]*group)
+	for _, doc := range docs {
+		seen := make(map[string]bool)
+		for _, v := range doc.values[g.Field] {
+			key := groupKey(v)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			gr, ok := byKey[key]
+			if !ok {
+				gr = &group{value: v, item: search.GroupByFieldResultItem{Key: key}}
+				byKey[key] = gr
+				groups = append(groups, gr)
+			}
+			gr.docs = append(gr.docs, doc)
+		}
+	}
+	for _, gr := range groups {
+		gr.item.RowCount = int64(len(gr.docs))
+		var err error
+		if gr.item.SubAggregations, err = ix.aggregate(g.SubAggList, gr.docs); err != nil {
+			return nil, err
+		}
+		if gr.item.SubGroupBys, err = ix.groupBy(g.SubGroupByList, gr.docs); err != nil {
+			return nil, err
+		}
+	}
+
+	less, err := groupLess(g.Sorters)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return less(groups[i], groups[j]) })
+	size := defaultGroupBySize
+	if g.Sz != nil {
+		size = int(*g.Sz)
+	}
+	if len(groups) > size {
+		groups = groups[:size]
+	}
+	result := &search.GroupByFieldResult{Name: g.AggName}
+	for _, gr := range groups {
+		result.Items = append(result.Items, gr.item)
+	}
+	return result, nil
+}
+
+// groupLess orders groups by the sorters, by row count descending when
+// there are none, and then by key.
+func groupLess(sorters []search.GroupBySorter) (func(a, b *group) bool, error) {
+	if len(sorters) == 0 {
+		sorters = []search.GroupBySorter{&search.RowCountGroupBySort{Order: search.SortOrder_DESC.Enum()}}
+	}
+	var compare []func(a, b *group) int
+	for _, sorter := range sorters {
+		switch s := sorter.(type) {
+		case *search.GroupKeyGroupBySort:
+			desc := isDesc(s.Order, false)
+			compare = append(compare, func(a, b *group) int {
+				c, _ := compareValues(a.value, b.value)
+				return direction(c, desc)
+			})
+		case *search.RowCountGroupBySort:
+			desc := isDesc(s.Order, true)
+			compare = append(compare, func(a, b *group) int {
+				return direction(compareInt(a.item.RowCount, b.item.RowCount), desc)
+			})
+		case *search.SubAggGroupBySort:
+			desc, name := isDesc(s.Order, false), s.SubAggName
+			compare = append(compare, func(a, b *group) int {
+				return direction(compareFloat(subAggValue(a, name), subAggValue(b, name)), desc)
+			})
+		default:
+			return nil, unsupportedf("group by sorter %T", sorter)
+		}
+	}
+	compare = append(compare, func(a, b *group) int {
+		c, _ := compareValues(a.value, b.value)
+		return c
+	})
+	return func(a, b *group) bool {
+		for _, c := range compare {
+			if r := c(a, b); r != 0 {
+				return r < 0
+			}
+		}
+		return false
+	}, nil
+}
+
+func direction(c int, desc bool) int {
+	if desc {
+		return -c
+	}
+	return c
+}
+
+// subAggValue is the value of a numeric sub-aggregation of a group, NaN
+// when there is none.
+func subAggValue(g *group, name string) float64 {
+	raw := g.item.SubAggregations.GetRawResults()
+	switch result := raw[name].(type) {
+	case *search.AvgAggregationResult:
+		return result.Value
+	case *search.MaxAggregationResult:
+		return result.Value
+	case *search.MinAggregationResult:
+		return result.Value
+	case *search.SumAggregationResult:
+		return result.Value
+	case *search.CountAggregationResult:
+		return float64(result.Value)
+	case *search.DistinctCountAggregationResult:
+		return float64(result.Value)
+	}
+	return math.NaN()
+}
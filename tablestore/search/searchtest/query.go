@@ -0,0 +1,470 @@
+package searchtest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+// match reports whether doc matches q and its score.
+func (ix *Index) match(q search.Query, doc *document) (bool, float64, error) {
+	switch q := q.(type) {
+	case *search.MatchAllQuery:
+		return true, 1, nil
+	case *search.TermQuery:
+		field, err := ix.field(q.FieldName)
+		if err != nil {
+			return false, 0, err
+		}
+		return ix.any(field, q.FieldName, doc, func(v interface{}) bool { return equalValue(v, q.Term) }), 1, nil
+	case *search.TermsQuery:
+		field, err := ix.field(q.FieldName)
+		if err != nil {
+			return false, 0, err
+		}
+		ok := ix.any(field, q.FieldName, doc, func(v interface{}) bool {
+			for _, term := range q.Terms {
+				if equalValue(v, term) {
+					return true
+				}
+			}
+			return false
+		})
+		return ok, 1, nil
+	case *search.RangeQuery:
+		if _, err := ix.field(q.FieldName); err != nil {
+			return false, 0, err
+		}
+		ok := anyValue(doc.values[q.FieldName], func(v interface{}) bool {
+			if q.From != nil {
+				c, ok := compareValues(v, q.From)
+				if !ok || c < 0 || (c == 0 && !q.IncludeLower) {
+					return false
+				}
+			}
+			if q.To != nil {
+				c, ok := compareValues(v, q.To)
+				if !ok || c > 0 || (c == 0 && !q.IncludeUpper) {
+					return false
+				}
+			}
+			return true
+		})
+		return ok, 1, nil
+	case *search.PrefixQuery:
+		field, err := ix.field(q.FieldName)
+		if err != nil {
+			return false, 0, err
+		}
+		ok := ix.any(field, q.FieldName, doc, func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && strings.HasPrefix(s, q.Prefix)
+		})
+		return ok, 1, nil
+	case *search.WildcardQuery:
+		field, err := ix.field(q.FieldName)
+		if err != nil {
+			return false, 0, err
+		}
+		ok := ix.any(field, q.FieldName, doc, func(v interface{}) bool {
+			s, ok := v.(string)
+			return ok && wildcardMatch(q.Value, s)
+		})
+		return ok, 1, nil
+	case *search.MatchQuery:
+		return ix.matchText(q, doc)
+	case *search.MatchPhraseQuery:
+		field, err := ix.field(q.FieldName)
+		if err != nil {
+			return false, 0, err
+		}
+		if field.FieldType != tablestore.FieldType_TEXT {
+			return ix.match(&search.TermQuery{FieldName: q.FieldName, Term: q.Text}, doc)
+		}
+		phrase := analyze(q.Text)
+		tokens := doc.tokens[q.FieldName]
+		if len(phrase) == 0 {
+			return false, 0, nil
+		}
+		var score float64
+		for i := 0; i+len(phrase) <= len(tokens); i++ {
+			if equalStrings(tokens[i:i+len(phrase)], phrase) {
+				score++
+			}
+		}
+		return score > 0, score, nil
+	case *search.ExistsQuery:
+		field, err := ix.field(q.FieldName)
+		if err != nil {
+			return false, 0, err
+		}
+		if field.FieldType == tablestore.FieldType_NESTED {
+			return len(doc.nested[q.FieldName]) > 0, 1, nil
+		}
+		return len(doc.values[q.FieldName]) > 0, 1, nil
+	case *search.BoolQuery:
+		return ix.matchBool(q, doc)
+	case *search.ConstScoreQuery:
+		ok, _, err := ix.match(q.Filter, doc)
+		return ok, 1, err
+	case *search.NestedQuery:
+		return ix.matchNested(q, doc)
+	case *search.GeoDistanceQuery:
+		if _, err := ix.field(q.FieldName); err != nil {
+			return false, 0, err
+		}
+		center, err := parseGeoPoint(q.CenterPoint)
+		if err != nil {
+			return false, 0, err
+		}
+		ok := anyValue(doc.values[q.FieldName], func(v interface{}) bool {
+			point, ok := v.(geoPoint)
+			return ok && point.distance(center) <= q.DistanceInMeter
+		})
+		return ok, 1, nil
+	case *search.GeoBoundingBoxQuery:
+		if _, err := ix.field(q.FieldName); err != nil {
+			return false, 0, err
+		}
+		topLeft, err := parseGeoPoint(q.TopLeft)
+		if err != nil {
+			return false, 0, err
+		}
+		bottomRight, err := parseGeoPoint(q.BottomRight)
+		if err != nil {
+			return false, 0, err
+		}
+		ok := anyValue(doc.values[q.FieldName], func(v interface{}) bool {
+			point, ok := v.(geoPoint)
+			if !ok || point.lat > topLeft.lat || point.lat < bottomRight.lat {
+				return false
+			}
+			if topLeft.lon <= bottomRight.lon {
+				return point.lon >= topLeft.lon && point.lon <= bottomRight.lon
+			}
+			// the box crosses the antimeridian
+			return point.lon >= topLeft.lon || point.lon <= bottomRight.lon
+		})
+		return ok, 1, nil
+	case nil:
+		return false, 0, invalidQueryf("nil query")
+	}
+	return false, 0, unsupportedf("%s", q.Type().String())
+}
+
+// field returns the schema of a field of a query.
+func (ix *Index) field(name string) (*tablestore.FieldSchema, error) {
+	field, ok := ix.fields[name]
+	if !ok {
+		return nil, invalidQueryf("field %s is not in the index schema", name)
+	}
+	return field, nil
+}
+
+// any reports whether a value of a field matches, the tokens of a TEXT
+// field.
+func (ix *Index) any(field *tablestore.FieldSchema, name string, doc *document, fn func(v interface{}) bool) bool {
+	if field.FieldType == tablestore.FieldType_TEXT {
+		for _, token := range doc.tokens[name] {
+			if fn(token) {
+				return true
+			}
+		}
+		return false
+	}
+	return anyValue(doc.values[name], fn)
+}
+
+func anyValue(values []interface{}, fn func(v interface{}) bool) bool {
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ix *Index) matchText(q *search.MatchQuery, doc *document) (bool, float64, error) {
+	field, err := ix.field(q.FieldName)
+	if err != nil {
+		return false, 0, err
+	}
+	if field.FieldType != tablestore.FieldType_TEXT {
+		return ix.match(&search.TermQuery{FieldName: q.FieldName, Term: q.Text}, doc)
+	}
+	terms := analyze(q.Text)
+	if len(terms) == 0 {
+		return false, 0, nil
+	}
+	counts := make(map[string]int)
+	for _, token := range doc.tokens[q.FieldName] {
+		counts[token]++
+	}
+	matched, score := 0, 0.0
+	for _, term := range terms {
+		if n := counts[term]; n > 0 {
+			matched++
+			score += float64(n)
+		}
+	}
+	minimum := 1
+	if q.Operator != nil && *q.Operator == search.QueryOperator_AND {
+		minimum = len(terms)
+	} else if q.MinimumShouldMatch != nil {
+		minimum = int(*q.MinimumShouldMatch)
+	}
+	return matched > 0 && matched >= minimum, score, nil
+}
+
+func (ix *Index) matchBool(q *search.BoolQuery, doc *document) (bool, float64, error) {
+	var score float64
+	for _, must := range q.MustQueries {
+		ok, s, err := ix.match(must, doc)
+		if err != nil || !ok {
+			return false, 0, err
+		}
+		score += s
+	}
+	for _, filter := range q.FilterQueries {
+		ok, _, err := ix.match(filter, doc)
+		if err != nil || !ok {
+			return false, 0, err
+		}
+	}
+	for _, mustNot := range q.MustNotQueries {
+		ok, _, err := ix.match(mustNot, doc)
+		if err != nil || ok {
+			return false, 0, err
+		}
+	}
+	matched := 0
+	for _, should := range q.ShouldQueries {
+		ok, s, err := ix.match(should, doc)
+		if err != nil {
+			return false, 0, err
+		}
+		if ok {
+			matched++
+			score += s
+		}
+	}
+	minimum := 0
+	if q.MinimumShouldMatch != nil {
+		minimum = int(*q.MinimumShouldMatch)
+	} else if len(q.ShouldQueries) > 0 && len(q.MustQueries) == 0 && len(q.FilterQueries) == 0 {
+		minimum = 1
+	}
+	if matched < minimum {
+		return false, 0, nil
+	}
+	if score == 0 {
+		score = 1
+	}
+	return true, score, nil
+}
+
+func (ix *Index) matchNested(q *search.NestedQuery, doc *document) (bool, float64, error) {
+	field, err := ix.field(q.Path)
+	if err != nil {
+		return false, 0, err
+	}
+	if field.FieldType != tablestore.FieldType_NESTED {
+		return false, 0, invalidQueryf("field %s is not NESTED", q.Path)
+	}
+	var scores []float64
+	for _, child := range doc.nested[q.Path] {
+		ok, score, err := ix.match(q.Query, child)
+		if err != nil {
+			return false, 0, err
+		}
+		if ok {
+			scores = append(scores, score)
+		}
+	}
+	if len(scores) == 0 {
+		return false, 0, nil
+	}
+	var score float64
+	switch q.ScoreMode {
+	case search.ScoreMode_None:
+		score = 0
+	case search.ScoreMode_Max:
+		score = math.Inf(-1)
+		for _, s := range scores {
+			score = math.Max(score, s)
+		}
+	case search.ScoreMode_Min:
+		score = math.Inf(1)
+		for _, s := range scores {
+			score = math.Min(score, s)
+		}
+	default:
+		for _, s := range scores {
+			score += s
+		}
+		if q.ScoreMode != search.ScoreMode_Total {
+			score /= float64(len(scores))
+		}
+	}
+	return true, score, nil
+}
+
+// equalValue compares a field value with a term, numbers by value.
+func equalValue(v, term interface{}) bool {
+	c, ok := compareValues(v, term)
+	return ok && c == 0
+}
+
+// compareValues orders two values of the same kind, numbers of any type by
+// value. ok is false for values of different kinds.
+func compareValues(a, b interface{}) (int, bool) {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			if ia, ok := a.(int64); ok {
+				if ib, ok := toInt(b); ok {
+					return compareInt(ia, ib), true
+				}
+			}
+			return compareFloat(fa, fb), true
+		}
+		return 0, false
+	}
+	switch a := a.(type) {
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	case bool:
+		b, ok := b.(bool)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a == b:
+			return 0, true
+		case b:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// wildcardMatch matches s against a pattern where * is any sequence of
+// characters and ? any single character.
+func wildcardMatch(pattern, s string) bool {
+	p, r := []rune(pattern), []rune(s)
+	// star is the position after the last * and mark where it matched
+	star, mark := -1, 0
+	i, j := 0, 0
+	for j < len(r) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == r[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, mark = i+1, j
+			i++
+		case star >= 0:
+			mark++
+			i, j = star, mark
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+type geoPoint struct {
+	lat, lon float64
+}
+
+func parseGeoPoint(s string) (geoPoint, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) == 2 {
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 == nil && err2 == nil && math.Abs(lat) <= 90 && math.Abs(lon) <= 180 {
+			return geoPoint{lat: lat, lon: lon}, nil
+		}
+	}
+	return geoPoint{}, fmt.Errorf("%w: geo point %q is not lat,lon", ErrInvalidValue, s)
+}
+
+// distance is the great circle distance in meters.
+func (p geoPoint) distance(o geoPoint) float64 {
+	const earthRadius = 6371008.8
+	lat1, lat2 := p.lat*math.Pi/180, o.lat*math.Pi/180
+	dLat, dLon := lat2-lat1, (o.lon-p.lon)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,497 @@
+// Package searchtest runs search queries against rows held in memory, so
+// code built on Search can be tested without an instance:
+//
+//	index, _ := searchtest.NewIndex(schema)
+//	index.PutRow(rows...)
+//	resp, err := index.Search(&tablestore.SearchRequest{SearchQuery: query})
+//
+// Index implements the Search method of *tablestore.TableStoreClient. It
+// evaluates term, terms, range, prefix, wildcard, match, match phrase, bool,
+// exists, nested, geo distance, geo bounding box, match all and const score
+// queries, sorts by field, primary key, score and geo distance, pages with
+// offset, limit and token, and computes the avg, max, min, sum, count and
+// distinct count aggregations and field group-bys. Anything else makes
+// Search fail rather than answer differently from the service.
+//
+// Text is analyzed by lower casing it and splitting it at every character
+// that is neither a letter nor a digit, whatever the analyzer of the field.
+// Scores only order matches roughly like the service does: a match scores
+// the occurrences of its tokens, a bool query sums its clauses and the other
+// queries score 1.
+package searchtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+var (
+	ErrUnsupported  = errors.New("[searchtest] unsupported")
+	ErrInvalidValue = errors.New("[searchtest] invalid value")
+	ErrInvalidQuery = errors.New("[searchtest] invalid query")
+)
+
+const defaultLimit = 10
+
+func unsupportedf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrUnsupported, fmt.Sprintf(format, args...))
+}
+
+func invalidQueryf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidQuery, fmt.Sprintf(format, args...))
+}
+
+// Index is a search index over rows held in memory. It is safe for
+// concurrent use.
+type Index struct {
+	schema *tablestore.IndexSchema
+	// fields are the field schemas by path, items.sku for the field sku of
+	// the nested field items
+	fields map[string]*tablestore.FieldSchema
+
+	mu sync.RWMutex
+	// docs are replaced rather than changed in place, a search goes on
+	// with the rows it started with
+	docs []*document
+	keys map[string]int
+	// tokens are the positions and sorts of the next pages by token
+	tokens map[string]*pageToken
+}
+
+type pageToken struct {
+	offset int
+	sort   *search.Sort
+}
+
+// NewIndex creates an empty index with the given schema.
+func NewIndex(schema *tablestore.IndexSchema) (*Index, error) {
+	if schema == nil || len(schema.FieldSchemas) == 0 {
+		return nil, fmt.Errorf("%w: index schema has no fields", ErrInvalidValue)
+	}
+	ix := &Index{schema: schema, fields: make(map[string]*tablestore.FieldSchema), keys: make(map[string]int), tokens: make(map[string]*pageToken)}
+	var add func(prefix string, fields []*tablestore.FieldSchema) error
+	add = func(prefix string, fields []*tablestore.FieldSchema) error {
+		for _, field := range fields {
+			if field.FieldName == nil {
+				return fmt.Errorf("%w: field without a name", ErrInvalidValue)
+			}
+			path := prefix + *field.FieldName
+			ix.fields[path] = field
+			if err := add(path+".", field.FieldSchemas); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := add("", schema.FieldSchemas); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// PutRow adds rows to the index, replacing the rows with the same primary
+// key. A column that does not fit the type of its field fails the call and
+// leaves the index unchanged.
+func (ix *Index) PutRow(rows ...*tablestore.Row) error {
+	docs := make([]*document, 0, len(rows))
+	for _, row := range rows {
+		doc, err := ix.newDocument(row)
+		if err != nil {
+			return err
+		}
+		docs = append(docs, doc)
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	next := make([]*document, len(ix.docs), len(ix.docs)+len(docs))
+	copy(next, ix.docs)
+	for _, doc := range docs {
+		key := primaryKeyString(doc.row.PrimaryKey)
+		if i, ok := ix.keys[key]; ok {
+			next[i] = doc
+			continue
+		}
+		ix.keys[key] = len(next)
+		next = append(next, doc)
+	}
+	ix.docs = next
+	return nil
+}
+
+// DeleteRow removes the row with the primary key, if there is one.
+func (ix *Index) DeleteRow(pk *tablestore.PrimaryKey) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	key := primaryKeyString(pk)
+	i, ok := ix.keys[key]
+	if !ok {
+		return
+	}
+	next := make([]*document, 0, len(ix.docs)-1)
+	next = append(next, ix.docs[:i]...)
+	ix.docs = append(next, ix.docs[i+1:]...)
+	delete(ix.keys, key)
+	for j := i; j < len(ix.docs); j++ {
+		ix.keys[primaryKeyString(ix.docs[j].row.PrimaryKey)] = j
+	}
+}
+
+// Search runs the search query of the request. The table and index names
+// are ignored.
+func (ix *Index) Search(request *tablestore.SearchRequest) (*tablestore.SearchResponse, error) {
+	query, err := searchQueryOf(request.SearchQuery)
+	if err != nil {
+		return nil, err
+	}
+	if query.Collapse != nil {
+		return nil, unsupportedf("collapse")
+	}
+	if query.Highlight != nil {
+		return nil, unsupportedf("highlight")
+	}
+
+	ix.mu.RLock()
+	docs := ix.docs
+	ix.mu.RUnlock()
+
+	var q search.Query = &search.MatchAllQuery{}
+	if query.Query != nil {
+		q = query.Query
+	}
+	var hits []*hit
+	for _, doc := range docs {
+		ok, score, err := ix.match(q, doc)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			hits = append(hits, &hit{doc: doc, score: score})
+		}
+	}
+	offset, limit, sort, err := ix.page(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := ix.sort(hits, sort); err != nil {
+		return nil, err
+	}
+
+	resp := &tablestore.SearchResponse{TotalCount: -1, IsAllSuccess: true}
+	if query.GetTotalCount {
+		resp.TotalCount = int64(len(hits))
+	}
+	matched := make([]*document, 0, len(hits))
+	for _, h := range hits {
+		matched = append(matched, h.doc)
+	}
+	if resp.AggregationResults, err = ix.aggregate(query.Aggregations, matched); err != nil {
+		return nil, err
+	}
+	if resp.GroupByResults, err = ix.groupBy(query.GroupBys, matched); err != nil {
+		return nil, err
+	}
+
+	if offset > len(hits) {
+		offset = len(hits)
+	}
+	end := offset + limit
+	if end < len(hits) {
+		resp.NextToken = ix.newToken(end, sort)
+	} else {
+		end = len(hits)
+	}
+	for _, h := range hits[offset:end] {
+		row := ix.columns(h.doc.row, request.ColumnsToGet)
+		score := h.score
+		resp.Rows = append(resp.Rows, row)
+		resp.SearchHits = append(resp.SearchHits, &tablestore.SearchHit{Row: row, Score: &score})
+	}
+	return resp, nil
+}
+
+func searchQueryOf(query search.SearchQuery) (*search.SearchQueryParts, error) {
+	parts, err := search.QueryParts(query)
+	if err != nil {
+		return nil, invalidQueryf("%s", err)
+	}
+	return parts, nil
+}
+
+// page returns the offset, limit and sort of a query, which come from the
+// token when it has one.
+func (ix *Index) page(query *search.SearchQueryParts) (int, int, *search.Sort, error) {
+	offset, limit, sort := 0, defaultLimit, query.Sort
+	if query.Offset != nil {
+		offset = int(*query.Offset)
+	}
+	if query.Limit != nil {
+		limit = int(*query.Limit)
+	}
+	if len(query.Token) > 0 {
+		ix.mu.RLock()
+		token, ok := ix.tokens[string(query.Token)]
+		ix.mu.RUnlock()
+		if !ok {
+			return 0, 0, nil, invalidQueryf("invalid token %q", query.Token)
+		}
+		if query.Sort != nil {
+			return 0, 0, nil, invalidQueryf("sort is not allowed with a token")
+		}
+		offset, sort = token.offset, token.sort
+	}
+	if offset < 0 || limit < 0 {
+		return 0, 0, nil, invalidQueryf("negative offset or limit")
+	}
+	return offset, limit, sort, nil
+}
+
+func (ix *Index) newToken(offset int, sort *search.Sort) []byte {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	token := strconv.Itoa(len(ix.tokens)) + ":" + strconv.Itoa(offset)
+	ix.tokens[token] = &pageToken{offset: offset, sort: sort}
+	return []byte(token)
+}
+
+// columns copies the columns ColumnsToGet asks for, only the primary key
+// when it is nil.
+func (ix *Index) columns(row *tablestore.Row, columnsToGet *tablestore.ColumnsToGet) *tablestore.Row {
+	result := &tablestore.Row{PrimaryKey: row.PrimaryKey}
+	if columnsToGet == nil {
+		return result
+	}
+	want := func(name string) bool {
+		switch {
+		case columnsToGet.ReturnAll:
+			return true
+		case columnsToGet.ReturnAllFromIndex:
+			field, ok := ix.fields[name]
+			return ok && (field.IsVirtualField == nil || !*field.IsVirtualField)
+		}
+		for _, column := range columnsToGet.Columns {
+			if column == name {
+				return true
+			}
+		}
+		return false
+	}
+	seen := make(map[string]bool)
+	for _, column := range row.Columns {
+		if !seen[column.ColumnName] && want(column.ColumnName) {
+			result.Columns = append(result.Columns, column)
+		}
+		seen[column.ColumnName] = true
+	}
+	return result
+}
+
+type hit struct {
+	doc   *document
+	score float64
+}
+
+// document is a row as the index sees it. Nested fields are documents of
+// their own.
+type document struct {
+	row *tablestore.Row
+	// values are the values of the fields by path, string, int64, float64,
+	// bool or geoPoint
+	values map[string][]interface{}
+	// tokens are the analyzed values of TEXT fields
+	tokens map[string][]string
+	nested map[string][]*document
+}
+
+func newDocumentOf(row *tablestore.Row) *document {
+	return &document{
+		row:    row,
+		values: make(map[string][]interface{}),
+		tokens: make(map[string][]string),
+		nested: make(map[string][]*document),
+	}
+}
+
+func (ix *Index) newDocument(row *tablestore.Row) (*document, error) {
+	if row == nil || row.PrimaryKey == nil {
+		return nil, fmt.Errorf("%w: row without a primary key", ErrInvalidValue)
+	}
+	doc := newDocumentOf(row)
+	for _, field := range ix.schema.FieldSchemas {
+		column := *field.FieldName
+		if field.IsVirtualField != nil && *field.IsVirtualField && len(field.SourceFieldNames) > 0 {
+			column = field.SourceFieldNames[0]
+		}
+		value, ok := columnValue(row, column)
+		if !ok {
+			continue
+		}
+		if err := ix.addValue(doc, *field.FieldName, field, value); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func columnValue(row *tablestore.Row, name string) (interface{}, bool) {
+	for _, pk := range row.PrimaryKey.PrimaryKeys {
+		if pk.ColumnName == name {
+			return pk.Value, true
+		}
+	}
+	for _, column := range row.Columns {
+		if column.ColumnName == name {
+			return column.Value, true
+		}
+	}
+	return nil, false
+}
+
+// addValue adds the value of a field to doc. Nested fields and arrays are
+// JSON strings in the row and already decoded below them.
+func (ix *Index) addValue(doc *document, path string, field *tablestore.FieldSchema, value interface{}) error {
+	invalid := func() error {
+		return fmt.Errorf("%w: field %s is %s, got %T %v", ErrInvalidValue, path, field.FieldType.String(), value, value)
+	}
+	if field.FieldType == tablestore.FieldType_NESTED {
+		objects, err := decodeJSON(value)
+		if err != nil {
+			return invalid()
+		}
+		list, ok := objects.([]interface{})
+		if !ok {
+			list = []interface{}{objects}
+		}
+		for _, object := range list {
+			m, ok := object.(map[string]interface{})
+			if !ok {
+				return invalid()
+			}
+			child := newDocumentOf(doc.row)
+			for _, sub := range field.FieldSchemas {
+				if v, ok := m[*sub.FieldName]; ok && v != nil {
+					if err := ix.addValue(child, path+"."+*sub.FieldName, sub, v); err != nil {
+						return err
+					}
+				}
+			}
+			doc.nested[path] = append(doc.nested[path], child)
+		}
+		return nil
+	}
+	if field.IsArray != nil && *field.IsArray {
+		if _, ok := value.([]interface{}); !ok {
+			decoded, err := decodeJSON(value)
+			if err != nil {
+				return invalid()
+			}
+			value = decoded
+		}
+		list, ok := value.([]interface{})
+		if !ok {
+			return invalid()
+		}
+		for _, v := range list {
+			if err := ix.addScalar(doc, path, field, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ix.addScalar(doc, path, field, value)
+}
+
+func (ix *Index) addScalar(doc *document, path string, field *tablestore.FieldSchema, value interface{}) error {
+	v, ok := convertValue(field.FieldType, value)
+	if !ok {
+		return fmt.Errorf("%w: field %s is %s, got %T %v", ErrInvalidValue, path, field.FieldType.String(), value, value)
+	}
+	doc.values[path] = append(doc.values[path], v)
+	if field.FieldType == tablestore.FieldType_TEXT {
+		doc.tokens[path] = append(doc.tokens[path], analyze(v.(string))...)
+	}
+	return nil
+}
+
+func decodeJSON(value interface{}) (interface{}, error) {
+	var data []byte
+	switch v := value.(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return value, nil
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// convertValue converts a column or JSON value to the value of a field of
+// the type.
+func convertValue(fieldType tablestore.FieldType, value interface{}) (interface{}, bool) {
+	switch fieldType {
+	case tablestore.FieldType_KEYWORD, tablestore.FieldType_TEXT, tablestore.FieldType_VECTOR:
+		v, ok := value.(string)
+		return v, ok
+	case tablestore.FieldType_LONG:
+		switch v := value.(type) {
+		case int64:
+			return v, true
+		case float64:
+			return int64(v), float64(int64(v)) == v
+		}
+	case tablestore.FieldType_DOUBLE:
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case int64:
+			return float64(v), true
+		}
+	case tablestore.FieldType_BOOLEAN:
+		v, ok := value.(bool)
+		return v, ok
+	case tablestore.FieldType_DATE:
+		switch v := value.(type) {
+		case int64, string:
+			return v, true
+		case float64:
+			return int64(v), float64(int64(v)) == v
+		}
+	case tablestore.FieldType_GEO_POINT:
+		if s, ok := value.(string); ok {
+			point, err := parseGeoPoint(s)
+			return point, err == nil
+		}
+	}
+	return nil, false
+}
+
+// analyze lower cases text and splits it at everything that is neither a
+// letter nor a digit.
+func analyze(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func primaryKeyString(pk *tablestore.PrimaryKey) string {
+	if pk == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, column := range pk.PrimaryKeys {
+		fmt.Fprintf(&b, "%s=%T:%v;", column.ColumnName, column.Value, column.Value)
+	}
+	return b.String()
+}
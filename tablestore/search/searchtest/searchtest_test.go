@@ -0,0 +1,290 @@
+package searchtest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ interface {
+	Search(*tablestore.SearchRequest) (*tablestore.SearchResponse, error)
+} = (*tablestore.TableStoreClient)(nil)
+
+var _ interface {
+	Search(*tablestore.SearchRequest) (*tablestore.SearchResponse, error)
+} = (*Index)(nil)
+
+func field(name string, fieldType tablestore.FieldType) *tablestore.FieldSchema {
+	return &tablestore.FieldSchema{FieldName: proto.String(name), FieldType: fieldType}
+}
+
+func testIndex(t *testing.T) *Index {
+	items := field("items", tablestore.FieldType_NESTED)
+	items.FieldSchemas = []*tablestore.FieldSchema{
+		field("sku", tablestore.FieldType_KEYWORD),
+		field("qty", tablestore.FieldType_LONG),
+	}
+	tags := field("tags", tablestore.FieldType_KEYWORD)
+	tags.IsArray = proto.Bool(true)
+	ix, err := NewIndex(&tablestore.IndexSchema{FieldSchemas: []*tablestore.FieldSchema{
+		field("city", tablestore.FieldType_KEYWORD),
+		field("price", tablestore.FieldType_DOUBLE),
+		field("stock", tablestore.FieldType_LONG),
+		field("title", tablestore.FieldType_TEXT),
+		field("location", tablestore.FieldType_GEO_POINT),
+		tags,
+		items,
+	}})
+	assert.Nil(t, err)
+
+	assert.Nil(t, ix.PutRow(
+		testRow("a", map[string]interface{}{"city": "hangzhou", "price": 10.5, "stock": int64(3), "title": "Red apple pie",
+			"location": "30.25,120.16", "tags": `["fruit","sweet"]`, "items": `[{"sku":"x1","qty":2},{"sku":"x2","qty":7}]`}),
+		testRow("b", map[string]interface{}{"city": "beijing", "price": 20.0, "stock": int64(0), "title": "green apple",
+			"location": "39.90,116.40", "tags": `["fruit"]`, "items": `[{"sku":"x1","qty":9}]`}),
+		testRow("c", map[string]interface{}{"city": "hangzhou", "price": 5.0, "title": "Banana bread",
+			"location": "30.27,120.15", "tags": `["bakery"]`}),
+		testRow("d", map[string]interface{}{"city": "shanghai", "price": 30.0, "stock": int64(12), "title": "apple apple juice",
+			"location": "31.23,121.47"}),
+	))
+	return ix
+}
+
+func testRow(id string, columns map[string]interface{}) *tablestore.Row {
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn("id", id)
+	row := &tablestore.Row{PrimaryKey: pk}
+	for _, name := range []string{"city", "price", "stock", "title", "location", "tags", "items"} {
+		if v, ok := columns[name]; ok {
+			row.Columns = append(row.Columns, &tablestore.AttributeColumn{ColumnName: name, Value: v})
+		}
+	}
+	return row
+}
+
+func doSearch(t *testing.T, ix *Index, query search.SearchQuery) *tablestore.SearchResponse {
+	resp, err := ix.Search(&tablestore.SearchRequest{SearchQuery: query})
+	assert.Nil(t, err)
+	return resp
+}
+
+func ids(resp *tablestore.SearchResponse) []string {
+	var result []string
+	for _, row := range resp.Rows {
+		result = append(result, row.PrimaryKey.PrimaryKeys[0].Value.(string))
+	}
+	return result
+}
+
+func TestQueries(t *testing.T) {
+	ix := testIndex(t)
+	priceRange := &search.RangeQuery{FieldName: "price"}
+	priceRange.GTE(10.0)
+	priceRange.LT(30.0)
+	cases := []struct {
+		name  string
+		query search.Query
+		want  []string
+	}{
+		{"match all", &search.MatchAllQuery{}, []string{"a", "b", "c", "d"}},
+		{"term", &search.TermQuery{FieldName: "city", Term: "hangzhou"}, []string{"a", "c"}},
+		{"terms", &search.TermsQuery{FieldName: "city", Terms: []interface{}{"beijing", "shanghai"}}, []string{"b", "d"}},
+		{"term on array", &search.TermQuery{FieldName: "tags", Term: "fruit"}, []string{"a", "b"}},
+		{"range", priceRange, []string{"a", "b"}},
+		{"prefix", &search.PrefixQuery{FieldName: "city", Prefix: "sh"}, []string{"d"}},
+		{"wildcard", &search.WildcardQuery{FieldName: "city", Value: "*g??ou"}, []string{"a", "c"}},
+		{"match", &search.MatchQuery{FieldName: "title", Text: "APPLE"}, []string{"d", "a", "b"}},
+		{"match phrase", &search.MatchPhraseQuery{FieldName: "title", Text: "apple pie"}, []string{"a"}},
+		{"exists", &search.ExistsQuery{FieldName: "stock"}, []string{"a", "b", "d"}},
+		{"bool", &search.BoolQuery{
+			MustQueries:    []search.Query{&search.MatchQuery{FieldName: "title", Text: "apple"}},
+			MustNotQueries: []search.Query{&search.TermQuery{FieldName: "city", Term: "beijing"}},
+		}, []string{"d", "a"}},
+		{"nested", &search.NestedQuery{Path: "items", ScoreMode: search.ScoreMode_None, Query: &search.BoolQuery{
+			MustQueries: []search.Query{
+				&search.TermQuery{FieldName: "items.sku", Term: "x1"},
+				&search.TermQuery{FieldName: "items.qty", Term: int64(9)},
+			},
+		}}, []string{"b"}},
+		{"geo distance", &search.GeoDistanceQuery{FieldName: "location", CenterPoint: "30.26,120.16", DistanceInMeter: 5000}, []string{"a", "c"}},
+		{"geo bounding box", &search.GeoBoundingBoxQuery{FieldName: "location", TopLeft: "40,116", BottomRight: "31,122"}, []string{"b", "d"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query := search.NewSearchQuery().SetQuery(c.query).SetGetTotalCount(true)
+			if _, ok := c.query.(*search.MatchQuery); ok {
+				query.SetSort(&search.Sort{Sorters: []search.Sorter{&search.ScoreSort{}}})
+			}
+			if _, ok := c.query.(*search.BoolQuery); ok {
+				query.SetSort(&search.Sort{Sorters: []search.Sorter{&search.ScoreSort{}}})
+			}
+			resp := doSearch(t, ix, query)
+			assert.Equal(t, c.want, ids(resp))
+			assert.Equal(t, int64(len(c.want)), resp.TotalCount)
+		})
+	}
+}
+
+func TestSortAndPage(t *testing.T) {
+	ix := testIndex(t)
+
+	resp := doSearch(t, ix, search.NewSearchQuery().SetSort(&search.Sort{Sorters: []search.Sorter{
+		&search.FieldSort{FieldName: "stock", Order: search.SortOrder_DESC.Enum(), MissingValue: search.FirstWhenMissing},
+	}}))
+	assert.Equal(t, []string{"c", "d", "a", "b"}, ids(resp))
+	assert.Equal(t, int64(-1), resp.TotalCount)
+
+	resp = doSearch(t, ix, search.NewSearchQuery().SetSort(&search.Sort{Sorters: []search.Sorter{
+		&search.GeoDistanceSort{FieldName: "location", Points: []string{"39.9,116.4"}},
+	}}))
+	assert.Equal(t, []string{"b", "d", "c", "a"}, ids(resp))
+
+	resp = doSearch(t, ix, search.NewSearchQuery().SetOffset(1).SetLimit(2).SetSort(&search.Sort{Sorters: []search.Sorter{
+		&search.FieldSort{FieldName: "price"},
+	}}))
+	assert.Equal(t, []string{"a", "b"}, ids(resp))
+
+	query := search.NewSearchQuery().SetLimit(3).SetSort(&search.Sort{Sorters: []search.Sorter{
+		&search.PrimaryKeySort{Order: search.SortOrder_DESC.Enum()},
+	}})
+	resp = doSearch(t, ix, query)
+	assert.Equal(t, []string{"d", "c", "b"}, ids(resp))
+	assert.NotNil(t, resp.NextToken)
+	next, err := search.PageQuery(query, resp.NextToken, 0)
+	assert.Nil(t, err)
+	resp = doSearch(t, ix, next)
+	assert.Equal(t, []string{"a"}, ids(resp))
+	assert.Nil(t, resp.NextToken)
+
+	_, err = ix.Search(&tablestore.SearchRequest{SearchQuery: search.NewSearchQuery().SetToken([]byte("nope"))})
+	assert.True(t, errors.Is(err, ErrInvalidQuery))
+}
+
+func TestColumnsToGet(t *testing.T) {
+	ix := testIndex(t)
+	query := search.NewSearchQuery().SetQuery(&search.TermQuery{FieldName: "city", Term: "beijing"})
+
+	resp := doSearch(t, ix, query)
+	assert.Empty(t, resp.Rows[0].Columns)
+
+	resp, err := ix.Search(&tablestore.SearchRequest{SearchQuery: query, ColumnsToGet: &tablestore.ColumnsToGet{Columns: []string{"price"}}})
+	assert.Nil(t, err)
+	assert.Len(t, resp.Rows[0].Columns, 1)
+	assert.Equal(t, 20.0, resp.Rows[0].Columns[0].Value)
+	assert.Equal(t, resp.Rows[0], resp.SearchHits[0].Row)
+}
+
+func TestPutAndDeleteRow(t *testing.T) {
+	ix := testIndex(t)
+	term := search.NewSearchQuery().SetQuery(&search.TermQuery{FieldName: "city", Term: "beijing"})
+
+	assert.Nil(t, ix.PutRow(testRow("b", map[string]interface{}{"city": "shenzhen"})))
+	assert.Empty(t, ids(doSearch(t, ix, term)))
+
+	assert.Nil(t, ix.PutRow(testRow("e", map[string]interface{}{"city": "beijing"})))
+	assert.Equal(t, []string{"e"}, ids(doSearch(t, ix, term)))
+
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn("id", "e")
+	ix.DeleteRow(pk)
+	assert.Empty(t, ids(doSearch(t, ix, term)))
+
+	err := ix.PutRow(testRow("f", map[string]interface{}{"price": "cheap"}))
+	assert.True(t, errors.Is(err, ErrInvalidValue))
+}
+
+func TestConcurrentSearchAndWrite(t *testing.T) {
+	ix := testIndex(t)
+	query := search.NewSearchQuery().SetQuery(&search.MatchAllQuery{})
+	pk := new(tablestore.PrimaryKey)
+	pk.AddPrimaryKeyColumn("id", "a")
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, ix.PutRow(testRow("b", map[string]interface{}{"city": "beijing"})))
+			ix.DeleteRow(pk)
+			assert.Nil(t, ix.PutRow(testRow("a", map[string]interface{}{"city": "hangzhou"})))
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		resp := doSearch(t, ix, query)
+		assert.True(t, len(resp.Rows) == 3 || len(resp.Rows) == 4)
+	}
+	wg.Wait()
+}
+
+func TestAggregations(t *testing.T) {
+	ix := testIndex(t)
+	resp := doSearch(t, ix, search.NewSearchQuery().SetLimit(0).Aggregation(
+		search.NewAvgAggregation("avg", "price"),
+		search.NewMaxAggregation("max", "stock"),
+		search.NewMinAggregation("min", "price"),
+		search.NewSumAggregation("sum", "stock"),
+		search.NewCountAggregation("count", "stock"),
+		search.NewDistinctCountAggregation("cities", "city"),
+	))
+	assert.Empty(t, resp.Rows)
+
+	avg, err := resp.AggregationResults.Avg("avg")
+	assert.Nil(t, err)
+	assert.Equal(t, 16.375, avg.Value)
+	max, _ := resp.AggregationResults.Max("max")
+	assert.Equal(t, 12.0, max.Value)
+	min, _ := resp.AggregationResults.Min("min")
+	assert.Equal(t, 5.0, min.Value)
+	sum, _ := resp.AggregationResults.Sum("sum")
+	assert.Equal(t, 15.0, sum.Value)
+	count, _ := resp.AggregationResults.Count("count")
+	assert.Equal(t, int64(3), count.Value)
+	cities, _ := resp.AggregationResults.DistinctCount("cities")
+	assert.Equal(t, int64(3), cities.Value)
+}
+
+func TestGroupByField(t *testing.T) {
+	ix := testIndex(t)
+	groupBy := search.NewGroupByField("by_city", "city").
+		SubAggregation(search.NewSumAggregation("price", "price"))
+	resp := doSearch(t, ix, search.NewSearchQuery().GroupBy(groupBy))
+
+	result, err := resp.GroupByResults.GroupByField("by_city")
+	assert.Nil(t, err)
+	assert.Len(t, result.Items, 3)
+	assert.Equal(t, "hangzhou", result.Items[0].Key)
+	assert.Equal(t, int64(2), result.Items[0].RowCount)
+	price, _ := result.Items[0].SubAggregations.Sum("price")
+	assert.Equal(t, 15.5, price.Value)
+	assert.Equal(t, "beijing", result.Items[1].Key)
+	assert.Equal(t, "shanghai", result.Items[2].Key)
+
+	groupBy = search.NewGroupByField("by_tag", "tags")
+	groupBy.Sz = proto.Int32(1)
+	groupBy.Sorters = []search.GroupBySorter{&search.GroupKeyGroupBySort{Order: search.SortOrder_DESC.Enum()}}
+	resp = doSearch(t, ix, search.NewSearchQuery().GroupBy(groupBy))
+	result, _ = resp.GroupByResults.GroupByField("by_tag")
+	assert.Len(t, result.Items, 1)
+	assert.Equal(t, "sweet", result.Items[0].Key)
+}
+
+func TestUnsupported(t *testing.T) {
+	ix := testIndex(t)
+	for _, query := range []search.SearchQuery{
+		search.NewSearchQuery().SetQuery(&search.FunctionScoreQuery{}),
+		search.NewSearchQuery().SetCollapse(&search.Collapse{FieldName: "city"}),
+		search.NewSearchQuery().Aggregation(search.NewPercentilesAggregation("p", "price")),
+		search.NewSearchQuery().GroupBy(search.NewGroupByRange("r", "price")),
+	} {
+		_, err := ix.Search(&tablestore.SearchRequest{SearchQuery: query})
+		assert.True(t, errors.Is(err, ErrUnsupported), "%v", err)
+	}
+
+	_, err := ix.Search(&tablestore.SearchRequest{SearchQuery: search.NewSearchQuery().SetQuery(
+		&search.TermQuery{FieldName: "unknown", Term: "x"})})
+	assert.True(t, errors.Is(err, ErrInvalidQuery))
+}
@@ -0,0 +1,264 @@
+package searchtest
+
+import (
+	"sort"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+// sortKey is the value a hit is ordered by for one sorter, nil when the
+// hit has none.
+type sortKey struct {
+	value interface{}
+	// first places a missing value first, it goes last otherwise
+	first bool
+}
+
+type sorter struct {
+	desc bool
+	key  func(h *hit) (sortKey, error)
+}
+
+// sort orders hits by the sorters of s, by the index sort of the schema when
+// s is nil, and then by primary key unless s disables it.
+func (ix *Index) sort(hits []*hit, s *search.Sort) error {
+	if s == nil {
+		s = ix.schema.IndexSort
+	}
+	var sorters []search.Sorter
+	pkSort := true
+	if s != nil {
+		sorters = s.Sorters
+		pkSort = s.DisableDefaultPkSorter == nil || !*s.DisableDefaultPkSorter
+	}
+	if pkSort {
+		sorters = append(sorters[:len(sorters):len(sorters)], &search.PrimaryKeySort{})
+	}
+
+	var compiled []*sorter
+	for _, s := range sorters {
+		c, err := ix.compileSorter(s)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, c)
+	}
+	keys := make([][]sortKey, len(hits))
+	for i, h := range hits {
+		for _, c := range compiled {
+			key, err := c.key(h)
+			if err != nil {
+				return err
+			}
+			keys[i] = append(keys[i], key)
+		}
+	}
+
+	index := make([]int, len(hits))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		for k, c := range compiled {
+			if r := compareKeys(keys[index[a]][k], keys[index[b]][k], c.desc); r != 0 {
+				return r < 0
+			}
+		}
+		return false
+	})
+	sorted := make([]*hit, len(hits))
+	for i, j := range index {
+		sorted[i] = hits[j]
+	}
+	copy(hits, sorted)
+	return nil
+}
+
+// compareKeys orders two keys, missing values are placed whatever the
+// order.
+func compareKeys(a, b sortKey, desc bool) int {
+	if a.value == nil || b.value == nil {
+		rank := func(k sortKey) int {
+			switch {
+			case k.value != nil:
+				return 1
+			case k.first:
+				return 0
+			}
+			return 2
+		}
+		return rank(a) - rank(b)
+	}
+	var c int
+	if pa, ok := a.value.(primaryKeyValue); ok {
+		c = comparePrimaryKeys(pa.pk, b.value.(primaryKeyValue).pk)
+	} else {
+		c, _ = compareValues(a.value, b.value)
+	}
+	if desc {
+		return -c
+	}
+	return c
+}
+
+func isDesc(order *search.SortOrder, desc bool) bool {
+	if order == nil {
+		return desc
+	}
+	return *order == search.SortOrder_DESC
+}
+
+func (ix *Index) compileSorter(s search.Sorter) (*sorter, error) {
+	switch s := s.(type) {
+	case *search.PrimaryKeySort:
+		return &sorter{desc: isDesc(s.Order, false), key: func(h *hit) (sortKey, error) {
+			return sortKey{value: primaryKeyValue{h.doc.row.PrimaryKey}}, nil
+		}}, nil
+	case *search.ScoreSort:
+		return &sorter{desc: isDesc(s.Order, true), key: func(h *hit) (sortKey, error) {
+			return sortKey{value: h.score}, nil
+		}}, nil
+	case *search.FieldSort:
+		return ix.compileFieldSort(s)
+	case *search.GeoDistanceSort:
+		if _, err := ix.field(s.FieldName); err != nil {
+			return nil, err
+		}
+		var points []geoPoint
+		for _, p := range s.Points {
+			point, err := parseGeoPoint(p)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, point)
+		}
+		desc := isDesc(s.Order, false)
+		return &sorter{desc: desc, key: func(h *hit) (sortKey, error) {
+			values, err := ix.sortValues(h.doc, s.FieldName, s.NestedFilter)
+			if err != nil {
+				return sortKey{}, err
+			}
+			var distances []interface{}
+			for _, v := range values {
+				if point, ok := v.(geoPoint); ok {
+					for _, p := range points {
+						distances = append(distances, point.distance(p))
+					}
+				}
+			}
+			return sortKey{value: reduce(distances, s.Mode, desc)}, nil
+		}}, nil
+	case *search.DocSort:
+		return nil, unsupportedf("doc sort")
+	}
+	return nil, unsupportedf("sorter %T", s)
+}
+
+func (ix *Index) compileFieldSort(s *search.FieldSort) (*sorter, error) {
+	if _, err := ix.field(s.FieldName); err != nil {
+		return nil, err
+	}
+	if s.MissingField != nil {
+		if _, err := ix.field(*s.MissingField); err != nil {
+			return nil, err
+		}
+	}
+	desc := isDesc(s.Order, false)
+	return &sorter{desc: desc, key: func(h *hit) (sortKey, error) {
+		values, err := ix.sortValues(h.doc, s.FieldName, s.NestedFilter)
+		if err != nil {
+			return sortKey{}, err
+		}
+		if len(values) == 0 && s.MissingField != nil {
+			values = h.doc.values[*s.MissingField]
+		}
+		if len(values) > 0 {
+			return sortKey{value: reduce(values, s.Mode, desc)}, nil
+		}
+		switch s.MissingValue {
+		case nil, search.LastWhenMissing:
+			return sortKey{}, nil
+		case search.FirstWhenMissing:
+			return sortKey{first: true}, nil
+		}
+		return sortKey{value: s.MissingValue}, nil
+	}}, nil
+}
+
+// sortValues are the values of a field of doc, of the nested documents the
+// filter matches for a field of a nested document.
+func (ix *Index) sortValues(doc *document, field string, filter *search.NestedFilter) ([]interface{}, error) {
+	if filter == nil {
+		return doc.values[field], nil
+	}
+	var values []interface{}
+	for _, child := range doc.nested[filter.Path] {
+		ok := true
+		if filter.Filter != nil {
+			var err error
+			if ok, _, err = ix.match(filter.Filter, child); err != nil {
+				return nil, err
+			}
+		}
+		if ok {
+			values = append(values, child.values[field]...)
+		}
+	}
+	return values, nil
+}
+
+// reduce picks the value of a multi-valued field a sort uses, the smallest
+// for an ascending and the largest for a descending sort by default.
+func reduce(values []interface{}, mode *search.SortMode, desc bool) interface{} {
+	if len(values) == 0 {
+		return nil
+	}
+	m := search.SortMode_Min
+	if desc {
+		m = search.SortMode_Max
+	}
+	if mode != nil {
+		m = *mode
+	}
+	if m == search.SortMode_Avg {
+		var sum float64
+		for _, v := range values {
+			f, ok := toFloat(v)
+			if !ok {
+				return values[0]
+			}
+			sum += f
+		}
+		return sum / float64(len(values))
+	}
+	best := values[0]
+	for _, v := range values[1:] {
+		c, _ := compareValues(v, best)
+		if (m == search.SortMode_Min && c < 0) || (m == search.SortMode_Max && c > 0) {
+			best = v
+		}
+	}
+	return best
+}
+
+// primaryKeyValue orders primary keys column by column.
+type primaryKeyValue struct {
+	pk *tablestore.PrimaryKey
+}
+
+func comparePrimaryKeys(a, b *tablestore.PrimaryKey) int {
+	for i := 0; i < len(a.PrimaryKeys) && i < len(b.PrimaryKeys); i++ {
+		av, bv := a.PrimaryKeys[i].Value, b.PrimaryKeys[i].Value
+		if ab, ok := av.([]byte); ok {
+			av = string(ab)
+		}
+		if bb, ok := bv.([]byte); ok {
+			bv = string(bb)
+		}
+		if c, _ := compareValues(av, bv); c != 0 {
+			return c
+		}
+	}
+	return len(a.PrimaryKeys) - len(b.PrimaryKeys)
+}
@@ -1,6 +1,7 @@
 package search
 
 import (
+	"encoding/json"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/otsprotocol"
 )
 
@@ -85,3 +86,51 @@ func (s *FieldSort) ProtoBuffer() (*otsprotocol.Sorter, error) {
 	}
 	return pbSorter, nil
 }
+
+func (f *NestedFilter) MarshalJSON() ([]byte, error) {
+	type NestedFilterAlias NestedFilter
+	return json.Marshal(&struct {
+		*NestedFilterAlias
+		Filter *queryAlias
+	}{
+		(*NestedFilterAlias)(f),
+		newQueryAlias(f.Filter),
+	})
+}
+
+func (f *NestedFilter) UnmarshalJSON(data []byte) error {
+	type NestedFilterAlias NestedFilter
+	aux := &struct {
+		*NestedFilterAlias
+		Filter *queryAlias
+	}{NestedFilterAlias: (*NestedFilterAlias)(f)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	f.Filter = queryFromAlias(aux.Filter)
+	return nil
+}
+
+func (s *FieldSort) MarshalJSON() ([]byte, error) {
+	type FieldSortAlias FieldSort
+	return json.Marshal(&struct {
+		*FieldSortAlias
+		MissingValue jsonValue
+	}{
+		(*FieldSortAlias)(s),
+		jsonValue{s.MissingValue},
+	})
+}
+
+func (s *FieldSort) UnmarshalJSON(data []byte) error {
+	type FieldSortAlias FieldSort
+	aux := &struct {
+		*FieldSortAlias
+		MissingValue jsonValue
+	}{FieldSortAlias: (*FieldSortAlias)(s)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	s.MissingValue = aux.MissingValue.value
+	return nil
+}
@@ -0,0 +1,482 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// FieldInfo describes a field of a search index.
+type FieldInfo struct {
+	// Type is the type name, "TEXT", "KEYWORD", "LONG" and so on.
+	Type string
+	// Index is false for fields that are stored but cannot be queried.
+	Index bool
+	// SortAndAgg is true for fields that can be sorted, aggregated, grouped
+	// and collapsed by.
+	SortAndAgg bool
+	Array      bool
+	// Dimension is the dimension of a VECTOR field.
+	Dimension int32
+}
+
+// IndexFields describes the fields of a search index, nested fields by
+// their path joined with dots. *tablestore.IndexSchema implements it.
+type IndexFields interface {
+	FieldInfo(field string) (FieldInfo, bool)
+}
+
+// ValidationError is a problem Validate found in a query, at the path of
+// the part of the query that has it, such as Query.MustQueries[1] or
+// GroupBys[0].SubAggList[2].
+type ValidationError struct {
+	Path   string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Reason
+	}
+	return e.Path + ": " + e.Reason
+}
+
+// ValidationErrors are all the problems Validate found in a query.
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	reasons := make([]string, len(e))
+	for i, err := range e {
+		reasons[i] = err.Error()
+	}
+	return "invalid search query: " + strings.Join(reasons, "; ")
+}
+
+var (
+	numberTypes    = []string{"LONG", "DOUBLE"}
+	numericTypes   = []string{"LONG", "DOUBLE", "DATE"}
+	rangeTypes     = []string{"LONG", "DOUBLE", "DATE", "KEYWORD"}
+	sortableTypes  = []string{"LONG", "DOUBLE", "BOOLEAN", "KEYWORD", "DATE"}
+	countableTypes = []string{"LONG", "DOUBLE", "BOOLEAN", "KEYWORD", "DATE", "GEO_POINT"}
+	termTypes      = []string{"LONG", "DOUBLE", "BOOLEAN", "KEYWORD", "TEXT", "DATE"}
+	stringTypes    = []string{"KEYWORD", "TEXT"}
+	geoTypes       = []string{"GEO_POINT"}
+)
+
+// Validate checks a query built with NewSearchQuery or NewScanQuery against
+// the fields of the index it runs on, usually the Schema of a
+// DescribeSearchIndex response, so that mistakes the service would reject
+// show up before the request is sent:
+//
+//	err := search.Validate(query, describeResp.Schema)
+//
+// Describing an index is a request of its own, callers validating many
+// queries describe the index once and keep the schema.
+//
+// Validate walks the query, the sort, the aggregations and the group-bys,
+// checking the fields they use exist, are indexed, or enable sort and
+// aggregation when sorted or aggregated by, and have a type the query,
+// sorter, aggregation or group-by accepts. It checks nested fields are
+// reached through a NestedQuery or NestedFilter on their path, term and
+// range values fit the type of their field, and KNN query vectors have the
+// dimension of their field. It returns every problem it finds as
+// ValidationErrors, nil when there are none. Query types it does not know
+// are left to the service.
+func Validate(query SearchQuery, schema IndexFields) error {
+	v := &validator{schema: schema}
+	if schema == nil {
+		v.addf("", "no index schema")
+		return v.errs
+	}
+	switch q := query.(type) {
+	case *searchQuery:
+		if q.Query != nil {
+			v.query("Query", q.Query, "")
+		}
+		if q.Sort != nil {
+			v.sort("Sort", q.Sort)
+		}
+		if q.Collapse != nil {
+			v.sortField("Collapse", "Collapse", q.Collapse.FieldName, nil, sortableTypes...)
+		}
+		if q.Highlight != nil {
+			for name := range q.Highlight.FieldHighlightParameters {
+				v.queryField("Highlight", "Highlight", name, "", stringTypes...)
+			}
+		}
+		v.aggregations("Aggregations", q.Aggregations)
+		v.groupBys("GroupBys", q.GroupBys)
+	case *scanQuery:
+		if q.Query != nil {
+			v.query("Query", q.Query, "")
+		}
+	default:
+		v.addf("", "search query %T was not built with NewSearchQuery or NewScanQuery", query)
+	}
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+type validator struct {
+	schema IndexFields
+	errs   ValidationErrors
+}
+
+func (v *validator) addf(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{Path: path, Reason: fmt.Sprintf(format, args...)})
+}
+
+// field looks a field up and checks its type is one of types, if any.
+func (v *validator) field(path, what, name string, types ...string) (FieldInfo, bool) {
+	if name == "" {
+		v.addf(path, "%s has no field name", what)
+		return FieldInfo{}, false
+	}
+	info, ok := v.schema.FieldInfo(name)
+	if !ok {
+		v.addf(path, "field %s is not in the index schema", name)
+		return info, false
+	}
+	if len(types) > 0 && !containsString(types, info.Type) {
+		v.addf(path, "%s on %s field %s, want %s", what, info.Type, name, joinTypes(types))
+		return info, false
+	}
+	return info, true
+}
+
+// nestedParent is the path of the innermost nested field a field is in, ""
+// when it is in none.
+func (v *validator) nestedParent(name string) string {
+	for i := strings.LastIndex(name, "."); i > 0; i = strings.LastIndex(name[:i], ".") {
+		if info, ok := v.schema.FieldInfo(name[:i]); ok && info.Type == "NESTED" {
+			return name[:i]
+		}
+	}
+	return ""
+}
+
+// queryField checks a field a query matches, nested being the path of the
+// innermost NestedQuery the query is in.
+func (v *validator) queryField(path, what, name, nested string, types ...string) (FieldInfo, bool) {
+	info, ok := v.field(path, what, name, types...)
+	if !ok {
+		return info, false
+	}
+	if parent := v.nestedParent(name); parent != nested {
+		if parent == "" {
+			v.addf(path, "field %s is not in the nested field %s of the NestedQuery", name, nested)
+		} else {
+			v.addf(path, "field %s is in the nested field %s and must be queried within a NestedQuery on %s", name, parent, parent)
+		}
+		return info, false
+	}
+	if !info.Index {
+		v.addf(path, "field %s is not indexed", name)
+		return info, false
+	}
+	return info, true
+}
+
+// sortField checks a field a sort or a collapse orders by.
+func (v *validator) sortField(path, what, name string, filter *NestedFilter, types ...string) {
+	info, ok := v.field(path, what, name, types...)
+	if !ok {
+		return
+	}
+	parent := v.nestedParent(name)
+	switch {
+	case parent != "" && (filter == nil || filter.Path != parent):
+		v.addf(path, "field %s is in the nested field %s and needs a NestedFilter on %s", name, parent, parent)
+	case parent == "" && filter != nil:
+		v.addf(path, "field %s is not in the nested field %s of the NestedFilter", name, filter.Path)
+	}
+	if filter != nil && filter.Filter != nil {
+		v.query(path+".NestedFilter.Filter", filter.Filter, filter.Path)
+	}
+	if !info.SortAndAgg {
+		v.addf(path, "field %s does not enable sort and aggregation", name)
+	}
+}
+
+// aggField checks a field an aggregation or a group-by uses.
+func (v *validator) aggField(path, what, name string, types ...string) {
+	info, ok := v.field(path, what, name, types...)
+	if !ok {
+		return
+	}
+	if parent := v.nestedParent(name); parent != "" {
+		v.addf(path, "field %s is in the nested field %s and cannot be aggregated", name, parent)
+		return
+	}
+	if !info.SortAndAgg {
+		v.addf(path, "field %s does not enable sort and aggregation", name)
+	}
+}
+
+func (v *validator) value(path, name string, info FieldInfo, value interface{}) {
+	if !fitsType(info.Type, value) {
+		v.addf(path, "value %v (%T) does not fit %s field %s", value, value, info.Type, name)
+	}
+}
+
+func (v *validator) queries(path string, queries []Query, nested string) {
+	for i, q := range queries {
+		v.subQuery(fmt.Sprintf("%s[%d]", path, i), q, nested)
+	}
+}
+
+func (v *validator) subQuery(path string, query Query, nested string) {
+	if query == nil {
+		v.addf(path, "query is nil")
+		return
+	}
+	v.query(path, query, nested)
+}
+
+func (v *validator) query(path string, query Query, nested string) {
+	switch q := query.(type) {
+	case *TermQuery:
+		if info, ok := v.queryField(path, "TermQuery", q.FieldName, nested, termTypes...); ok {
+			v.value(path+".Term", q.FieldName, info, q.Term)
+		}
+	case *TermsQuery:
+		if info, ok := v.queryField(path, "TermsQuery", q.FieldName, nested, termTypes...); ok {
+			if len(q.Terms) == 0 {
+				v.addf(path, "TermsQuery has no terms")
+			}
+			for i, term := range q.Terms {
+				v.value(fmt.Sprintf("%s.Terms[%d]", path, i), q.FieldName, info, term)
+			}
+		}
+	case *RangeQuery:
+		if info, ok := v.queryField(path, "RangeQuery", q.FieldName, nested, rangeTypes...); ok {
+			if q.From != nil {
+				v.value(path+".From", q.FieldName, info, q.From)
+			}
+			if q.To != nil {
+				v.value(path+".To", q.FieldName, info, q.To)
+			}
+		}
+	case *PrefixQuery:
+		v.queryField(path, "PrefixQuery", q.FieldName, nested, stringTypes...)
+	case *WildcardQuery:
+		v.queryField(path, "WildcardQuery", q.FieldName, nested, stringTypes...)
+	case *MatchQuery:
+		v.queryField(path, "MatchQuery", q.FieldName, nested, termTypes...)
+	case *MatchPhraseQuery:
+		v.queryField(path, "MatchPhraseQuery", q.FieldName, nested, "TEXT")
+	case *ExistsQuery:
+		if info, ok := v.schema.FieldInfo(q.FieldName); ok && info.Type == "NESTED" {
+			v.field(path, "ExistsQuery", q.FieldName)
+		} else {
+			v.queryField(path, "ExistsQuery", q.FieldName, nested)
+		}
+	case *GeoDistanceQuery:
+		v.queryField(path, "GeoDistanceQuery", q.FieldName, nested, geoTypes...)
+	case *GeoBoundingBoxQuery:
+		v.queryField(path, "GeoBoundingBoxQuery", q.FieldName, nested, geoTypes...)
+	case *GeoPolygonQuery:
+		v.queryField(path, "GeoPolygonQuery", q.FieldName, nested, geoTypes...)
+	case *BoolQuery:
+		v.queries(path+".MustQueries", q.MustQueries, nested)
+		v.queries(path+".MustNotQueries", q.MustNotQueries, nested)
+		v.queries(path+".FilterQueries", q.FilterQueries, nested)
+		v.queries(path+".ShouldQueries", q.ShouldQueries, nested)
+	case *ConstScoreQuery:
+		v.subQuery(path+".Filter", q.Filter, nested)
+	case *FunctionScoreQuery:
+		v.subQuery(path+".Query", q.Query, nested)
+		if q.FieldValueFactor != nil {
+			v.sortField(path+".FieldValueFactor", "FieldValueFactor", q.FieldValueFactor.FieldName, nil, numberTypes...)
+		}
+	case *FunctionsScoreQuery:
+		for i, f := range q.Functions {
+			p := fmt.Sprintf("%s.Functions[%d]", path, i)
+			if f.Filter != nil {
+				v.query(p+".Filter", f.Filter, nested)
+			}
+			if f.FieldValueFactorFunction != nil && f.FieldValueFactorFunction.FieldName != nil {
+				v.sortField(p+".FieldValueFactorFunction", "FieldValueFactorFunction", *f.FieldValueFactorFunction.FieldName, nil, numberTypes...)
+			}
+			if f.DecayFunction != nil && f.DecayFunction.FieldName != nil {
+				v.sortField(p+".DecayFunction", "DecayFunction", *f.DecayFunction.FieldName, nil, "LONG", "DOUBLE", "DATE", "GEO_POINT")
+			}
+		}
+	case *NestedQuery:
+		if _, ok := v.field(path, "NestedQuery", q.Path, "NESTED"); ok {
+			if parent := v.nestedParent(q.Path); parent != nested {
+				v.addf(path, "nested field %s must be queried within a NestedQuery on %s", q.Path, parent)
+			}
+		}
+		v.subQuery(path+".Query", q.Query, q.Path)
+	case *KnnVectorQuery:
+		if info, ok := v.queryField(path, "KnnVectorQuery", q.FieldName, nested, "VECTOR"); ok {
+			if info.Dimension > 0 && int32(len(q.Float32QueryVector)) != info.Dimension {
+				v.addf(path, "query vector has dimension %d, field %s has dimension %d", len(q.Float32QueryVector), q.FieldName, info.Dimension)
+			}
+		}
+		if q.TopK != nil && *q.TopK <= 0 {
+			v.addf(path, "TopK %d is not positive", *q.TopK)
+		}
+		if q.Filter != nil {
+			v.query(path+".Filter", q.Filter, nested)
+		}
+	}
+}
+
+func (v *validator) sort(path string, sort *Sort) {
+	for i, sorter := range sort.Sorters {
+		p := fmt.Sprintf("%s.Sorters[%d]", path, i)
+		switch s := sorter.(type) {
+		case *FieldSort:
+			v.sortField(p, "FieldSort", s.FieldName, s.NestedFilter, sortableTypes...)
+		case *GeoDistanceSort:
+			v.sortField(p, "GeoDistanceSort", s.FieldName, s.NestedFilter, geoTypes...)
+		case nil:
+			v.addf(p, "sorter is nil")
+		}
+	}
+}
+
+func (v *validator) aggregations(path string, aggs []Aggregation) {
+	names := make(map[string]bool)
+	for i, agg := range aggs {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		if agg == nil {
+			v.addf(p, "aggregation is nil")
+			continue
+		}
+		if names[agg.GetName()] {
+			v.addf(p, "aggregation name %s is used twice", agg.GetName())
+		}
+		names[agg.GetName()] = true
+		switch a := agg.(type) {
+		case *AvgAggregation:
+			v.aggField(p, "AvgAggregation", a.Field, numericTypes...)
+		case *MaxAggregation:
+			v.aggField(p, "MaxAggregation", a.Field, numericTypes...)
+		case *MinAggregation:
+			v.aggField(p, "MinAggregation", a.Field, numericTypes...)
+		case *SumAggregation:
+			v.aggField(p, "SumAggregation", a.Field, numericTypes...)
+		case *PercentilesAggregation:
+			v.aggField(p, "PercentilesAggregation", a.Field, numericTypes...)
+		case *CountAggregation:
+			v.aggField(p, "CountAggregation", a.Field, countableTypes...)
+		case *DistinctCountAggregation:
+			v.aggField(p, "DistinctCountAggregation", a.Field, countableTypes...)
+		case *TopRowsAggregation:
+			if a.Sort != nil {
+				v.sort(p+".Sort", a.Sort)
+			}
+		}
+	}
+}
+
+func (v *validator) groupBys(path string, groupBys []GroupBy) {
+	names := make(map[string]bool)
+	for i, groupBy := range groupBys {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		if groupBy == nil {
+			v.addf(p, "group by is nil")
+			continue
+		}
+		if names[groupBy.GetName()] {
+			v.addf(p, "group by name %s is used twice", groupBy.GetName())
+		}
+		names[groupBy.GetName()] = true
+		switch g := groupBy.(type) {
+		case *GroupByField:
+			v.aggField(p, "GroupByField", g.Field, sortableTypes...)
+			v.groupBySorters(p, g.Sorters, g.SubAggList)
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		case *GroupByRange:
+			v.aggField(p, "GroupByRange", g.Field, numericTypes...)
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		case *GroupByHistogram:
+			v.aggField(p, "GroupByHistogram", g.Field, numberTypes...)
+			v.groupBySorters(p, g.Sorters, g.SubAggList)
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		case *GroupByDateHistogram:
+			v.aggField(p, "GroupByDateHistogram", g.Field, "DATE")
+			v.groupBySorters(p, g.Sorters, g.SubAggList)
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		case *GroupByGeoDistance:
+			v.aggField(p, "GroupByGeoDistance", g.Field, geoTypes...)
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		case *GroupByGeoGrid:
+			v.aggField(p, "GroupByGeoGrid", g.Field, geoTypes...)
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		case *GroupByFilter:
+			v.queries(p+".Queries", g.Queries, "")
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		case *GroupByComposite:
+			v.groupBys(p+".SourceGroupByList", g.SourceGroupByList)
+			v.aggregations(p+".SubAggList", g.SubAggList)
+			v.groupBys(p+".SubGroupByList", g.SubGroupByList)
+		}
+	}
+}
+
+func (v *validator) groupBySorters(path string, sorters []GroupBySorter, subAggs []Aggregation) {
+	for i, sorter := range sorters {
+		s, ok := sorter.(*SubAggGroupBySort)
+		if !ok {
+			continue
+		}
+		found := false
+		for _, agg := range subAggs {
+			found = found || (agg != nil && agg.GetName() == s.SubAggName)
+		}
+		if !found {
+			v.addf(fmt.Sprintf("%s.Sorters[%d]", path, i), "sub aggregation %s is not in SubAggList", s.SubAggName)
+		}
+	}
+}
+
+// fitsType reports whether a term or range value can be compared with a
+// field of the type.
+func fitsType(typ string, value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	rv := reflect.ValueOf(value)
+	switch kind := rv.Kind(); {
+	case kind >= reflect.Int && kind <= reflect.Uint64:
+		return typ == "LONG" || typ == "DOUBLE" || typ == "DATE"
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		f := rv.Float()
+		return typ == "DOUBLE" || ((typ == "LONG" || typ == "DATE") && f == math.Trunc(f))
+	case kind == reflect.Bool:
+		return typ == "BOOLEAN"
+	case kind == reflect.String:
+		return typ == "KEYWORD" || typ == "TEXT" || typ == "DATE"
+	}
+	return true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	return strings.Join(types[:len(types)-1], ", ") + " or " + types[len(types)-1]
+}
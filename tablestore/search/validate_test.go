@@ -0,0 +1,150 @@
+package search
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type fieldInfos map[string]FieldInfo
+
+func (s fieldInfos) FieldInfo(field string) (FieldInfo, bool) {
+	info, ok := s[field]
+	return info, ok
+}
+
+var testFields = fieldInfos{
+	"title":       {Type: "TEXT", Index: true},
+	"city":        {Type: "KEYWORD", Index: true, SortAndAgg: true},
+	"note":        {Type: "KEYWORD"},
+	"price":       {Type: "DOUBLE", Index: true, SortAndAgg: true},
+	"stock":       {Type: "LONG", Index: true, SortAndAgg: true},
+	"created":     {Type: "DATE", Index: true, SortAndAgg: true},
+	"location":    {Type: "GEO_POINT", Index: true, SortAndAgg: true},
+	"vec":         {Type: "VECTOR", Index: true, Dimension: 3},
+	"items":       {Type: "NESTED", Index: true},
+	"items.sku":   {Type: "KEYWORD", Index: true, SortAndAgg: true},
+	"items.price": {Type: "DOUBLE", Index: true, SortAndAgg: true},
+}
+
+func validationErrors(t *testing.T, query SearchQuery) ValidationErrors {
+	err := Validate(query, testFields)
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	assert.True(t, errors.As(err, &errs))
+	return errs
+}
+
+func TestValidate_Valid(t *testing.T) {
+	priceRange := &RangeQuery{FieldName: "price"}
+	priceRange.GT(1)
+	query := NewSearchQuery().
+		SetQuery(&BoolQuery{
+			MustQueries: []Query{
+				&MatchQuery{FieldName: "title", Text: "hello"},
+				&TermQuery{FieldName: "city", Term: "hangzhou"},
+				priceRange,
+				&NestedQuery{Path: "items", Query: &TermQuery{FieldName: "items.sku", Term: "x1"}},
+				&GeoDistanceQuery{FieldName: "location", CenterPoint: "30,120", DistanceInMeter: 100},
+			},
+			ShouldQueries: []Query{&KnnVectorQuery{FieldName: "vec", TopK: proto.Int32(5), Float32QueryVector: []float32{1, 2, 3}}},
+		}).
+		SetSort(&Sort{Sorters: []Sorter{
+			&FieldSort{FieldName: "stock"},
+			&FieldSort{FieldName: "items.price", NestedFilter: &NestedFilter{Path: "items"}},
+			&ScoreSort{},
+		}}).
+		Aggregation(NewAvgAggregation("avg", "price"), NewDistinctCountAggregation("cities", "city")).
+		GroupBy(NewGroupByField("by_city", "city").SubAggregation(NewMaxAggregation("max", "created")))
+	assert.Nil(t, Validate(query, testFields))
+	assert.Nil(t, Validate(NewScanQuery().SetQuery(&TermQuery{FieldName: "city", Term: "x"}), testFields))
+}
+
+func TestValidate_Errors(t *testing.T) {
+	query := NewSearchQuery().
+		SetQuery(&BoolQuery{
+			MustQueries: []Query{
+				&RangeQuery{FieldName: "title", From: "a"},
+				&TermQuery{FieldName: "missing", Term: "x"},
+				&TermQuery{FieldName: "stock", Term: "ten"},
+				&TermQuery{FieldName: "note", Term: "x"},
+				&TermQuery{FieldName: "items.sku", Term: "x1"},
+				&NestedQuery{Path: "title", Query: &MatchAllQuery{}},
+			},
+			MustNotQueries: []Query{
+				&KnnVectorQuery{FieldName: "vec", Float32QueryVector: []float32{1, 2}},
+				nil,
+			},
+		}).
+		SetSort(&Sort{Sorters: []Sorter{
+			&FieldSort{FieldName: "title"},
+			&FieldSort{FieldName: "items.price"},
+		}}).
+		Aggregation(NewSumAggregation("sum", "city"), NewCountAggregation("sum", "city")).
+		GroupBy(NewGroupByField("by_price", "title").SubGroupBy(NewGroupByField("inner", "items.sku")))
+
+	assert.Equal(t, ValidationErrors{
+		{Path: "Query.MustQueries[0]", Reason: "RangeQuery on TEXT field title, want LONG, DOUBLE, DATE or KEYWORD"},
+		{Path: "Query.MustQueries[1]", Reason: "field missing is not in the index schema"},
+		{Path: "Query.MustQueries[2].Term", Reason: "value ten (string) does not fit LONG field stock"},
+		{Path: "Query.MustQueries[3]", Reason: "field note is not indexed"},
+		{Path: "Query.MustQueries[4]", Reason: "field items.sku is in the nested field items and must be queried within a NestedQuery on items"},
+		{Path: "Query.MustQueries[5]", Reason: "NestedQuery on TEXT field title, want NESTED"},
+		{Path: "Query.MustNotQueries[0]", Reason: "query vector has dimension 2, field vec has dimension 3"},
+		{Path: "Query.MustNotQueries[1]", Reason: "query is nil"},
+		{Path: "Sort.Sorters[0]", Reason: "FieldSort on TEXT field title, want LONG, DOUBLE, BOOLEAN, KEYWORD or DATE"},
+		{Path: "Sort.Sorters[1]", Reason: "field items.price is in the nested field items and needs a NestedFilter on items"},
+		{Path: "Aggregations[0]", Reason: "SumAggregation on KEYWORD field city, want LONG, DOUBLE or DATE"},
+		{Path: "Aggregations[1]", Reason: "aggregation name sum is used twice"},
+		{Path: "GroupBys[0]", Reason: "GroupByField on TEXT field title, want LONG, DOUBLE, BOOLEAN, KEYWORD or DATE"},
+		{Path: "GroupBys[0].SubGroupByList[0]", Reason: "field items.sku is in the nested field items and cannot be aggregated"},
+	}, validationErrors(t, query))
+}
+
+func TestValidate_Nested(t *testing.T) {
+	errs := validationErrors(t, NewSearchQuery().SetQuery(&NestedQuery{
+		Path:  "items",
+		Query: &TermQuery{FieldName: "city", Term: "x"},
+	}))
+	assert.Equal(t, ValidationErrors{
+		{Path: "Query.Query", Reason: "field city is not in the nested field items of the NestedQuery"},
+	}, errs)
+
+	errs = validationErrors(t, NewSearchQuery().SetQuery(&NestedQuery{Path: "items"}))
+	assert.Equal(t, ValidationErrors{{Path: "Query.Query", Reason: "query is nil"}}, errs)
+
+	errs = validationErrors(t, NewSearchQuery().SetSort(&Sort{Sorters: []Sorter{
+		&FieldSort{FieldName: "items.price", NestedFilter: &NestedFilter{Path: "items", Filter: &TermQuery{FieldName: "items.sku", Term: 1}}},
+	}}))
+	assert.Equal(t, ValidationErrors{
+		{Path: "Sort.Sorters[0].NestedFilter.Filter.Term", Reason: "value 1 (int) does not fit KEYWORD field items.sku"},
+	}, errs)
+}
+
+func TestValidate_GroupBySorter(t *testing.T) {
+	groupBy := NewGroupByField("by_city", "city").SubAggregation(NewAvgAggregation("avg", "price"))
+	groupBy.Sorters = []GroupBySorter{&SubAggGroupBySort{SubAggName: "avg"}, &SubAggGroupBySort{SubAggName: "max"}}
+	errs := validationErrors(t, NewSearchQuery().GroupBy(groupBy))
+	assert.Equal(t, ValidationErrors{
+		{Path: "GroupBys[0].Sorters[1]", Reason: "sub aggregation max is not in SubAggList"},
+	}, errs)
+}
+
+func TestValidate_NoSchema(t *testing.T) {
+	err := Validate(NewSearchQuery(), nil)
+	assert.EqualError(t, err, "invalid search query: no index schema")
+}
+
+func TestFitsType(t *testing.T) {
+	assert.True(t, fitsType("LONG", int32(1)))
+	assert.True(t, fitsType("LONG", 2.0))
+	assert.False(t, fitsType("LONG", 2.5))
+	assert.True(t, fitsType("DOUBLE", 2))
+	assert.True(t, fitsType("DATE", "2024-01-01"))
+	assert.False(t, fitsType("BOOLEAN", "true"))
+	assert.False(t, fitsType("KEYWORD", nil))
+}
@@ -0,0 +1,206 @@
+package tablestore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+)
+
+// SearchTokenExpiredError is returned by a SearchIterator when the server no
+// longer accepts the token of the next page, which happens when the pages
+// are read too slowly. The search has to start over.
+type SearchTokenExpiredError struct {
+	Token []byte
+	// Rows is the number of rows the iterator returned before.
+	Rows int64
+	Err  error
+}
+
+func (e *SearchTokenExpiredError) Error() string {
+	return fmt.Sprintf("[tablestore] search token expired after %d rows: %v", e.Rows, e.Err)
+}
+
+func (e *SearchTokenExpiredError) Unwrap() error {
+	return e.Err
+}
+
+func isSearchTokenExpired(err error) bool {
+	otsErr, ok := err.(*OtsError)
+	if !ok {
+		return false
+	}
+	switch otsErr.Code {
+	case "OTSSessionExpired":
+		return true
+	case "OTSParameterInvalid":
+		return strings.Contains(strings.ToLower(otsErr.Message), "token")
+	}
+	return false
+}
+
+// searchClient is the part of the client a SearchIterator uses.
+type searchClient interface {
+	Search(request *SearchRequest) (*SearchResponse, error)
+}
+
+// SearchIterator reads every match of a search across pages, following the
+// NextToken of each response:
+//
+//	it := client.SearchIterator(ctx, request).SetLimit(1000)
+//	for it.Next() {
+//		row := it.Row()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// The search query of the request has to be built with
+// search.NewSearchQuery. Its aggregations and group-bys are computed with the
+// first page only, see AggregationResults and GroupByResults.
+type SearchIterator struct {
+	ctx     context.Context
+	client  searchClient
+	request *SearchRequest
+	limit   int64
+
+	started bool
+	done    bool
+	token   []byte
+	hits    []*SearchHit
+	hit     *SearchHit
+	rows    int64
+	pages   int
+	err     error
+
+	totalCount         int64
+	aggregationResults search.AggregationResults
+	groupByResults     search.GroupByResults
+}
+
+// SearchIterator returns an iterator over the matches of request. No request
+// is sent before the first call to Next.
+func (tableStoreClient *TableStoreClient) SearchIterator(ctx context.Context, request *SearchRequest) *SearchIterator {
+	return newSearchIterator(ctx, tableStoreClient, request)
+}
+
+func newSearchIterator(ctx context.Context, client searchClient, request *SearchRequest) *SearchIterator {
+	return &SearchIterator{ctx: ctx, client: client, request: request}
+}
+
+// SetLimit stops the iterator after limit rows, 0 reads every match. The
+// page size of the query is lowered to not read past the limit.
+func (it *SearchIterator) SetLimit(limit int64) *SearchIterator {
+	it.limit = limit
+	return it
+}
+
+// Next moves to the next match, reading the next page when needed. It
+// returns false at the end of the matches, at the limit or on an error.
+func (it *SearchIterator) Next() bool {
+	it.hit = nil
+	for {
+		if it.err != nil || (it.limit > 0 && it.rows >= it.limit) {
+			return false
+		}
+		if len(it.hits) > 0 {
+			it.hit, it.hits = it.hits[0], it.hits[1:]
+			it.rows++
+			return true
+		}
+		if it.done {
+			return false
+		}
+		it.err = it.readPage()
+	}
+}
+
+func (it *SearchIterator) readPage() error {
+	if err := it.ctx.Err(); err != nil {
+		return err
+	}
+	var maxLimit int32
+	if it.limit > 0 {
+		maxLimit = int32(math.Min(float64(it.limit-it.rows), math.MaxInt32))
+	}
+	query, err := search.PageQuery(it.request.SearchQuery, it.token, maxLimit)
+	if err != nil {
+		return err
+	}
+	request := *it.request
+	request.SearchQuery = query
+	resp, err := it.client.Search(&request)
+	if err != nil {
+		if it.started && isSearchTokenExpired(err) {
+			return &SearchTokenExpiredError{Token: it.token, Rows: it.rows, Err: err}
+		}
+		return err
+	}
+
+	if !it.started {
+		it.started = true
+		it.totalCount = resp.TotalCount
+		it.aggregationResults = resp.AggregationResults
+		it.groupByResults = resp.GroupByResults
+	}
+	it.pages++
+	if len(resp.SearchHits) == len(resp.Rows) {
+		it.hits = resp.SearchHits
+	} else {
+		it.hits = make([]*SearchHit, 0, len(resp.Rows))
+		for _, row := range resp.Rows {
+			it.hits = append(it.hits, &SearchHit{Row: row})
+		}
+	}
+	it.token = resp.NextToken
+	it.done = len(it.token) == 0
+	return nil
+}
+
+// Row is the row of the current match.
+func (it *SearchIterator) Row() *Row {
+	if it.hit == nil {
+		return nil
+	}
+	return it.hit.Row
+}
+
+// SearchHit is the current match with its score and highlights.
+func (it *SearchIterator) SearchHit() *SearchHit {
+	return it.hit
+}
+
+// Err is the error that stopped the iterator, a *SearchTokenExpiredError
+// when a page token expired.
+func (it *SearchIterator) Err() error {
+	return it.err
+}
+
+// TotalCount is the TotalCount of the first page, set when the query asks
+// for it.
+func (it *SearchIterator) TotalCount() int64 {
+	return it.totalCount
+}
+
+func (it *SearchIterator) AggregationResults() search.AggregationResults {
+	return it.aggregationResults
+}
+
+func (it *SearchIterator) GroupByResults() search.GroupByResults {
+	return it.groupByResults
+}
+
+// Pages is the number of pages read so far.
+func (it *SearchIterator) Pages() int {
+	return it.pages
+}
+
+// Token is the token of the page after the rows read so far, nil after the
+// last page. A later search can continue from it with
+// search.SearchQuery.SetToken.
+func (it *SearchIterator) Token() []byte {
+	return it.token
+}
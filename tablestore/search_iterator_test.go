@@ -0,0 +1,199 @@
+package tablestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/search"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ searchClient = (*TableStoreClient)(nil)
+
+// fakeSearchIndex serves rows 0..total-1, at most pageSize per page, with
+// the offset of the next page as token.
+type fakeSearchIndex struct {
+	total    int
+	pageSize int32
+	// failAt makes the request for the page at this offset fail
+	failAt int
+	err    error
+
+	queries []*searchQueryJSON
+}
+
+// searchQueryJSON is what the fake saw of a page query.
+type searchQueryJSON struct {
+	Limit  *int32
+	Offset *int32
+	Token  []byte
+	Sort   *search.Sort
+}
+
+func (f *fakeSearchIndex) Search(request *SearchRequest) (*SearchResponse, error) {
+	data, err := json.Marshal(request.SearchQuery)
+	if err != nil {
+		return nil, err
+	}
+	query := search.NewSearchQuery()
+	if err := json.Unmarshal(data, query); err != nil {
+		return nil, err
+	}
+	f.queries = append(f.queries, &searchQueryJSON{Limit: query.Limit, Offset: query.Offset, Token: query.Token, Sort: query.Sort})
+
+	start := 0
+	if len(query.Token) > 0 {
+		start, _ = strconv.Atoi(string(query.Token))
+	}
+	if f.err != nil && start == f.failAt {
+		return nil, f.err
+	}
+	limit := f.pageSize
+	if query.Limit != nil && *query.Limit < limit {
+		limit = *query.Limit
+	}
+	resp := &SearchResponse{TotalCount: int64(f.total), IsAllSuccess: true}
+	if start == 0 {
+		resp.AggregationResults = search.AggregationResults{}
+	}
+	end := start + int(limit)
+	if end > f.total {
+		end = f.total
+	}
+	for i := start; i < end; i++ {
+		pk := new(PrimaryKey)
+		pk.AddPrimaryKeyColumn("id", int64(i))
+		resp.Rows = append(resp.Rows, &Row{PrimaryKey: pk})
+	}
+	if end < f.total {
+		resp.NextToken = []byte(strconv.Itoa(end))
+	}
+	return resp, nil
+}
+
+func newTestSearchRequest() *SearchRequest {
+	query := search.NewSearchQuery().
+		SetQuery(&search.MatchAllQuery{}).
+		SetLimit(3).
+		SetGetTotalCount(true).
+		SetSort(&search.Sort{Sorters: []search.Sorter{&search.PrimaryKeySort{Order: search.SortOrder_ASC.Enum()}}}).
+		Aggregation(search.NewCountAggregation("count", "id"))
+	return &SearchRequest{TableName: "table", IndexName: "index", SearchQuery: query}
+}
+
+func TestSearchIterator_AllPages(t *testing.T) {
+	f := &fakeSearchIndex{total: 7, pageSize: 3}
+	request := newTestSearchRequest()
+	it := newSearchIterator(context.Background(), f, request)
+
+	var ids []int64
+	for it.Next() {
+		assert.Equal(t, it.SearchHit().Row, it.Row())
+		ids = append(ids, it.Row().PrimaryKey.PrimaryKeys[0].Value.(int64))
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, []int64{0, 1, 2, 3, 4, 5, 6}, ids)
+	assert.Equal(t, int64(7), it.TotalCount())
+	assert.NotNil(t, it.AggregationResults())
+	assert.Equal(t, 3, it.Pages())
+	assert.Nil(t, it.Token())
+	assert.False(t, it.Next())
+	assert.Nil(t, it.Row())
+
+	// only the first page sorts and aggregates, the others follow the token
+	assert.Len(t, f.queries, 3)
+	assert.Nil(t, f.queries[0].Token)
+	assert.NotNil(t, f.queries[0].Sort)
+	assert.Equal(t, []byte("3"), f.queries[1].Token)
+	assert.Nil(t, f.queries[1].Sort)
+	assert.Equal(t, []byte("6"), f.queries[2].Token)
+	// the request is left alone
+	assert.Equal(t, newTestSearchRequest(), request)
+}
+
+func TestSearchIterator_Limit(t *testing.T) {
+	f := &fakeSearchIndex{total: 10, pageSize: 3}
+	it := newSearchIterator(context.Background(), f, newTestSearchRequest()).SetLimit(4)
+
+	var ids []int64
+	for it.Next() {
+		ids = append(ids, it.Row().PrimaryKey.PrimaryKeys[0].Value.(int64))
+	}
+	assert.Nil(t, it.Err())
+	assert.Equal(t, []int64{0, 1, 2, 3}, ids)
+	// the last page only asks for the rows left
+	assert.Len(t, f.queries, 2)
+	assert.Equal(t, int32(3), *f.queries[0].Limit)
+	assert.Equal(t, int32(1), *f.queries[1].Limit)
+	assert.Equal(t, []byte("4"), it.Token())
+}
+
+func TestSearchIterator_TokenExpired(t *testing.T) {
+	expired := &OtsError{Code: "OTSSessionExpired", Message: "session expired"}
+	f := &fakeSearchIndex{total: 7, pageSize: 3, failAt: 3, err: expired}
+	it := newSearchIterator(context.Background(), f, newTestSearchRequest())
+
+	n := 0
+	for it.Next() {
+		n++
+	}
+	assert.Equal(t, 3, n)
+	var tokenErr *SearchTokenExpiredError
+	assert.True(t, errors.As(it.Err(), &tokenErr))
+	assert.Equal(t, []byte("3"), tokenErr.Token)
+	assert.Equal(t, int64(3), tokenErr.Rows)
+	assert.Equal(t, expired, errors.Unwrap(it.Err()))
+	assert.False(t, it.Next())
+
+	f = &fakeSearchIndex{total: 7, pageSize: 3, failAt: 3, err: &OtsError{Code: "OTSParameterInvalid", Message: "Invalid token"}}
+	it = newSearchIterator(context.Background(), f, newTestSearchRequest())
+	for it.Next() {
+	}
+	assert.True(t, errors.As(it.Err(), &tokenErr))
+}
+
+func TestSearchIterator_Errors(t *testing.T) {
+	// the first page has no token to expire
+	failed := &OtsError{Code: "OTSSessionExpired", Message: "session expired"}
+	f := &fakeSearchIndex{total: 7, pageSize: 3, failAt: 0, err: failed}
+	it := newSearchIterator(context.Background(), f, newTestSearchRequest())
+	assert.False(t, it.Next())
+	assert.Equal(t, failed, it.Err())
+
+	other := &OtsError{Code: "OTSParameterInvalid", Message: "invalid limit"}
+	f = &fakeSearchIndex{total: 7, pageSize: 3, failAt: 3, err: other}
+	it = newSearchIterator(context.Background(), f, newTestSearchRequest())
+	for it.Next() {
+	}
+	assert.Equal(t, other, it.Err())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f = &fakeSearchIndex{total: 7, pageSize: 3}
+	it = newSearchIterator(ctx, f, newTestSearchRequest())
+	assert.False(t, it.Next())
+	assert.Equal(t, context.Canceled, it.Err())
+	assert.Len(t, f.queries, 0)
+}
+
+func TestSearchIterator_SearchHits(t *testing.T) {
+	score := 1.5
+	client := searchClientFunc(func(request *SearchRequest) (*SearchResponse, error) {
+		row := &Row{}
+		return &SearchResponse{Rows: []*Row{row}, SearchHits: []*SearchHit{{Row: row, Score: &score}}}, nil
+	})
+	it := newSearchIterator(context.Background(), client, newTestSearchRequest())
+	assert.True(t, it.Next())
+	assert.Equal(t, &score, it.SearchHit().Score)
+	assert.False(t, it.Next())
+	assert.Nil(t, it.Err())
+}
+
+type searchClientFunc func(request *SearchRequest) (*SearchResponse, error)
+
+func (f searchClientFunc) Search(request *SearchRequest) (*SearchResponse, error) {
+	return f(request)
+}
@@ -363,6 +363,53 @@ type IndexSchema struct {
 	IndexSort    *search.Sort
 }
 
+// FieldInfo describes a field, nested fields named by their path joined
+// with dots, so that the schema can be passed to search.Validate and
+// search.ParseQueryString. Index and EnableSortAndAgg left
+// unset count as enabled, except sort and aggregation of TEXT, NESTED and
+// VECTOR fields.
+func (s *IndexSchema) FieldInfo(field string) (search.FieldInfo, bool) {
+	fs := s.fieldSchema(field)
+	if fs == nil {
+		return search.FieldInfo{}, false
+	}
+	info := search.FieldInfo{
+		Type:       fs.FieldType.String(),
+		Index:      fs.Index == nil || *fs.Index,
+		SortAndAgg: fs.FieldType != FieldType_TEXT && fs.FieldType != FieldType_NESTED && fs.FieldType != FieldType_VECTOR,
+		Array:      fs.IsArray != nil && *fs.IsArray,
+	}
+	if fs.EnableSortAndAgg != nil {
+		info.SortAndAgg = *fs.EnableSortAndAgg
+	}
+	if fs.VectorOptions != nil && fs.VectorOptions.Dimension != nil {
+		info.Dimension = *fs.VectorOptions.Dimension
+	}
+	return info, true
+}
+
+func (s *IndexSchema) fieldSchema(field string) *FieldSchema {
+	if s == nil {
+		return nil
+	}
+	schemas := s.FieldSchemas
+	path := strings.Split(field, ".")
+	for i, name := range path {
+		var found *FieldSchema
+		for _, fs := range schemas {
+			if fs.FieldName != nil && *fs.FieldName == name {
+				found = fs
+				break
+			}
+		}
+		if found == nil || i == len(path)-1 {
+			return found
+		}
+		schemas = found.FieldSchemas
+	}
+	return nil
+}
+
 type FieldType int32
 
 const (
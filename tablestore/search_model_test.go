@@ -1530,4 +1530,48 @@ func Test_buildSearchHit(t *testing.T) {
 			assert.Equalf(t, tt.want, buildSearchHit(tt.args.pbSearchHit, tt.args.row), "buildSearchHit(%v, %v)", tt.args.pbSearchHit, tt.args.row)
 		})
 	}
-}
\ No newline at end of file
+}
+func TestIndexSchema_ParseQueryString(t *testing.T) {
+	schema := &IndexSchema{FieldSchemas: []*FieldSchema{
+		{FieldName: proto.String("title"), FieldType: FieldType_TEXT},
+		{FieldName: proto.String("items"), FieldType: FieldType_NESTED, FieldSchemas: []*FieldSchema{
+			{FieldName: proto.String("price"), FieldType: FieldType_DOUBLE},
+		}},
+	}}
+	_, ok := schema.FieldInfo("items.missing")
+	assert.False(t, ok)
+
+	q, err := search.ParseQueryString("title:hello AND items.price:>1", schema)
+	assert.Nil(t, err)
+	assert.Equal(t, &search.BoolQuery{MustQueries: []search.Query{
+		&search.MatchQuery{FieldName: "title", Text: "hello"},
+		&search.NestedQuery{Path: "items", Query: &search.RangeQuery{FieldName: "items.price", From: 1.0}, ScoreMode: search.ScoreMode_Avg},
+	}}, q)
+	assert.Nil(t, search.Validate(search.NewSearchQuery().SetQuery(q), schema))
+}
+
+func TestIndexSchema_FieldInfo(t *testing.T) {
+	schema := &IndexSchema{FieldSchemas: []*FieldSchema{
+		{FieldName: proto.String("title"), FieldType: FieldType_TEXT},
+		{FieldName: proto.String("code"), FieldType: FieldType_KEYWORD, Index: proto.Bool(false), EnableSortAndAgg: proto.Bool(false)},
+		{FieldName: proto.String("vec"), FieldType: FieldType_VECTOR, VectorOptions: &VectorOptions{Dimension: proto.Int32(4)}},
+		{FieldName: proto.String("items"), FieldType: FieldType_NESTED, FieldSchemas: []*FieldSchema{
+			{FieldName: proto.String("price"), FieldType: FieldType_DOUBLE, IsArray: proto.Bool(true)},
+		}},
+	}}
+	info, ok := schema.FieldInfo("title")
+	assert.True(t, ok)
+	assert.Equal(t, search.FieldInfo{Type: "TEXT", Index: true}, info)
+	info, _ = schema.FieldInfo("code")
+	assert.Equal(t, search.FieldInfo{Type: "KEYWORD"}, info)
+	info, _ = schema.FieldInfo("vec")
+	assert.Equal(t, search.FieldInfo{Type: "VECTOR", Index: true, Dimension: 4}, info)
+	info, _ = schema.FieldInfo("items.price")
+	assert.Equal(t, search.FieldInfo{Type: "DOUBLE", Index: true, SortAndAgg: true, Array: true}, info)
+	_, ok = (*IndexSchema)(nil).FieldInfo("title")
+	assert.False(t, ok)
+
+	query := search.NewSearchQuery().SetQuery(&search.RangeQuery{FieldName: "title", From: 1})
+	err := search.Validate(query, schema)
+	assert.Equal(t, search.ValidationErrors{{Path: "Query", Reason: "RangeQuery on TEXT field title, want LONG, DOUBLE, DATE or KEYWORD"}}, err)
+}
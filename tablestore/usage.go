@@ -0,0 +1,250 @@
+package tablestore
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultUsageRetention  = 15 * time.Minute
+	DefaultUsageResolution = time.Second
+)
+
+// CapacityUsage is the sum of the capacity units consumed by a number of
+// requests.
+type CapacityUsage struct {
+	Read     int64
+	Write    int64
+	Requests int64
+}
+
+func (u *CapacityUsage) add(other *CapacityUsage) {
+	u.Read += other.Read
+	u.Write += other.Write
+	u.Requests += other.Requests
+}
+
+// TableUsage is the usage of a table over a window ending now.
+type TableUsage struct {
+	Table  string
+	Window time.Duration
+	CapacityUsage
+	// Actions splits the usage by action, such as GetRow or BatchWriteRow.
+	Actions map[string]*CapacityUsage
+	// PeakReadPerSecond and PeakWritePerSecond are the highest rates over a
+	// single resolution interval of the window.
+	PeakReadPerSecond  float64
+	PeakWritePerSecond float64
+	// Since is when the accountant started recording, at its creation or
+	// its last Reset. A window that starts before Since is not fully
+	// covered.
+	Since time.Time
+}
+
+func (u *TableUsage) ReadPerSecond() float64 {
+	return float64(u.Read) / u.Window.Seconds()
+}
+
+func (u *TableUsage) WritePerSecond() float64 {
+	return float64(u.Write) / u.Window.Seconds()
+}
+
+// UsageAccountant adds up the capacity units consumed by the row operations
+// of a client per table and action. It keeps one slot per resolution
+// interval over the retention period, so usage can be read over any window
+// up to the retention. It only sees the requests of the clients it is
+// installed on, in this process. UsageAccountant is safe for concurrent
+// use.
+//
+// Install it with SetUsageAccountant, the client then records PutRow,
+// UpdateRow, DeleteRow, GetRow, GetRange, BatchGetRow and BatchWriteRow.
+type UsageAccountant struct {
+	mu         sync.Mutex
+	resolution time.Duration
+	slots      int
+	usage      map[usageKey][]usageSlot
+	started    time.Time
+	now        func() time.Time
+}
+
+type usageKey struct {
+	table  string
+	action string
+}
+
+type usageSlot struct {
+	number int64
+	CapacityUsage
+}
+
+// NewUsageAccountant keeps usage for retention in slots of resolution, zero
+// values mean DefaultUsageRetention and DefaultUsageResolution.
+func NewUsageAccountant(retention, resolution time.Duration) *UsageAccountant {
+	if retention <= 0 {
+		retention = DefaultUsageRetention
+	}
+	if resolution <= 0 {
+		resolution = DefaultUsageResolution
+	}
+	slots := int(retention / resolution)
+	if slots < 1 {
+		slots = 1
+	}
+	return &UsageAccountant{
+		resolution: resolution,
+		slots:      slots,
+		usage:      make(map[usageKey][]usageSlot),
+		started:    time.Now(),
+		now:        time.Now,
+	}
+}
+
+// Retention is the longest window Usage can report.
+func (a *UsageAccountant) Retention() time.Duration {
+	return time.Duration(a.slots) * a.resolution
+}
+
+// Record adds the capacity consumed by one request, nil is counted as a
+// request that consumed nothing.
+func (a *UsageAccountant) Record(table, action string, consumed *ConsumedCapacityUnit) {
+	usage := CapacityUsage{Requests: 1}
+	if consumed != nil {
+		usage.Read, usage.Write = int64(consumed.Read), int64(consumed.Write)
+	}
+	a.add(table, action, &usage)
+}
+
+func (a *UsageAccountant) add(table, action string, usage *CapacityUsage) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := usageKey{table: table, action: strings.TrimPrefix(action, "/")}
+	slots, ok := a.usage[key]
+	if !ok {
+		slots = make([]usageSlot, a.slots)
+		a.usage[key] = slots
+	}
+	number := a.now().UnixNano() / int64(a.resolution)
+	slot := &slots[number%int64(a.slots)]
+	if slot.number != number {
+		*slot = usageSlot{number: number}
+	}
+	slot.add(usage)
+}
+
+// Usage returns the usage of table over the window ending now. The window
+// is rounded to the resolution and capped at the retention.
+func (a *UsageAccountant) Usage(table string, window time.Duration) *TableUsage {
+	if window <= 0 || window > a.Retention() {
+		window = a.Retention()
+	}
+	count := int64((window + a.resolution - 1) / a.resolution)
+	window = time.Duration(count) * a.resolution
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	last := a.now().UnixNano() / int64(a.resolution)
+	first := last - count + 1
+
+	usage := &TableUsage{Table: table, Window: window, Actions: make(map[string]*CapacityUsage), Since: a.started}
+	perSlot := make(map[int64]*CapacityUsage)
+	for key, slots := range a.usage {
+		if key.table != table {
+			continue
+		}
+		for i := range slots {
+			slot := &slots[i]
+			if slot.number < first || slot.number > last || slot.Requests == 0 {
+				continue
+			}
+			action, ok := usage.Actions[key.action]
+			if !ok {
+				action = new(CapacityUsage)
+				usage.Actions[key.action] = action
+			}
+			action.add(&slot.CapacityUsage)
+			usage.add(&slot.CapacityUsage)
+			if perSlot[slot.number] == nil {
+				perSlot[slot.number] = new(CapacityUsage)
+			}
+			perSlot[slot.number].add(&slot.CapacityUsage)
+		}
+	}
+	seconds := a.resolution.Seconds()
+	for _, slot := range perSlot {
+		if rate := float64(slot.Read) / seconds; rate > usage.PeakReadPerSecond {
+			usage.PeakReadPerSecond = rate
+		}
+		if rate := float64(slot.Write) / seconds; rate > usage.PeakWritePerSecond {
+			usage.PeakWritePerSecond = rate
+		}
+	}
+	return usage
+}
+
+// Tables lists the tables with recorded usage, sorted by name.
+func (a *UsageAccountant) Tables() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seen := make(map[string]bool)
+	var tables []string
+	for key := range a.usage {
+		if !seen[key.table] {
+			seen[key.table] = true
+			tables = append(tables, key.table)
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+// Reset drops all recorded usage.
+func (a *UsageAccountant) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.usage = make(map[usageKey][]usageSlot)
+	a.started = a.now()
+}
+
+// SetUsageAccountant makes the client record the capacity consumed by its
+// row operations in accountant.
+func SetUsageAccountant(accountant *UsageAccountant) ClientOption {
+	return func(client *TableStoreClient) {
+		client.usageAccountant = accountant
+	}
+}
+
+// UsageAccountant returns the accountant installed with SetUsageAccountant,
+// or nil. Like GetRetryNotify it returns nil for a client not created by
+// NewClient, whose embedded internalClient holding the accountant is nil.
+func (tableStoreClient *TableStoreClient) UsageAccountant() *UsageAccountant {
+	if tableStoreClient.internalClient == nil {
+		return nil
+	}
+	return tableStoreClient.usageAccountant
+}
+
+func (tableStoreClient *TableStoreClient) recordUsage(table, action string, consumed *ConsumedCapacityUnit) {
+	if tableStoreClient.usageAccountant != nil {
+		tableStoreClient.usageAccountant.Record(table, action, consumed)
+	}
+}
+
+// recordBatchUsage counts a batch request once per table it touched, with
+// the capacity of all its rows.
+func (tableStoreClient *TableStoreClient) recordBatchUsage(action string, results map[string][]RowResult) {
+	if tableStoreClient.usageAccountant == nil {
+		return
+	}
+	for table, rows := range results {
+		usage := CapacityUsage{Requests: 1}
+		for _, row := range rows {
+			if row.ConsumedCapacityUnit != nil {
+				usage.Read += int64(row.ConsumedCapacityUnit.Read)
+				usage.Write += int64(row.ConsumedCapacityUnit.Write)
+			}
+		}
+		tableStoreClient.usageAccountant.add(table, action, &usage)
+	}
+}
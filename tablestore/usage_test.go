@@ -0,0 +1,69 @@
+package tablestore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageAccountant(t *testing.T) {
+	now := time.Unix(1000, 0)
+	accountant := NewUsageAccountant(time.Minute, time.Second)
+	accountant.now = func() time.Time { return now }
+
+	accountant.Record("users", getRowUri, &ConsumedCapacityUnit{Read: 1})
+	accountant.Record("users", getRowUri, &ConsumedCapacityUnit{Read: 2})
+	accountant.Record("users", putRowUri, &ConsumedCapacityUnit{Write: 3})
+	accountant.Record("orders", "GetRange", &ConsumedCapacityUnit{Read: 10})
+	accountant.Record("orders", "GetRange", nil)
+
+	now = now.Add(10 * time.Second)
+	accountant.Record("users", getRowUri, &ConsumedCapacityUnit{Read: 7})
+
+	usage := accountant.Usage("users", 30*time.Second)
+	assert.Equal(t, 30*time.Second, usage.Window)
+	assert.Equal(t, CapacityUsage{Read: 10, Write: 3, Requests: 4}, usage.CapacityUsage)
+	assert.Equal(t, &CapacityUsage{Read: 10, Requests: 3}, usage.Actions["GetRow"])
+	assert.Equal(t, &CapacityUsage{Write: 3, Requests: 1}, usage.Actions["PutRow"])
+	assert.Equal(t, float64(7), usage.PeakReadPerSecond)
+	assert.Equal(t, float64(3), usage.PeakWritePerSecond)
+	assert.InDelta(t, 10.0/30, usage.ReadPerSecond(), 1e-9)
+	assert.InDelta(t, 3.0/30, usage.WritePerSecond(), 1e-9)
+
+	// the first second is out of a 5 second window
+	usage = accountant.Usage("users", 5*time.Second)
+	assert.Equal(t, CapacityUsage{Read: 7, Requests: 1}, usage.CapacityUsage)
+
+	assert.Equal(t, CapacityUsage{Read: 10, Requests: 2}, accountant.Usage("orders", 0).CapacityUsage)
+	assert.Equal(t, time.Minute, accountant.Usage("orders", time.Hour).Window)
+	assert.Equal(t, []string{"orders", "users"}, accountant.Tables())
+
+	// slots are reused once the retention has passed
+	now = now.Add(time.Minute)
+	accountant.Record("users", getRowUri, &ConsumedCapacityUnit{Read: 1})
+	assert.Equal(t, CapacityUsage{Read: 1, Requests: 1}, accountant.Usage("users", 0).CapacityUsage)
+	assert.Equal(t, CapacityUsage{}, accountant.Usage("orders", 0).CapacityUsage)
+
+	accountant.Reset()
+	assert.Empty(t, accountant.Tables())
+	assert.Equal(t, now, accountant.Usage("users", 0).Since)
+}
+
+func TestClientUsageAccountant(t *testing.T) {
+	accountant := NewUsageAccountant(0, 0)
+	client := NewClient("endpoint", "instance", "id", "secret", SetUsageAccountant(accountant))
+	assert.Equal(t, accountant, client.UsageAccountant())
+
+	client.recordBatchUsage(batchWriteRowUri, map[string][]RowResult{
+		"users":  {{ConsumedCapacityUnit: &ConsumedCapacityUnit{Write: 1}}, {ConsumedCapacityUnit: &ConsumedCapacityUnit{Write: 2}}},
+		"orders": {{IsSucceed: false}},
+	})
+	usage := accountant.Usage("users", time.Minute)
+	assert.Equal(t, &CapacityUsage{Write: 3, Requests: 1}, usage.Actions["BatchWriteRow"])
+	assert.Equal(t, int64(1), accountant.Usage("orders", time.Minute).Requests)
+
+	// a client without an accountant records nothing
+	NewClient("endpoint", "instance", "id", "secret").recordUsage("users", getRowUri, &ConsumedCapacityUnit{Read: 1})
+	assert.Nil(t, (&TableStoreClient{}).UsageAccountant())
+}
@@ -410,36 +410,7 @@ type PrimaryKey2 struct {
 }
 
 func (pk *PrimaryKey) Build(isDelete bool) []byte {
-	var b bytes.Buffer
-	writeHeader(&b)
-	writeTag(&b, TAG_ROW_PK)
-
-	rowChecksum := byte(0x0)
-	var cellChecksum byte
-
-	for _, column := range pk.PrimaryKeys {
-		primaryKeyColumn := NewPrimaryKeyColumn([]byte(column.ColumnName), column.Value, column.PrimaryKeyOption)
-
-		cellChecksum = crc8Bytes(byte(0x0), []byte(primaryKeyColumn.Name))
-		cellChecksum = primaryKeyColumn.getCheckSum(cellChecksum)
-		rowChecksum = crc8Byte(rowChecksum, cellChecksum)
-		primaryKeyColumn.writePrimaryKeyColumn(&b)
-
-		writeTag(&b, TAG_CELL_CHECKSUM)
-		writeRawByte(&b, cellChecksum)
-	}
-
-	// 没有deleteMarker, 要与0x0做crc.
-	if isDelete {
-		writeTag(&b, TAG_DELETE_ROW_MARKER)
-		rowChecksum = crc8Byte(rowChecksum, byte(0x1))
-	} else {
-		rowChecksum = crc8Byte(rowChecksum, byte(0x0))
-	}
-	writeTag(&b, TAG_ROW_CHECKSUM)
-	writeRawByte(&b, rowChecksum)
-
-	return b.Bytes()
+	return pk.appendTo(make([]byte, 0, pk.encodedSize(isDelete)), isDelete)
 }
 
 type RowPutChange struct {
@@ -682,13 +653,17 @@ func buildRowUpdateChange(primarykey *PrimaryKey, columns []ColumnToUpdate) *Row
 }
 
 func (condition *RowCondition) buildCondition() *otsprotocol.RowExistenceExpectation {
+	return condition.rowExistence().Enum()
+}
+
+func (condition *RowCondition) rowExistence() otsprotocol.RowExistenceExpectation {
 	switch condition.RowExistenceExpectation {
 	case RowExistenceExpectation_IGNORE:
-		return otsprotocol.RowExistenceExpectation_IGNORE.Enum()
+		return otsprotocol.RowExistenceExpectation_IGNORE
 	case RowExistenceExpectation_EXPECT_EXIST:
-		return otsprotocol.RowExistenceExpectation_EXPECT_EXIST.Enum()
+		return otsprotocol.RowExistenceExpectation_EXPECT_EXIST
 	case RowExistenceExpectation_EXPECT_NOT_EXIST:
-		return otsprotocol.RowExistenceExpectation_EXPECT_NOT_EXIST.Enum()
+		return otsprotocol.RowExistenceExpectation_EXPECT_NOT_EXIST
 	}
 
 	panic(errInvalidInput)
@@ -831,13 +806,11 @@ func (rowchange *DeleteRowChange) Serialize() []byte {
 }
 
 func (rowchange *PutRowChange) Serialize() []byte {
-	row := buildRowPutChange(rowchange.PrimaryKey, rowchange.Columns)
-	return row.Build()
+	return rowchange.appendTo(make([]byte, 0, rowchange.encodedSize()))
 }
 
 func (rowchange *UpdateRowChange) Serialize() []byte {
-	row := buildRowUpdateChange(rowchange.PrimaryKey, rowchange.Columns)
-	return row.Build()
+	return rowchange.appendTo(make([]byte, 0, rowchange.encodedSize()))
 }
 
 func (rowchange *DeleteRowChange) GetTableName() string {
@@ -865,30 +838,38 @@ func (rowchange *UpdateRowChange) getOperationType() otsprotocol.OperationType {
 }
 
 func (rowchange *DeleteRowChange) getCondition() *otsprotocol.Condition {
-	condition := new(otsprotocol.Condition)
-	condition.RowExistence = rowchange.Condition.buildCondition()
-	if rowchange.Condition.ColumnCondition != nil {
-		condition.ColumnCondition = rowchange.Condition.ColumnCondition.Serialize()
-	}
-	return condition
+	return rowchange.fillCondition(new(otsprotocol.Condition), new(otsprotocol.RowExistenceExpectation))
 }
 
 func (rowchange *UpdateRowChange) getCondition() *otsprotocol.Condition {
-	condition := new(otsprotocol.Condition)
-	condition.RowExistence = rowchange.Condition.buildCondition()
-	if rowchange.Condition.ColumnCondition != nil {
-		condition.ColumnCondition = rowchange.Condition.ColumnCondition.Serialize()
-	}
-	return condition
+	return rowchange.fillCondition(new(otsprotocol.Condition), new(otsprotocol.RowExistenceExpectation))
 }
 
 func (rowchange *PutRowChange) getCondition() *otsprotocol.Condition {
-	condition := new(otsprotocol.Condition)
-	condition.RowExistence = rowchange.Condition.buildCondition()
-	if rowchange.Condition.ColumnCondition != nil {
-		condition.ColumnCondition = rowchange.Condition.ColumnCondition.Serialize()
+	return rowchange.fillCondition(new(otsprotocol.Condition), new(otsprotocol.RowExistenceExpectation))
+}
+
+func (rowchange *DeleteRowChange) fillCondition(condition *otsprotocol.Condition, existence *otsprotocol.RowExistenceExpectation) *otsprotocol.Condition {
+	return rowchange.Condition.fillCondition(condition, existence)
+}
+
+func (rowchange *UpdateRowChange) fillCondition(condition *otsprotocol.Condition, existence *otsprotocol.RowExistenceExpectation) *otsprotocol.Condition {
+	return rowchange.Condition.fillCondition(condition, existence)
+}
+
+func (rowchange *PutRowChange) fillCondition(condition *otsprotocol.Condition, existence *otsprotocol.RowExistenceExpectation) *otsprotocol.Condition {
+	return rowchange.Condition.fillCondition(condition, existence)
+}
+
+// fillCondition writes the condition into caller provided messages so batch
+// requests can allocate them in bulk.
+func (condition *RowCondition) fillCondition(pbCondition *otsprotocol.Condition, existence *otsprotocol.RowExistenceExpectation) *otsprotocol.Condition {
+	*existence = condition.rowExistence()
+	pbCondition.RowExistence = existence
+	if condition.ColumnCondition != nil {
+		pbCondition.ColumnCondition = condition.ColumnCondition.Serialize()
 	}
-	return condition
+	return pbCondition
 }
 
 func (request *BatchWriteRowRequest) AddRowChange(change RowChange) {
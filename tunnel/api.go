@@ -544,6 +544,11 @@ func (api *TunnelApi) ReadRecords(req *ReadRecordRequest) (*ReadRecordResponse,
 			if err != nil {
 				return nil, err
 			}
+			if req.DecompressValues {
+				if err := decompressRecord(tunnelRecord); err != nil {
+					return nil, err
+				}
+			}
 			tunnelRecords = append(tunnelRecords, tunnelRecord)
 		}
 		response.Records = tunnelRecords
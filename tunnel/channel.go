@@ -99,6 +99,7 @@ type channelDialer struct {
 	channelParallelChan chan bool
 	needManualRelease   bool
 	syncReadRecords     bool
+	decompressRecords   bool
 }
 
 func (d *channelDialer) ChannelDial(tunnelId, clientId, channelId, token string, p ChannelProcessor, state *TunnelStateMachine) ChannelConn {
@@ -124,6 +125,7 @@ func (d *channelDialer) ChannelDial(tunnelId, clientId, channelId, token string,
 		lg:            d.lg,
 		bc:            d.bc,
 		streamChannel: isStream,
+		decompress:    d.decompressRecords,
 	}
 	if d.channelParallelChan != nil {
 		conn.parallelReleaseManager = &defaultParallelReleaseManager{
@@ -175,6 +177,7 @@ type channelConn struct {
 	parallelReleaseManager *defaultParallelReleaseManager
 	needManualRelease      bool
 	syncReadRecordChan     chan bool
+	decompress             bool
 }
 
 func (c *channelConn) NotifyStatus(channel *ChannelStatus) {
@@ -352,6 +355,7 @@ func (c *channelConn) readRecordsPipe(outCh chan *pipeResult, closeCh chan struc
 				ClientId:         c.clientId,
 				Token:            c.token,
 				NeedBinaryRecord: needBinaryRecords,
+				DecompressValues: c.decompress,
 			}
 			resp, err := c.api.ReadRecords(request)
 			if err != nil {
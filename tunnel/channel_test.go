@@ -6,6 +6,11 @@ import (
 	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -29,6 +34,22 @@ var (
 	}
 )
 
+// TestMain sends the logs of the tests to a temporary directory rather
+// than to the package directory.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "tunnel-test")
+	if err != nil {
+		panic(err)
+	}
+	utLog := filepath.Join(dir, "ut.log")
+	testLogConfig.OutputPaths = []string{utLog}
+	testLogConfig.ErrorOutputPaths = []string{utLog}
+	DefaultSyncer = zapcore.AddSync(&lumberjack.Logger{Filename: filepath.Join(dir, "tunnelClient.log")})
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestFailConn_NotifyStatus(t *testing.T) {
 	cases := []struct {
 		desc        string
@@ -122,6 +122,8 @@ type TunnelWorkerConfig struct {
 	NeedManualRelease bool
 	//Whether to read data synchronously
 	SyncReadRecords bool
+	//Whether to decompress the column values written by a compression.Client, a value that fails to decompress fails the read
+	DecompressRecords bool
 }
 
 // hack replace zap config build core with lumberjack logger
@@ -100,6 +100,9 @@ type ReadRecordRequest struct {
 	ClientId         string
 	Token            string
 	NeedBinaryRecord bool
+	// DecompressValues restores the values a compression.Client wrote, it
+	// does not apply to binary records
+	DecompressValues bool
 }
 
 type ReadRecordResponse struct {
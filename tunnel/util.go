@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/common"
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/compression"
 	"github.com/aliyun/aliyun-tablestore-go-sdk/tunnel/protocol"
 	"github.com/cenkalti/backoff"
 	"github.com/golang/protobuf/proto"
@@ -56,6 +57,21 @@ func ParseActionType(pbType *protocol.ActionType) (ActionType, error) {
 	}
 }
 
+// decompressRecord restores the column values of a record written by a
+// compression.Client.
+func decompressRecord(record *Record) error {
+	for _, columns := range [][]*RecordColumn{record.Columns, record.OriginColumns} {
+		for _, column := range columns {
+			value, err := compression.Decompress(column.Value)
+			if err != nil {
+				return &TunnelError{Code: ErrCodeClientError, Message: fmt.Sprintf("decompress column %s: %v", *column.Name, err)}
+			}
+			column.Value = value
+		}
+	}
+	return nil
+}
+
 func DeserializeRecordFromRawBytes(data []byte, originData []byte, actionType ActionType) (*Record, error) {
 	rows, err := protocol.ReadRowsWithHeader(bytes.NewReader(data))
 	if err != nil {
@@ -1,7 +1,9 @@
 package tunnel
 
 import (
+	"github.com/aliyun/aliyun-tablestore-go-sdk/tablestore/compression"
 	"github.com/smartystreets/goconvey/convey"
+	"strings"
 	"testing"
 )
 
@@ -55,3 +57,24 @@ func TestStreamRecordSequenceLess(t *testing.T) {
 		}
 	})
 }
+
+func TestDecompressRecord(t *testing.T) {
+	convey.Convey("decompress record values", t, func() {
+		text := strings.Repeat("tunnel record ", 200)
+		compressed, err := compression.Compress(text, compression.Zstd, 0)
+		convey.So(err, convey.ShouldBeNil)
+		name, origin := "col", "old"
+		record := &Record{
+			Columns:       []*RecordColumn{{Name: &name, Value: compressed}},
+			OriginColumns: []*RecordColumn{{Name: &origin, Value: "plain"}},
+		}
+		convey.So(decompressRecord(record), convey.ShouldBeNil)
+		convey.So(record.Columns[0].Value, convey.ShouldEqual, text)
+		convey.So(record.OriginColumns[0].Value, convey.ShouldEqual, "plain")
+
+		record.Columns[0].Value = compressed.([]byte)[:10]
+		err = decompressRecord(record)
+		convey.So(err, convey.ShouldNotBeNil)
+		convey.So(err.Error(), convey.ShouldContainSubstring, "decompress column col")
+	})
+}
@@ -69,6 +69,7 @@ func newTunnelWorker(tunnelId string, api *TunnelApi, conf *TunnelWorkerConfig)
 			dialer.needManualRelease = cloneConf.NeedManualRelease
 		}
 		dialer.syncReadRecords = cloneConf.SyncReadRecords
+		dialer.decompressRecords = cloneConf.DecompressRecords
 		cloneConf.ChannelDialer = dialer
 	}
 	return &tunnelWorker{